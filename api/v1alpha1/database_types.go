@@ -0,0 +1,133 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DatabaseKind and ClusterDatabaseKind are the kinds a database reference points to
+	DatabaseKind        = "Database"
+	ClusterDatabaseKind = "ClusterDatabase"
+
+	// ConditionReachable tells whether the database accepted a connection on the latest probe
+	ConditionReachable MigrationConditionType = "Reachable"
+)
+
+// DatabaseReference points to a Database of the migration namespace or to a ClusterDatabase
+type DatabaseReference struct {
+	// Kind defaults to Database
+	// +optional
+	// +kubebuilder:validation:Enum=Database;ClusterDatabase
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name"`
+}
+
+// DatabaseSpec defines the connection and credentials shared by the migrations of a database
+type DatabaseSpec struct {
+	DBSpec `json:",inline"`
+}
+
+// ClusterDatabaseSpec defines a database shared by the migrations of several namespaces,
+// its credentials secret lives in the namespace set on the secret
+type ClusterDatabaseSpec struct {
+	DatabaseSpec `json:",inline"`
+	// AllowedNamespaces are the namespaces whose migrations may reference the database and get its credentials,
+	// "*" allows every namespace. No namespace is allowed when empty.
+	// +optional
+	AllowedNamespaces []string `json:"allowedNamespaces,omitempty"`
+}
+
+// DatabaseStatus defines the observed state of Database
+type DatabaseStatus struct {
+	// +optional
+	Conditions []MigrationCondition `json:"conditions,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Driver",type=string,JSONPath=`.spec.driver`
+// +kubebuilder:printcolumn:name="Host",type=string,JSONPath=`.spec.host`
+// +kubebuilder:printcolumn:name="Reachable",type=string,JSONPath=`.status.conditions[?(@.type=="Reachable")].status`
+
+// Database is the Schema for the databases API
+type Database struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   DatabaseSpec   `json:"spec,omitempty"`
+	Status DatabaseStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// DatabaseList contains a list of Database
+type DatabaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Database `json:"items"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Driver",type=string,JSONPath=`.spec.driver`
+// +kubebuilder:printcolumn:name="Host",type=string,JSONPath=`.spec.host`
+// +kubebuilder:printcolumn:name="Reachable",type=string,JSONPath=`.status.conditions[?(@.type=="Reachable")].status`
+
+// ClusterDatabase is the Schema for the clusterdatabases API
+type ClusterDatabase struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterDatabaseSpec `json:"spec,omitempty"`
+	Status DatabaseStatus      `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// ClusterDatabaseList contains a list of ClusterDatabase
+type ClusterDatabaseList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterDatabase `json:"items"`
+}
+
+// Default fills the port and secret keys left empty
+func (r *Database) Default() {
+	defaultDB(&r.Spec.DBSpec)
+}
+
+// Default fills the port and secret keys left empty
+func (r *ClusterDatabase) Default() {
+	defaultDB(&r.Spec.DBSpec)
+}
+
+// AllowsNamespace tells whether the migrations of the namespace may reference the database
+func (r *ClusterDatabase) AllowsNamespace(namespace string) bool {
+	for _, allowed := range r.Spec.AllowedNamespaces {
+		if allowed == "*" || allowed == namespace {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	SchemeBuilder.Register(&Database{}, &DatabaseList{}, &ClusterDatabase{}, &ClusterDatabaseList{})
+}
@@ -0,0 +1,69 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// GetCondition returns the condition of the given type, nil if it was never reported
+func (s *MigrationStatus) GetCondition(conditionType MigrationConditionType) *MigrationCondition {
+	return getCondition(s.Conditions, conditionType)
+}
+
+// SetCondition adds or replaces the condition of the same type,
+// the transition time only moves when the condition status changes
+func (s *MigrationStatus) SetCondition(condition MigrationCondition) {
+	setCondition(&s.Conditions, condition)
+}
+
+// GetCondition returns the condition of the given type, nil if it was never reported
+func (s *DatabaseStatus) GetCondition(conditionType MigrationConditionType) *MigrationCondition {
+	return getCondition(s.Conditions, conditionType)
+}
+
+// SetCondition adds or replaces the condition of the same type,
+// the transition time only moves when the condition status changes
+func (s *DatabaseStatus) SetCondition(condition MigrationCondition) {
+	setCondition(&s.Conditions, condition)
+}
+
+func getCondition(conditions []MigrationCondition, conditionType MigrationConditionType) *MigrationCondition {
+	for i := range conditions {
+		if conditions[i].Type == conditionType {
+			return &conditions[i]
+		}
+	}
+	return nil
+}
+
+func setCondition(conditions *[]MigrationCondition, condition MigrationCondition) {
+	existing := getCondition(*conditions, condition.Type)
+	if existing == nil {
+		if condition.LastTransitionTime.IsZero() {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		*conditions = append(*conditions, condition)
+		return
+	}
+	if existing.Status != condition.Status {
+		existing.Status = condition.Status
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Reason = condition.Reason
+	existing.Message = condition.Message
+}
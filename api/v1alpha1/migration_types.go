@@ -17,52 +17,606 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
+const (
+	// PostgresDriver is the JDBC driver class of PostgreSQL databases
+	PostgresDriver = "org.postgresql.Driver"
+	// MySQLDriver is the JDBC driver class of MySQL databases
+	MySQLDriver = "com.mysql.cj.jdbc.Driver"
+
+	// GitRevisionAnnotation requests a new run at the given commit of the followed git branch
+	GitRevisionAnnotation = "migrations.flywayoperator.io/git-revision"
+
+	// RerunAnnotation requests a new run on every target once the current one is over, the admission webhook
+	// replaces its value with the requesting user
+	RerunAnnotation = "migrations.flywayoperator.io/rerun"
+	// ApproveAnnotation approves the pending rollout of a migration requiring approval, it is removed once the rollout starts,
+	// the admission webhook replaces its value with the approving user
+	ApproveAnnotation = "migrations.flywayoperator.io/approve"
+
+	// RequiresAnnotation on a pod holds it until migrations applied a schema version,
+	// as comma separated <migration>@<version> requirements, the migration may be prefixed by its namespace
+	RequiresAnnotation = "flyway.io/requires"
+	// SchemaGateLabel opts a pod in the schema gate, only the pods labelled enabled are sent to the pod webhook
+	SchemaGateLabel = "flyway.io/schema-gate"
+
+	// MigrationNameLabel and TargetNameLabel identify the migration and target of a flyway job
+	MigrationNameLabel = "migrations.flywayoperator.io/migration"
+	TargetNameLabel    = "migrations.flywayoperator.io/target"
+
+	// DefaultImage is the flyway image used when none is set on the migration
+	DefaultImage = "flyway/flyway"
+	// DefaultUserKey is the secret key holding the db user when none is set
+	DefaultUserKey = "user"
+	// DefaultPasswordKey is the secret key holding the db password when none is set
+	DefaultPasswordKey = "password"
+	// DefaultSSHPort is the port of SSH bastions when none is set
+	DefaultSSHPort = 22
+	// DefaultHistoryTable is the flyway schema history table when none is set
+	DefaultHistoryTable = "flyway_schema_history"
+	// DefaultHistoryLimit is the number of applied scripts reported when none is set
+	DefaultHistoryLimit = 10
+	// DefaultBackupKeepLast is the number of backups kept per target when none is set
+	DefaultBackupKeepLast = 5
+	// BackupLabel marks the backup jobs and volume snapshots taken before runs
+	BackupLabel = "migrations.flywayoperator.io/backup"
+)
+
+var (
+	// DriverPorts maps every supported JDBC driver class to the default port of its database
+	DriverPorts = map[string]int32{
+		PostgresDriver: 5432,
+		MySQLDriver:    3306,
+	}
+)
+
 // MigrationSpec defines the desired state of Migration
 type MigrationSpec struct {
-	DB  DBSpec  `json:"db"`
-	SQL SQLSpec `json:"sql"`
+	// DB is the database migrated when the migration has a single target
+	// +optional
+	DB DBSpec `json:"db,omitempty"`
+	// DatabaseRef migrates a Database or ClusterDatabase instead of the inline db
+	// +optional
+	DatabaseRef *DatabaseReference `json:"databaseRef,omitempty"`
+	// Targets fans the migration out to every listed database
+	// +optional
+	Targets []MigrationTarget `json:"targets,omitempty"`
+	// TargetSelector fans the migration out to every Database of the namespace matching the selector
+	// +optional
+	TargetSelector *metav1.LabelSelector `json:"targetSelector,omitempty"`
+	// Rollout paces the runs of a migration having several targets
+	// +optional
+	Rollout RolloutStrategy `json:"rollout,omitempty"`
+	SQL     SQLSpec         `json:"sql"`
+	// Image is the flyway image running the migration, defaults to flyway/flyway
+	// +optional
+	Image string `json:"image,omitempty"`
+	// DependsOn holds the migration back until the referenced migrations succeeded
+	// +optional
+	DependsOn []MigrationReference `json:"dependsOn,omitempty"`
+	// Schedule only starts runs inside maintenance windows
+	// +optional
+	Schedule *ScheduleSpec `json:"schedule,omitempty"`
+	// History locates the flyway schema history table the applied state is read from
+	// +optional
+	History HistorySpec `json:"history,omitempty"`
+	// DriftDetection periodically compares the schema history of succeeded migrations with their scripts
+	// +optional
+	DriftDetection *DriftDetectionSpec `json:"driftDetection,omitempty"`
+	// TargetVersion is the schema version to migrate to, the latest one when empty.
+	// A version below the applied one rolls the schema back.
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+([._][0-9]+)*$`
+	TargetVersion string `json:"target,omitempty"`
+	// Rollback defines how the schema moves back to a target below the applied version
+	// +optional
+	Rollback RollbackSpec `json:"rollback,omitempty"`
+	// Backup dumps or snapshots every database before its run starts
+	// +optional
+	Backup *BackupSpec `json:"backup,omitempty"`
+	// OnFailure is what happens to a database whose run failed, restore requires a backup
+	// +optional
+	OnFailure OnFailurePolicy `json:"onFailure,omitempty"`
+	// Notifications are sent when a rollout starts and ends, on top of the notification policies of the namespace
+	// +optional
+	Notifications *NotificationSpec `json:"notifications,omitempty"`
+	// Suspend stops starting runs, the running ones complete
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+	// RequireApproval holds every rollout until the migration is annotated as approved
+	// +optional
+	RequireApproval bool `json:"requireApproval,omitempty"`
+}
+
+// OnFailurePolicy is what happens to a database whose run failed
+// +kubebuilder:validation:Enum=fail;restore
+type OnFailurePolicy string
+
+const (
+	// OnFailureFail leaves the database as the failed run left it
+	OnFailureFail OnFailurePolicy = "fail"
+	// OnFailureRestore restores the database from the backup taken before the run
+	OnFailureRestore OnFailurePolicy = "restore"
+)
+
+// BackupSpec defines where the backup taken before each run is stored, exactly one destination must be set
+type BackupSpec struct {
+	// VolumeClaim receives the dumps of the databases
+	// +optional
+	VolumeClaim string `json:"volumeClaim,omitempty"`
+	// ObjectStorage receives the dumps of the databases
+	// +optional
+	ObjectStorage *ObjectStorageSpec `json:"objectStorage,omitempty"`
+	// VolumeSnapshot snapshots the volume of a database running in the cluster instead of dumping it
+	// +optional
+	VolumeSnapshot *VolumeSnapshotSpec `json:"volumeSnapshot,omitempty"`
+	// Image running the dump, defaults to the client image of the driver
+	// +optional
+	Image string `json:"image,omitempty"`
+	// KeepLast is the number of backups kept per target, defaults to 5
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	KeepLast int32 `json:"keepLast,omitempty"`
+}
+
+// ObjectStorageSpec locates an S3 compatible bucket
+type ObjectStorageSpec struct {
+	// URL of the bucket and prefix, such as s3://backups/flyway
+	URL string `json:"url"`
+	// Endpoint of the S3 compatible storage, AWS when empty
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// Secret holding the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY of the bucket
+	// +optional
+	Secret string `json:"secret,omitempty"`
+}
+
+// VolumeSnapshotSpec snapshots the persistent volume claim of an in-cluster database
+type VolumeSnapshotSpec struct {
+	// ClaimName of the database volume in the namespace of the migration
+	ClaimName string `json:"claimName"`
+	// ClassName of the volume snapshots, the default class when empty
+	// +optional
+	ClassName string `json:"className,omitempty"`
+}
+
+// RollbackSpec defines how versions above the target are reverted. Flyway undo runs when every
+// version has an undo script and Flyway Teams is available, the compensating scripts are executed otherwise.
+type RollbackSpec struct {
+	// AllowDowngrade must be set for the operator to move the schema backwards
+	// +optional
+	AllowDowngrade bool `json:"allowDowngrade,omitempty"`
+	// CompensatingScripts is the directory of the scripts location holding the compensating scripts,
+	// named after the version they revert such as C3__drop_orders.sql
+	// +optional
+	CompensatingScripts string `json:"compensatingScripts,omitempty"`
+	// LicenseKey of Flyway Teams, which flyway undo requires unless the image is a redgate/flyway edition
+	// +optional
+	LicenseKey *corev1.SecretKeySelector `json:"licenseKey,omitempty"`
+}
+
+// HistorySpec locates the flyway schema history table and sizes its report
+type HistorySpec struct {
+	// Table defaults to flyway_schema_history
+	// +optional
+	Table string `json:"table,omitempty"`
+	// Schema holding the table, defaults to the default schema of the connection
+	// +optional
+	Schema string `json:"schema,omitempty"`
+	// Limit is the number of latest applied scripts reported, defaults to 10
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	Limit int32 `json:"limit,omitempty"`
+	// RunLimit is the number of MigrationRuns kept per target, defaults to 20
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	RunLimit int32 `json:"runLimit,omitempty"`
+}
+
+// ScheduleSpec defines the maintenance windows runs may start in
+type ScheduleSpec struct {
+	// Windows are the cron expressions opening a window, in the standard five fields format
+	// +kubebuilder:validation:MinItems=1
+	Windows []string `json:"windows"`
+	// TimeZone of the cron expressions as an IANA name, defaults to UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+	// Duration is how long each window stays open
+	Duration metav1.Duration `json:"duration"`
+	// HardStop stops the flyway jobs still running when their window closes
+	// +optional
+	HardStop bool `json:"hardStop,omitempty"`
+}
+
+// DriftDetectionSpec defines the periodic check of the applied scripts against the recorded revision
+type DriftDetectionSpec struct {
+	// Interval between two checks
+	Interval metav1.Duration `json:"interval"`
+	// Events emits a warning event when drift is first detected
+	// +optional
+	Events bool `json:"events,omitempty"`
+}
+
+// MigrationReference points to a migration another one depends on
+type MigrationReference struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the dependent migration
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// MinVersion is the schema version the referenced migration must have reached
+	// +optional
+	MinVersion string `json:"minVersion,omitempty"`
+}
+
+// MigrationTarget is one of the databases a migration fans out to
+type MigrationTarget struct {
+	// Name identifies the target in the status and names its flyway job
+	Name string `json:"name"`
+	// DatabaseRef points to the database of the target instead of the inline connection
+	// +optional
+	DatabaseRef *DatabaseReference `json:"databaseRef,omitempty"`
+	DBSpec      `json:",inline"`
+}
+
+// RolloutFailurePolicy tells what happens to the remaining targets once one of them failed
+type RolloutFailurePolicy string
+
+const (
+	// RolloutStop starts no more targets after a failure
+	RolloutStop RolloutFailurePolicy = "Stop"
+	// RolloutContinue migrates the remaining targets regardless of failures
+	RolloutContinue RolloutFailurePolicy = "Continue"
+)
+
+// RolloutStrategy paces the runs of a migration across its targets
+type RolloutStrategy struct {
+	// MaxParallel caps the number of targets migrated at the same time, unlimited when unset
+	// +optional
+	MaxParallel int32 `json:"maxParallel,omitempty"`
+	// WaveSize splits the targets, in order, in waves started once the previous wave is over, a single wave when unset
+	// +optional
+	WaveSize int32 `json:"waveSize,omitempty"`
+	// FailurePolicy defaults to Stop
+	// +optional
+	// +kubebuilder:validation:Enum=Stop;Continue
+	FailurePolicy RolloutFailurePolicy `json:"failurePolicy,omitempty"`
 }
 
 type DBSpec struct {
-	Host   string     `json:"host"`
-	Port   int32      `json:"port"`
-	DBName string     `json:"dbName"`
-	Secret SecretSpec `json:"secret,omitempty"`
-	Vault  VaultSpec  `json:"vault,omitempty"`
-	Driver string     `json:"driver"`
+	// +optional
+	Host string `json:"host,omitempty"`
+	// Port defaults to the standard port of the driver database
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// +optional
+	DBName string      `json:"dbName,omitempty"`
+	Secret *SecretSpec `json:"secret,omitempty"`
+	Vault  *VaultSpec  `json:"vault,omitempty"`
+	// RDSIAM connects with RDS IAM authentication tokens instead of a password, over SSL verified with the RDS CA bundle of the operator
+	// +optional
+	RDSIAM *RDSIAMSpec `json:"rdsIAM,omitempty"`
+	// +optional
+	Driver string `json:"driver,omitempty"`
+	// AdvisoryLock holds a database level lock for the whole run, serializing the runs of operators
+	// from other clusters sharing the database
+	// +optional
+	AdvisoryLock bool `json:"advisoryLock,omitempty"`
+	// Connectivity reaches databases which are not directly reachable from the cluster
+	// +optional
+	Connectivity *ConnectivitySpec `json:"connectivity,omitempty"`
+	// SSHTunnel reaches the database through an SSH bastion
+	// +optional
+	SSHTunnel *SSHTunnelSpec `json:"sshTunnel,omitempty"`
+}
+
+// cloudSQLProxy returns the Cloud SQL Auth Proxy the database is reached through, if any
+func (s *DBSpec) cloudSQLProxy() *CloudSQLProxySpec {
+	if s.Connectivity == nil {
+		return nil
+	}
+	return s.Connectivity.CloudSQLProxy
+}
+
+// isEmpty tells whether no inline connection is set
+func (s *DBSpec) isEmpty() bool {
+	return s.Host == "" && s.Driver == ""
 }
 
 type SecretSpec struct {
-	Name        string `json:"name"`
-	UserKey     string `json:"userKey"`
-	PasswordKey string `json:"passwordKey"`
+	Name string `json:"name"`
+	// Namespace of the secret, only honoured on cluster databases
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// +optional
+	UserKey string `json:"userKey,omitempty"`
+	// +optional
+	PasswordKey string `json:"passwordKey,omitempty"`
 }
 
 type VaultSpec struct {
 }
 
+// RDSIAMSpec generates the RDS IAM authentication tokens of a database user,
+// signed with the AWS credentials of the operator from IRSA or EKS pod identity
+type RDSIAMSpec struct {
+	// User is the database user granted the rds_iam role
+	User string `json:"user"`
+	// Region of the database, defaults to the AWS_REGION of the operator
+	// +optional
+	Region string `json:"region,omitempty"`
+}
+
+type ConnectivitySpec struct {
+	// CloudSQLProxy reaches a Google Cloud SQL instance through the Cloud SQL Auth Proxy
+	// +optional
+	CloudSQLProxy *CloudSQLProxySpec `json:"cloudSQLProxy,omitempty"`
+}
+
+// CloudSQLProxySpec runs the Cloud SQL Auth Proxy next to the pods connecting to the database, which connect to it
+// on localhost. The operator connects the same way as the proxy, with the Google credentials of its workload identity.
+// The proxy runs as a native sidecar container, which requires Kubernetes 1.29 or later.
+type CloudSQLProxySpec struct {
+	// Instance is the connection name of the instance, project:region:instance
+	Instance string `json:"instance"`
+	// IAMUser connects as an IAM database user instead of with credentials, the name of a service account
+	// user is its email without the .gserviceaccount.com suffix
+	// +optional
+	IAMUser string `json:"iamUser,omitempty"`
+	// PrivateIP connects to the private IP of the instance instead of its public IP
+	// +optional
+	PrivateIP bool `json:"privateIP,omitempty"`
+	// ServiceAccountName runs the pods with the Kubernetes service account bound to the Google service account
+	// allowed to connect, the default service account of the namespace otherwise
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// Image of the proxy, defaults to the Cloud SQL Auth Proxy v2 image
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// SSHTunnelSpec forwards the connections to the database through an SSH bastion. The operator opens the tunnel itself,
+// the pods connect on localhost to a tunnel sidecar, which runs as a native sidecar container requiring Kubernetes 1.29 or later.
+type SSHTunnelSpec struct {
+	// Host of the bastion
+	Host string `json:"host"`
+	// Port of the bastion, defaults to 22
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// User the bastion authenticates
+	User string `json:"user"`
+	// KeySecret is the secret holding the private key of the user under ssh-privatekey, like kubernetes.io/ssh-auth
+	// secrets do, in the namespace of the pods connecting
+	KeySecret string `json:"keySecret"`
+	// KeySecretNamespace is the namespace of the key secret of a cluster database, the operator copies the secret
+	// in the namespaces of the migrations connecting. Other databases read it from their own namespace.
+	// +optional
+	KeySecretNamespace string `json:"keySecretNamespace,omitempty"`
+	// KnownHosts are the host keys of the bastion, in the known_hosts format. The tunnel is refused when the bastion
+	// presents another key.
+	KnownHosts string `json:"knownHosts"`
+	// Image of the tunnel sidecar, which needs a shell and the OpenSSH client
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
 type SQLSpec struct {
-	Git         GitMigrationSpec `json:"fromGit,omitempty"`
-	VolumeClaim string           `json:"fromVolumeClaim,omitempty"`
-	Path        string           `json:"path"`
+	Git         *GitMigrationSpec `json:"fromGit,omitempty"`
+	VolumeClaim string            `json:"fromVolumeClaim,omitempty"`
+	Path        string            `json:"path"`
 }
 
 type GitMigrationSpec struct {
 	CheckoutURL string `json:"checkoutUrl"`
 	Branch      string `json:"branch"`
-	Secret      string `json:"secret"`
+	// Secret holds the private key under id_rsa and the host keys of the git server, in the known_hosts format, under known_hosts
+	Secret string `json:"secret"`
+	// StrictHostKeyChecking has the jobs clone the scripts from a git server presenting one of the known host keys of the secret
+	// +optional
+	StrictHostKeyChecking bool `json:"strictHostKeyChecking,omitempty"`
+	// PollInterval opts in following the branch, a new run starts whenever its head moves
+	// +optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+}
+
+// MigrationPhase is the lifecycle step of the latest migration run
+type MigrationPhase string
+
+const (
+	MigrationPending          MigrationPhase = "Pending"
+	MigrationBlocked          MigrationPhase = "Blocked"
+	MigrationQueued           MigrationPhase = "Queued"
+	MigrationWaitingForWindow MigrationPhase = "WaitingForWindow"
+	MigrationRunning          MigrationPhase = "Running"
+	MigrationSucceeded        MigrationPhase = "Succeeded"
+	MigrationFailed           MigrationPhase = "Failed"
+	// MigrationRolledBack is a failed run whose database was restored from its backup
+	MigrationRolledBack MigrationPhase = "RolledBack"
+	// MigrationSuspended and MigrationAwaitingApproval hold rollouts that have not started
+	MigrationSuspended        MigrationPhase = "Suspended"
+	MigrationAwaitingApproval MigrationPhase = "AwaitingApproval"
+)
+
+// MigrationConditionType is a kind of condition reported on a migration
+type MigrationConditionType string
+
+const (
+	// ConditionReady tells whether the migration could be reconciled into a flyway job
+	ConditionReady MigrationConditionType = "Ready"
+	// ConditionWindowExceeded tells whether runs were still going when their maintenance window closed
+	ConditionWindowExceeded MigrationConditionType = "WindowExceeded"
+	// ConditionSchemaDrift tells whether the applied scripts differ from the scripts at the recorded revision
+	ConditionSchemaDrift MigrationConditionType = "SchemaDrift"
+)
+
+// MigrationCondition describes the state of a migration at a certain point
+type MigrationCondition struct {
+	Type   MigrationConditionType `json:"type"`
+	Status corev1.ConditionStatus `json:"status"`
+	// Reason is a CamelCase code for the last transition
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// TargetStatus is the outcome of the latest run on one target
+type TargetStatus struct {
+	Name string `json:"name"`
+	// +optional
+	Phase MigrationPhase `json:"phase,omitempty"`
+	// Job is the flyway job migrating the target
+	// +optional
+	Job string `json:"job,omitempty"`
+	// Run is the MigrationRun recording the latest run
+	// +optional
+	Run string `json:"run,omitempty"`
+	// Runs is the number of runs started on the target
+	// +optional
+	Runs int32 `json:"runs,omitempty"`
+	// QueuePosition is the rank of the target among the runs waiting for its database
+	// +optional
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+	// WaitingSince is when the database of the target was first found unreachable by the run about to start
+	// +optional
+	WaitingSince *metav1.Time `json:"waitingSince,omitempty"`
+	// +optional
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	// +optional
+	PendingMigrations int32 `json:"pendingMigrations,omitempty"`
+	// Message holds the flyway error of a failed run
+	// +optional
+	Message string `json:"message,omitempty"`
+	// AppliedScripts are the latest entries of the schema history table, the most recent first
+	// +optional
+	AppliedScripts []AppliedScript `json:"appliedScripts,omitempty"`
+	// FailedScripts are the failed entries of the schema history table
+	// +optional
+	FailedScripts []AppliedScript `json:"failedScripts,omitempty"`
+	// Target is the version the latest run migrated to, empty for the latest version
+	// +optional
+	Target string `json:"target,omitempty"`
+	// RollbackSteps are the versions reverted by the latest run, the most recent first
+	// +optional
+	RollbackSteps []RollbackStep `json:"rollbackSteps,omitempty"`
+	// Backup is the backup taken before the latest run
+	// +optional
+	Backup *BackupStatus `json:"backup,omitempty"`
+	// Restore is the restore of the backup once the latest run failed, the flyway error stays in message
+	// +optional
+	Restore *BackupStatus `json:"restore,omitempty"`
+}
+
+// RollbackMethod is how a version is reverted
+type RollbackMethod string
+
+const (
+	// RollbackUndo runs flyway undo with the undo scripts
+	RollbackUndo RollbackMethod = "Undo"
+	// RollbackCompensate executes the compensating script and removes the version from the schema history
+	RollbackCompensate RollbackMethod = "Compensate"
+)
+
+// RollbackStep records the revert of one version
+type RollbackStep struct {
+	Version string         `json:"version"`
+	Method  RollbackMethod `json:"method"`
+	// Script is the path of the undo or compensating script in the scripts location
+	Script string         `json:"script"`
+	Phase  MigrationPhase `json:"phase"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
+}
+
+// BackupStatus is the backup taken before the latest run of a target
+type BackupStatus struct {
+	// Name of the backup job or volume snapshot
+	Name  string         `json:"name"`
+	Phase MigrationPhase `json:"phase"`
+	// Location of the dump or name of the volume snapshot
+	// +optional
+	Location string `json:"location,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+// AppliedScript is an entry of the flyway schema history table
+type AppliedScript struct {
+	InstalledRank int32 `json:"installedRank"`
+	// +optional
+	Version string `json:"version,omitempty"`
+	// +optional
+	Description string `json:"description,omitempty"`
+	// Type is the kind of script, such as SQL, UNDO_SQL or BASELINE
+	// +optional
+	Type   string `json:"type,omitempty"`
+	Script string `json:"script"`
+	// +optional
+	Checksum *int32 `json:"checksum,omitempty"`
+	// +optional
+	InstalledBy string `json:"installedBy,omitempty"`
+	// +optional
+	InstalledOn metav1.Time `json:"installedOn,omitempty"`
+	// ExecutionTime is the duration of the script in milliseconds
+	ExecutionTime int32 `json:"executionTime"`
+	Success       bool  `json:"success"`
 }
 
 // MigrationStatus defines the observed state of Migration
 type MigrationStatus struct {
-	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
+	// Phase of the latest migration run
+	// +optional
+	Phase MigrationPhase `json:"phase,omitempty"`
+	// CurrentVersion is the schema version reported by flyway after the latest run,
+	// the lowest one across targets
+	// +optional
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	// PendingMigrations is the number of scripts flyway has not applied yet, the highest one across targets
+	// +optional
+	PendingMigrations int32 `json:"pendingMigrations,omitempty"`
+	// QueuePosition is the best rank of the queued targets among the runs waiting for their database
+	// +optional
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+	// NextWindow is when the next maintenance window opens while runs wait for it
+	// +optional
+	NextWindow *metav1.Time `json:"nextWindow,omitempty"`
+	// WindowCloses is when the maintenance window of the current runs closes
+	// +optional
+	WindowCloses *metav1.Time `json:"windowCloses,omitempty"`
+	// Targets reports the run of every database the migration applies to
+	// +optional
+	Targets []TargetStatus `json:"targets,omitempty"`
+	// Revision is the last commit seen on the followed git branch
+	// +optional
+	Revision string `json:"revision,omitempty"`
+	// Trigger is what started the latest rollout
+	// +optional
+	Trigger *RunTrigger `json:"trigger,omitempty"`
+	// LastDriftCheck is when the schema history was last compared with the scripts
+	// +optional
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+	// Notifications records the latest delivery of every event to every sink
+	// +optional
+	Notifications []NotificationDelivery `json:"notifications,omitempty"`
+	// +optional
+	Conditions []MigrationCondition `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Driver",type=string,JSONPath=`.spec.db.driver`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.currentVersion`
 
 // Migration is the Schema for the migrations API
 type Migration struct {
@@ -0,0 +1,504 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/robfig/cron/v3"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+// log is for logging in this package.
+var migrationlog = logf.Log.WithName("migration-resource")
+
+func (r *Migration) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-migrations-flywayoperator-io-v1alpha1-migration,mutating=true,failurePolicy=fail,groups=migrations.flywayoperator.io,resources=migrations,verbs=create;update,versions=v1alpha1,name=mmigration.kb.io
+
+var _ webhook.Defaulter = &Migration{}
+
+// Default implements webhook.Defaulter so a webhook will be registered for the type
+func (r *Migration) Default() {
+	if r.Spec.Image == "" {
+		r.Spec.Image = DefaultImage
+	}
+	defaultDB(&r.Spec.DB)
+	defaultDatabaseRef(r.Spec.DatabaseRef)
+	for i := range r.Spec.Targets {
+		defaultDB(&r.Spec.Targets[i].DBSpec)
+		defaultDatabaseRef(r.Spec.Targets[i].DatabaseRef)
+	}
+	if r.Spec.Rollout.FailurePolicy == "" {
+		r.Spec.Rollout.FailurePolicy = RolloutStop
+	}
+	if r.Spec.History.Table == "" {
+		r.Spec.History.Table = DefaultHistoryTable
+	}
+	if r.Spec.History.Limit == 0 {
+		r.Spec.History.Limit = DefaultHistoryLimit
+	}
+	if r.Spec.History.RunLimit == 0 {
+		r.Spec.History.RunLimit = DefaultRunHistoryLimit
+	}
+	if backup := r.Spec.Backup; backup != nil && backup.KeepLast == 0 {
+		backup.KeepLast = DefaultBackupKeepLast
+	}
+	if r.Spec.OnFailure == "" {
+		r.Spec.OnFailure = OnFailureFail
+	}
+	defaultNotifications(r.Spec.Notifications)
+}
+
+func defaultNotifications(spec *NotificationSpec) {
+	if spec != nil && spec.Retries == nil {
+		retries := int32(DefaultNotificationRetries)
+		spec.Retries = &retries
+	}
+}
+
+func defaultDatabaseRef(ref *DatabaseReference) {
+	if ref != nil && ref.Kind == "" {
+		ref.Kind = DatabaseKind
+	}
+}
+
+func defaultDB(db *DBSpec) {
+	if port, ok := DriverPorts[db.Driver]; ok && db.Port == 0 {
+		db.Port = port
+	}
+	if secret := db.Secret; secret != nil {
+		if secret.UserKey == "" {
+			secret.UserKey = DefaultUserKey
+		}
+		if secret.PasswordKey == "" {
+			secret.PasswordKey = DefaultPasswordKey
+		}
+	}
+	if tunnel := db.SSHTunnel; tunnel != nil && tunnel.Port == 0 {
+		tunnel.Port = DefaultSSHPort
+	}
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-migrations-flywayoperator-io-v1alpha1-migration,mutating=false,failurePolicy=fail,groups=migrations.flywayoperator.io,resources=migrations,versions=v1alpha1,name=vmigration.kb.io
+
+var _ webhook.Validator = &Migration{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Migration) ValidateCreate() error {
+	migrationlog.Info("validate create", "name", r.Name)
+
+	return r.validateMigration(nil)
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Migration) ValidateUpdate(old runtime.Object) error {
+	migrationlog.Info("validate update", "name", r.Name)
+
+	return r.validateMigration(old.(*Migration))
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Migration) ValidateDelete() error {
+	return nil
+}
+
+func (r *Migration) validateMigration(old *Migration) error {
+	var allErrs field.ErrorList
+	allErrs = append(allErrs, r.validateTargets()...)
+	allErrs = append(allErrs, r.validateRollout()...)
+	allErrs = append(allErrs, r.validateSQL()...)
+	allErrs = append(allErrs, r.validateDependencies()...)
+	allErrs = append(allErrs, r.validateSchedule()...)
+	allErrs = append(allErrs, r.validateDriftDetection()...)
+	allErrs = append(allErrs, r.validateBackup()...)
+	if r.Spec.Notifications != nil {
+		allErrs = append(allErrs, validateNotifications(field.NewPath("spec").Child("notifications"), r.Spec.Notifications)...)
+	}
+	if old != nil {
+		allErrs = append(allErrs, r.validateImmutableFields(old)...)
+	}
+	if len(allErrs) == 0 {
+		return nil
+	}
+
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "Migration"},
+		r.Name, allErrs)
+}
+
+// validateTargets checks the migration applies to exactly one of db, databaseRef, targets or targetSelector
+func (r *Migration) validateTargets() field.ErrorList {
+	var allErrs field.ErrorList
+	path := field.NewPath("spec")
+
+	var sources []string
+	if !r.Spec.DB.isEmpty() {
+		sources = append(sources, "db")
+	}
+	if r.Spec.DatabaseRef != nil {
+		sources = append(sources, "databaseRef")
+	}
+	if len(r.Spec.Targets) > 0 {
+		sources = append(sources, "targets")
+	}
+	if r.Spec.TargetSelector != nil {
+		sources = append(sources, "targetSelector")
+	}
+	switch {
+	case len(sources) == 0:
+		return field.ErrorList{field.Required(path.Child("db"), "one of db, databaseRef, targets or targetSelector must be set")}
+	case len(sources) > 1:
+		return field.ErrorList{field.Forbidden(path.Child(sources[1]), fmt.Sprintf("%s are mutually exclusive", strings.Join(sources, " and ")))}
+	}
+
+	switch sources[0] {
+	case "db":
+		return validateDB(path.Child("db"), &r.Spec.DB)
+	case "databaseRef":
+		return validateDatabaseRef(path.Child("databaseRef"), r.Spec.DatabaseRef)
+	}
+	if r.Spec.TargetSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(r.Spec.TargetSelector); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("targetSelector"), r.Spec.TargetSelector, err.Error()))
+		}
+	}
+
+	names := map[string]bool{}
+	for i, target := range r.Spec.Targets {
+		targetPath := path.Child("targets").Index(i)
+		for _, msg := range validation.IsDNS1123Label(target.Name) {
+			allErrs = append(allErrs, field.Invalid(targetPath.Child("name"), target.Name, msg))
+		}
+		if names[target.Name] {
+			allErrs = append(allErrs, field.Duplicate(targetPath.Child("name"), target.Name))
+		}
+		names[target.Name] = true
+		if target.DatabaseRef == nil {
+			allErrs = append(allErrs, validateDB(targetPath, &target.DBSpec)...)
+		} else if !target.DBSpec.isEmpty() {
+			allErrs = append(allErrs, field.Forbidden(targetPath.Child("databaseRef"), "databaseRef and an inline database are mutually exclusive"))
+		} else {
+			allErrs = append(allErrs, validateDatabaseRef(targetPath.Child("databaseRef"), target.DatabaseRef)...)
+		}
+	}
+
+	return allErrs
+}
+
+func validateDB(path *field.Path, db *DBSpec) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if _, ok := DriverPorts[db.Driver]; !ok {
+		allErrs = append(allErrs, field.NotSupported(path.Child("driver"), db.Driver, supportedDrivers()))
+	}
+	var sources []string
+	if db.Secret != nil {
+		sources = append(sources, "secret")
+	}
+	if db.Vault != nil {
+		sources = append(sources, "vault")
+	}
+	if iam := db.RDSIAM; iam != nil {
+		sources = append(sources, "rdsIAM")
+		if iam.User == "" {
+			allErrs = append(allErrs, field.Required(path.Child("rdsIAM", "user"), "the database user must be set"))
+		}
+	}
+	if proxy := db.cloudSQLProxy(); proxy != nil {
+		proxyPath := path.Child("connectivity", "cloudSQLProxy")
+		if proxy.IAMUser != "" {
+			sources = append(sources, "connectivity.cloudSQLProxy.iamUser")
+		}
+		if parts := strings.Split(proxy.Instance, ":"); len(parts) < 3 {
+			allErrs = append(allErrs, field.Invalid(proxyPath.Child("instance"), proxy.Instance, "must be the connection name of the instance, project:region:instance"))
+		}
+	}
+	if len(sources) > 1 {
+		allErrs = append(allErrs, field.Forbidden(path.Child(sources[1]), fmt.Sprintf("%s credentials are mutually exclusive", strings.Join(sources, " and "))))
+	}
+	if tunnel := db.SSHTunnel; tunnel != nil {
+		tunnelPath := path.Child("sshTunnel")
+		for _, required := range []struct{ name, value string }{
+			{"host", tunnel.Host},
+			{"user", tunnel.User},
+			{"keySecret", tunnel.KeySecret},
+			{"knownHosts", tunnel.KnownHosts},
+		} {
+			if required.value == "" {
+				allErrs = append(allErrs, field.Required(tunnelPath.Child(required.name), "must be set"))
+			}
+		}
+		if tunnel.KeySecretNamespace != "" {
+			allErrs = append(allErrs, field.Forbidden(tunnelPath.Child("keySecretNamespace"), "only cluster databases read the key secret from another namespace"))
+		}
+		if db.cloudSQLProxy() != nil {
+			allErrs = append(allErrs, field.Forbidden(tunnelPath, "must not be set along with connectivity.cloudSQLProxy"))
+		}
+	}
+
+	return allErrs
+}
+
+func validateDatabaseRef(path *field.Path, ref *DatabaseReference) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if ref.Name == "" {
+		allErrs = append(allErrs, field.Required(path.Child("name"), "database name must be set"))
+	}
+	if ref.Kind != "" && ref.Kind != DatabaseKind && ref.Kind != ClusterDatabaseKind {
+		allErrs = append(allErrs, field.NotSupported(path.Child("kind"), ref.Kind, []string{DatabaseKind, ClusterDatabaseKind}))
+	}
+
+	return allErrs
+}
+
+func (r *Migration) validateRollout() field.ErrorList {
+	var allErrs field.ErrorList
+	path := field.NewPath("spec").Child("rollout")
+
+	if r.Spec.Rollout.MaxParallel < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("maxParallel"), r.Spec.Rollout.MaxParallel, "must not be negative"))
+	}
+	if r.Spec.Rollout.WaveSize < 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("waveSize"), r.Spec.Rollout.WaveSize, "must not be negative"))
+	}
+	switch r.Spec.Rollout.FailurePolicy {
+	case "", RolloutStop, RolloutContinue:
+	default:
+		allErrs = append(allErrs, field.NotSupported(path.Child("failurePolicy"), r.Spec.Rollout.FailurePolicy, []string{string(RolloutStop), string(RolloutContinue)}))
+	}
+
+	return allErrs
+}
+
+func (r *Migration) validateSQL() field.ErrorList {
+	path := field.NewPath("spec").Child("sql")
+
+	if r.Spec.SQL.Git != nil && r.Spec.SQL.VolumeClaim != "" {
+		return field.ErrorList{field.Forbidden(path.Child("fromVolumeClaim"), "fromGit and fromVolumeClaim are mutually exclusive")}
+	}
+	if r.Spec.SQL.Git == nil && r.Spec.SQL.VolumeClaim == "" {
+		return field.ErrorList{field.Required(path, "one of fromGit or fromVolumeClaim must be set")}
+	}
+
+	return nil
+}
+
+func (r *Migration) validateDependencies() field.ErrorList {
+	var allErrs field.ErrorList
+	path := field.NewPath("spec").Child("dependsOn")
+
+	for i, ref := range r.Spec.DependsOn {
+		if ref.Name == "" {
+			allErrs = append(allErrs, field.Required(path.Index(i).Child("name"), "dependency name must be set"))
+		} else if ref.Name == r.Name && (ref.Namespace == "" || ref.Namespace == r.Namespace) {
+			allErrs = append(allErrs, field.Invalid(path.Index(i), ref.Name, "a migration cannot depend on itself"))
+		}
+	}
+
+	return allErrs
+}
+
+func (r *Migration) validateSchedule() field.ErrorList {
+	schedule := r.Spec.Schedule
+	if schedule == nil {
+		return nil
+	}
+	var allErrs field.ErrorList
+	path := field.NewPath("spec").Child("schedule")
+
+	if len(schedule.Windows) == 0 {
+		allErrs = append(allErrs, field.Required(path.Child("windows"), "at least one window must be set"))
+	}
+	for i, window := range schedule.Windows {
+		if _, err := cron.ParseStandard(window); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("windows").Index(i), window, err.Error()))
+		}
+	}
+	if schedule.TimeZone != "" {
+		if _, err := time.LoadLocation(schedule.TimeZone); err != nil {
+			allErrs = append(allErrs, field.Invalid(path.Child("timeZone"), schedule.TimeZone, err.Error()))
+		}
+	}
+	if schedule.Duration.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(path.Child("duration"), schedule.Duration.Duration.String(), "must be positive"))
+	}
+
+	return allErrs
+}
+
+func (r *Migration) validateDriftDetection() field.ErrorList {
+	drift := r.Spec.DriftDetection
+	if drift == nil || drift.Interval.Duration > 0 {
+		return nil
+	}
+	path := field.NewPath("spec").Child("driftDetection").Child("interval")
+	return field.ErrorList{field.Invalid(path, drift.Interval.Duration.String(), "must be positive")}
+}
+
+func (r *Migration) validateBackup() field.ErrorList {
+	backup := r.Spec.Backup
+	if backup == nil {
+		if r.Spec.OnFailure == OnFailureRestore {
+			return field.ErrorList{field.Required(field.NewPath("spec").Child("backup"), "restoring failed runs requires a backup")}
+		}
+		return nil
+	}
+	var allErrs field.ErrorList
+	path := field.NewPath("spec").Child("backup")
+
+	var destinations []string
+	if backup.VolumeClaim != "" {
+		destinations = append(destinations, "volumeClaim")
+	}
+	if storage := backup.ObjectStorage; storage != nil {
+		destinations = append(destinations, "objectStorage")
+		if !strings.HasPrefix(storage.URL, "s3://") {
+			allErrs = append(allErrs, field.Invalid(path.Child("objectStorage", "url"), storage.URL, "must be an s3:// URL"))
+		}
+	}
+	if snapshot := backup.VolumeSnapshot; snapshot != nil {
+		destinations = append(destinations, "volumeSnapshot")
+		if snapshot.ClaimName == "" {
+			allErrs = append(allErrs, field.Required(path.Child("volumeSnapshot", "claimName"), "the claim of the database volume must be set"))
+		}
+		if r.Spec.OnFailure == OnFailureRestore {
+			allErrs = append(allErrs, field.Forbidden(field.NewPath("spec").Child("onFailure"), "volume snapshots are not restored automatically, dump the database instead"))
+		}
+	}
+	switch {
+	case len(destinations) == 0:
+		allErrs = append(allErrs, field.Required(path, "one of volumeClaim, objectStorage or volumeSnapshot must be set"))
+	case len(destinations) > 1:
+		allErrs = append(allErrs, field.Forbidden(path.Child(destinations[1]), fmt.Sprintf("%s are mutually exclusive", strings.Join(destinations, " and "))))
+	}
+
+	return allErrs
+}
+
+// validateNotifications checks the retries are bounded and every sink has a unique name, a single kind,
+// a single URL source and valid templates
+func validateNotifications(path *field.Path, spec *NotificationSpec) field.ErrorList {
+	var allErrs field.ErrorList
+	if retries := spec.Retries; retries != nil && (*retries < 0 || *retries > MaxNotificationRetries) {
+		allErrs = append(allErrs, field.Invalid(path.Child("retries"), *retries, fmt.Sprintf("must be between 0 and %d", MaxNotificationRetries)))
+	}
+	names := map[string]bool{}
+	for i, sink := range spec.Sinks {
+		sinkPath := path.Child("sinks").Index(i)
+		if sink.Name == "" {
+			allErrs = append(allErrs, field.Required(sinkPath.Child("name"), "sinks must be named"))
+		} else if names[sink.Name] {
+			allErrs = append(allErrs, field.Duplicate(sinkPath.Child("name"), sink.Name))
+		}
+		names[sink.Name] = true
+
+		var kinds []string
+		check := func(kind string, endpoint *SinkEndpoint, templates map[string]string) {
+			kinds = append(kinds, kind)
+			kindPath := sinkPath.Child(kind)
+			switch {
+			case endpoint.URL == "" && endpoint.URLSecret == nil:
+				allErrs = append(allErrs, field.Required(kindPath.Child("url"), "one of url or urlSecret must be set"))
+			case endpoint.URL != "" && endpoint.URLSecret != nil:
+				allErrs = append(allErrs, field.Forbidden(kindPath.Child("urlSecret"), "url and urlSecret are mutually exclusive"))
+			}
+			for name, text := range templates {
+				if _, err := template.New(name).Parse(text); err != nil {
+					allErrs = append(allErrs, field.Invalid(kindPath.Child(name), text, err.Error()))
+				}
+			}
+		}
+		if sink.Webhook != nil {
+			check("webhook", &sink.Webhook.SinkEndpoint, map[string]string{"body": sink.Webhook.Body})
+		}
+		if sink.Slack != nil {
+			check("slack", &sink.Slack.SinkEndpoint, map[string]string{"text": sink.Slack.Text})
+		}
+		if sink.Teams != nil {
+			check("teams", &sink.Teams.SinkEndpoint, map[string]string{"text": sink.Teams.Text})
+		}
+		if sink.CloudEvents != nil {
+			check("cloudEvents", &sink.CloudEvents.SinkEndpoint, nil)
+		}
+		switch {
+		case len(kinds) == 0:
+			allErrs = append(allErrs, field.Required(sinkPath, "one of webhook, slack, teams or cloudEvents must be set"))
+		case len(kinds) > 1:
+			allErrs = append(allErrs, field.Forbidden(sinkPath.Child(kinds[1]), fmt.Sprintf("%s are mutually exclusive", strings.Join(kinds, " and "))))
+		}
+	}
+	return allErrs
+}
+
+// validateImmutableFields forbids retargeting a migration once it has been successfully applied
+func (r *Migration) validateImmutableFields(old *Migration) field.ErrorList {
+	if old.Status.Phase != MigrationSucceeded {
+		return nil
+	}
+
+	var allErrs field.ErrorList
+	path := field.NewPath("spec").Child("db")
+	immutable := func(name string, value, oldValue interface{}) {
+		if value != oldValue {
+			allErrs = append(allErrs, field.Invalid(path.Child(name), value, fmt.Sprintf("field is immutable once the migration succeeded, was %v", oldValue)))
+		}
+	}
+	immutable("host", r.Spec.DB.Host, old.Spec.DB.Host)
+	immutable("port", r.Spec.DB.Port, old.Spec.DB.Port)
+	immutable("dbName", r.Spec.DB.DBName, old.Spec.DB.DBName)
+	immutable("driver", r.Spec.DB.Driver, old.Spec.DB.Driver)
+	// the databases the migration was applied to cannot be swapped either
+	immutableSpec := func(name string, value, oldValue interface{}) {
+		if !reflect.DeepEqual(value, oldValue) {
+			allErrs = append(allErrs, field.Invalid(field.NewPath("spec").Child(name), value, "field is immutable once the migration succeeded"))
+		}
+	}
+	immutableSpec("databaseRef", r.Spec.DatabaseRef, old.Spec.DatabaseRef)
+	immutableSpec("targets", r.Spec.Targets, old.Spec.Targets)
+	immutableSpec("targetSelector", r.Spec.TargetSelector, old.Spec.TargetSelector)
+
+	return allErrs
+}
+
+func supportedDrivers() []string {
+	drivers := make([]string, 0, len(DriverPorts))
+	for driver := range DriverPorts {
+		drivers = append(drivers, driver)
+	}
+	sort.Strings(drivers)
+	return drivers
+}
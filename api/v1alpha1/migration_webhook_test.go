@@ -0,0 +1,170 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestMigration() *Migration {
+	return &Migration{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+		Spec: MigrationSpec{
+			DB: DBSpec{
+				Host:   "orders.db",
+				DBName: "orders",
+				Driver: PostgresDriver,
+				Secret: &SecretSpec{Name: "orders"},
+			},
+			SQL: SQLSpec{VolumeClaim: "orders-scripts", Path: "sql"},
+		},
+	}
+}
+
+func TestMigrationDefault(t *testing.T) {
+	migration := newTestMigration()
+	migration.Spec.Notifications = &NotificationSpec{Sinks: []NotificationSink{{Name: "chat"}}}
+	migration.Default()
+
+	spec := migration.Spec
+	for name, check := range map[string]struct {
+		got, want interface{}
+	}{
+		"image":                {spec.Image, DefaultImage},
+		"port":                 {spec.DB.Port, int32(5432)},
+		"user key":             {spec.DB.Secret.UserKey, DefaultUserKey},
+		"password key":         {spec.DB.Secret.PasswordKey, DefaultPasswordKey},
+		"failure policy":       {spec.Rollout.FailurePolicy, RolloutStop},
+		"history table":        {spec.History.Table, DefaultHistoryTable},
+		"history limit":        {spec.History.Limit, int32(DefaultHistoryLimit)},
+		"run history limit":    {spec.History.RunLimit, int32(DefaultRunHistoryLimit)},
+		"on failure":           {spec.OnFailure, OnFailureFail},
+		"notification retries": {*spec.Notifications.Retries, int32(DefaultNotificationRetries)},
+	} {
+		if check.got != check.want {
+			t.Errorf("%s defaulted to %v, want %v", name, check.got, check.want)
+		}
+	}
+}
+
+func TestMigrationDefaultKeepsSetValues(t *testing.T) {
+	migration := newTestMigration()
+	migration.Spec.Image = "flyway/flyway:7"
+	migration.Spec.DB.Port = 6432
+	migration.Spec.DB.Secret.UserKey = "username"
+	migration.Default()
+
+	if migration.Spec.Image != "flyway/flyway:7" || migration.Spec.DB.Port != 6432 || migration.Spec.DB.Secret.UserKey != "username" {
+		t.Errorf("defaulting overwrote set values: %+v", migration.Spec)
+	}
+}
+
+func TestMigrationValidateCreate(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		mutate func(*Migration)
+		// field is the path of the rejected field, empty when the migration is valid
+		field string
+	}{
+		{name: "valid", mutate: func(*Migration) {}},
+		{name: "unknown driver", mutate: func(m *Migration) { m.Spec.DB.Driver = "com.example.Driver" }, field: "spec.db.driver"},
+		{name: "secret and vault", mutate: func(m *Migration) { m.Spec.DB.Vault = &VaultSpec{} }, field: "spec.db.vault"},
+		{name: "no scripts source", mutate: func(m *Migration) { m.Spec.SQL.VolumeClaim = "" }, field: "spec.sql"},
+		{name: "git and volume claim", mutate: func(m *Migration) {
+			m.Spec.SQL.Git = &GitMigrationSpec{CheckoutURL: "git@example.com:shop/orders.git", Branch: "main"}
+		}, field: "spec.sql.fromVolumeClaim"},
+		{name: "no target", mutate: func(m *Migration) { m.Spec.DB = DBSpec{} }, field: "spec.db"},
+		{name: "db and database ref", mutate: func(m *Migration) { m.Spec.DatabaseRef = &DatabaseReference{Name: "orders"} }, field: "spec.databaseRef"},
+		{name: "unbounded notification retries", mutate: func(m *Migration) {
+			retries := int32(MaxNotificationRetries + 1)
+			m.Spec.Notifications = &NotificationSpec{
+				Sinks:   []NotificationSink{{Name: "chat", Slack: &ChatSink{SinkEndpoint: SinkEndpoint{URL: "https://hooks.slack.com/services/x"}}}},
+				Retries: &retries,
+			}
+		}, field: "spec.notifications.retries"},
+		{name: "ssh tunnel key of another namespace", mutate: func(m *Migration) {
+			m.Spec.DB.SSHTunnel = &SSHTunnelSpec{Host: "bastion", User: "flyway", KeySecret: "bastion-key", KeySecretNamespace: "databases", KnownHosts: "bastion ssh-ed25519 AAAA"}
+		}, field: "spec.db.sshTunnel.keySecretNamespace"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			migration := newTestMigration()
+			test.mutate(migration)
+			migration.Default()
+			expectInvalidField(t, migration.ValidateCreate(), test.field)
+		})
+	}
+}
+
+func TestMigrationValidateUpdate(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		phase  MigrationPhase
+		old    func(*Migration)
+		mutate func(*Migration)
+		field  string
+	}{
+		{name: "retarget before success", phase: MigrationFailed, mutate: func(m *Migration) { m.Spec.DB.Host = "orders-v2.db" }},
+		{name: "change scripts after success", phase: MigrationSucceeded, mutate: func(m *Migration) { m.Spec.SQL.Path = "sql/v2" }},
+		{name: "host after success", phase: MigrationSucceeded, mutate: func(m *Migration) { m.Spec.DB.Host = "orders-v2.db" }, field: "spec.db.host"},
+		{name: "port after success", phase: MigrationSucceeded, mutate: func(m *Migration) { m.Spec.DB.Port = 6432 }, field: "spec.db.port"},
+		{name: "database after success", phase: MigrationSucceeded, mutate: func(m *Migration) { m.Spec.DB.DBName = "orders_v2" }, field: "spec.db.dbName"},
+		{name: "targets after success", phase: MigrationSucceeded, old: func(m *Migration) {
+			m.Spec.DB = DBSpec{}
+			m.Spec.Targets = []MigrationTarget{{Name: "eu", DatabaseRef: &DatabaseReference{Name: "orders-eu"}}}
+		}, mutate: func(m *Migration) { m.Spec.Targets[0].DatabaseRef.Name = "orders-us" }, field: "spec.targets"},
+		{name: "target selector after success", phase: MigrationSucceeded, old: func(m *Migration) {
+			m.Spec.DB = DBSpec{}
+			m.Spec.TargetSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "orders"}}
+		}, mutate: func(m *Migration) { m.Spec.TargetSelector.MatchLabels["app"] = "billing" }, field: "spec.targetSelector"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			old := newTestMigration()
+			if test.old != nil {
+				test.old(old)
+			}
+			old.Default()
+			old.Status.Phase = test.phase
+			migration := old.DeepCopy()
+			test.mutate(migration)
+			expectInvalidField(t, migration.ValidateUpdate(old), test.field)
+		})
+	}
+}
+
+// expectInvalidField checks the error rejects the field, or that there is no error when the field is empty
+func expectInvalidField(t *testing.T, err error, field string) {
+	t.Helper()
+	if field == "" {
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return
+	}
+	if !apierrors.IsInvalid(err) {
+		t.Fatalf("expected an invalid error on %s, got %v", field, err)
+	}
+	for _, cause := range err.(*apierrors.StatusError).ErrStatus.Details.Causes {
+		if cause.Field == field || strings.HasPrefix(cause.Field, field+".") || strings.HasPrefix(cause.Field, field+"[") {
+			return
+		}
+	}
+	t.Fatalf("expected an error on %s, got %v", field, err)
+}
@@ -0,0 +1,161 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultRunHistoryLimit is the number of MigrationRuns kept per target when none is set
+	DefaultRunHistoryLimit = 20
+)
+
+// RunCommand is what a run executed on its database
+// +kubebuilder:validation:Enum=migrate;undo;compensate
+type RunCommand string
+
+const (
+	RunMigrate RunCommand = "migrate"
+	RunUndo    RunCommand = "undo"
+	// RunCompensate executes the compensating scripts in a job with the sql client of the driver
+	RunCompensate RunCommand = "compensate"
+)
+
+// TriggerReason is what started a rollout
+// +kubebuilder:validation:Enum=Created;Rerun;TargetChanged;RevisionChanged
+type TriggerReason string
+
+const (
+	// TriggerCreated is the first rollout of a migration
+	TriggerCreated TriggerReason = "Created"
+	// TriggerRerun is a rollout requested with the rerun annotation
+	TriggerRerun TriggerReason = "Rerun"
+	// TriggerTargetChanged is a rollout to a new target version
+	TriggerTargetChanged TriggerReason = "TargetChanged"
+	// TriggerRevisionChanged is a rollout of a new commit of the followed branch
+	TriggerRevisionChanged TriggerReason = "RevisionChanged"
+)
+
+// RunTrigger records what started a rollout and who requested it
+type RunTrigger struct {
+	Reason TriggerReason `json:"reason"`
+	// User who requested the rollout, when known
+	// +optional
+	User string `json:"user,omitempty"`
+	// ApprovedBy is the approver of the rollout of a migration requiring approval
+	// +optional
+	ApprovedBy string `json:"approvedBy,omitempty"`
+	// Time the rollout was requested
+	Time metav1.Time `json:"time"`
+}
+
+// RunSource identifies the scripts a run executed
+type RunSource struct {
+	// Revision is the commit checked out from git, empty when the head of the branch was cloned
+	// +optional
+	Revision string `json:"revision,omitempty"`
+	// Branch the scripts were cloned from
+	// +optional
+	Branch string `json:"branch,omitempty"`
+	// ConfigMap holding the scripts
+	// +optional
+	ConfigMap string `json:"configMap,omitempty"`
+	// Digest is the sha256 of the scripts of the config map
+	// +optional
+	Digest string `json:"digest,omitempty"`
+}
+
+// MigrationRunSpec records what a run executed, it cannot be changed once created
+type MigrationRunSpec struct {
+	// Migration is the name of the migration the run belongs to
+	Migration string `json:"migration"`
+	// Target is the name of the migrated target
+	Target string `json:"target"`
+	// Database is the address of the migrated database
+	Database string     `json:"database"`
+	Command  RunCommand `json:"command"`
+	// Job is the flyway job of the run, empty for compensating scripts
+	// +optional
+	Job string `json:"job,omitempty"`
+	// TargetVersion is the version the run migrated to, empty for the latest version
+	// +optional
+	TargetVersion string     `json:"targetVersion,omitempty"`
+	Source        RunSource  `json:"source"`
+	Trigger       RunTrigger `json:"trigger"`
+	// MigrationSpec is the spec of the migration when the run started
+	MigrationSpec MigrationSpec `json:"migrationSpec"`
+}
+
+// MigrationRunStatus is the outcome of the run
+type MigrationRunStatus struct {
+	// +optional
+	Phase MigrationPhase `json:"phase,omitempty"`
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+	// InitialVersion is the schema version before the run
+	// +optional
+	InitialVersion string `json:"initialVersion,omitempty"`
+	// SchemaVersion is the schema version after the run
+	// +optional
+	SchemaVersion string `json:"schemaVersion,omitempty"`
+	// ScriptsApplied is the number of scripts flyway applied or undid
+	// +optional
+	ScriptsApplied int32 `json:"scriptsApplied,omitempty"`
+	// PendingMigrations is the number of scripts left to apply after the run
+	// +optional
+	PendingMigrations int32 `json:"pendingMigrations,omitempty"`
+	// Message holds the flyway error of a failed run
+	// +optional
+	Message string `json:"message,omitempty"`
+	// RollbackSteps are the versions the run reverted
+	// +optional
+	RollbackSteps []RollbackStep `json:"rollbackSteps,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Migration",type=string,JSONPath=`.spec.migration`
+// +kubebuilder:printcolumn:name="Target",type=string,JSONPath=`.spec.target`
+// +kubebuilder:printcolumn:name="Command",type=string,JSONPath=`.spec.command`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.schemaVersion`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// MigrationRun is the immutable record of one run of a migration on one of its targets
+type MigrationRun struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MigrationRunSpec   `json:"spec,omitempty"`
+	Status MigrationRunStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MigrationRunList contains a list of MigrationRun
+type MigrationRunList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []MigrationRun `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&MigrationRun{}, &MigrationRunList{})
+}
@@ -0,0 +1,58 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"reflect"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+func (r *MigrationRun) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=update,path=/validate-migrations-flywayoperator-io-v1alpha1-migrationrun,mutating=false,failurePolicy=fail,groups=migrations.flywayoperator.io,resources=migrationruns,versions=v1alpha1,name=vmigrationrun.kb.io
+
+var _ webhook.Validator = &MigrationRun{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *MigrationRun) ValidateCreate() error {
+	return nil
+}
+
+// ValidateUpdate forbids any change of the record of the run, the operator only writes its status
+func (r *MigrationRun) ValidateUpdate(old runtime.Object) error {
+	if reflect.DeepEqual(r.Spec, old.(*MigrationRun).Spec) {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "MigrationRun"},
+		r.Name, field.ErrorList{field.Forbidden(field.NewPath("spec"), "migration runs are immutable")})
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *MigrationRun) ValidateDelete() error {
+	return nil
+}
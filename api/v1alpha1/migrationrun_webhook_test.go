@@ -0,0 +1,68 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTestMigrationRun() *MigrationRun {
+	return &MigrationRun{
+		ObjectMeta: metav1.ObjectMeta{Name: "flyway-orders-1", Namespace: "shop"},
+		Spec: MigrationRunSpec{
+			Migration: "orders",
+			Target:    "orders",
+			Command:   RunMigrate,
+			Job:       "flyway-orders-1",
+			Trigger:   RunTrigger{Reason: TriggerRerun, User: "alice"},
+		},
+	}
+}
+
+func TestMigrationRunValidateUpdate(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		mutate func(*MigrationRun)
+		field  string
+	}{
+		{name: "status", mutate: func(r *MigrationRun) { r.Status.Phase = MigrationSucceeded }},
+		{name: "labels", mutate: func(r *MigrationRun) { r.ObjectMeta.Labels = map[string]string{"team": "orders"} }},
+		{name: "trigger user", mutate: func(r *MigrationRun) { r.Spec.Trigger.User = "mallory" }, field: "spec"},
+		{name: "approver", mutate: func(r *MigrationRun) { r.Spec.Trigger.ApprovedBy = "mallory" }, field: "spec"},
+		{name: "command", mutate: func(r *MigrationRun) { r.Spec.Command = RunUndo }, field: "spec"},
+		{name: "source", mutate: func(r *MigrationRun) { r.Spec.Source.Revision = "c0ffee" }, field: "spec"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			old := newTestMigrationRun()
+			run := old.DeepCopy()
+			test.mutate(run)
+			expectInvalidField(t, run.ValidateUpdate(old), test.field)
+		})
+	}
+}
+
+func TestMigrationRunValidateCreateAndDelete(t *testing.T) {
+	run := newTestMigrationRun()
+	if err := run.ValidateCreate(); err != nil {
+		t.Errorf("unexpected error on create: %v", err)
+	}
+	if err := run.ValidateDelete(); err != nil {
+		t.Errorf("unexpected error on delete: %v", err)
+	}
+}
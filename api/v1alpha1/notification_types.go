@@ -0,0 +1,156 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// DefaultNotificationRetries is the number of retries of a failed delivery when none is set
+	DefaultNotificationRetries = 3
+	// MaxNotificationRetries bounds the retries of a delivery so that a sink down does not hold its notifications for long
+	MaxNotificationRetries = 10
+)
+
+// NotificationEvent is a step of a rollout the sinks are notified of
+// +kubebuilder:validation:Enum=Started;Succeeded;Failed
+type NotificationEvent string
+
+const (
+	NotificationStarted   NotificationEvent = "Started"
+	NotificationSucceeded NotificationEvent = "Succeeded"
+	// NotificationFailed is also sent when the failed databases were restored
+	NotificationFailed NotificationEvent = "Failed"
+)
+
+// NotificationSpec lists the sinks notified of the rollouts of a migration
+type NotificationSpec struct {
+	// Events sent to the sinks, every event when empty
+	// +optional
+	Events []NotificationEvent `json:"events,omitempty"`
+	// +kubebuilder:validation:MinItems=1
+	Sinks []NotificationSink `json:"sinks"`
+	// Retries is the number of attempts made after a failed delivery, defaults to 3 and at most 10
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	Retries *int32 `json:"retries,omitempty"`
+}
+
+// NotificationSink is one destination of the notifications, exactly one kind of sink must be set
+type NotificationSink struct {
+	// Name identifies the sink in the delivery results
+	Name string `json:"name"`
+	// Webhook posts the notification to any HTTP endpoint
+	// +optional
+	Webhook *WebhookSink `json:"webhook,omitempty"`
+	// Slack posts a message to a Slack incoming webhook
+	// +optional
+	Slack *ChatSink `json:"slack,omitempty"`
+	// Teams posts a message card to a Microsoft Teams incoming webhook
+	// +optional
+	Teams *ChatSink `json:"teams,omitempty"`
+	// CloudEvents posts the notification as a CloudEvent in binary content mode
+	// +optional
+	CloudEvents *CloudEventsSink `json:"cloudEvents,omitempty"`
+}
+
+// SinkEndpoint is the URL a sink posts to, exactly one of url or urlSecret must be set
+type SinkEndpoint struct {
+	// +optional
+	URL string `json:"url,omitempty"`
+	// URLSecret reads the URL from a secret of the namespace, for the URLs embedding a token
+	// +optional
+	URLSecret *corev1.SecretKeySelector `json:"urlSecret,omitempty"`
+}
+
+// WebhookSink posts the notification to an HTTP endpoint
+type WebhookSink struct {
+	SinkEndpoint `json:",inline"`
+	// Headers added to the requests
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is a Go template of the request body executed with the notification,
+	// the notification as JSON when empty
+	// +optional
+	Body string `json:"body,omitempty"`
+}
+
+// ChatSink posts a message to a chat incoming webhook
+type ChatSink struct {
+	SinkEndpoint `json:",inline"`
+	// Text is a Go template of the message executed with the notification, a summary of the rollout when empty
+	// +optional
+	Text string `json:"text,omitempty"`
+}
+
+// CloudEventsSink posts the notification to a CloudEvents HTTP receiver
+type CloudEventsSink struct {
+	SinkEndpoint `json:",inline"`
+	// Source of the events, defaults to the path of the migration
+	// +optional
+	Source string `json:"source,omitempty"`
+}
+
+// NotificationDelivery is the outcome of the latest notification of an event to a sink
+type NotificationDelivery struct {
+	// Sink is the name of the sink, prefixed with the notification policy it comes from
+	Sink  string            `json:"sink"`
+	Event NotificationEvent `json:"event"`
+	// Phase is Succeeded once the sink accepted the notification, Failed once every attempt failed
+	Phase    MigrationPhase `json:"phase"`
+	Attempts int32          `json:"attempts"`
+	// Message holds the error of the last failed attempt
+	// +optional
+	Message string      `json:"message,omitempty"`
+	Time    metav1.Time `json:"time"`
+}
+
+// NotificationPolicySpec defines the notifications of every migration of the namespace
+type NotificationPolicySpec struct {
+	NotificationSpec `json:",inline"`
+}
+
+// +kubebuilder:object:root=true
+
+// NotificationPolicy is the Schema for the notificationpolicies API
+type NotificationPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec NotificationPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NotificationPolicyList contains a list of NotificationPolicy
+type NotificationPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NotificationPolicy `json:"items"`
+}
+
+// Default fills the retries left empty
+func (r *NotificationPolicy) Default() {
+	defaultNotifications(&r.Spec.NotificationSpec)
+}
+
+func init() {
+	SchemeBuilder.Register(&NotificationPolicy{}, &NotificationPolicyList{})
+}
@@ -0,0 +1,632 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"encoding/json"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"flyway-operator/api/v1alpha1"
+)
+
+const (
+	// VaultAnnotation keeps the connections of a v1alpha1 migration that had vault credentials, which v1beta1
+	// has no room for, so that converting it back restores them. It holds the JSON list of the connection paths.
+	VaultAnnotation = "migrations.flywayoperator.io/v1alpha1-vault"
+
+	vaultDBPath      = "db"
+	vaultTargetsPath = "targets/"
+)
+
+var _ conversion.Convertible = &Migration{}
+
+// ConvertTo converts the migration to the v1alpha1 hub
+func (src *Migration) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.Migration)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	var vaults []string
+	if value, ok := dst.ObjectMeta.Annotations[VaultAnnotation]; ok {
+		if err := json.Unmarshal([]byte(value), &vaults); err != nil {
+			return err
+		}
+		delete(dst.ObjectMeta.Annotations, VaultAnnotation)
+		if len(dst.ObjectMeta.Annotations) == 0 {
+			dst.ObjectMeta.Annotations = nil
+		}
+	}
+
+	dst.Spec = convertSpecTo(&src.Spec)
+	for _, path := range vaults {
+		if path == vaultDBPath {
+			dst.Spec.DB.Vault = &v1alpha1.VaultSpec{}
+			continue
+		}
+		for i := range dst.Spec.Targets {
+			if vaultTargetsPath+dst.Spec.Targets[i].Name == path {
+				dst.Spec.Targets[i].Vault = &v1alpha1.VaultSpec{}
+			}
+		}
+	}
+	dst.Status = convertStatusTo(&src.Status)
+	return nil
+}
+
+// ConvertFrom converts the v1alpha1 hub to this version
+func (dst *Migration) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.Migration)
+
+	src.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+	var vaults []string
+	dst.Spec = convertSpecFrom(&src.Spec, &vaults)
+	if len(vaults) > 0 {
+		value, err := json.Marshal(vaults)
+		if err != nil {
+			return err
+		}
+		if dst.ObjectMeta.Annotations == nil {
+			dst.ObjectMeta.Annotations = map[string]string{}
+		}
+		dst.ObjectMeta.Annotations[VaultAnnotation] = string(value)
+	}
+	dst.Status = convertStatusFrom(&src.Status)
+	return nil
+}
+
+func convertSpecTo(src *MigrationSpec) v1alpha1.MigrationSpec {
+	dst := v1alpha1.MigrationSpec{
+		Rollout: v1alpha1.RolloutStrategy{
+			MaxParallel:   src.Rollout.MaxParallel,
+			WaveSize:      src.Rollout.WaveSize,
+			FailurePolicy: v1alpha1.RolloutFailurePolicy(src.Rollout.FailurePolicy),
+		},
+		Image:           src.Image,
+		Schedule:        (*v1alpha1.ScheduleSpec)(src.Schedule.DeepCopy()),
+		History:         v1alpha1.HistorySpec(src.History),
+		DriftDetection:  (*v1alpha1.DriftDetectionSpec)(src.DriftDetection.DeepCopy()),
+		TargetVersion:   src.TargetVersion,
+		Rollback:        v1alpha1.RollbackSpec(src.Rollback),
+		OnFailure:       onFailureTo(src.OnFailure),
+		Suspend:         src.Suspend,
+		RequireApproval: src.RequireApproval,
+	}
+
+	// every member is converted, the discriminator only matters to the validation of this version
+	databases := &src.Databases
+	if databases.Inline != nil {
+		dst.DB = connectionTo(databases.Inline)
+	}
+	if databases.Reference != nil {
+		ref := v1alpha1.DatabaseReference(*databases.Reference)
+		dst.DatabaseRef = &ref
+	}
+	for _, target := range databases.Targets {
+		converted := v1alpha1.MigrationTarget{Name: target.Name}
+		if target.Inline != nil {
+			converted.DBSpec = connectionTo(target.Inline)
+		}
+		if target.Reference != nil {
+			ref := v1alpha1.DatabaseReference(*target.Reference)
+			converted.DatabaseRef = &ref
+		}
+		dst.Targets = append(dst.Targets, converted)
+	}
+	dst.TargetSelector = databases.Selector.DeepCopy()
+
+	dst.SQL.Path = src.Scripts.Path
+	if git := src.Scripts.Git; git != nil {
+		dst.SQL.Git = &v1alpha1.GitMigrationSpec{
+			CheckoutURL:           git.URL,
+			Branch:                git.Branch,
+			Secret:                git.SSHKeySecret,
+			StrictHostKeyChecking: git.StrictHostKeyChecking,
+			PollInterval:          git.PollInterval.DeepCopy(),
+		}
+	}
+	if configMap := src.Scripts.ConfigMap; configMap != nil {
+		dst.SQL.VolumeClaim = configMap.Name
+	}
+
+	for _, ref := range src.DependsOn {
+		dst.DependsOn = append(dst.DependsOn, v1alpha1.MigrationReference(ref))
+	}
+	if backup := src.Backup; backup != nil {
+		dst.Backup = &v1alpha1.BackupSpec{
+			ObjectStorage:  (*v1alpha1.ObjectStorageSpec)(backup.ObjectStorage.DeepCopy()),
+			VolumeSnapshot: (*v1alpha1.VolumeSnapshotSpec)(backup.VolumeSnapshot.DeepCopy()),
+			Image:          backup.Image,
+			KeepLast:       backup.KeepLast,
+		}
+		if backup.VolumeClaim != nil {
+			dst.Backup.VolumeClaim = backup.VolumeClaim.ClaimName
+		}
+	}
+	if notifications := src.Notifications; notifications != nil {
+		dst.Notifications = &v1alpha1.NotificationSpec{}
+		for _, event := range notifications.Events {
+			dst.Notifications.Events = append(dst.Notifications.Events, v1alpha1.NotificationEvent(event))
+		}
+		for i := range notifications.Sinks {
+			dst.Notifications.Sinks = append(dst.Notifications.Sinks, sinkTo(&notifications.Sinks[i]))
+		}
+		if notifications.Retries != nil {
+			retries := *notifications.Retries
+			dst.Notifications.Retries = &retries
+		}
+	}
+	return dst
+}
+
+func connectionTo(src *DatabaseConnection) v1alpha1.DBSpec {
+	dst := v1alpha1.DBSpec{
+		Host:         src.Host,
+		Port:         src.Port,
+		DBName:       src.Database,
+		Driver:       src.Driver,
+		AdvisoryLock: src.AdvisoryLock,
+	}
+	if credentials := src.Credentials; credentials != nil {
+		if credentials.Secret != nil {
+			secret := v1alpha1.SecretSpec(*credentials.Secret)
+			dst.Secret = &secret
+		}
+		if credentials.RDSIAM != nil {
+			iam := v1alpha1.RDSIAMSpec(*credentials.RDSIAM)
+			dst.RDSIAM = &iam
+		}
+	}
+	if connectivity := src.Connectivity; connectivity != nil {
+		// v1alpha1 only nests the cloud sql proxy under connectivity, the ssh tunnel is a field of its own
+		if connectivity.CloudSQLProxy != nil {
+			proxy := v1alpha1.CloudSQLProxySpec(*connectivity.CloudSQLProxy)
+			dst.Connectivity = &v1alpha1.ConnectivitySpec{CloudSQLProxy: &proxy}
+		}
+		if connectivity.SSHTunnel != nil {
+			tunnel := v1alpha1.SSHTunnelSpec(*connectivity.SSHTunnel)
+			dst.SSHTunnel = &tunnel
+		}
+	}
+	return dst
+}
+
+func onFailureTo(policy OnFailurePolicy) v1alpha1.OnFailurePolicy {
+	switch policy {
+	case OnFailureFail:
+		return v1alpha1.OnFailureFail
+	case OnFailureRestore:
+		return v1alpha1.OnFailureRestore
+	}
+	return v1alpha1.OnFailurePolicy(policy)
+}
+
+func sinkTo(src *NotificationSink) v1alpha1.NotificationSink {
+	dst := v1alpha1.NotificationSink{Name: src.Name}
+	if webhook := src.Webhook; webhook != nil {
+		dst.Webhook = &v1alpha1.WebhookSink{
+			SinkEndpoint: endpointTo(&webhook.SinkEndpoint),
+			Body:         webhook.Body,
+		}
+		for name, value := range webhook.Headers {
+			if dst.Webhook.Headers == nil {
+				dst.Webhook.Headers = map[string]string{}
+			}
+			dst.Webhook.Headers[name] = value
+		}
+	}
+	if slack := src.Slack; slack != nil {
+		dst.Slack = &v1alpha1.ChatSink{SinkEndpoint: endpointTo(&slack.SinkEndpoint), Text: slack.Text}
+	}
+	if teams := src.Teams; teams != nil {
+		dst.Teams = &v1alpha1.ChatSink{SinkEndpoint: endpointTo(&teams.SinkEndpoint), Text: teams.Text}
+	}
+	if cloudEvents := src.CloudEvents; cloudEvents != nil {
+		dst.CloudEvents = &v1alpha1.CloudEventsSink{SinkEndpoint: endpointTo(&cloudEvents.SinkEndpoint), Source: cloudEvents.Source}
+	}
+	return dst
+}
+
+func endpointTo(src *SinkEndpoint) v1alpha1.SinkEndpoint {
+	return v1alpha1.SinkEndpoint{URL: src.URL, URLSecret: src.URLSecret.DeepCopy()}
+}
+
+func convertSpecFrom(src *v1alpha1.MigrationSpec, vaults *[]string) MigrationSpec {
+	dst := MigrationSpec{
+		Rollout: RolloutStrategy{
+			MaxParallel:   src.Rollout.MaxParallel,
+			WaveSize:      src.Rollout.WaveSize,
+			FailurePolicy: RolloutFailurePolicy(src.Rollout.FailurePolicy),
+		},
+		Image:           src.Image,
+		Schedule:        (*ScheduleSpec)(src.Schedule.DeepCopy()),
+		History:         HistorySpec(src.History),
+		DriftDetection:  (*DriftDetectionSpec)(src.DriftDetection.DeepCopy()),
+		TargetVersion:   src.TargetVersion,
+		Rollback:        RollbackSpec(src.Rollback),
+		OnFailure:       onFailureFrom(src.OnFailure),
+		Suspend:         src.Suspend,
+		RequireApproval: src.RequireApproval,
+	}
+
+	// the discriminator is the member the v1alpha1 validation picks first, the other members are kept
+	// so that converting back is lossless
+	databases := &dst.Databases
+	if src.DB != (v1alpha1.DBSpec{}) {
+		databases.Type = DatabasesInline
+		databases.Inline = connectionFrom(&src.DB, vaultDBPath, vaults)
+	}
+	if src.DatabaseRef != nil {
+		ref := DatabaseReference(*src.DatabaseRef)
+		databases.Reference = &ref
+		setDatabasesType(databases, DatabasesReference)
+	}
+	for i := range src.Targets {
+		target := &src.Targets[i]
+		converted := MigrationTarget{Name: target.Name, Type: DatabasesInline}
+		if target.DBSpec != (v1alpha1.DBSpec{}) {
+			converted.Inline = connectionFrom(&target.DBSpec, vaultTargetsPath+target.Name, vaults)
+		}
+		if target.DatabaseRef != nil {
+			ref := DatabaseReference(*target.DatabaseRef)
+			converted.Type = DatabasesReference
+			converted.Reference = &ref
+		}
+		databases.Targets = append(databases.Targets, converted)
+	}
+	if len(src.Targets) > 0 {
+		setDatabasesType(databases, DatabasesTargets)
+	}
+	if src.TargetSelector != nil {
+		databases.Selector = src.TargetSelector.DeepCopy()
+		setDatabasesType(databases, DatabasesSelector)
+	}
+	setDatabasesType(databases, DatabasesInline)
+
+	dst.Scripts.Path = src.SQL.Path
+	if git := src.SQL.Git; git != nil {
+		dst.Scripts.Type = ScriptsGit
+		dst.Scripts.Git = &GitSource{
+			URL:                   git.CheckoutURL,
+			Branch:                git.Branch,
+			SSHKeySecret:          git.Secret,
+			StrictHostKeyChecking: git.StrictHostKeyChecking,
+			PollInterval:          git.PollInterval.DeepCopy(),
+		}
+	}
+	if src.SQL.VolumeClaim != "" {
+		dst.Scripts.ConfigMap = &ConfigMapSource{Name: src.SQL.VolumeClaim}
+	}
+	if dst.Scripts.Type == "" {
+		dst.Scripts.Type = ScriptsConfigMap
+	}
+
+	for _, ref := range src.DependsOn {
+		dst.DependsOn = append(dst.DependsOn, MigrationReference(ref))
+	}
+	if backup := src.Backup; backup != nil {
+		dst.Backup = &BackupSpec{
+			ObjectStorage:  (*ObjectStorageSpec)(backup.ObjectStorage.DeepCopy()),
+			VolumeSnapshot: (*VolumeSnapshotSpec)(backup.VolumeSnapshot.DeepCopy()),
+			Image:          backup.Image,
+			KeepLast:       backup.KeepLast,
+		}
+		if backup.VolumeClaim != "" {
+			dst.Backup.VolumeClaim = &VolumeClaimDestination{ClaimName: backup.VolumeClaim}
+		}
+		switch {
+		case dst.Backup.VolumeClaim != nil:
+			dst.Backup.Type = BackupVolumeClaim
+		case dst.Backup.ObjectStorage != nil:
+			dst.Backup.Type = BackupObjectStorage
+		case dst.Backup.VolumeSnapshot != nil:
+			dst.Backup.Type = BackupVolumeSnapshot
+		}
+	}
+	if notifications := src.Notifications; notifications != nil {
+		dst.Notifications = &NotificationSpec{}
+		for _, event := range notifications.Events {
+			dst.Notifications.Events = append(dst.Notifications.Events, NotificationEvent(event))
+		}
+		for i := range notifications.Sinks {
+			dst.Notifications.Sinks = append(dst.Notifications.Sinks, sinkFrom(&notifications.Sinks[i]))
+		}
+		if notifications.Retries != nil {
+			retries := *notifications.Retries
+			dst.Notifications.Retries = &retries
+		}
+	}
+	return dst
+}
+
+// setDatabasesType sets the discriminator unless a member picked before already did
+func setDatabasesType(databases *DatabasesSpec, databasesType DatabasesType) {
+	if databases.Type == "" {
+		databases.Type = databasesType
+	}
+}
+
+func connectionFrom(src *v1alpha1.DBSpec, path string, vaults *[]string) *DatabaseConnection {
+	dst := &DatabaseConnection{
+		Host:         src.Host,
+		Port:         src.Port,
+		Database:     src.DBName,
+		Driver:       src.Driver,
+		AdvisoryLock: src.AdvisoryLock,
+	}
+	if src.Secret != nil {
+		secret := SecretCredentials(*src.Secret)
+		dst.Credentials = &Credentials{Type: CredentialsSecret, Secret: &secret}
+	}
+	if src.RDSIAM != nil {
+		iam := RDSIAMCredentials(*src.RDSIAM)
+		if dst.Credentials == nil {
+			dst.Credentials = &Credentials{Type: CredentialsRDSIAM}
+		}
+		dst.Credentials.RDSIAM = &iam
+	}
+	if connectivity := src.Connectivity; connectivity != nil && connectivity.CloudSQLProxy != nil {
+		proxy := CloudSQLProxyConnectivity(*connectivity.CloudSQLProxy)
+		dst.Connectivity = &Connectivity{Type: ConnectivityCloudSQLProxy, CloudSQLProxy: &proxy}
+	}
+	if src.SSHTunnel != nil {
+		tunnel := SSHTunnelConnectivity(*src.SSHTunnel)
+		if dst.Connectivity == nil {
+			dst.Connectivity = &Connectivity{Type: ConnectivitySSHTunnel}
+		}
+		dst.Connectivity.SSHTunnel = &tunnel
+	}
+	if src.Vault != nil {
+		*vaults = append(*vaults, path)
+	}
+	return dst
+}
+
+func onFailureFrom(policy v1alpha1.OnFailurePolicy) OnFailurePolicy {
+	switch policy {
+	case v1alpha1.OnFailureFail:
+		return OnFailureFail
+	case v1alpha1.OnFailureRestore:
+		return OnFailureRestore
+	}
+	return OnFailurePolicy(policy)
+}
+
+func sinkFrom(src *v1alpha1.NotificationSink) NotificationSink {
+	dst := NotificationSink{Name: src.Name}
+	if webhook := src.Webhook; webhook != nil {
+		dst.Webhook = &WebhookSink{
+			SinkEndpoint: endpointFrom(&webhook.SinkEndpoint),
+			Body:         webhook.Body,
+		}
+		for name, value := range webhook.Headers {
+			if dst.Webhook.Headers == nil {
+				dst.Webhook.Headers = map[string]string{}
+			}
+			dst.Webhook.Headers[name] = value
+		}
+		dst.Type = SinkWebhook
+	}
+	if slack := src.Slack; slack != nil {
+		dst.Slack = &ChatSink{SinkEndpoint: endpointFrom(&slack.SinkEndpoint), Text: slack.Text}
+		setSinkType(&dst, SinkSlack)
+	}
+	if teams := src.Teams; teams != nil {
+		dst.Teams = &ChatSink{SinkEndpoint: endpointFrom(&teams.SinkEndpoint), Text: teams.Text}
+		setSinkType(&dst, SinkTeams)
+	}
+	if cloudEvents := src.CloudEvents; cloudEvents != nil {
+		dst.CloudEvents = &CloudEventsSink{SinkEndpoint: endpointFrom(&cloudEvents.SinkEndpoint), Source: cloudEvents.Source}
+		setSinkType(&dst, SinkCloudEvents)
+	}
+	return dst
+}
+
+func setSinkType(sink *NotificationSink, sinkType SinkType) {
+	if sink.Type == "" {
+		sink.Type = sinkType
+	}
+}
+
+func endpointFrom(src *v1alpha1.SinkEndpoint) SinkEndpoint {
+	return SinkEndpoint{URL: src.URL, URLSecret: src.URLSecret.DeepCopy()}
+}
+
+func convertStatusTo(src *MigrationStatus) v1alpha1.MigrationStatus {
+	dst := v1alpha1.MigrationStatus{
+		Phase:             v1alpha1.MigrationPhase(src.Phase),
+		CurrentVersion:    src.Schema.CurrentVersion,
+		PendingMigrations: src.Schema.PendingMigrations,
+		QueuePosition:     src.Rollout.QueuePosition,
+		NextWindow:        src.Rollout.NextWindow.DeepCopy(),
+		WindowCloses:      src.Rollout.WindowCloses.DeepCopy(),
+		Revision:          src.Rollout.Revision,
+		LastDriftCheck:    src.LastDriftCheck.DeepCopy(),
+	}
+	if trigger := src.Rollout.Trigger; trigger != nil {
+		dst.Trigger = &v1alpha1.RunTrigger{
+			Reason:     v1alpha1.TriggerReason(trigger.Reason),
+			User:       trigger.User,
+			ApprovedBy: trigger.ApprovedBy,
+			Time:       trigger.Time,
+		}
+	}
+	for i := range src.Targets {
+		target := &src.Targets[i]
+		converted := v1alpha1.TargetStatus{
+			Name:              target.Name,
+			Phase:             v1alpha1.MigrationPhase(target.Phase),
+			Job:               target.Job,
+			Run:               target.Run,
+			Runs:              target.Runs,
+			QueuePosition:     target.QueuePosition,
+			WaitingSince:      target.WaitingSince.DeepCopy(),
+			CurrentVersion:    target.Schema.CurrentVersion,
+			PendingMigrations: target.Schema.PendingMigrations,
+			Message:           target.Message,
+			Target:            target.TargetVersion,
+			Backup:            backupStatusTo(target.Backup),
+			Restore:           backupStatusTo(target.Restore),
+		}
+		for _, script := range target.AppliedScripts {
+			converted.AppliedScripts = append(converted.AppliedScripts, v1alpha1.AppliedScript(*script.DeepCopy()))
+		}
+		for _, script := range target.FailedScripts {
+			converted.FailedScripts = append(converted.FailedScripts, v1alpha1.AppliedScript(*script.DeepCopy()))
+		}
+		for _, step := range target.RollbackSteps {
+			converted.RollbackSteps = append(converted.RollbackSteps, v1alpha1.RollbackStep{
+				Version:    step.Version,
+				Method:     v1alpha1.RollbackMethod(step.Method),
+				Script:     step.Script,
+				Phase:      v1alpha1.MigrationPhase(step.Phase),
+				Message:    step.Message,
+				FinishedAt: step.FinishedAt.DeepCopy(),
+			})
+		}
+		dst.Targets = append(dst.Targets, converted)
+	}
+	for _, delivery := range src.Notifications {
+		dst.Notifications = append(dst.Notifications, v1alpha1.NotificationDelivery{
+			Sink:     delivery.Sink,
+			Event:    v1alpha1.NotificationEvent(delivery.Event),
+			Phase:    v1alpha1.MigrationPhase(delivery.Phase),
+			Attempts: delivery.Attempts,
+			Message:  delivery.Message,
+			Time:     delivery.Time,
+		})
+	}
+	for _, condition := range src.Conditions {
+		dst.Conditions = append(dst.Conditions, v1alpha1.MigrationCondition{
+			Type:               v1alpha1.MigrationConditionType(condition.Type),
+			Status:             condition.Status,
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+			LastTransitionTime: condition.LastTransitionTime,
+		})
+	}
+	return dst
+}
+
+func backupStatusTo(src *BackupStatus) *v1alpha1.BackupStatus {
+	if src == nil {
+		return nil
+	}
+	return &v1alpha1.BackupStatus{
+		Name:        src.Name,
+		Phase:       v1alpha1.MigrationPhase(src.Phase),
+		Location:    src.Location,
+		Message:     src.Message,
+		StartedAt:   src.StartedAt.DeepCopy(),
+		CompletedAt: src.CompletedAt.DeepCopy(),
+	}
+}
+
+func convertStatusFrom(src *v1alpha1.MigrationStatus) MigrationStatus {
+	dst := MigrationStatus{
+		Phase: MigrationPhase(src.Phase),
+		Schema: SchemaStatus{
+			CurrentVersion:    src.CurrentVersion,
+			PendingMigrations: src.PendingMigrations,
+		},
+		Rollout: RolloutStatus{
+			Revision:      src.Revision,
+			QueuePosition: src.QueuePosition,
+			NextWindow:    src.NextWindow.DeepCopy(),
+			WindowCloses:  src.WindowCloses.DeepCopy(),
+		},
+		LastDriftCheck: src.LastDriftCheck.DeepCopy(),
+	}
+	if trigger := src.Trigger; trigger != nil {
+		dst.Rollout.Trigger = &RunTrigger{
+			Reason:     string(trigger.Reason),
+			User:       trigger.User,
+			ApprovedBy: trigger.ApprovedBy,
+			Time:       trigger.Time,
+		}
+	}
+	for i := range src.Targets {
+		target := &src.Targets[i]
+		converted := TargetStatus{
+			Name:  target.Name,
+			Phase: MigrationPhase(target.Phase),
+			Schema: SchemaStatus{
+				CurrentVersion:    target.CurrentVersion,
+				PendingMigrations: target.PendingMigrations,
+			},
+			TargetVersion: target.Target,
+			Job:           target.Job,
+			Run:           target.Run,
+			Runs:          target.Runs,
+			QueuePosition: target.QueuePosition,
+			WaitingSince:  target.WaitingSince.DeepCopy(),
+			Message:       target.Message,
+			Backup:        backupStatusFrom(target.Backup),
+			Restore:       backupStatusFrom(target.Restore),
+		}
+		for _, script := range target.AppliedScripts {
+			converted.AppliedScripts = append(converted.AppliedScripts, AppliedScript(*script.DeepCopy()))
+		}
+		for _, script := range target.FailedScripts {
+			converted.FailedScripts = append(converted.FailedScripts, AppliedScript(*script.DeepCopy()))
+		}
+		for _, step := range target.RollbackSteps {
+			converted.RollbackSteps = append(converted.RollbackSteps, RollbackStep{
+				Version:    step.Version,
+				Method:     string(step.Method),
+				Script:     step.Script,
+				Phase:      MigrationPhase(step.Phase),
+				Message:    step.Message,
+				FinishedAt: step.FinishedAt.DeepCopy(),
+			})
+		}
+		dst.Targets = append(dst.Targets, converted)
+	}
+	for _, delivery := range src.Notifications {
+		dst.Notifications = append(dst.Notifications, NotificationDelivery{
+			Sink:     delivery.Sink,
+			Event:    NotificationEvent(delivery.Event),
+			Phase:    MigrationPhase(delivery.Phase),
+			Attempts: delivery.Attempts,
+			Message:  delivery.Message,
+			Time:     delivery.Time,
+		})
+	}
+	for _, condition := range src.Conditions {
+		dst.Conditions = append(dst.Conditions, MigrationCondition{
+			Type:               string(condition.Type),
+			Status:             condition.Status,
+			Reason:             condition.Reason,
+			Message:            condition.Message,
+			LastTransitionTime: condition.LastTransitionTime,
+		})
+	}
+	return dst
+}
+
+func backupStatusFrom(src *v1alpha1.BackupStatus) *BackupStatus {
+	if src == nil {
+		return nil
+	}
+	return &BackupStatus{
+		Name:        src.Name,
+		Phase:       MigrationPhase(src.Phase),
+		Location:    src.Location,
+		Message:     src.Message,
+		StartedAt:   src.StartedAt.DeepCopy(),
+		CompletedAt: src.CompletedAt.DeepCopy(),
+	}
+}
@@ -0,0 +1,271 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/diff"
+
+	"flyway-operator/api/v1alpha1"
+)
+
+var (
+	started   = metav1.NewTime(time.Date(2020, 3, 1, 10, 0, 0, 0, time.UTC))
+	completed = metav1.NewTime(time.Date(2020, 3, 1, 10, 5, 0, 0, time.UTC))
+)
+
+func newTestMigration(databases DatabasesSpec) *Migration {
+	retries := int32(2)
+	return &Migration{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop", Labels: map[string]string{"team": "orders"}},
+		Spec: MigrationSpec{
+			Databases: databases,
+			Scripts:   ScriptsSpec{Type: ScriptsConfigMap, ConfigMap: &ConfigMapSource{Name: "orders-scripts"}, Path: "sql"},
+			Rollout:   RolloutStrategy{MaxParallel: 2, WaveSize: 1, FailurePolicy: RolloutContinue},
+			Image:     "flyway/flyway:7",
+			DependsOn: []MigrationReference{{Name: "users", Namespace: "auth", MinVersion: "4"}},
+			Schedule:  &ScheduleSpec{Windows: []string{"0 2 * * *"}, TimeZone: "Europe/Paris", Duration: metav1.Duration{Duration: time.Hour}},
+			History:   HistorySpec{Table: "flyway_schema_history", Schema: "audit", Limit: 5, RunLimit: 3},
+			Rollback: RollbackSpec{
+				AllowDowngrade:      true,
+				CompensatingScripts: "sql/rollback",
+				LicenseKey:          &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "flyway-teams"}, Key: "key"},
+			},
+			DriftDetection:  &DriftDetectionSpec{Interval: metav1.Duration{Duration: time.Minute}, Events: true},
+			TargetVersion:   "5",
+			OnFailure:       OnFailureRestore,
+			Suspend:         true,
+			RequireApproval: true,
+			Notifications: &NotificationSpec{
+				Events:  []NotificationEvent{"Failed"},
+				Retries: &retries,
+				Sinks: []NotificationSink{{
+					Name: "hook", Type: SinkWebhook,
+					Webhook: &WebhookSink{SinkEndpoint: SinkEndpoint{URL: "https://hooks.example.com"}, Headers: map[string]string{"Authorization": "Bearer token"}, Body: "{{ .Event }}"},
+				}},
+			},
+		},
+	}
+}
+
+func newTestStatus() MigrationStatus {
+	return MigrationStatus{
+		Phase:  "Failed",
+		Schema: SchemaStatus{CurrentVersion: "4", PendingMigrations: 1},
+		Rollout: RolloutStatus{
+			Revision:      "c0ffee",
+			QueuePosition: 2,
+			NextWindow:    &completed,
+			WindowCloses:  &completed,
+			Trigger:       &RunTrigger{Reason: "Rerun", User: "alice", ApprovedBy: "bob", Time: started},
+		},
+		LastDriftCheck: &started,
+		Targets: []TargetStatus{{
+			Name:           "eu",
+			Phase:          "RolledBack",
+			Schema:         SchemaStatus{CurrentVersion: "4", PendingMigrations: 1},
+			TargetVersion:  "5",
+			Job:            "flyway-orders-eu-1",
+			Run:            "flyway-orders-eu-1",
+			Runs:           1,
+			QueuePosition:  1,
+			WaitingSince:   &started,
+			Message:        "V5 failed",
+			AppliedScripts: []AppliedScript{{InstalledRank: 4, Version: "4", Description: "add index", Type: "SQL", Script: "V4__add_index.sql", InstalledBy: "flyway", InstalledOn: started, ExecutionTime: 12, Success: true}},
+			FailedScripts:  []AppliedScript{{InstalledRank: 5, Version: "5", Script: "V5__drop.sql", InstalledOn: completed}},
+			RollbackSteps:  []RollbackStep{{Version: "5", Method: "Compensate", Script: "rollback/C5__drop.sql", Phase: "Succeeded", Message: "done", FinishedAt: &completed}},
+			Backup:         &BackupStatus{Name: "flyway-orders-eu-backup-1", Phase: "Succeeded", Location: "pvc://backups/eu.dump", StartedAt: &started, CompletedAt: &completed},
+			Restore:        &BackupStatus{Name: "flyway-orders-eu-restore-1", Phase: "Failed", Message: "the restore job failed", StartedAt: &started},
+		}},
+		Notifications: []NotificationDelivery{{Sink: "hook", Event: "Failed", Phase: "Succeeded", Attempts: 2, Message: "", Time: completed}},
+		Conditions:    []MigrationCondition{{Type: "Ready", Status: corev1.ConditionFalse, Reason: "DatabaseNotFound", Message: "database orders not found", LastTransitionTime: started}},
+	}
+}
+
+func secretCredentials() *Credentials {
+	return &Credentials{Type: CredentialsSecret, Secret: &SecretCredentials{Name: "orders", Namespace: "shop", UserKey: "user", PasswordKey: "password"}}
+}
+
+func TestConvertRoundTripFromV1beta1(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		mutate func(*Migration)
+	}{
+		{name: "inline database with secret credentials", mutate: func(*Migration) {}},
+		{name: "rds iam credentials", mutate: func(m *Migration) {
+			m.Spec.Databases.Inline.Credentials = &Credentials{Type: CredentialsRDSIAM, RDSIAM: &RDSIAMCredentials{User: "flyway", Region: "eu-west-1"}}
+		}},
+		{name: "cloud sql proxy", mutate: func(m *Migration) {
+			m.Spec.Databases.Inline.Connectivity = &Connectivity{Type: ConnectivityCloudSQLProxy, CloudSQLProxy: &CloudSQLProxyConnectivity{
+				Instance: "project:region:orders", IAMUser: "flyway@project.iam", PrivateIP: true, ServiceAccountName: "flyway", Image: "proxy:2",
+			}}
+		}},
+		{name: "ssh tunnel", mutate: func(m *Migration) {
+			m.Spec.Databases.Inline.Connectivity = &Connectivity{Type: ConnectivitySSHTunnel, SSHTunnel: &SSHTunnelConnectivity{
+				Host: "bastion", Port: 2222, User: "tunnel", KeySecret: "bastion-key", KnownHosts: "bastion ssh-ed25519 AAAA", Image: "ssh:1",
+			}}
+		}},
+		{name: "database reference", mutate: func(m *Migration) {
+			m.Spec.Databases = DatabasesSpec{Type: DatabasesReference, Reference: &DatabaseReference{Kind: "ClusterDatabase", Name: "orders"}}
+		}},
+		{name: "targets", mutate: func(m *Migration) {
+			m.Spec.Databases = DatabasesSpec{Type: DatabasesTargets, Targets: []MigrationTarget{
+				{Name: "eu", Type: DatabasesInline, Inline: &DatabaseConnection{Driver: v1alpha1.PostgresDriver, Host: "eu.db", Port: 5432, Database: "orders", Credentials: secretCredentials()}},
+				{Name: "us", Type: DatabasesReference, Reference: &DatabaseReference{Kind: "Database", Name: "orders-us"}},
+			}}
+		}},
+		{name: "selector", mutate: func(m *Migration) {
+			m.Spec.Databases = DatabasesSpec{Type: DatabasesSelector, Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "orders"}}}
+		}},
+		{name: "git scripts", mutate: func(m *Migration) {
+			m.Spec.Scripts = ScriptsSpec{Type: ScriptsGit, Path: "sql", Git: &GitSource{
+				URL: "git@example.com:shop/orders.git", Branch: "main", SSHKeySecret: "git-key", StrictHostKeyChecking: true,
+				PollInterval: &metav1.Duration{Duration: time.Minute},
+			}}
+		}},
+		{name: "volume claim backup", mutate: func(m *Migration) {
+			m.Spec.Backup = &BackupSpec{Type: BackupVolumeClaim, VolumeClaim: &VolumeClaimDestination{ClaimName: "backups"}, Image: "postgres:13", KeepLast: 3}
+		}},
+		{name: "object storage backup", mutate: func(m *Migration) {
+			m.Spec.Backup = &BackupSpec{Type: BackupObjectStorage, ObjectStorage: &ObjectStorageSpec{URL: "s3://backups/orders", Endpoint: "https://minio", Secret: "minio"}}
+		}},
+		{name: "volume snapshot backup", mutate: func(m *Migration) {
+			m.Spec.Backup = &BackupSpec{Type: BackupVolumeSnapshot, VolumeSnapshot: &VolumeSnapshotSpec{ClaimName: "orders-data", ClassName: "csi"}}
+		}},
+		{name: "every kind of sink", mutate: func(m *Migration) {
+			secret := &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "slack"}, Key: "url"}
+			m.Spec.Notifications.Sinks = append(m.Spec.Notifications.Sinks,
+				NotificationSink{Name: "slack", Type: SinkSlack, Slack: &ChatSink{SinkEndpoint: SinkEndpoint{URLSecret: secret}, Text: "{{ .Migration }}"}},
+				NotificationSink{Name: "teams", Type: SinkTeams, Teams: &ChatSink{SinkEndpoint: SinkEndpoint{URL: "https://teams.example.com"}}},
+				NotificationSink{Name: "events", Type: SinkCloudEvents, CloudEvents: &CloudEventsSink{SinkEndpoint: SinkEndpoint{URL: "https://broker"}, Source: "/orders"}},
+			)
+		}},
+		{name: "status", mutate: func(m *Migration) { m.Status = newTestStatus() }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			migration := newTestMigration(DatabasesSpec{Type: DatabasesInline, Inline: &DatabaseConnection{
+				Driver: v1alpha1.PostgresDriver, Host: "orders.db", Port: 5432, Database: "orders", AdvisoryLock: true, Credentials: secretCredentials(),
+			}})
+			test.mutate(migration)
+
+			var hub v1alpha1.Migration
+			if err := migration.ConvertTo(&hub); err != nil {
+				t.Fatal(err)
+			}
+			var converted Migration
+			if err := converted.ConvertFrom(&hub); err != nil {
+				t.Fatal(err)
+			}
+			if !equality.Semantic.DeepEqual(migration, &converted) {
+				t.Errorf("round trip through v1alpha1 changed the migration:\n%s", diff.ObjectReflectDiff(migration, &converted))
+			}
+		})
+	}
+}
+
+func TestConvertRoundTripFromV1alpha1(t *testing.T) {
+	newHub := func() *v1alpha1.Migration {
+		var hub v1alpha1.Migration
+		if err := newTestMigration(DatabasesSpec{Type: DatabasesInline, Inline: &DatabaseConnection{Driver: v1alpha1.PostgresDriver}}).ConvertTo(&hub); err != nil {
+			t.Fatal(err)
+		}
+		hub.Spec.DB = v1alpha1.DBSpec{Driver: v1alpha1.PostgresDriver, Host: "orders.db", Port: 5432, DBName: "orders", Secret: &v1alpha1.SecretSpec{Name: "orders"}}
+		return &hub
+	}
+
+	for _, test := range []struct {
+		name   string
+		mutate func(*v1alpha1.Migration)
+		// annotated tells whether the v1beta1 migration keeps the vault connections in an annotation
+		annotated bool
+	}{
+		{name: "inline database", mutate: func(*v1alpha1.Migration) {}},
+		{name: "vault credentials", annotated: true, mutate: func(m *v1alpha1.Migration) {
+			m.Spec.DB.Secret = nil
+			m.Spec.DB.Vault = &v1alpha1.VaultSpec{}
+		}},
+		{name: "vault credentials of a target", annotated: true, mutate: func(m *v1alpha1.Migration) {
+			m.Spec.DB = v1alpha1.DBSpec{}
+			m.Spec.Targets = []v1alpha1.MigrationTarget{
+				{Name: "eu", DBSpec: v1alpha1.DBSpec{Driver: v1alpha1.PostgresDriver, Host: "eu.db", Vault: &v1alpha1.VaultSpec{}}},
+				{Name: "us", DatabaseRef: &v1alpha1.DatabaseReference{Kind: "Database", Name: "orders-us"}},
+			}
+		}},
+		{name: "rds iam credentials", mutate: func(m *v1alpha1.Migration) {
+			m.Spec.DB.Secret = nil
+			m.Spec.DB.RDSIAM = &v1alpha1.RDSIAMSpec{User: "flyway", Region: "eu-west-1"}
+		}},
+		{name: "cloud sql proxy", mutate: func(m *v1alpha1.Migration) {
+			m.Spec.DB.Connectivity = &v1alpha1.ConnectivitySpec{CloudSQLProxy: &v1alpha1.CloudSQLProxySpec{Instance: "project:region:orders"}}
+		}},
+		{name: "ssh tunnel", mutate: func(m *v1alpha1.Migration) {
+			m.Spec.DB.SSHTunnel = &v1alpha1.SSHTunnelSpec{Host: "bastion", Port: 22, User: "tunnel", KeySecret: "bastion-key", KnownHosts: "bastion ssh-ed25519 AAAA"}
+		}},
+		{name: "database reference", mutate: func(m *v1alpha1.Migration) {
+			m.Spec.DB = v1alpha1.DBSpec{}
+			m.Spec.DatabaseRef = &v1alpha1.DatabaseReference{Kind: "Database", Name: "orders"}
+		}},
+		{name: "selector", mutate: func(m *v1alpha1.Migration) {
+			m.Spec.DB = v1alpha1.DBSpec{}
+			m.Spec.TargetSelector = &metav1.LabelSelector{MatchLabels: map[string]string{"app": "orders"}}
+		}},
+		{name: "git scripts", mutate: func(m *v1alpha1.Migration) {
+			m.Spec.SQL = v1alpha1.SQLSpec{Path: "sql", Git: &v1alpha1.GitMigrationSpec{CheckoutURL: "git@example.com:shop/orders.git", Branch: "main", Secret: "git-key"}}
+		}},
+		{name: "object storage backup", mutate: func(m *v1alpha1.Migration) {
+			m.Spec.Backup = &v1alpha1.BackupSpec{ObjectStorage: &v1alpha1.ObjectStorageSpec{URL: "s3://backups/orders"}, KeepLast: 2}
+		}},
+		{name: "volume claim backup", mutate: func(m *v1alpha1.Migration) {
+			m.Spec.Backup = &v1alpha1.BackupSpec{VolumeClaim: "backups"}
+		}},
+		{name: "every kind of sink", mutate: func(m *v1alpha1.Migration) {
+			m.Spec.Notifications.Sinks = append(m.Spec.Notifications.Sinks,
+				v1alpha1.NotificationSink{Name: "slack", Slack: &v1alpha1.ChatSink{SinkEndpoint: v1alpha1.SinkEndpoint{URL: "https://slack"}}},
+				v1alpha1.NotificationSink{Name: "teams", Teams: &v1alpha1.ChatSink{SinkEndpoint: v1alpha1.SinkEndpoint{URL: "https://teams"}, Text: "{{ .Phase }}"}},
+				v1alpha1.NotificationSink{Name: "events", CloudEvents: &v1alpha1.CloudEventsSink{SinkEndpoint: v1alpha1.SinkEndpoint{URL: "https://broker"}}},
+			)
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			hub := newHub()
+			test.mutate(hub)
+
+			var migration Migration
+			if err := migration.ConvertFrom(hub); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := migration.ObjectMeta.Annotations[VaultAnnotation]; ok != test.annotated {
+				t.Errorf("vault annotation set: %v, want %v", ok, test.annotated)
+			}
+			if errs := migration.validateUnions(); len(errs) > 0 {
+				t.Errorf("converted migration has invalid unions: %v", errs)
+			}
+			var converted v1alpha1.Migration
+			if err := migration.ConvertTo(&converted); err != nil {
+				t.Fatal(err)
+			}
+			if !equality.Semantic.DeepEqual(hub, &converted) {
+				t.Errorf("round trip through v1beta1 changed the migration:\n%s", diff.ObjectReflectDiff(hub, &converted))
+			}
+		})
+	}
+}
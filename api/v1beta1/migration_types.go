@@ -0,0 +1,757 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// MigrationSpec defines the desired state of Migration
+type MigrationSpec struct {
+	// Databases selects the databases the migration applies to
+	Databases DatabasesSpec `json:"databases"`
+	// Rollout paces the runs of a migration having several targets
+	// +optional
+	Rollout RolloutStrategy `json:"rollout,omitempty"`
+	Scripts ScriptsSpec     `json:"scripts"`
+	// Image is the flyway image running the migration, defaults to flyway/flyway
+	// +optional
+	Image string `json:"image,omitempty"`
+	// DependsOn holds the migration back until the referenced migrations succeeded
+	// +optional
+	DependsOn []MigrationReference `json:"dependsOn,omitempty"`
+	// Schedule only starts runs inside maintenance windows
+	// +optional
+	Schedule *ScheduleSpec `json:"schedule,omitempty"`
+	// History locates the flyway schema history table the applied state is read from
+	// +optional
+	History HistorySpec `json:"history,omitempty"`
+	// DriftDetection periodically compares the schema history of succeeded migrations with their scripts
+	// +optional
+	DriftDetection *DriftDetectionSpec `json:"driftDetection,omitempty"`
+	// TargetVersion is the schema version to migrate to, the latest one when empty.
+	// A version below the applied one rolls the schema back.
+	// +optional
+	// +kubebuilder:validation:Pattern=`^[0-9]+([._][0-9]+)*$`
+	TargetVersion string `json:"targetVersion,omitempty"`
+	// Rollback defines how the schema moves back to a target below the applied version
+	// +optional
+	Rollback RollbackSpec `json:"rollback,omitempty"`
+	// Backup dumps or snapshots every database before its run starts
+	// +optional
+	Backup *BackupSpec `json:"backup,omitempty"`
+	// OnFailure is what happens to a database whose run failed, Restore requires a backup
+	// +optional
+	// +kubebuilder:validation:Enum=Fail;Restore
+	OnFailure OnFailurePolicy `json:"onFailure,omitempty"`
+	// Notifications are sent when a rollout starts and ends, on top of the notification policies of the namespace
+	// +optional
+	Notifications *NotificationSpec `json:"notifications,omitempty"`
+	// Suspend stops starting runs, the running ones complete
+	// +optional
+	Suspend bool `json:"suspend,omitempty"`
+	// RequireApproval holds every rollout until the migration is annotated as approved
+	// +optional
+	RequireApproval bool `json:"requireApproval,omitempty"`
+}
+
+// DatabasesType tells which member of a databases union is set
+type DatabasesType string
+
+const (
+	DatabasesInline    DatabasesType = "Inline"
+	DatabasesReference DatabasesType = "Reference"
+	DatabasesTargets   DatabasesType = "Targets"
+	DatabasesSelector  DatabasesType = "Selector"
+)
+
+// DatabasesSpec selects the databases of a migration, the member named by type must be the only one set
+// +union
+type DatabasesSpec struct {
+	// +unionDiscriminator
+	// +kubebuilder:validation:Enum=Inline;Reference;Targets;Selector
+	Type DatabasesType `json:"type"`
+	// Inline migrates a single database
+	// +optional
+	Inline *DatabaseConnection `json:"inline,omitempty"`
+	// Reference migrates a Database or ClusterDatabase
+	// +optional
+	Reference *DatabaseReference `json:"reference,omitempty"`
+	// Targets fans the migration out to every listed database
+	// +optional
+	Targets []MigrationTarget `json:"targets,omitempty"`
+	// Selector fans the migration out to every Database of the namespace matching the selector
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// MigrationTarget is one of the databases a migration fans out to, the member named by type must be the only one set
+// +union
+type MigrationTarget struct {
+	// Name identifies the target in the status and names its flyway job
+	Name string `json:"name"`
+	// +unionDiscriminator
+	// +kubebuilder:validation:Enum=Inline;Reference
+	Type DatabasesType `json:"type"`
+	// +optional
+	Inline *DatabaseConnection `json:"inline,omitempty"`
+	// +optional
+	Reference *DatabaseReference `json:"reference,omitempty"`
+}
+
+// DatabaseReference points to a Database of the migration namespace or to a ClusterDatabase
+type DatabaseReference struct {
+	// Kind defaults to Database
+	// +optional
+	// +kubebuilder:validation:Enum=Database;ClusterDatabase
+	Kind string `json:"kind,omitempty"`
+	Name string `json:"name"`
+}
+
+// DatabaseConnection locates a database and the credentials flyway connects with
+type DatabaseConnection struct {
+	// JDBC driver class of the database
+	Driver string `json:"driver"`
+	// +optional
+	Host string `json:"host,omitempty"`
+	// Port defaults to the standard port of the driver database
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// +optional
+	Database string `json:"database,omitempty"`
+	// +optional
+	Credentials *Credentials `json:"credentials,omitempty"`
+	// AdvisoryLock holds a database level lock for the whole run, serializing the runs of operators
+	// from other clusters sharing the database
+	// +optional
+	AdvisoryLock bool `json:"advisoryLock,omitempty"`
+	// Connectivity reaches databases which are not directly reachable from the cluster
+	// +optional
+	Connectivity *Connectivity `json:"connectivity,omitempty"`
+}
+
+// ConnectivityType tells which member of a connectivity union is set
+type ConnectivityType string
+
+const (
+	ConnectivityCloudSQLProxy ConnectivityType = "CloudSQLProxy"
+	ConnectivitySSHTunnel     ConnectivityType = "SSHTunnel"
+)
+
+// Connectivity is the way the database is reached, the member named by type must be the only one set
+// +union
+type Connectivity struct {
+	// +unionDiscriminator
+	// +kubebuilder:validation:Enum=CloudSQLProxy;SSHTunnel
+	Type ConnectivityType `json:"type"`
+	// +optional
+	CloudSQLProxy *CloudSQLProxyConnectivity `json:"cloudSQLProxy,omitempty"`
+	// +optional
+	SSHTunnel *SSHTunnelConnectivity `json:"sshTunnel,omitempty"`
+}
+
+// CloudSQLProxyConnectivity runs the Cloud SQL Auth Proxy next to the pods connecting to the database, which connect
+// to it on localhost. The operator connects the same way as the proxy, with the Google credentials of its workload identity.
+// The proxy runs as a native sidecar container, which requires Kubernetes 1.29 or later.
+type CloudSQLProxyConnectivity struct {
+	// Instance is the connection name of the instance, project:region:instance
+	Instance string `json:"instance"`
+	// IAMUser connects as an IAM database user instead of with credentials, the name of a service account
+	// user is its email without the .gserviceaccount.com suffix
+	// +optional
+	IAMUser string `json:"iamUser,omitempty"`
+	// PrivateIP connects to the private IP of the instance instead of its public IP
+	// +optional
+	PrivateIP bool `json:"privateIP,omitempty"`
+	// ServiceAccountName runs the pods with the Kubernetes service account bound to the Google service account
+	// allowed to connect, the default service account of the namespace otherwise
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+	// Image of the proxy, defaults to the Cloud SQL Auth Proxy v2 image
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// SSHTunnelConnectivity forwards the connections to the database through an SSH bastion. The operator opens the tunnel
+// itself, the pods connect on localhost to a tunnel sidecar, which runs as a native sidecar container requiring Kubernetes
+// 1.29 or later.
+type SSHTunnelConnectivity struct {
+	// Host of the bastion
+	Host string `json:"host"`
+	// Port of the bastion, defaults to 22
+	// +optional
+	Port int32 `json:"port,omitempty"`
+	// User the bastion authenticates
+	User string `json:"user"`
+	// KeySecret is the secret holding the private key of the user under ssh-privatekey, like kubernetes.io/ssh-auth
+	// secrets do, in the namespace of the pods connecting
+	KeySecret string `json:"keySecret"`
+	// KeySecretNamespace is the namespace of the key secret of a cluster database, the operator copies the secret
+	// in the namespaces of the migrations connecting. Other databases read it from their own namespace.
+	// +optional
+	KeySecretNamespace string `json:"keySecretNamespace,omitempty"`
+	// KnownHosts are the host keys of the bastion, in the known_hosts format. The tunnel is refused when the bastion
+	// presents another key.
+	KnownHosts string `json:"knownHosts"`
+	// Image of the tunnel sidecar, which needs a shell and the OpenSSH client
+	// +optional
+	Image string `json:"image,omitempty"`
+}
+
+// CredentialsType tells which member of a credentials union is set
+type CredentialsType string
+
+const (
+	CredentialsSecret CredentialsType = "Secret"
+	CredentialsRDSIAM CredentialsType = "RDSIAM"
+)
+
+// Credentials are the user and password flyway connects with, the member named by type must be the only one set
+// +union
+type Credentials struct {
+	// +unionDiscriminator
+	// +kubebuilder:validation:Enum=Secret;RDSIAM
+	Type CredentialsType `json:"type"`
+	// +optional
+	Secret *SecretCredentials `json:"secret,omitempty"`
+	// RDSIAM connects with RDS IAM authentication tokens instead of a password, over SSL verified with the RDS CA bundle of the operator
+	// +optional
+	RDSIAM *RDSIAMCredentials `json:"rdsIAM,omitempty"`
+}
+
+// SecretCredentials reads the user and password from a secret
+type SecretCredentials struct {
+	Name string `json:"name"`
+	// Namespace of the secret, only honoured on cluster databases
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// UserKey defaults to user
+	// +optional
+	UserKey string `json:"userKey,omitempty"`
+	// PasswordKey defaults to password
+	// +optional
+	PasswordKey string `json:"passwordKey,omitempty"`
+}
+
+// RDSIAMCredentials generates the RDS IAM authentication tokens of a database user,
+// signed with the AWS credentials of the operator from IRSA or EKS pod identity
+type RDSIAMCredentials struct {
+	// User is the database user granted the rds_iam role
+	User string `json:"user"`
+	// Region of the database, defaults to the AWS_REGION of the operator
+	// +optional
+	Region string `json:"region,omitempty"`
+}
+
+// ScriptsType tells which member of a scripts union is set
+type ScriptsType string
+
+const (
+	ScriptsGit       ScriptsType = "Git"
+	ScriptsConfigMap ScriptsType = "ConfigMap"
+)
+
+// ScriptsSpec locates the sql scripts, the member named by type must be the only one set
+// +union
+type ScriptsSpec struct {
+	// +unionDiscriminator
+	// +kubebuilder:validation:Enum=Git;ConfigMap
+	Type ScriptsType `json:"type"`
+	// +optional
+	Git *GitSource `json:"git,omitempty"`
+	// +optional
+	ConfigMap *ConfigMapSource `json:"configMap,omitempty"`
+	// Path of the scripts in the source
+	Path string `json:"path"`
+}
+
+// GitSource clones the scripts from a git branch
+type GitSource struct {
+	URL    string `json:"url"`
+	Branch string `json:"branch"`
+	// SSHKeySecret holds the private key of the repository under the id_rsa key and the host keys of the git server,
+	// in the known_hosts format, under the known_hosts key
+	SSHKeySecret string `json:"sshKeySecret"`
+	// StrictHostKeyChecking has the jobs clone the scripts from a git server presenting one of the known host keys of the secret
+	// +optional
+	StrictHostKeyChecking bool `json:"strictHostKeyChecking,omitempty"`
+	// PollInterval opts in following the branch, a new run starts whenever its head moves
+	// +optional
+	PollInterval *metav1.Duration `json:"pollInterval,omitempty"`
+}
+
+// ConfigMapSource reads the scripts from a config map of the namespace
+type ConfigMapSource struct {
+	Name string `json:"name"`
+}
+
+// RolloutFailurePolicy tells what happens to the remaining targets once one of them failed
+type RolloutFailurePolicy string
+
+const (
+	RolloutStop     RolloutFailurePolicy = "Stop"
+	RolloutContinue RolloutFailurePolicy = "Continue"
+)
+
+// RolloutStrategy paces the runs of a migration across its targets
+type RolloutStrategy struct {
+	// MaxParallel caps the number of targets migrated at the same time, unlimited when unset
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	MaxParallel int32 `json:"maxParallel,omitempty"`
+	// WaveSize splits the targets, in order, in waves started once the previous wave is over, a single wave when unset
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	WaveSize int32 `json:"waveSize,omitempty"`
+	// FailurePolicy defaults to Stop
+	// +optional
+	// +kubebuilder:validation:Enum=Stop;Continue
+	FailurePolicy RolloutFailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// MigrationReference points to a migration another one depends on
+type MigrationReference struct {
+	Name string `json:"name"`
+	// Namespace defaults to the namespace of the dependent migration
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+	// MinVersion is the schema version the referenced migration must have reached
+	// +optional
+	MinVersion string `json:"minVersion,omitempty"`
+}
+
+// ScheduleSpec defines the maintenance windows runs may start in
+type ScheduleSpec struct {
+	// Windows are the cron expressions opening a window, in the standard five fields format
+	// +kubebuilder:validation:MinItems=1
+	Windows []string `json:"windows"`
+	// TimeZone of the cron expressions as an IANA name, defaults to UTC
+	// +optional
+	TimeZone string `json:"timeZone,omitempty"`
+	// Duration is how long each window stays open
+	Duration metav1.Duration `json:"duration"`
+	// HardStop stops the flyway jobs still running when their window closes
+	// +optional
+	HardStop bool `json:"hardStop,omitempty"`
+}
+
+// HistorySpec locates the flyway schema history table and sizes the reports
+type HistorySpec struct {
+	// Table defaults to flyway_schema_history
+	// +optional
+	Table string `json:"table,omitempty"`
+	// Schema holding the table, defaults to the default schema of the connection
+	// +optional
+	Schema string `json:"schema,omitempty"`
+	// Limit is the number of latest applied scripts reported, defaults to 10
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	Limit int32 `json:"limit,omitempty"`
+	// RunLimit is the number of MigrationRuns kept per target, defaults to 20
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	RunLimit int32 `json:"runLimit,omitempty"`
+}
+
+// DriftDetectionSpec defines the periodic check of the applied scripts against the recorded revision
+type DriftDetectionSpec struct {
+	// Interval between two checks
+	Interval metav1.Duration `json:"interval"`
+	// Events emits a warning event when drift is first detected
+	// +optional
+	Events bool `json:"events,omitempty"`
+}
+
+// RollbackSpec defines how versions above the target are reverted. Flyway undo runs when every
+// version has an undo script and Flyway Teams is available, the compensating scripts are executed otherwise.
+type RollbackSpec struct {
+	// AllowDowngrade must be set for the operator to move the schema backwards
+	// +optional
+	AllowDowngrade bool `json:"allowDowngrade,omitempty"`
+	// CompensatingScripts is the directory of the scripts location holding the compensating scripts,
+	// named after the version they revert such as C3__drop_orders.sql
+	// +optional
+	CompensatingScripts string `json:"compensatingScripts,omitempty"`
+	// LicenseKey of Flyway Teams, which flyway undo requires unless the image is a redgate/flyway edition
+	// +optional
+	LicenseKey *corev1.SecretKeySelector `json:"licenseKey,omitempty"`
+}
+
+// OnFailurePolicy is what happens to a database whose run failed
+type OnFailurePolicy string
+
+const (
+	OnFailureFail    OnFailurePolicy = "Fail"
+	OnFailureRestore OnFailurePolicy = "Restore"
+)
+
+// BackupType tells which member of a backup union is set
+type BackupType string
+
+const (
+	BackupVolumeClaim    BackupType = "VolumeClaim"
+	BackupObjectStorage  BackupType = "ObjectStorage"
+	BackupVolumeSnapshot BackupType = "VolumeSnapshot"
+)
+
+// BackupSpec defines where the backup taken before each run is stored, the member named by type must be the only one set
+// +union
+type BackupSpec struct {
+	// +unionDiscriminator
+	// +kubebuilder:validation:Enum=VolumeClaim;ObjectStorage;VolumeSnapshot
+	Type BackupType `json:"type"`
+	// VolumeClaim receives the dumps of the databases
+	// +optional
+	VolumeClaim *VolumeClaimDestination `json:"volumeClaim,omitempty"`
+	// ObjectStorage receives the dumps of the databases
+	// +optional
+	ObjectStorage *ObjectStorageSpec `json:"objectStorage,omitempty"`
+	// VolumeSnapshot snapshots the volume of a database running in the cluster instead of dumping it
+	// +optional
+	VolumeSnapshot *VolumeSnapshotSpec `json:"volumeSnapshot,omitempty"`
+	// Image running the dump, defaults to the client image of the driver
+	// +optional
+	Image string `json:"image,omitempty"`
+	// KeepLast is the number of backups kept per target, defaults to 5
+	// +optional
+	// +kubebuilder:validation:Minimum=1
+	KeepLast int32 `json:"keepLast,omitempty"`
+}
+
+// VolumeClaimDestination stores the dumps on a persistent volume claim of the namespace
+type VolumeClaimDestination struct {
+	ClaimName string `json:"claimName"`
+}
+
+// ObjectStorageSpec locates an S3 compatible bucket
+type ObjectStorageSpec struct {
+	// URL of the bucket and prefix, such as s3://backups/flyway
+	URL string `json:"url"`
+	// Endpoint of the S3 compatible storage, AWS when empty
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+	// Secret holding the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY of the bucket
+	// +optional
+	Secret string `json:"secret,omitempty"`
+}
+
+// VolumeSnapshotSpec snapshots the persistent volume claim of an in-cluster database
+type VolumeSnapshotSpec struct {
+	// ClaimName of the database volume in the namespace of the migration
+	ClaimName string `json:"claimName"`
+	// ClassName of the volume snapshots, the default class when empty
+	// +optional
+	ClassName string `json:"className,omitempty"`
+}
+
+// NotificationEvent is a step of a rollout the sinks are notified of
+// +kubebuilder:validation:Enum=Started;Succeeded;Failed
+type NotificationEvent string
+
+// NotificationSpec lists the sinks notified of the rollouts of a migration
+type NotificationSpec struct {
+	// Events sent to the sinks, every event when empty
+	// +optional
+	Events []NotificationEvent `json:"events,omitempty"`
+	// +kubebuilder:validation:MinItems=1
+	Sinks []NotificationSink `json:"sinks"`
+	// Retries is the number of attempts made after a failed delivery, defaults to 3 and at most 10
+	// +optional
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=10
+	Retries *int32 `json:"retries,omitempty"`
+}
+
+// SinkType tells which member of a sink union is set
+type SinkType string
+
+const (
+	SinkWebhook     SinkType = "Webhook"
+	SinkSlack       SinkType = "Slack"
+	SinkTeams       SinkType = "Teams"
+	SinkCloudEvents SinkType = "CloudEvents"
+)
+
+// NotificationSink is one destination of the notifications, the member named by type must be the only one set
+// +union
+type NotificationSink struct {
+	// Name identifies the sink in the delivery results
+	Name string `json:"name"`
+	// +unionDiscriminator
+	// +kubebuilder:validation:Enum=Webhook;Slack;Teams;CloudEvents
+	Type SinkType `json:"type"`
+	// +optional
+	Webhook *WebhookSink `json:"webhook,omitempty"`
+	// +optional
+	Slack *ChatSink `json:"slack,omitempty"`
+	// +optional
+	Teams *ChatSink `json:"teams,omitempty"`
+	// +optional
+	CloudEvents *CloudEventsSink `json:"cloudEvents,omitempty"`
+}
+
+// SinkEndpoint is the URL a sink posts to, exactly one of url or urlSecret must be set
+type SinkEndpoint struct {
+	// +optional
+	URL string `json:"url,omitempty"`
+	// URLSecret reads the URL from a secret of the namespace, for the URLs embedding a token
+	// +optional
+	URLSecret *corev1.SecretKeySelector `json:"urlSecret,omitempty"`
+}
+
+// WebhookSink posts the notification to an HTTP endpoint
+type WebhookSink struct {
+	SinkEndpoint `json:",inline"`
+	// Headers added to the requests
+	// +optional
+	Headers map[string]string `json:"headers,omitempty"`
+	// Body is a Go template of the request body executed with the notification,
+	// the notification as JSON when empty
+	// +optional
+	Body string `json:"body,omitempty"`
+}
+
+// ChatSink posts a message to a chat incoming webhook
+type ChatSink struct {
+	SinkEndpoint `json:",inline"`
+	// Text is a Go template of the message executed with the notification, a summary of the rollout when empty
+	// +optional
+	Text string `json:"text,omitempty"`
+}
+
+// CloudEventsSink posts the notification to a CloudEvents HTTP receiver
+type CloudEventsSink struct {
+	SinkEndpoint `json:",inline"`
+	// Source of the events, defaults to the path of the migration
+	// +optional
+	Source string `json:"source,omitempty"`
+}
+
+// MigrationPhase is the lifecycle step of the latest migration run
+type MigrationPhase string
+
+// MigrationStatus defines the observed state of Migration
+type MigrationStatus struct {
+	// Phase of the latest rollout
+	// +optional
+	Phase MigrationPhase `json:"phase,omitempty"`
+	// Schema is the lowest schema version and the most pending scripts across targets
+	// +optional
+	Schema SchemaStatus `json:"schema,omitempty"`
+	// Rollout reports the progress of the latest rollout
+	// +optional
+	Rollout RolloutStatus `json:"rollout,omitempty"`
+	// Targets reports the run of every database the migration applies to
+	// +optional
+	Targets []TargetStatus `json:"targets,omitempty"`
+	// LastDriftCheck is when the schema history was last compared with the scripts
+	// +optional
+	LastDriftCheck *metav1.Time `json:"lastDriftCheck,omitempty"`
+	// Notifications records the latest delivery of every event to every sink
+	// +optional
+	Notifications []NotificationDelivery `json:"notifications,omitempty"`
+	// +optional
+	Conditions []MigrationCondition `json:"conditions,omitempty"`
+}
+
+// SchemaStatus is the state of a schema as reported by flyway
+type SchemaStatus struct {
+	// +optional
+	CurrentVersion string `json:"currentVersion,omitempty"`
+	// +optional
+	PendingMigrations int32 `json:"pendingMigrations,omitempty"`
+}
+
+// RolloutStatus is the progress of a rollout
+type RolloutStatus struct {
+	// Revision is the commit of the followed git branch the rollout runs
+	// +optional
+	Revision string `json:"revision,omitempty"`
+	// Trigger is what started the rollout
+	// +optional
+	Trigger *RunTrigger `json:"trigger,omitempty"`
+	// QueuePosition is the best rank of the queued targets among the runs waiting for their database
+	// +optional
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+	// NextWindow is when the next maintenance window opens while runs wait for it
+	// +optional
+	NextWindow *metav1.Time `json:"nextWindow,omitempty"`
+	// WindowCloses is when the maintenance window of the current runs closes
+	// +optional
+	WindowCloses *metav1.Time `json:"windowCloses,omitempty"`
+}
+
+// RunTrigger records what started a rollout and who requested it
+type RunTrigger struct {
+	Reason string `json:"reason"`
+	// +optional
+	User string `json:"user,omitempty"`
+	// +optional
+	ApprovedBy string      `json:"approvedBy,omitempty"`
+	Time       metav1.Time `json:"time"`
+}
+
+// TargetStatus is the outcome of the latest run on one target
+type TargetStatus struct {
+	Name string `json:"name"`
+	// +optional
+	Phase MigrationPhase `json:"phase,omitempty"`
+	// +optional
+	Schema SchemaStatus `json:"schema,omitempty"`
+	// TargetVersion is the version the latest run migrated to, empty for the latest version
+	// +optional
+	TargetVersion string `json:"targetVersion,omitempty"`
+	// Job is the flyway job migrating the target
+	// +optional
+	Job string `json:"job,omitempty"`
+	// Run is the MigrationRun recording the latest run
+	// +optional
+	Run string `json:"run,omitempty"`
+	// Runs is the number of runs started on the target
+	// +optional
+	Runs int32 `json:"runs,omitempty"`
+	// QueuePosition is the rank of the target among the runs waiting for its database
+	// +optional
+	QueuePosition int32 `json:"queuePosition,omitempty"`
+	// WaitingSince is when the database of the target was first found unreachable by the run about to start
+	// +optional
+	WaitingSince *metav1.Time `json:"waitingSince,omitempty"`
+	// Message holds the flyway error of a failed run
+	// +optional
+	Message string `json:"message,omitempty"`
+	// AppliedScripts are the latest entries of the schema history table, the most recent first
+	// +optional
+	AppliedScripts []AppliedScript `json:"appliedScripts,omitempty"`
+	// FailedScripts are the failed entries of the schema history table
+	// +optional
+	FailedScripts []AppliedScript `json:"failedScripts,omitempty"`
+	// RollbackSteps are the versions reverted by the latest run, the most recent first
+	// +optional
+	RollbackSteps []RollbackStep `json:"rollbackSteps,omitempty"`
+	// Backup is the backup taken before the latest run
+	// +optional
+	Backup *BackupStatus `json:"backup,omitempty"`
+	// Restore is the restore of the backup once the latest run failed, the flyway error stays in message
+	// +optional
+	Restore *BackupStatus `json:"restore,omitempty"`
+}
+
+// AppliedScript is an entry of the flyway schema history table
+type AppliedScript struct {
+	InstalledRank int32 `json:"installedRank"`
+	// +optional
+	Version string `json:"version,omitempty"`
+	// +optional
+	Description string `json:"description,omitempty"`
+	// Type is the kind of script, such as SQL, UNDO_SQL or BASELINE
+	// +optional
+	Type   string `json:"type,omitempty"`
+	Script string `json:"script"`
+	// +optional
+	Checksum *int32 `json:"checksum,omitempty"`
+	// +optional
+	InstalledBy string `json:"installedBy,omitempty"`
+	// +optional
+	InstalledOn metav1.Time `json:"installedOn,omitempty"`
+	// ExecutionTime is the duration of the script in milliseconds
+	ExecutionTime int32 `json:"executionTime"`
+	Success       bool  `json:"success"`
+}
+
+// RollbackStep records the revert of one version
+type RollbackStep struct {
+	Version string `json:"version"`
+	// Method is Undo or Compensate
+	Method string `json:"method"`
+	// Script is the path of the undo or compensating script in the scripts location
+	Script string         `json:"script"`
+	Phase  MigrationPhase `json:"phase"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	FinishedAt *metav1.Time `json:"finishedAt,omitempty"`
+}
+
+// BackupStatus is the backup taken before the latest run of a target
+type BackupStatus struct {
+	// Name of the backup job or volume snapshot
+	Name  string         `json:"name"`
+	Phase MigrationPhase `json:"phase"`
+	// Location of the dump or name of the volume snapshot
+	// +optional
+	Location string `json:"location,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// +optional
+	CompletedAt *metav1.Time `json:"completedAt,omitempty"`
+}
+
+// NotificationDelivery is the outcome of the latest notification of an event to a sink
+type NotificationDelivery struct {
+	Sink     string            `json:"sink"`
+	Event    NotificationEvent `json:"event"`
+	Phase    MigrationPhase    `json:"phase"`
+	Attempts int32             `json:"attempts"`
+	// +optional
+	Message string      `json:"message,omitempty"`
+	Time    metav1.Time `json:"time"`
+}
+
+// MigrationCondition describes the state of a migration at a certain point
+type MigrationCondition struct {
+	Type   string                 `json:"type"`
+	Status corev1.ConditionStatus `json:"status"`
+	// Reason is a CamelCase code for the last transition
+	// +optional
+	Reason string `json:"reason,omitempty"`
+	// +optional
+	Message string `json:"message,omitempty"`
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Databases",type=string,JSONPath=`.spec.databases.type`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Version",type=string,JSONPath=`.status.schema.currentVersion`
+
+// Migration is the Schema for the migrations API
+type Migration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   MigrationSpec   `json:"spec,omitempty"`
+	Status MigrationStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// MigrationList contains a list of Migration
+type MigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []Migration `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&Migration{}, &MigrationList{})
+}
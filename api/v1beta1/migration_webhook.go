@@ -0,0 +1,216 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"flyway-operator/api/v1alpha1"
+)
+
+// log is for logging in this package.
+var migrationlog = logf.Log.WithName("migration-resource")
+
+func (r *Migration) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-migrations-flywayoperator-io-v1beta1-migration,mutating=true,failurePolicy=fail,groups=migrations.flywayoperator.io,resources=migrations,verbs=create;update,versions=v1beta1,name=mmigration.v1beta1.kb.io
+
+var _ webhook.Defaulter = &Migration{}
+
+// Default applies the defaults of v1alpha1, a migration with invalid unions is left to the validation
+func (r *Migration) Default() {
+	if len(r.validateUnions()) > 0 {
+		return
+	}
+	var hub v1alpha1.Migration
+	if err := r.ConvertTo(&hub); err != nil {
+		return
+	}
+	hub.Default()
+	// the status is not part of admitted requests, keep it out of the round trip
+	status := r.Status
+	if err := r.ConvertFrom(&hub); err != nil {
+		return
+	}
+	r.Status = status
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-migrations-flywayoperator-io-v1beta1-migration,mutating=false,failurePolicy=fail,groups=migrations.flywayoperator.io,resources=migrations,versions=v1beta1,name=vmigration.v1beta1.kb.io
+
+var _ webhook.Validator = &Migration{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Migration) ValidateCreate() error {
+	migrationlog.Info("validate create", "name", r.Name)
+
+	if err := r.invalidUnions(); err != nil {
+		return err
+	}
+	var hub v1alpha1.Migration
+	if err := r.ConvertTo(&hub); err != nil {
+		return err
+	}
+	return hub.ValidateCreate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Migration) ValidateUpdate(old runtime.Object) error {
+	migrationlog.Info("validate update", "name", r.Name)
+
+	if err := r.invalidUnions(); err != nil {
+		return err
+	}
+	var hub, oldHub v1alpha1.Migration
+	if err := r.ConvertTo(&hub); err != nil {
+		return err
+	}
+	if err := old.(*Migration).ConvertTo(&oldHub); err != nil {
+		return err
+	}
+	return hub.ValidateUpdate(&oldHub)
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Migration) ValidateDelete() error {
+	return nil
+}
+
+func (r *Migration) invalidUnions() error {
+	allErrs := r.validateUnions()
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(
+		schema.GroupKind{Group: GroupVersion.Group, Kind: "Migration"},
+		r.Name, allErrs)
+}
+
+// validateUnions checks the member named by every discriminator is the only one set
+func (r *Migration) validateUnions() field.ErrorList {
+	var allErrs field.ErrorList
+	path := field.NewPath("spec")
+
+	databases := &r.Spec.Databases
+	allErrs = append(allErrs, validateUnion(path.Child("databases"), string(databases.Type), map[string]bool{
+		"inline":    databases.Inline != nil,
+		"reference": databases.Reference != nil,
+		"targets":   len(databases.Targets) > 0,
+		"selector":  databases.Selector != nil,
+	})...)
+	if databases.Inline != nil {
+		allErrs = append(allErrs, validateConnection(path.Child("databases", "inline"), databases.Inline)...)
+	}
+	for i := range databases.Targets {
+		target := &databases.Targets[i]
+		targetPath := path.Child("databases", "targets").Index(i)
+		allErrs = append(allErrs, validateUnion(targetPath, string(target.Type), map[string]bool{
+			"inline":    target.Inline != nil,
+			"reference": target.Reference != nil,
+		})...)
+		if target.Inline != nil {
+			allErrs = append(allErrs, validateConnection(targetPath.Child("inline"), target.Inline)...)
+		}
+	}
+
+	scripts := &r.Spec.Scripts
+	allErrs = append(allErrs, validateUnion(path.Child("scripts"), string(scripts.Type), map[string]bool{
+		"git":       scripts.Git != nil,
+		"configMap": scripts.ConfigMap != nil,
+	})...)
+
+	if backup := r.Spec.Backup; backup != nil {
+		allErrs = append(allErrs, validateUnion(path.Child("backup"), string(backup.Type), map[string]bool{
+			"volumeClaim":    backup.VolumeClaim != nil,
+			"objectStorage":  backup.ObjectStorage != nil,
+			"volumeSnapshot": backup.VolumeSnapshot != nil,
+		})...)
+	}
+	if notifications := r.Spec.Notifications; notifications != nil {
+		for i := range notifications.Sinks {
+			sink := &notifications.Sinks[i]
+			allErrs = append(allErrs, validateUnion(path.Child("notifications", "sinks").Index(i), string(sink.Type), map[string]bool{
+				"webhook":     sink.Webhook != nil,
+				"slack":       sink.Slack != nil,
+				"teams":       sink.Teams != nil,
+				"cloudEvents": sink.CloudEvents != nil,
+			})...)
+		}
+	}
+
+	return allErrs
+}
+
+func validateConnection(path *field.Path, connection *DatabaseConnection) field.ErrorList {
+	var allErrs field.ErrorList
+
+	if credentials := connection.Credentials; credentials != nil {
+		allErrs = append(allErrs, validateUnion(path.Child("credentials"), string(credentials.Type), map[string]bool{
+			"secret": credentials.Secret != nil,
+			"rdsIAM": credentials.RDSIAM != nil,
+		})...)
+	}
+	if connectivity := connection.Connectivity; connectivity != nil {
+		allErrs = append(allErrs, validateUnion(path.Child("connectivity"), string(connectivity.Type), map[string]bool{
+			"cloudSQLProxy": connectivity.CloudSQLProxy != nil,
+			"sshTunnel":     connectivity.SSHTunnel != nil,
+		})...)
+	}
+
+	return allErrs
+}
+
+// validateUnion checks the member named by the discriminator, regardless of case, is the only one set
+func validateUnion(path *field.Path, discriminator string, members map[string]bool) field.ErrorList {
+	var allErrs field.ErrorList
+
+	names := make([]string, 0, len(members))
+	var selected string
+	for member := range members {
+		names = append(names, member)
+		if strings.EqualFold(member, discriminator) {
+			selected = member
+		}
+	}
+	sort.Strings(names)
+	if selected == "" {
+		return field.ErrorList{field.Invalid(path.Child("type"), discriminator, "must name one of "+strings.Join(names, ", "))}
+	}
+	if !members[selected] {
+		allErrs = append(allErrs, field.Required(path.Child(selected), fmt.Sprintf("must be set when type is %s", discriminator)))
+	}
+	for _, member := range names {
+		if members[member] && member != selected {
+			allErrs = append(allErrs, field.Forbidden(path.Child(member), fmt.Sprintf("must not be set when type is %s", discriminator)))
+		}
+	}
+
+	return allErrs
+}
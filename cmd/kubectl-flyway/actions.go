@@ -0,0 +1,90 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+func runRerun(ctx context.Context, p *plugin, args []string) error {
+	migration, err := p.migrationArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	if err := annotate(ctx, p, migration, migrationsv1alpha1.RerunAnnotation); err != nil {
+		return err
+	}
+	switch migration.Status.Phase {
+	case migrationsv1alpha1.MigrationSucceeded, migrationsv1alpha1.MigrationFailed, migrationsv1alpha1.MigrationRolledBack:
+		fmt.Fprintf(p.out, "migration %s rerun requested\n", migration.ObjectMeta.Name)
+	default:
+		fmt.Fprintf(p.out, "migration %s is %s, it runs again once the current run is over\n", migration.ObjectMeta.Name, migration.Status.Phase)
+	}
+	return nil
+}
+
+func runApprove(ctx context.Context, p *plugin, args []string) error {
+	migration, err := p.migrationArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	if !migration.Spec.RequireApproval {
+		return fmt.Errorf("migration %s does not require approval", migration.ObjectMeta.Name)
+	}
+	if err := annotate(ctx, p, migration, migrationsv1alpha1.ApproveAnnotation); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "migration %s approved\n", migration.ObjectMeta.Name)
+	return nil
+}
+
+func setSuspend(ctx context.Context, p *plugin, args []string, suspend bool) error {
+	migration, err := p.migrationArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	verb := "suspended"
+	if !suspend {
+		verb = "resumed"
+	}
+	if migration.Spec.Suspend == suspend {
+		fmt.Fprintf(p.out, "migration %s is already %s\n", migration.ObjectMeta.Name, verb)
+		return nil
+	}
+	patch := client.MergeFrom(migration.DeepCopy())
+	migration.Spec.Suspend = suspend
+	if err := p.client.Patch(ctx, migration, patch); err != nil {
+		return err
+	}
+	fmt.Fprintf(p.out, "migration %s %s\n", migration.ObjectMeta.Name, verb)
+	return nil
+}
+
+// annotate requests an action, the admission webhook of the operator sets the value to the requesting user
+func annotate(ctx context.Context, p *plugin, migration *migrationsv1alpha1.Migration, key string) error {
+	patch := client.MergeFrom(migration.DeepCopy())
+	if migration.ObjectMeta.Annotations == nil {
+		migration.ObjectMeta.Annotations = map[string]string{}
+	}
+	migration.ObjectMeta.Annotations[key] = ""
+	return p.client.Patch(ctx, migration, patch)
+}
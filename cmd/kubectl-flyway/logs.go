@@ -0,0 +1,150 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+	"flyway-operator/controllers"
+)
+
+const (
+	// jobNameLabel is set by the job controller on the pods of a job
+	jobNameLabel = "job-name"
+	podPollDelay = 2 * time.Second
+)
+
+// lineWriter writes whole lines so that the logs of concurrent targets do not interleave
+type lineWriter struct {
+	mutex sync.Mutex
+	out   io.Writer
+}
+
+func (w *lineWriter) println(line string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	fmt.Fprintln(w.out, line)
+}
+
+func runLogs(ctx context.Context, p *plugin, args []string) error {
+	migration, err := p.migrationArg(ctx, args)
+	if err != nil {
+		return err
+	}
+	jobs := runJobs(migration, p.opts.target)
+	if len(jobs) == 0 {
+		return fmt.Errorf("migration %s has no flyway job to read the logs of", migration.ObjectMeta.Name)
+	}
+
+	out := &lineWriter{out: p.out}
+	errs := make(chan error, len(jobs))
+	var wg sync.WaitGroup
+	for target, job := range jobs {
+		prefix := ""
+		if len(jobs) > 1 {
+			prefix = "[" + target + "] "
+		}
+		wg.Add(1)
+		go func(job, prefix string) {
+			defer wg.Done()
+			errs <- p.followLogs(ctx, migration.ObjectMeta.Namespace, job, prefix, out)
+		}(job, prefix)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil && ctx.Err() == nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runJobs returns the flyway jobs of the running targets by target, the jobs of the latest run when none runs
+func runJobs(migration *migrationsv1alpha1.Migration, only string) map[string]string {
+	running, latest := map[string]string{}, map[string]string{}
+	for _, target := range migration.Status.Targets {
+		if target.Job == "" || (only != "" && target.Name != only) {
+			continue
+		}
+		latest[target.Name] = target.Job
+		if target.Phase == migrationsv1alpha1.MigrationRunning {
+			running[target.Name] = target.Job
+		}
+	}
+	if len(running) > 0 {
+		return running
+	}
+	return latest
+}
+
+// followLogs streams the flyway container logs of the latest pod of the job, once the pod started
+func (p *plugin) followLogs(ctx context.Context, namespace, job, prefix string, out *lineWriter) error {
+	pod, err := p.waitForPod(ctx, namespace, job)
+	if err != nil {
+		return err
+	}
+	stream, err := p.clientset.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{
+		Container: controllers.FlywayContainerName,
+		Follow:    true,
+	}).Stream()
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	go func() {
+		// unblocks the scanner on interrupt
+		<-ctx.Done()
+		stream.Close()
+	}()
+
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		out.println(prefix + scanner.Text())
+	}
+	return scanner.Err()
+}
+
+func (p *plugin) waitForPod(ctx context.Context, namespace, job string) (string, error) {
+	for {
+		var pods corev1.PodList
+		if err := p.client.List(ctx, &pods, client.InNamespace(namespace), client.MatchingLabels{jobNameLabel: job}); err != nil {
+			return "", err
+		}
+		sort.Slice(pods.Items, func(i, j int) bool {
+			return pods.Items[i].ObjectMeta.CreationTimestamp.After(pods.Items[j].ObjectMeta.CreationTimestamp.Time)
+		})
+		if len(pods.Items) > 0 && pods.Items[0].Status.Phase != corev1.PodPending {
+			return pods.Items[0].ObjectMeta.Name, nil
+		}
+		select {
+		case <-time.After(podPollDelay):
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,258 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-flyway operates the migrations of the flyway operator, kubectl runs it as kubectl flyway
+// once the binary is on the PATH
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+type (
+	// options are the flags of the commands, every command registers the ones it reads
+	options struct {
+		kubeconfig    string
+		context       string
+		namespace     string
+		allNamespaces bool
+		target        string
+		file          string
+	}
+
+	command struct {
+		usage       string
+		description string
+		flags       func(flags *flag.FlagSet, opts *options)
+		run         func(ctx context.Context, p *plugin, args []string) error
+	}
+
+	// plugin holds the clients of the cluster of the current kubeconfig context
+	plugin struct {
+		client    client.Client
+		clientset kubernetes.Interface
+		scheme    *runtime.Scheme
+		namespace string
+		opts      *options
+		out       io.Writer
+	}
+)
+
+var commands = map[string]*command{
+	"status": {
+		usage:       "status [MIGRATION]",
+		description: "List the migrations with their phase and schema version, or the targets of one migration",
+		flags:       func(flags *flag.FlagSet, opts *options) { allNamespacesFlag(flags, opts) },
+		run:         runStatus,
+	},
+	"history": {
+		usage:       "history MIGRATION",
+		description: "Print the latest entries of the schema history table of every target",
+		flags:       func(flags *flag.FlagSet, opts *options) { targetFlag(flags, opts) },
+		run:         runHistory,
+	},
+	"logs": {
+		usage:       "logs MIGRATION",
+		description: "Follow the flyway logs of the current run, or print the ones of the latest run",
+		flags:       func(flags *flag.FlagSet, opts *options) { targetFlag(flags, opts) },
+		run:         runLogs,
+	},
+	"rerun": {
+		usage:       "rerun MIGRATION",
+		description: "Start a new run on every target once the current run is over",
+		run:         runRerun,
+	},
+	"approve": {
+		usage:       "approve MIGRATION",
+		description: "Approve the pending rollout of a migration requiring approval",
+		run:         runApprove,
+	},
+	"suspend": {
+		usage:       "suspend MIGRATION",
+		description: "Stop starting runs, the running ones complete",
+		run:         func(ctx context.Context, p *plugin, args []string) error { return setSuspend(ctx, p, args, true) },
+	},
+	"resume": {
+		usage:       "resume MIGRATION",
+		description: "Start the runs held by suspend again",
+		run:         func(ctx context.Context, p *plugin, args []string) error { return setSuspend(ctx, p, args, false) },
+	},
+	"render": {
+		usage:       "render (MIGRATION | -f FILE)",
+		description: "Print the flyway jobs the operator creates for a forward run of the migration",
+		flags: func(flags *flag.FlagSet, opts *options) {
+			flags.StringVar(&opts.file, "f", "", "Render the migration of the manifest instead of the one of the cluster")
+			flags.StringVar(&opts.file, "filename", "", "Render the migration of the manifest instead of the one of the cluster")
+		},
+		run: runRender,
+	},
+}
+
+func allNamespacesFlag(flags *flag.FlagSet, opts *options) {
+	flags.BoolVar(&opts.allNamespaces, "A", false, "List the migrations of every namespace")
+	flags.BoolVar(&opts.allNamespaces, "all-namespaces", false, "List the migrations of every namespace")
+}
+
+func targetFlag(flags *flag.FlagSet, opts *options) {
+	flags.StringVar(&opts.target, "target", "", "Only the target of that name")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "help" || name == "-h" || name == "--help" {
+		usage(os.Stdout)
+		return
+	}
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+
+	opts := &options{}
+	flags := flag.NewFlagSet("kubectl flyway "+name, flag.ExitOnError)
+	flags.StringVar(&opts.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file")
+	flags.StringVar(&opts.context, "context", "", "The kubeconfig context to use")
+	flags.StringVar(&opts.namespace, "n", "", "The namespace of the migrations, the one of the context by default")
+	flags.StringVar(&opts.namespace, "namespace", "", "The namespace of the migrations, the one of the context by default")
+	if cmd.flags != nil {
+		cmd.flags(flags, opts)
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "%s\n\nUsage:\n  kubectl flyway %s [flags]\n\nFlags:\n", cmd.description, cmd.usage)
+		flags.PrintDefaults()
+	}
+	args := parseInterleaved(flags, os.Args[2:])
+
+	p, err := newPlugin(opts)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	if err := cmd.run(ctx, p, args); err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
+
+// parseInterleaved parses the flags wherever they are among the positional arguments, as kubectl does
+func parseInterleaved(flags *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		flags.Parse(args)
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func usage(out io.Writer) {
+	fmt.Fprint(out, "Operate the migrations of the flyway operator.\n\nUsage:\n  kubectl flyway COMMAND [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-34s %s\n", commands[name].usage, commands[name].description)
+	}
+	fmt.Fprint(out, "\nRun kubectl flyway COMMAND -h for the flags of a command.\n")
+}
+
+func newPlugin(opts *options) (*plugin, error) {
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = opts.kubeconfig
+	config := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, &clientcmd.ConfigOverrides{CurrentContext: opts.context})
+
+	restConfig, err := config.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	namespace := opts.namespace
+	if namespace == "" {
+		if namespace, _, err = config.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	if err := migrationsv1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
+	c, err := client.New(restConfig, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	clientset, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &plugin{
+		client:    c,
+		clientset: clientset,
+		scheme:    scheme,
+		namespace: namespace,
+		opts:      opts,
+		out:       os.Stdout,
+	}, nil
+}
+
+// migrationArg reads the migration named by the single positional argument
+func (p *plugin) migrationArg(ctx context.Context, args []string) (*migrationsv1alpha1.Migration, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf("expected the name of a migration, got %q", strings.Join(args, " "))
+	}
+	var migration migrationsv1alpha1.Migration
+	if err := p.client.Get(ctx, client.ObjectKey{Namespace: p.namespace, Name: args[0]}, &migration); err != nil {
+		return nil, err
+	}
+	return &migration, nil
+}
@@ -0,0 +1,196 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"reflect"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+func newTestPlugin(t *testing.T, objects ...runtime.Object) (*plugin, *bytes.Buffer) {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrationsv1alpha1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	out := &bytes.Buffer{}
+	return &plugin{
+		client:    fake.NewFakeClientWithScheme(scheme, objects...),
+		scheme:    scheme,
+		namespace: "shop",
+		opts:      &options{},
+		out:       out,
+	}, out
+}
+
+func newTestMigration(name string, phase migrationsv1alpha1.MigrationPhase) *migrationsv1alpha1.Migration {
+	return &migrationsv1alpha1.Migration{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+		Status: migrationsv1alpha1.MigrationStatus{
+			Phase:          phase,
+			CurrentVersion: "3",
+			Targets: []migrationsv1alpha1.TargetStatus{
+				{Name: "eu", Phase: migrationsv1alpha1.MigrationSucceeded, CurrentVersion: "3", Job: "flyway-orders-eu-1"},
+				{Name: "us", Phase: migrationsv1alpha1.MigrationFailed, CurrentVersion: "2", Message: "V3 failed\nstack trace"},
+			},
+		},
+	}
+}
+
+func getMigration(t *testing.T, p *plugin, name string) *migrationsv1alpha1.Migration {
+	t.Helper()
+	var migration migrationsv1alpha1.Migration
+	if err := p.client.Get(context.Background(), client.ObjectKey{Namespace: "shop", Name: name}, &migration); err != nil {
+		t.Fatal(err)
+	}
+	return &migration
+}
+
+func TestRerunLeavesTheRequesterToTheOperator(t *testing.T) {
+	for _, test := range []struct {
+		phase migrationsv1alpha1.MigrationPhase
+		out   string
+	}{
+		{phase: migrationsv1alpha1.MigrationFailed, out: "migration orders rerun requested\n"},
+		{phase: migrationsv1alpha1.MigrationRunning, out: "migration orders is Running, it runs again once the current run is over\n"},
+	} {
+		t.Run(string(test.phase), func(t *testing.T) {
+			p, out := newTestPlugin(t, newTestMigration("orders", test.phase))
+			if err := runRerun(context.Background(), p, []string{"orders"}); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != test.out {
+				t.Errorf("printed %q, want %q", out.String(), test.out)
+			}
+			value, ok := getMigration(t, p, "orders").ObjectMeta.Annotations[migrationsv1alpha1.RerunAnnotation]
+			if !ok || value != "" {
+				t.Errorf("rerun annotation is %q (set: %v), want an empty value for the webhook to stamp", value, ok)
+			}
+		})
+	}
+}
+
+func TestApprove(t *testing.T) {
+	migration := newTestMigration("orders", migrationsv1alpha1.MigrationAwaitingApproval)
+	p, _ := newTestPlugin(t, migration)
+	if err := runApprove(context.Background(), p, []string{"orders"}); err == nil || !strings.Contains(err.Error(), "does not require approval") {
+		t.Fatalf("expected the approval to be refused, got %v", err)
+	}
+
+	migration.Spec.RequireApproval = true
+	p, out := newTestPlugin(t, migration)
+	if err := runApprove(context.Background(), p, []string{"orders"}); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "migration orders approved\n" {
+		t.Errorf("printed %q", out.String())
+	}
+	if value, ok := getMigration(t, p, "orders").ObjectMeta.Annotations[migrationsv1alpha1.ApproveAnnotation]; !ok || value != "" {
+		t.Errorf("approve annotation is %q (set: %v), want an empty value for the webhook to stamp", value, ok)
+	}
+}
+
+func TestSuspendAndResume(t *testing.T) {
+	p, out := newTestPlugin(t, newTestMigration("orders", migrationsv1alpha1.MigrationRunning))
+	ctx := context.Background()
+
+	if err := setSuspend(ctx, p, []string{"orders"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if !getMigration(t, p, "orders").Spec.Suspend {
+		t.Error("migration was not suspended")
+	}
+	if err := setSuspend(ctx, p, []string{"orders"}, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := setSuspend(ctx, p, []string{"orders"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if getMigration(t, p, "orders").Spec.Suspend {
+		t.Error("migration was not resumed")
+	}
+	want := "migration orders suspended\nmigration orders is already suspended\nmigration orders resumed\n"
+	if out.String() != want {
+		t.Errorf("printed %q, want %q", out.String(), want)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	p, out := newTestPlugin(t, newTestMigration("orders", migrationsv1alpha1.MigrationFailed), newTestMigration("billing", migrationsv1alpha1.MigrationSucceeded))
+	if err := runStatus(context.Background(), p, nil); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "NAME") || !strings.Contains(out.String(), "Failed") || !strings.Contains(out.String(), "1/2") {
+		t.Errorf("unexpected migration list:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := runStatus(context.Background(), p, []string{"orders"}); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Migration:  shop/orders\n", "Phase:      Failed\n", "flyway-orders-eu-1", "V3 failed ...\n"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("status of orders misses %q:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestHistoryOfAnUnknownTarget(t *testing.T) {
+	p, _ := newTestPlugin(t, newTestMigration("orders", migrationsv1alpha1.MigrationSucceeded))
+	p.opts.target = "asia"
+	if err := runHistory(context.Background(), p, []string{"orders"}); err == nil || !strings.Contains(err.Error(), `no target "asia"`) {
+		t.Errorf("expected an unknown target error, got %v", err)
+	}
+}
+
+func TestMigrationArg(t *testing.T) {
+	p, _ := newTestPlugin(t)
+	if _, err := p.migrationArg(context.Background(), nil); err == nil {
+		t.Error("expected an error without migration name")
+	}
+	if _, err := p.migrationArg(context.Background(), []string{"orders", "billing"}); err == nil {
+		t.Error("expected an error with several migration names")
+	}
+}
+
+func TestParseInterleaved(t *testing.T) {
+	opts := &options{}
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.StringVar(&opts.namespace, "n", "", "")
+	targetFlag(flags, opts)
+
+	args := parseInterleaved(flags, []string{"orders", "-n", "shop", "--target", "eu"})
+	if !reflect.DeepEqual(args, []string{"orders"}) || opts.namespace != "shop" || opts.target != "eu" {
+		t.Errorf("parsed %v, namespace %q, target %q", args, opts.namespace, opts.target)
+	}
+}
@@ -0,0 +1,72 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"sigs.k8s.io/yaml"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+	"flyway-operator/controllers"
+)
+
+func runRender(ctx context.Context, p *plugin, args []string) error {
+	var migration *migrationsv1alpha1.Migration
+	if p.opts.file != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("either a migration name or a file must be given")
+		}
+		manifest, err := ioutil.ReadFile(p.opts.file)
+		if err != nil {
+			return err
+		}
+		migration = &migrationsv1alpha1.Migration{}
+		if err := yaml.UnmarshalStrict(manifest, migration); err != nil {
+			return fmt.Errorf("unable to read migration %s: %v", p.opts.file, err)
+		}
+		if migration.ObjectMeta.Namespace == "" {
+			migration.ObjectMeta.Namespace = p.namespace
+		}
+	} else {
+		var err error
+		if migration, err = p.migrationArg(ctx, args); err != nil {
+			return err
+		}
+	}
+
+	jobs, err := controllers.RenderJobs(ctx, p.client, p.scheme, migration)
+	if err != nil {
+		return err
+	}
+	for i, job := range jobs {
+		job.TypeMeta.APIVersion = batchv1.SchemeGroupVersion.String()
+		job.TypeMeta.Kind = "Job"
+		manifest, err := yaml.Marshal(job)
+		if err != nil {
+			return err
+		}
+		if i > 0 {
+			fmt.Fprintln(p.out, "---")
+		}
+		fmt.Fprint(p.out, string(manifest))
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+func runStatus(ctx context.Context, p *plugin, args []string) error {
+	if len(args) > 0 {
+		migration, err := p.migrationArg(ctx, args)
+		if err != nil {
+			return err
+		}
+		printMigration(p, migration)
+		return nil
+	}
+
+	var migrations migrationsv1alpha1.MigrationList
+	var opts []client.ListOption
+	if !p.opts.allNamespaces {
+		opts = append(opts, client.InNamespace(p.namespace))
+	}
+	if err := p.client.List(ctx, &migrations, opts...); err != nil {
+		return err
+	}
+	if len(migrations.Items) == 0 {
+		fmt.Fprintln(p.out, "No migrations found.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 0, 3, ' ', 0)
+	header := "NAME\tPHASE\tVERSION\tPENDING\tTARGETS\tAGE"
+	if p.opts.allNamespaces {
+		header = "NAMESPACE\t" + header
+	}
+	fmt.Fprintln(w, header)
+	for i := range migrations.Items {
+		migration := &migrations.Items[i]
+		succeeded := 0
+		for _, target := range migration.Status.Targets {
+			if target.Phase == migrationsv1alpha1.MigrationSucceeded {
+				succeeded++
+			}
+		}
+		row := fmt.Sprintf("%s\t%s\t%s\t%d\t%d/%d\t%s", migration.ObjectMeta.Name, orNone(string(migration.Status.Phase)),
+			orNone(migration.Status.CurrentVersion), migration.Status.PendingMigrations, succeeded, len(migration.Status.Targets),
+			age(migration.ObjectMeta.CreationTimestamp))
+		if p.opts.allNamespaces {
+			row = migration.ObjectMeta.Namespace + "\t" + row
+		}
+		fmt.Fprintln(w, row)
+	}
+	return w.Flush()
+}
+
+// printMigration describes one migration and the latest run of its targets
+func printMigration(p *plugin, migration *migrationsv1alpha1.Migration) {
+	status := &migration.Status
+	fmt.Fprintf(p.out, "Migration:  %s/%s\n", migration.ObjectMeta.Namespace, migration.ObjectMeta.Name)
+	fmt.Fprintf(p.out, "Phase:      %s\n", orNone(string(status.Phase)))
+	fmt.Fprintf(p.out, "Version:    %s\n", orNone(status.CurrentVersion))
+	if migration.Spec.TargetVersion != "" {
+		fmt.Fprintf(p.out, "Target:     %s\n", migration.Spec.TargetVersion)
+	}
+	if status.Revision != "" {
+		fmt.Fprintf(p.out, "Revision:   %s\n", status.Revision)
+	}
+	if migration.Spec.Suspend {
+		fmt.Fprintln(p.out, "Suspended:  true")
+	}
+	if status.Phase == migrationsv1alpha1.MigrationAwaitingApproval {
+		fmt.Fprintf(p.out, "Approval:   run kubectl flyway approve %s -n %s\n", migration.ObjectMeta.Name, migration.ObjectMeta.Namespace)
+	}
+	for _, condition := range status.Conditions {
+		if condition.Status != corev1.ConditionTrue && condition.Type == migrationsv1alpha1.ConditionReady {
+			fmt.Fprintf(p.out, "Not ready:  %s: %s\n", condition.Reason, condition.Message)
+		}
+	}
+	if len(status.Targets) == 0 {
+		return
+	}
+
+	fmt.Fprintln(p.out)
+	w := tabwriter.NewWriter(p.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tPHASE\tVERSION\tPENDING\tJOB\tMESSAGE")
+	for _, target := range status.Targets {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", target.Name, orNone(string(target.Phase)), orNone(target.CurrentVersion),
+			target.PendingMigrations, orNone(target.Job), firstLine(target.Message))
+	}
+	w.Flush()
+}
+
+func runHistory(ctx context.Context, p *plugin, args []string) error {
+	migration, err := p.migrationArg(ctx, args)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(p.out, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(w, "TARGET\tRANK\tVERSION\tDESCRIPTION\tTYPE\tINSTALLED ON\tDURATION\tSUCCESS")
+	found := false
+	for _, target := range migration.Status.Targets {
+		if p.opts.target != "" && target.Name != p.opts.target {
+			continue
+		}
+		found = true
+		for _, script := range target.AppliedScripts {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\t%s\n", target.Name, script.InstalledRank, orNone(script.Version),
+				script.Description, script.Type, script.InstalledOn.Format(time.RFC3339),
+				(time.Duration(script.ExecutionTime) * time.Millisecond).String(), strconv.FormatBool(script.Success))
+		}
+	}
+	if !found {
+		return fmt.Errorf("migration %s has no target %q", migration.ObjectMeta.Name, p.opts.target)
+	}
+	return w.Flush()
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "<none>"
+	}
+	return value
+}
+
+// firstLine keeps the table on one line per target, flyway errors span several
+func firstLine(message string) string {
+	if i := strings.IndexByte(message, '\n'); i >= 0 {
+		return message[:i] + " ..."
+	}
+	return message
+}
+
+func age(created metav1.Time) string {
+	if created.IsZero() {
+		return "<unknown>"
+	}
+	return duration.ShortHumanDuration(time.Since(created.Time))
+}
@@ -0,0 +1,282 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type (
+	// AWSCredentialsProvider reads the AWS credentials of the operator, from EKS pod identity, from the IRSA
+	// web identity token or from static keys, in that order. Temporary credentials are cached until they expire.
+	AWSCredentialsProvider struct {
+		HTTPClient *http.Client
+		// Getenv reads the environment, os.Getenv when nil
+		Getenv func(string) string
+		// STSEndpoint the web identity token is exchanged at, the regional endpoint of AWS_REGION when empty
+		STSEndpoint string
+
+		mu     sync.Mutex
+		cached *awsCredentials
+	}
+
+	awsCredentials struct {
+		AccessKeyID     string
+		SecretAccessKey string
+		SessionToken    string
+		// Expiration is zero for static keys
+		Expiration time.Time
+	}
+
+	// podIdentityCredentials is the response of the EKS pod identity agent
+	podIdentityCredentials struct {
+		AccessKeyID     string    `json:"AccessKeyId"`
+		SecretAccessKey string    `json:"SecretAccessKey"`
+		Token           string    `json:"Token"`
+		Expiration      time.Time `json:"Expiration"`
+	}
+
+	// webIdentityResponse is the response of STS AssumeRoleWithWebIdentity
+	webIdentityResponse struct {
+		Credentials struct {
+			AccessKeyID     string    `xml:"AccessKeyId"`
+			SecretAccessKey string    `xml:"SecretAccessKey"`
+			SessionToken    string    `xml:"SessionToken"`
+			Expiration      time.Time `xml:"Expiration"`
+		} `xml:"AssumeRoleWithWebIdentityResult>Credentials"`
+	}
+)
+
+const (
+	// awsCredentialsRefreshMargin renews temporary credentials before they expire
+	awsCredentialsRefreshMargin = 5 * time.Minute
+	awsSigningAlgorithm         = "AWS4-HMAC-SHA256"
+	awsTimeFormat               = "20060102T150405Z"
+	defaultAWSRoleSessionName   = "flyway-operator"
+	// rdsTokenLifetime is how long RDS accepts new connections authenticated with a token
+	rdsTokenLifetime = 15 * time.Minute
+)
+
+// DefaultAWSCredentials is the provider of the credentials generating RDS IAM tokens
+var DefaultAWSCredentials = &AWSCredentialsProvider{HTTPClient: &http.Client{Timeout: 10 * time.Second}}
+
+// Region returns the region of the operator
+func (p *AWSCredentialsProvider) Region() string {
+	if region := p.getenv("AWS_REGION"); region != "" {
+		return region
+	}
+	return p.getenv("AWS_DEFAULT_REGION")
+}
+
+// Retrieve returns the cached credentials unless they are about to expire
+func (p *AWSCredentialsProvider) Retrieve(ctx context.Context) (*awsCredentials, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if creds := p.cached; creds != nil && (creds.Expiration.IsZero() || time.Until(creds.Expiration) > awsCredentialsRefreshMargin) {
+		return creds, nil
+	}
+	var (
+		creds *awsCredentials
+		err   error
+	)
+	switch {
+	case p.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI") != "":
+		creds, err = p.podIdentity(ctx)
+	case p.getenv("AWS_WEB_IDENTITY_TOKEN_FILE") != "" && p.getenv("AWS_ROLE_ARN") != "":
+		creds, err = p.webIdentity(ctx)
+	case p.getenv("AWS_ACCESS_KEY_ID") != "" && p.getenv("AWS_SECRET_ACCESS_KEY") != "":
+		creds = &awsCredentials{
+			AccessKeyID:     p.getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: p.getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    p.getenv("AWS_SESSION_TOKEN"),
+		}
+	default:
+		return nil, newMigrationError(ReasonAWSCredentialsMissing, "no AWS credentials are available to the operator, set up IRSA or EKS pod identity for its service account")
+	}
+	if err != nil {
+		return nil, newTransientError(ReasonAWSCredentialsMissing, "unable to retrieve the AWS credentials of the operator: %v", err)
+	}
+	p.cached = creds
+	return creds, nil
+}
+
+// podIdentity asks the EKS pod identity agent, or any container credentials endpoint
+func (p *AWSCredentialsProvider) podIdentity(ctx context.Context) (*awsCredentials, error) {
+	req, err := http.NewRequest(http.MethodGet, p.getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"), nil)
+	if err != nil {
+		return nil, err
+	}
+	authorization := p.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	if file := p.getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); file != "" {
+		token, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		authorization = strings.TrimSpace(string(token))
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	var response podIdentityCredentials
+	if err := fetch(p.HTTPClient, req.WithContext(ctx), func(body []byte) error { return json.Unmarshal(body, &response) }); err != nil {
+		return nil, err
+	}
+	return &awsCredentials{
+		AccessKeyID:     response.AccessKeyID,
+		SecretAccessKey: response.SecretAccessKey,
+		SessionToken:    response.Token,
+		Expiration:      response.Expiration,
+	}, nil
+}
+
+// webIdentity exchanges the projected service account token of IRSA for the credentials of the role
+func (p *AWSCredentialsProvider) webIdentity(ctx context.Context) (*awsCredentials, error) {
+	token, err := ioutil.ReadFile(p.getenv("AWS_WEB_IDENTITY_TOKEN_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	session := p.getenv("AWS_ROLE_SESSION_NAME")
+	if session == "" {
+		session = defaultAWSRoleSessionName
+	}
+	form := url.Values{
+		"Action":           {"AssumeRoleWithWebIdentity"},
+		"Version":          {"2011-06-15"},
+		"RoleArn":          {p.getenv("AWS_ROLE_ARN")},
+		"RoleSessionName":  {session},
+		"WebIdentityToken": {strings.TrimSpace(string(token))},
+	}
+	endpoint := p.STSEndpoint
+	if endpoint == "" {
+		endpoint = "https://sts.amazonaws.com/"
+		if region := p.Region(); region != "" {
+			endpoint = "https://sts." + region + ".amazonaws.com/"
+		}
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var response webIdentityResponse
+	if err := fetch(p.HTTPClient, req.WithContext(ctx), func(body []byte) error { return xml.Unmarshal(body, &response) }); err != nil {
+		return nil, err
+	}
+	return &awsCredentials{
+		AccessKeyID:     response.Credentials.AccessKeyID,
+		SecretAccessKey: response.Credentials.SecretAccessKey,
+		SessionToken:    response.Credentials.SessionToken,
+		Expiration:      response.Credentials.Expiration,
+	}, nil
+}
+
+// fetch sends a request and decodes the body of its successful response
+func fetch(client *http.Client, req *http.Request, decode func([]byte) error) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return decode(body)
+}
+
+func (p *AWSCredentialsProvider) getenv(key string) string {
+	if p.Getenv != nil {
+		return p.Getenv(key)
+	}
+	return os.Getenv(key)
+}
+
+// rdsAuthToken presigns the connect action of a database user, RDS accepts the result as its password
+func rdsAuthToken(creds *awsCredentials, host string, port int32, region, user string, now time.Time) string {
+	endpoint := fmt.Sprintf("%s:%d", host, port)
+	query := url.Values{"Action": {"connect"}, "DBUser": {user}}
+	emptyPayload := sha256.Sum256(nil)
+	return endpoint + "/?" + presignV4(creds, http.MethodGet, endpoint, "/", query, "rds-db", region, hex.EncodeToString(emptyPayload[:]), rdsTokenLifetime, now)
+}
+
+// presignV4 returns the query of a request presigned with AWS signature version 4, signature included.
+// Only the host header is signed, the payload hash is the hex sha256 of the body or UNSIGNED-PAYLOAD.
+func presignV4(creds *awsCredentials, method, host, path string, query url.Values, service, region, payloadHash string, expires time.Duration, now time.Time) string {
+	now = now.UTC()
+	date := now.Format(awsTimeFormat)
+	scope := strings.Join([]string{date[:8], region, service, "aws4_request"}, "/")
+
+	signed := url.Values{}
+	for key, values := range query {
+		signed[key] = values
+	}
+	signed.Set("X-Amz-Algorithm", awsSigningAlgorithm)
+	signed.Set("X-Amz-Credential", creds.AccessKeyID+"/"+scope)
+	signed.Set("X-Amz-Date", date)
+	signed.Set("X-Amz-Expires", fmt.Sprint(int64(expires/time.Second)))
+	signed.Set("X-Amz-SignedHeaders", "host")
+	if creds.SessionToken != "" {
+		signed.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+	canonicalQuery := awsCanonicalQuery(signed)
+
+	canonicalRequest := strings.Join([]string{method, path, canonicalQuery, "host:" + host + "\n", "host", payloadHash}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{awsSigningAlgorithm, date, scope, hex.EncodeToString(requestHash[:])}, "\n")
+
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{date[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	return canonicalQuery + "&X-Amz-Signature=" + signature
+}
+
+// awsCanonicalQuery sorts the parameters and escapes everything but the unreserved characters
+func awsCanonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+func awsEscape(value string) string {
+	return strings.Replace(url.QueryEscape(value), "+", "%20", -1)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,330 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	backupContainerName = "backup"
+	uploadContainerName = "upload"
+	backupVolumeName    = "backup"
+	backupMountPath     = "/backup"
+	// objectStorageImage uploads the dumps to S3 compatible storages
+	objectStorageImage = "amazon/aws-cli:2.1.0"
+	pvcScheme          = "pvc://"
+	// backupPollInterval is how often volume snapshots are checked, backup jobs are watched
+	backupPollInterval = 10 * time.Second
+)
+
+var volumeSnapshotGVK = schema.GroupVersionKind{Group: "snapshot.storage.k8s.io", Version: "v1beta1", Kind: "VolumeSnapshot"}
+
+// startBackup dumps or snapshots a locked target, its run starts once the backup succeeded
+func (r *MigrationReconciler) startBackup(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus) error {
+	now := time.Now()
+	name := fmt.Sprintf("%s-backup-%s", run.target.job, now.UTC().Format("20060102150405"))
+
+	var location string
+	if snapshot := migration.Spec.Backup.VolumeSnapshot; snapshot != nil {
+		// volume snapshots outlive the migration like the dumps do
+		if err := r.Create(ctx, buildVolumeSnapshot(migration, run, name)); err != nil {
+			return err
+		}
+		location = fmt.Sprintf("volumesnapshot://%s/%s", migration.ObjectMeta.Namespace, name)
+	} else {
+		if err := r.issueToken(ctx, migration, run); err != nil {
+			return err
+		}
+		job, dump, err := buildBackupJob(migration, run, name)
+		if err != nil {
+			return err
+		}
+		if err := ctrl.SetControllerReference(migration, job, r.Scheme); err != nil {
+			return err
+		}
+		if err := createJob(ctx, r.Client, job); err != nil {
+			return err
+		}
+		location = dump
+	}
+
+	r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonBackupStarted, "backup %s of %s started", name, run.target.name)
+	started := metav1.NewTime(now)
+	status.Phase = migrationsv1alpha1.MigrationRunning
+	status.Job = ""
+	status.QueuePosition = 0
+	status.Message = ""
+	status.Backup = &migrationsv1alpha1.BackupStatus{
+		Name:      name,
+		Phase:     migrationsv1alpha1.MigrationRunning,
+		Location:  location,
+		StartedAt: &started,
+	}
+	return nil
+}
+
+// updateBackup follows the backup of a target and starts its run once the backup succeeded
+func (r *MigrationReconciler) updateBackup(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus) error {
+	backup := status.Backup
+	phase, message, err := r.backupPhase(ctx, migration, backup.Name)
+	if err != nil || phase == migrationsv1alpha1.MigrationRunning {
+		return err
+	}
+
+	completed := metav1.Now()
+	backup.CompletedAt = &completed
+	backup.Phase = phase
+	if phase == migrationsv1alpha1.MigrationFailed {
+		backup.Message = message
+		status.Phase = migrationsv1alpha1.MigrationFailed
+		status.Message = fmt.Sprintf("backup %s failed, %s was not migrated: %s", backup.Name, run.target.name, message)
+		r.Recorder.Event(migration, corev1.EventTypeWarning, ReasonBackupFailed, status.Message)
+		return r.unlockTarget(ctx, migration, run.target)
+	}
+
+	r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonBackupSucceeded, "backup of %s stored at %s", run.target.name, backup.Location)
+	if err := r.pruneBackups(ctx, migration, run.target); err != nil {
+		r.Log.Error(err, "unable to prune backups", "migration", migration.ObjectMeta.Name, "target", run.target.name)
+	}
+
+	if schedule := migration.Spec.Schedule; schedule != nil && schedule.HardStop && migration.Status.WindowCloses != nil {
+		run.deadline = migration.Status.WindowCloses.Time
+	}
+	if err := r.runTarget(ctx, migration, run, status); err != nil {
+		if unlockErr := r.unlockTarget(ctx, migration, run.target); unlockErr != nil {
+			return unlockErr
+		}
+		return err
+	}
+	return nil
+}
+
+// backupPhase reports whether the backup job or volume snapshot is over, with the error of failed ones
+func (r *MigrationReconciler) backupPhase(ctx context.Context, migration *migrationsv1alpha1.Migration, name string) (migrationsv1alpha1.MigrationPhase, string, error) {
+	key := client.ObjectKey{Namespace: migration.ObjectMeta.Namespace, Name: name}
+	if migration.Spec.Backup.VolumeSnapshot == nil {
+		var job batchv1.Job
+		if err := r.Get(ctx, key, &job); apierrors.IsNotFound(err) {
+			return migrationsv1alpha1.MigrationFailed, "the backup job was deleted", nil
+		} else if err != nil {
+			return "", "", err
+		}
+		return jobPhase(&job), "the backup job failed", nil
+	}
+
+	snapshot := unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	if err := r.APIReader.Get(ctx, key, &snapshot); apierrors.IsNotFound(err) {
+		return migrationsv1alpha1.MigrationFailed, "the volume snapshot was deleted", nil
+	} else if err != nil {
+		return "", "", err
+	}
+	if message, found, _ := unstructured.NestedString(snapshot.Object, "status", "error", "message"); found {
+		return migrationsv1alpha1.MigrationFailed, message, nil
+	}
+	if ready, _, _ := unstructured.NestedBool(snapshot.Object, "status", "readyToUse"); ready {
+		return migrationsv1alpha1.MigrationSucceeded, "", nil
+	}
+	return migrationsv1alpha1.MigrationRunning, "", nil
+}
+
+// pruneBackups deletes the backup jobs or volume snapshots of the target beyond the retention,
+// the dumps are pruned by the backup jobs themselves
+func (r *MigrationReconciler) pruneBackups(ctx context.Context, migration *migrationsv1alpha1.Migration, target *migrationTarget) error {
+	selector := client.MatchingLabels{
+		migrationsv1alpha1.MigrationNameLabel: migration.ObjectMeta.Name,
+		migrationsv1alpha1.TargetNameLabel:    target.name,
+		migrationsv1alpha1.BackupLabel:        "true",
+	}
+	namespace := client.InNamespace(migration.ObjectMeta.Namespace)
+
+	var backups []metav1.Object
+	if migration.Spec.Backup.VolumeSnapshot != nil {
+		snapshots := unstructured.UnstructuredList{}
+		snapshots.SetGroupVersionKind(volumeSnapshotGVK.GroupVersion().WithKind(volumeSnapshotGVK.Kind + "List"))
+		if err := r.APIReader.List(ctx, &snapshots, namespace, selector); err != nil {
+			return err
+		}
+		for i := range snapshots.Items {
+			backups = append(backups, &snapshots.Items[i])
+		}
+	} else {
+		var jobs batchv1.JobList
+		if err := r.List(ctx, &jobs, namespace, selector); err != nil {
+			return err
+		}
+		for i := range jobs.Items {
+			backups = append(backups, &jobs.Items[i])
+		}
+	}
+
+	for _, backup := range expiredObjects(backups, int(migration.Spec.Backup.KeepLast)) {
+		if err := r.Delete(ctx, backup, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiredObjects returns the objects beyond the most recent ones to keep
+func expiredObjects(objects []metav1.Object, keep int) []runtime.Object {
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].GetCreationTimestamp().After(objects[j].GetCreationTimestamp().Time)
+	})
+	var expired []runtime.Object
+	for i := keep; i < len(objects); i++ {
+		expired = append(expired, objects[i].(runtime.Object))
+	}
+	return expired
+}
+
+// buildBackupJob renders the job dumping the database of a target, it returns the job and the location of the dump
+func buildBackupJob(migration *migrationsv1alpha1.Migration, run *flywayRun, name string) (*batchv1.Job, string, error) {
+	dumper, ok := run.driver.(Dumper)
+	if !ok {
+		return nil, "", newMigrationError(ReasonBackupUnsupported, "driver %s cannot dump databases, use a volume snapshot backup instead", run.target.db.Driver)
+	}
+	spec := migration.Spec.Backup
+	image := spec.Image
+	if image == "" {
+		image = dumper.DumpImage()
+	}
+
+	dir := path.Join(migration.ObjectMeta.Name, run.target.name)
+	file := strings.TrimPrefix(name, run.target.job+"-backup-") + ".dump"
+	keep := spec.KeepLast
+	if keep <= 0 {
+		keep = migrationsv1alpha1.DefaultBackupKeepLast
+	}
+
+	job := newDatabaseJob(migration, run, name, "true", backupContainerName, image)
+	dump := &job.Spec.Template.Spec.Containers[0]
+
+	if spec.VolumeClaim != "" {
+		target := path.Join(backupMountPath, dir)
+		dump.Command = []string{"sh", "-c", fmt.Sprintf("mkdir -p %s && %s && ls -1 %s/*.dump | sort -r | tail -n +%d | xargs -r rm -f",
+			shellQuote(target), dumper.DumpCommand(&run.target.db, path.Join(target, file)), shellQuote(target), keep+1)}
+		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, backupVolume(&corev1.PersistentVolumeClaimVolumeSource{ClaimName: spec.VolumeClaim}))
+		return job, fmt.Sprintf("%s%s/%s/%s", pvcScheme, spec.VolumeClaim, dir, file), nil
+	}
+
+	// the dump is written to a scratch volume and uploaded once complete
+	storage := spec.ObjectStorage
+	prefix := strings.TrimSuffix(storage.URL, "/") + "/" + path.Join(migration.ObjectMeta.Namespace, dir) + "/"
+	aws := awsCLI(storage)
+	dump.Command = []string{"sh", "-c", dumper.DumpCommand(&run.target.db, path.Join(backupMountPath, file))}
+	upload := objectStorageContainer(uploadContainerName, storage, fmt.Sprintf(`%[1]s s3 cp %[2]s %[3]s && %[1]s s3 ls %[4]s | awk '{print $4}' | grep '\.dump$' | sort -r | tail -n +%[5]d | while read f; do %[1]s s3 rm %[4]s"$f"; done`,
+		aws, shellQuote(path.Join(backupMountPath, file)), shellQuote(prefix+file), shellQuote(prefix), keep+1))
+	job.Spec.Template.Spec.InitContainers = append(job.Spec.Template.Spec.InitContainers, *dump)
+	job.Spec.Template.Spec.Containers = []corev1.Container{upload}
+	job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, backupVolume(nil))
+	return job, prefix + file, nil
+}
+
+// newDatabaseJob renders a job whose single container connects to the database of the target with its credentials
+func newDatabaseJob(migration *migrationsv1alpha1.Migration, run *flywayRun, name, backupLabel, container, image string) *batchv1.Job {
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: migration.ObjectMeta.Namespace,
+			Labels: map[string]string{
+				migrationsv1alpha1.MigrationNameLabel: migration.ObjectMeta.Name,
+				migrationsv1alpha1.TargetNameLabel:    run.target.name,
+				migrationsv1alpha1.BackupLabel:        backupLabel,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: "Never",
+					Containers: []corev1.Container{
+						corev1.Container{
+							Name:            container,
+							Image:           image,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							VolumeMounts: []corev1.VolumeMount{
+								corev1.VolumeMount{Name: backupVolumeName, MountPath: backupMountPath},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	run.target.creds.MutateTemplate(&job.Spec.Template)
+	if connectivity := GetConnectivity(&run.target.db, migration.ObjectMeta.Namespace); connectivity != nil {
+		connectivity.MutateTemplate(&job.Spec.Template)
+	}
+	return &job
+}
+
+// objectStorageContainer runs the aws cli command with the credentials of the bucket
+func objectStorageContainer(name string, storage *migrationsv1alpha1.ObjectStorageSpec, command string) corev1.Container {
+	container := corev1.Container{
+		Name:            name,
+		Image:           objectStorageImage,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"sh", "-c", command},
+		VolumeMounts: []corev1.VolumeMount{
+			corev1.VolumeMount{Name: backupVolumeName, MountPath: backupMountPath},
+		},
+	}
+	if storage.Secret != "" {
+		container.EnvFrom = []corev1.EnvFromSource{
+			corev1.EnvFromSource{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: storage.Secret}}},
+		}
+	}
+	return container
+}
+
+func awsCLI(storage *migrationsv1alpha1.ObjectStorageSpec) string {
+	if storage.Endpoint == "" {
+		return "aws"
+	}
+	return "aws --endpoint-url " + shellQuote(storage.Endpoint)
+}
+
+// backupVolume mounts the claim holding the dumps, or a scratch volume for the dumps moved to object storage
+func backupVolume(claim *corev1.PersistentVolumeClaimVolumeSource) corev1.Volume {
+	if claim == nil {
+		return corev1.Volume{Name: backupVolumeName, VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}}
+	}
+	return corev1.Volume{Name: backupVolumeName, VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: claim}}
+}
+
+// buildVolumeSnapshot renders the snapshot of the database volume of a target
+func buildVolumeSnapshot(migration *migrationsv1alpha1.Migration, run *flywayRun, name string) *unstructured.Unstructured {
+	spec := migration.Spec.Backup.VolumeSnapshot
+	snapshot := &unstructured.Unstructured{}
+	snapshot.SetGroupVersionKind(volumeSnapshotGVK)
+	snapshot.SetName(name)
+	snapshot.SetNamespace(migration.ObjectMeta.Namespace)
+	snapshot.SetLabels(map[string]string{
+		migrationsv1alpha1.MigrationNameLabel: migration.ObjectMeta.Name,
+		migrationsv1alpha1.TargetNameLabel:    run.target.name,
+		migrationsv1alpha1.BackupLabel:        "true",
+	})
+	snapshot.Object["spec"] = map[string]interface{}{
+		"source": map[string]interface{}{"persistentVolumeClaimName": spec.ClaimName},
+	}
+	if spec.ClassName != "" {
+		unstructured.SetNestedField(snapshot.Object, spec.ClassName, "spec", "volumeSnapshotClassName")
+	}
+	return snapshot
+}
@@ -0,0 +1,97 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("backup", func() {
+	var (
+		migration *migrationsv1alpha1.Migration
+		run       *flywayRun
+	)
+
+	BeforeEach(func() {
+		migration = &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				Backup: &migrationsv1alpha1.BackupSpec{KeepLast: 3},
+			},
+		}
+		db := migrationsv1alpha1.DBSpec{Host: "db", Port: 5432, DBName: "orders", Driver: migrationsv1alpha1.PostgresDriver,
+			Secret: &migrationsv1alpha1.SecretSpec{Name: "db", UserKey: "user", PasswordKey: "password"}}
+		run = &flywayRun{
+			target: &migrationTarget{name: "orders", job: "flyway-orders", db: db, creds: GetCredentials(&db, "shop")},
+			driver: PostgresDriver{},
+		}
+	})
+
+	It("dumps to the volume claim and prunes the dumps beyond the retention", func() {
+		migration.Spec.Backup.VolumeClaim = "backups"
+		job, location, err := buildBackupJob(migration, run, "flyway-orders-backup-20200101000000")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(location).To(Equal("pvc://backups/orders/orders/20200101000000.dump"))
+		Expect(job.ObjectMeta.Labels).To(HaveKeyWithValue(migrationsv1alpha1.BackupLabel, "true"))
+		Expect(job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("backups"))
+		dump := job.Spec.Template.Spec.Containers[0]
+		Expect(dump.Image).To(Equal("postgres:13-alpine"))
+		Expect(dump.Command[2]).To(ContainSubstring("pg_dump --host='db' --port=5432"))
+		Expect(dump.Command[2]).To(ContainSubstring("tail -n +4"))
+		Expect(dump.Env).To(HaveLen(2))
+	})
+
+	It("uploads the dump to object storage once complete", func() {
+		migration.Spec.Backup.ObjectStorage = &migrationsv1alpha1.ObjectStorageSpec{URL: "s3://backups/flyway/", Endpoint: "http://minio:9000", Secret: "s3"}
+		job, location, err := buildBackupJob(migration, run, "flyway-orders-backup-20200101000000")
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(location).To(Equal("s3://backups/flyway/shop/orders/orders/20200101000000.dump"))
+		spec := job.Spec.Template.Spec
+		Expect(spec.InitContainers).To(HaveLen(1))
+		Expect(spec.InitContainers[0].Env).To(HaveLen(2))
+		Expect(spec.Containers[0].Name).To(Equal(uploadContainerName))
+		Expect(spec.Containers[0].Command[2]).To(HavePrefix("aws --endpoint-url 'http://minio:9000' s3 cp '/backup/20200101000000.dump'"))
+		Expect(spec.Containers[0].EnvFrom[0].SecretRef.Name).To(Equal("s3"))
+		Expect(spec.Volumes[0].EmptyDir).NotTo(BeNil())
+	})
+
+	It("expires the oldest backups", func() {
+		now := time.Now()
+		backup := func(name string, age time.Duration) metav1.Object {
+			return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name, CreationTimestamp: metav1.NewTime(now.Add(-age))}}
+		}
+		expired := expiredObjects([]metav1.Object{
+			backup("older", 2*time.Hour),
+			backup("newest", 0),
+			backup("oldest", 3*time.Hour),
+			backup("newer", time.Hour),
+		}, 2)
+
+		Expect(expired).To(HaveLen(2))
+		Expect(expired[0].(*batchv1.Job).ObjectMeta.Name).To(Equal("older"))
+		Expect(expired[1].(*batchv1.Job).ObjectMeta.Name).To(Equal("oldest"))
+	})
+})
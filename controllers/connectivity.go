@@ -0,0 +1,179 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/version"
+	k8sversion "k8s.io/apimachinery/pkg/version"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+type (
+	// Dialer opens the connections of the operator to a database it cannot reach directly
+	Dialer interface {
+		Dial(network, address string) (net.Conn, error)
+		DialTimeout(network, address string, timeout time.Duration) (net.Conn, error)
+	}
+
+	// Connectivity reaches a database which is not directly reachable, from the operator and from the pods
+	Connectivity interface {
+		// Dialer opens the connections of the operator
+		Dialer(ctx context.Context, c client.Reader) (Dialer, error)
+		// MutateTemplate adds the sidecar the pods connect through
+		MutateTemplate(tpl *corev1.PodTemplateSpec)
+	}
+
+	CloudSQLProxyConnectivity struct {
+		Spec     *migrationsv1alpha1.CloudSQLProxySpec
+		Port     int32
+		Provider *GoogleCredentialsProvider
+	}
+)
+
+const (
+	// CloudSQLProxyContainerName is the sidecar running the Cloud SQL Auth Proxy
+	CloudSQLProxyContainerName = "cloud-sql-proxy"
+	cloudSQLProxyImage         = "gcr.io/cloud-sql-connectors/cloud-sql-proxy:2.14.3"
+	cloudSQLProxyHealthPort    = 9090
+	// localhost is where the sidecars of the pods listen
+	localhost = "127.0.0.1"
+)
+
+// sidecarContainers are the init containers run as native sidecars, which the pods stop once their containers exited
+var sidecarContainers = map[string]bool{CloudSQLProxyContainerName: true, SSHTunnelContainerName: true}
+
+// NativeSidecars is cleared by the operator on clusters which do not run native sidecars, the jobs needing them are refused
+var NativeSidecars = true
+
+// nativeSidecarsVersion is the first version of Kubernetes running native sidecars by default
+var nativeSidecarsVersion = version.MustParseGeneric("1.29")
+
+// RunsNativeSidecars tells whether a cluster of the given version runs native sidecars. Older ones drop
+// the restart policy of init containers, the sidecars then never exit and the containers never start.
+func RunsNativeSidecars(info *k8sversion.Info) bool {
+	serverVersion, err := version.ParseGeneric(info.GitVersion)
+	return err == nil && serverVersion.AtLeast(nativeSidecarsVersion)
+}
+
+// GetConnectivity returns how the database is reached, nil when it is reached directly.
+// Secrets are read from the namespace of the pods connecting.
+func GetConnectivity(spec *migrationsv1alpha1.DBSpec, namespace string) Connectivity {
+	if spec.Connectivity != nil && spec.Connectivity.CloudSQLProxy != nil {
+		return CloudSQLProxyConnectivity{Spec: spec.Connectivity.CloudSQLProxy, Port: spec.Port, Provider: DefaultGoogleCredentials}
+	} else if spec.SSHTunnel != nil {
+		return SSHTunnelConnectivity{Spec: spec.SSHTunnel, Host: spec.Host, Port: spec.Port, Namespace: namespace}
+	}
+	return nil
+}
+
+// getDialer returns the dialer of the operator, nil when the database is reached directly
+func getDialer(ctx context.Context, c client.Reader, spec *migrationsv1alpha1.DBSpec, namespace string) (Dialer, error) {
+	connectivity := GetConnectivity(spec, namespace)
+	if connectivity == nil {
+		return nil, nil
+	}
+	return connectivity.Dialer(ctx, c)
+}
+
+// podAddress is the address the pods connect to, the local end of their sidecar when the database is not directly reachable.
+// Databases verified with the RDS CA bundle keep their host name, the pods resolve it to the sidecar.
+func podAddress(spec *migrationsv1alpha1.DBSpec) (string, int32) {
+	if GetConnectivity(spec, "") != nil && spec.RDSIAM == nil {
+		return localhost, spec.Port
+	}
+	return spec.Host, spec.Port
+}
+
+func (p CloudSQLProxyConnectivity) Dialer(ctx context.Context, c client.Reader) (Dialer, error) {
+	return newCloudSQLDialer(p.Spec, p.Provider)
+}
+
+// MutateTemplate starts the proxy before the containers connecting to it, on the port of the database.
+// The proxy exits cleanly once stopped, which the pod does when its containers exited so that the job completes.
+func (p CloudSQLProxyConnectivity) MutateTemplate(tpl *corev1.PodTemplateSpec) {
+	image := p.Spec.Image
+	if image == "" {
+		image = cloudSQLProxyImage
+	}
+	args := []string{
+		"--address=" + localhost,
+		fmt.Sprintf("--port=%d", p.Port),
+		"--health-check",
+		"--http-address=0.0.0.0",
+		fmt.Sprintf("--http-port=%d", cloudSQLProxyHealthPort),
+		"--exit-zero-on-sigterm",
+	}
+	if p.Spec.IAMUser != "" {
+		args = append(args, "--auto-iam-authn")
+	}
+	if p.Spec.PrivateIP {
+		args = append(args, "--private-ip")
+	}
+	nonRoot := true
+	proxy := corev1.Container{
+		Name:            CloudSQLProxyContainerName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Args:            append(args, p.Spec.Instance),
+		// the containers connecting to the proxy start once it accepts connections
+		StartupProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				HTTPGet: &corev1.HTTPGetAction{Path: "/startup", Port: intstr.FromInt(cloudSQLProxyHealthPort)},
+			},
+			PeriodSeconds:    1,
+			FailureThreshold: 60,
+		},
+		SecurityContext: &corev1.SecurityContext{RunAsNonRoot: &nonRoot},
+	}
+	tpl.Spec.InitContainers = append([]corev1.Container{proxy}, tpl.Spec.InitContainers...)
+	if p.Spec.ServiceAccountName != "" {
+		tpl.Spec.ServiceAccountName = p.Spec.ServiceAccountName
+	}
+}
+
+// createJob creates a job, the sidecars of its pods become native sidecars. The API version the operator is built with
+// has no restart policy on containers, the jobs running sidecars are created from their unstructured form instead.
+func createJob(ctx context.Context, c client.Client, job *batchv1.Job) error {
+	obj, err := runtime.DefaultUnstructuredConverter.ToUnstructured(job)
+	if err != nil {
+		return err
+	}
+	initContainers, _, err := unstructured.NestedSlice(obj, "spec", "template", "spec", "initContainers")
+	if err != nil {
+		return err
+	}
+	sidecars := false
+	for _, container := range initContainers {
+		container, ok := container.(map[string]interface{})
+		if ok && sidecarContainers[fmt.Sprint(container["name"])] {
+			container["restartPolicy"] = string(corev1.RestartPolicyAlways)
+			sidecars = true
+		}
+	}
+	if !sidecars {
+		return c.Create(ctx, job)
+	}
+	if !NativeSidecars {
+		return newMigrationError(ReasonSidecarsUnsupported, "job %s reaches the database through a sidecar, which requires Kubernetes 1.29 or later", job.ObjectMeta.Name)
+	}
+	if err := unstructured.SetNestedSlice(obj, initContainers, "spec", "template", "spec", "initContainers"); err != nil {
+		return err
+	}
+
+	u := &unstructured.Unstructured{Object: obj}
+	u.SetGroupVersionKind(batchv1.SchemeGroupVersion.WithKind("Job"))
+	if err := c.Create(ctx, u); err != nil {
+		return err
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, job)
+}
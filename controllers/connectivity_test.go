@@ -0,0 +1,278 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	k8sversion "k8s.io/apimachinery/pkg/version"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("Cloud SQL Auth Proxy connectivity", func() {
+	ctx := context.Background()
+
+	var spec *migrationsv1alpha1.DBSpec
+
+	BeforeEach(func() {
+		spec = &migrationsv1alpha1.DBSpec{
+			Driver: migrationsv1alpha1.PostgresDriver,
+			Host:   "orders",
+			Port:   5432,
+			DBName: "orders",
+			Connectivity: &migrationsv1alpha1.ConnectivitySpec{CloudSQLProxy: &migrationsv1alpha1.CloudSQLProxySpec{
+				Instance:           "shop:europe-west1:orders",
+				IAMUser:            "flyway@shop.iam",
+				PrivateIP:          true,
+				ServiceAccountName: "flyway",
+			}},
+		}
+	})
+
+	It("runs the proxy as a sidecar of the pods, which connect to it on localhost", func() {
+		tpl := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: FlywayContainerName}}}}
+		GetCredentials(spec, "shop").MutateTemplate(&tpl)
+		GetConnectivity(spec, "shop").MutateTemplate(&tpl)
+
+		Expect(tpl.Spec.ServiceAccountName).To(Equal("flyway"))
+		Expect(tpl.Spec.InitContainers).To(HaveLen(1))
+		proxy := tpl.Spec.InitContainers[0]
+		Expect(proxy.Name).To(Equal(CloudSQLProxyContainerName))
+		Expect(proxy.Args).To(ContainElement("--port=5432"))
+		Expect(proxy.Args).To(ContainElement("--exit-zero-on-sigterm"))
+		Expect(proxy.Args).To(ContainElement("--auto-iam-authn"))
+		Expect(proxy.Args).To(ContainElement("--private-ip"))
+		Expect(proxy.Args[len(proxy.Args)-1]).To(Equal("shop:europe-west1:orders"))
+		Expect(tpl.Spec.Containers[0].Env).To(ContainElement(corev1.EnvVar{Name: "FLYWAY_USER", Value: "flyway@shop.iam"}))
+
+		driver := PostgresDriver{}
+		Expect(driver.ConnectionURL(spec)).To(Equal("jdbc:postgresql://127.0.0.1:5432/orders"))
+		Expect(driver.DumpCommand(spec, "/backup/orders.dump")).To(ContainSubstring("--host='127.0.0.1' --port=5432"))
+	})
+
+	It("keeps the proxy next to the clone of the scripts in flyway jobs", func() {
+		migration := &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				SQL: migrationsv1alpha1.SQLSpec{Git: &migrationsv1alpha1.GitMigrationSpec{CheckoutURL: "git@example.com:shop/orders.git", Branch: "main"}},
+			},
+		}
+		run := &flywayRun{
+			target: &migrationTarget{name: "orders", job: "orders", db: *spec, creds: GetCredentials(spec, "shop")},
+			driver: PostgresDriver{},
+		}
+
+		job := buildJob(migration, run)
+		initContainers := job.Spec.Template.Spec.InitContainers
+		Expect(initContainers).To(HaveLen(2))
+		Expect(initContainers[0].Name).To(Equal(CloudSQLProxyContainerName))
+		Expect(initContainers[1].Name).To(Equal("git"))
+	})
+
+	It("creates the jobs running the proxy with a native sidecar", func() {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme)
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				RestartPolicy: corev1.RestartPolicyNever,
+				Containers:    []corev1.Container{{Name: FlywayContainerName}},
+			}}},
+		}
+		GetConnectivity(spec, "shop").MutateTemplate(&job.Spec.Template)
+		Expect(createJob(ctx, c, job)).To(Succeed())
+
+		created := &unstructured.Unstructured{}
+		created.SetGroupVersionKind(batchv1.SchemeGroupVersion.WithKind("Job"))
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "orders"}, created)).To(Succeed())
+		initContainers, _, _ := unstructured.NestedSlice(created.Object, "spec", "template", "spec", "initContainers")
+		Expect(initContainers).To(HaveLen(1))
+		Expect(initContainers[0]).To(HaveKeyWithValue("restartPolicy", "Always"))
+	})
+
+	It("refuses the jobs running the proxy on clusters without native sidecars", func() {
+		Expect(RunsNativeSidecars(&k8sversion.Info{GitVersion: "v1.29.1-gke.1589000"})).To(BeTrue())
+		Expect(RunsNativeSidecars(&k8sversion.Info{GitVersion: "v1.28.9"})).To(BeFalse())
+
+		defer func() { NativeSidecars = true }()
+		NativeSidecars = false
+		c := fake.NewFakeClientWithScheme(scheme.Scheme)
+		job := &batchv1.Job{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec: batchv1.JobSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+				Containers: []corev1.Container{{Name: FlywayContainerName}},
+			}}},
+		}
+		GetConnectivity(spec, "shop").MutateTemplate(&job.Spec.Template)
+		err := createJob(ctx, c, job)
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonSidecarsUnsupported))
+	})
+
+	// serveInstance answers the Admin API requests about the instance and accepts one connection presenting the certificate of name
+	serveInstance := func(name string) (dialer *cloudSQLDialer, accessToken *string, cleanup func()) {
+		ca, caKey := newTestCA()
+		accessToken = new(string)
+
+		metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			Expect(r.Header.Get("Metadata-Flavor")).To(Equal("Google"))
+			w.Write([]byte(`{"access_token":"ya29.token","expires_in":3600,"token_type":"Bearer"}`))
+		}))
+
+		server, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+			Certificates: []tls.Certificate{newTestCertificate(ca, caKey, name)},
+			ClientAuth:   tls.RequireAnyClientCert,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			conn, err := server.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.Write([]byte("hello"))
+		}()
+
+		admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer GinkgoRecover()
+			Expect(r.Header.Get("Authorization")).To(Equal("Bearer ya29.token"))
+			switch r.URL.Path {
+			case "/sql/v1beta4/projects/shop/instances/orders/connectSettings":
+				caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"region":          "europe-west1",
+					"backendType":     "SECOND_GEN",
+					"databaseVersion": "POSTGRES_15",
+					"serverCaCert":    map[string]string{"cert": string(caPEM)},
+					"ipAddresses":     []map[string]string{{"type": "PRIMARY", "ipAddress": "192.0.2.1"}, {"type": "PRIVATE", "ipAddress": "127.0.0.1"}},
+				})
+			case "/sql/v1beta4/projects/shop/instances/orders:generateEphemeralCert":
+				var request map[string]string
+				Expect(json.NewDecoder(r.Body).Decode(&request)).To(Succeed())
+				*accessToken = request["access_token"]
+				block, _ := pem.Decode([]byte(request["public_key"]))
+				public, err := x509.ParsePKIXPublicKey(block.Bytes)
+				Expect(err).NotTo(HaveOccurred())
+				cert := signTestCertificate(ca, caKey, "flyway", public)
+				json.NewEncoder(w).Encode(map[string]interface{}{
+					"ephemeralCert": map[string]string{"cert": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert}))},
+				})
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+
+		dialer, err = newCloudSQLDialer(spec.Connectivity.CloudSQLProxy, &GoogleCredentialsProvider{MetadataEndpoint: metadata.URL, AdminEndpoint: admin.URL + "/"})
+		Expect(err).NotTo(HaveOccurred())
+		// the instance serves the connections of the connector on the address of the test server instead of its port
+		dialer.dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			Expect(address).To(Equal("127.0.0.1:3307"))
+			return (&net.Dialer{}).DialContext(ctx, network, server.Addr().String())
+		}
+		return dialer, accessToken, func() {
+			server.Close()
+			admin.Close()
+			metadata.Close()
+		}
+	}
+
+	It("connects the operator to the private IP of the instance as its IAM user", func() {
+		dialer, accessToken, cleanup := serveInstance("shop:orders")
+		defer cleanup()
+
+		conn, err := dialer.Dial("tcp", "127.0.0.1:5432")
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		greeting, err := ioutil.ReadAll(conn)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(greeting)).To(Equal("hello"))
+		Expect(*accessToken).To(Equal("ya29.token"))
+	})
+
+	It("refuses instances presenting the certificate of another instance", func() {
+		dialer, _, cleanup := serveInstance("shop:payments")
+		defer cleanup()
+
+		_, err := dialer.Dial("tcp", "127.0.0.1:5432")
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("shop:payments"))
+
+		_, err = newCloudSQLDialer(&migrationsv1alpha1.CloudSQLProxySpec{Instance: "orders"}, DefaultGoogleCredentials)
+		Expect(err).To(HaveOccurred())
+	})
+})
+
+func newTestCA() (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Google Cloud SQL Server CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	Expect(err).NotTo(HaveOccurred())
+	ca, err := x509.ParseCertificate(raw)
+	Expect(err).NotTo(HaveOccurred())
+	return ca, key
+}
+
+func newTestCertificate(ca *x509.Certificate, caKey *rsa.PrivateKey, name string) tls.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	Expect(err).NotTo(HaveOccurred())
+	return tls.Certificate{Certificate: [][]byte{signTestCertificate(ca, caKey, name, &key.PublicKey)}, PrivateKey: key}
+}
+
+func signTestCertificate(ca *x509.Certificate, caKey *rsa.PrivateKey, name string, public interface{}) []byte {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	raw, err := x509.CreateCertificate(rand.Reader, template, ca, public, caKey)
+	Expect(err).NotTo(HaveOccurred())
+	return raw
+}
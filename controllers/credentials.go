@@ -2,18 +2,19 @@ package controllers
 
 import (
 	"context"
+	"time"
 
 	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/client/config"
 )
 
 type (
 	Credential interface {
-		GetUserPassword() (*UserPassword, error)
+		GetUserPassword(ctx context.Context, c client.Reader) (*UserPassword, error)
 		MutateTemplate(tpl *corev1.PodTemplateSpec)
 	}
 
@@ -22,6 +23,31 @@ type (
 		Namespace string
 	}
 
+	// MirroredSecretCredential reads a secret of another namespace, flyway pods read the copy made in their namespace
+	MirroredSecretCredential struct {
+		Source SecretCredential
+		Mirror *migrationsv1alpha1.SecretSpec
+	}
+
+	// RDSIAMCredential generates RDS IAM authentication tokens with the AWS credentials of the operator,
+	// flyway pods read the last token issued and the CA bundle verifying the database from Secret
+	RDSIAMCredential struct {
+		Spec     *migrationsv1alpha1.RDSIAMSpec
+		Host     string
+		Port     int32
+		Provider *AWSCredentialsProvider
+		CABundle *RDSCABundle
+		// Forwarded is set when the pods reach the database through a sidecar listening on localhost
+		Forwarded bool
+		Secret    string
+	}
+
+	// CloudSQLIAMCredential connects as an IAM database user, the Cloud SQL Auth Proxy and the operator
+	// authenticate it with the access token of their workload identity instead of a password
+	CloudSQLIAMCredential struct {
+		User string
+	}
+
 	VaultCredential struct {
 		Spec *migrationsv1alpha1.VaultSpec
 	}
@@ -29,31 +55,38 @@ type (
 	UserPassword struct {
 		User     string
 		Password string
+		// Expires is set when the password is a token, zero otherwise
+		Expires time.Time
 	}
 )
 
-func GetCredentials(migration *migrationsv1alpha1.Migration) Credential {
-	if migration.Spec.DB.Secret != (migrationsv1alpha1.SecretSpec{}) {
-		return SecretCredential{Spec: &migration.Spec.DB.Secret, Namespace: migration.ObjectMeta.Namespace}
-	} else if migration.Spec.DB.Vault != (migrationsv1alpha1.VaultSpec{}) {
-		return VaultCredential{Spec: &migration.Spec.DB.Vault}
+func GetCredentials(spec *migrationsv1alpha1.DBSpec, namespace string) Credential {
+	if spec.Secret != nil {
+		return SecretCredential{Spec: spec.Secret, Namespace: namespace}
+	} else if spec.RDSIAM != nil {
+		return RDSIAMCredential{Spec: spec.RDSIAM, Host: spec.Host, Port: spec.Port, Provider: DefaultAWSCredentials,
+			CABundle: DefaultRDSCABundle, Forwarded: GetConnectivity(spec, namespace) != nil}
+	} else if spec.Connectivity != nil && spec.Connectivity.CloudSQLProxy != nil && spec.Connectivity.CloudSQLProxy.IAMUser != "" {
+		return CloudSQLIAMCredential{User: spec.Connectivity.CloudSQLProxy.IAMUser}
+	} else if spec.Vault != nil {
+		return VaultCredential{Spec: spec.Vault}
 	}
 	return nil
 }
 
-func (s SecretCredential) GetUserPassword() (*UserPassword, error) {
-	cfg, err := config.GetConfig()
-	if err != nil {
-		return nil, err
-	}
-	c, err := client.New(cfg, client.Options{})
-	if err != nil {
+func (s SecretCredential) GetUserPassword(ctx context.Context, c client.Reader) (*UserPassword, error) {
+	creds := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: s.Spec.Name}}
+	if err := c.Get(ctx, client.ObjectKey{Namespace: s.Namespace, Name: s.Spec.Name}, &creds); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, newTransientError(ReasonSecretNotFound, "db credentials secret %s/%s not found", s.Namespace, s.Spec.Name)
+		}
 		return nil, err
 	}
 
-	creds := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: s.Spec.Name}}
-	if err := c.Get(context.Background(), client.ObjectKey{Namespace: s.Namespace, Name: s.Spec.Name}, &creds); err != nil {
-		return nil, err
+	for _, key := range []string{s.Spec.UserKey, s.Spec.PasswordKey} {
+		if _, ok := creds.Data[key]; !ok {
+			return nil, newTransientError(ReasonSecretKeyMissing, "key %q is missing from db credentials secret %s/%s", key, s.Namespace, s.Spec.Name)
+		}
 	}
 
 	return &UserPassword{User: string(creds.Data[s.Spec.UserKey]), Password: string(creds.Data[s.Spec.PasswordKey])}, nil
@@ -83,9 +116,90 @@ func (s SecretCredential) MutateTemplate(tpl *corev1.PodTemplateSpec) {
 	tpl.Spec.Containers[0].Env = append(tpl.Spec.Containers[0].Env, extraEnvs...)
 }
 
-func (v VaultCredential) GetUserPassword() (*UserPassword, error) {
+func (m MirroredSecretCredential) GetUserPassword(ctx context.Context, c client.Reader) (*UserPassword, error) {
+	return m.Source.GetUserPassword(ctx, c)
+}
+
+func (m MirroredSecretCredential) MutateTemplate(tpl *corev1.PodTemplateSpec) {
+	SecretCredential{Spec: m.Mirror}.MutateTemplate(tpl)
+}
+
+func (r RDSIAMCredential) GetUserPassword(ctx context.Context, c client.Reader) (*UserPassword, error) {
+	if r.CABundle == nil {
+		return nil, newMigrationError(ReasonRDSCABundleMissing, "the RDS CA bundle verifying database %s is not loaded, set --rds-ca-bundle on the operator", r.Host)
+	}
+	region := r.Spec.Region
+	if region == "" {
+		region = r.Provider.Region()
+	}
+	if region == "" {
+		return nil, newMigrationError(ReasonCredentialsMissing, "the AWS region of database %s is not set", r.Host)
+	}
+	creds, err := r.Provider.Retrieve(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &UserPassword{
+		User:     r.Spec.User,
+		Password: rdsAuthToken(creds, r.Host, r.Port, region, r.Spec.User, now),
+		Expires:  now.Add(rdsTokenLifetime),
+	}, nil
+}
+
+// MutateTemplate mounts the CA bundle next to the token. The pods connect to the host of the database, which
+// resolves to the sidecar when it is forwarded, so that the certificate is verified against the name of the database.
+func (r RDSIAMCredential) MutateTemplate(tpl *corev1.PodTemplateSpec) {
+	SecretCredential{Spec: &migrationsv1alpha1.SecretSpec{
+		Name:        r.Secret,
+		UserKey:     migrationsv1alpha1.DefaultUserKey,
+		PasswordKey: migrationsv1alpha1.DefaultPasswordKey,
+	}}.MutateTemplate(tpl)
+
+	tpl.Spec.Volumes = append(tpl.Spec.Volumes, corev1.Volume{
+		Name: rdsCAVolumeName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName: r.Secret,
+				Items: []corev1.KeyToPath{
+					corev1.KeyToPath{Key: rdsCAKey, Path: rdsCAKey},
+					corev1.KeyToPath{Key: rdsTrustStoreKey, Path: rdsTrustStoreKey},
+				},
+			},
+		},
+	})
+	tpl.Spec.Containers[0].VolumeMounts = append(tpl.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      rdsCAVolumeName,
+		MountPath: rdsCAMountPath,
+		ReadOnly:  true,
+	})
+	if r.Forwarded {
+		tpl.Spec.HostAliases = append(tpl.Spec.HostAliases, corev1.HostAlias{IP: localhost, Hostnames: []string{r.Host}})
+	}
+}
+
+func (i CloudSQLIAMCredential) GetUserPassword(ctx context.Context, c client.Reader) (*UserPassword, error) {
+	return &UserPassword{User: i.User}, nil
+}
+
+func (i CloudSQLIAMCredential) MutateTemplate(tpl *corev1.PodTemplateSpec) {
+	tpl.Spec.Containers[0].Env = append(tpl.Spec.Containers[0].Env,
+		corev1.EnvVar{Name: "FLYWAY_USER", Value: i.User},
+		corev1.EnvVar{Name: "FLYWAY_PASSWORD"},
+	)
+}
+
+// withTokenSecret names the secret the pods of a job read generated tokens from
+func withTokenSecret(creds Credential, job string) Credential {
+	if r, ok := creds.(RDSIAMCredential); ok {
+		r.Secret = job + "-credentials"
+		return r
+	}
+	return creds
+}
 
-	return nil, nil
+func (v VaultCredential) GetUserPassword(ctx context.Context, c client.Reader) (*UserPassword, error) {
+	return nil, newMigrationError(ReasonCredentialsMissing, "vault credentials are not supported yet")
 }
 
 func (s VaultCredential) MutateTemplate(tpl *corev1.PodTemplateSpec) {
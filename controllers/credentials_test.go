@@ -0,0 +1,224 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("SecretCredential", func() {
+	ctx := context.Background()
+
+	credential := SecretCredential{
+		Spec: &migrationsv1alpha1.SecretSpec{
+			Name:        "db-credentials",
+			UserKey:     "user",
+			PasswordKey: "password",
+		},
+		Namespace: "default",
+	}
+
+	newSecret := func(data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "db-credentials", Namespace: "default"},
+			Data:       data,
+		}
+	}
+
+	It("reads the user and password from the secret", func() {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, newSecret(map[string][]byte{
+			"user":     []byte("flyway"),
+			"password": []byte("s3cr3t"),
+		}))
+
+		creds, err := credential.GetUserPassword(ctx, c)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(creds).To(Equal(&UserPassword{User: "flyway", Password: "s3cr3t"}))
+	})
+
+	It("fails when the secret does not exist", func() {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme)
+
+		_, err := credential.GetUserPassword(ctx, c)
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonSecretNotFound))
+	})
+
+	It("fails when a key is missing from the secret", func() {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, newSecret(map[string][]byte{
+			"user": []byte("flyway"),
+		}))
+
+		_, err := credential.GetUserPassword(ctx, c)
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonSecretKeyMissing))
+	})
+})
+
+var _ = Describe("RDSIAMCredential", func() {
+	ctx := context.Background()
+
+	credential := RDSIAMCredential{
+		Spec: &migrationsv1alpha1.RDSIAMSpec{User: "flyway"},
+		Host: "orders.cluster-abc.eu-west-1.rds.amazonaws.com",
+		Port: 5432,
+		Provider: &AWSCredentialsProvider{Getenv: func(key string) string {
+			return map[string]string{
+				"AWS_REGION":            "eu-west-1",
+				"AWS_ACCESS_KEY_ID":     "AKID",
+				"AWS_SECRET_ACCESS_KEY": "secret",
+			}[key]
+		}},
+		CABundle: &RDSCABundle{Path: "/etc/rds/global-bundle.pem"},
+		Secret:   "orders-credentials",
+	}
+
+	It("generates a token expiring within minutes as the password", func() {
+		creds, err := credential.GetUserPassword(ctx, fake.NewFakeClientWithScheme(scheme.Scheme))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(creds.User).To(Equal("flyway"))
+		Expect(creds.Password).To(HavePrefix("orders.cluster-abc.eu-west-1.rds.amazonaws.com:5432/?Action=connect&DBUser=flyway&"))
+		Expect(creds.Expires).To(BeTemporally("~", time.Now().Add(15*time.Minute), time.Minute))
+	})
+
+	It("fails when the region is unknown", func() {
+		noRegion := credential
+		noRegion.Provider = &AWSCredentialsProvider{Getenv: func(string) string { return "" }}
+
+		_, err := noRegion.GetUserPassword(ctx, fake.NewFakeClientWithScheme(scheme.Scheme))
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonCredentialsMissing))
+	})
+
+	It("refuses to connect until the CA bundle is loaded", func() {
+		unverified := credential
+		unverified.CABundle = nil
+
+		_, err := unverified.GetUserPassword(ctx, fake.NewFakeClientWithScheme(scheme.Scheme))
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonRDSCABundleMissing))
+	})
+
+	It("reads the tokens issued to flyway pods and the CA bundle from its secret", func() {
+		tpl := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: FlywayContainerName}}}}
+		credential.MutateTemplate(&tpl)
+
+		env := tpl.Spec.Containers[0].Env
+		Expect(env).To(HaveLen(2))
+		Expect(env[1].Name).To(Equal("FLYWAY_PASSWORD"))
+		Expect(env[1].ValueFrom.SecretKeyRef.Name).To(Equal("orders-credentials"))
+		Expect(env[1].ValueFrom.SecretKeyRef.Key).To(Equal(migrationsv1alpha1.DefaultPasswordKey))
+		Expect(tpl.Spec.Volumes).To(HaveLen(1))
+		Expect(tpl.Spec.Volumes[0].Secret.SecretName).To(Equal("orders-credentials"))
+		Expect(tpl.Spec.Volumes[0].Secret.Items).To(HaveLen(2))
+		Expect(tpl.Spec.Containers[0].VolumeMounts).To(ConsistOf(corev1.VolumeMount{Name: rdsCAVolumeName, MountPath: "/etc/flyway/rds", ReadOnly: true}))
+		Expect(tpl.Spec.HostAliases).To(BeEmpty())
+	})
+
+	It("resolves the host of the database to the sidecar forwarding it", func() {
+		spec := &migrationsv1alpha1.DBSpec{Host: credential.Host, Port: 5432, DBName: "orders", RDSIAM: credential.Spec,
+			SSHTunnel: &migrationsv1alpha1.SSHTunnelSpec{Host: "bastion", User: "flyway", KeySecret: "bastion-key"}}
+		tpl := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: FlywayContainerName}}}}
+		GetCredentials(spec, "shop").MutateTemplate(&tpl)
+
+		Expect(tpl.Spec.HostAliases).To(Equal([]corev1.HostAlias{{IP: "127.0.0.1", Hostnames: []string{credential.Host}}}))
+		Expect(PostgresDriver{}.ConnectionURL(spec)).To(HavePrefix("jdbc:postgresql://orders.cluster-abc.eu-west-1.rds.amazonaws.com:5432/orders?"))
+	})
+
+	It("verifies the certificate of the database with the CA bundle", func() {
+		spec := &migrationsv1alpha1.DBSpec{Host: credential.Host, Port: 5432, DBName: "orders", RDSIAM: credential.Spec}
+		driver := PostgresDriver{}
+		defer func(bundle *RDSCABundle) { DefaultRDSCABundle = bundle }(DefaultRDSCABundle)
+		DefaultRDSCABundle = credential.CABundle
+
+		Expect(driver.dataSourceName(spec, &UserPassword{User: "flyway", Password: "token"})).To(HaveSuffix("sslmode=verify-full sslrootcert=/etc/rds/global-bundle.pem"))
+		Expect(driver.ConnectionURL(spec)).To(Equal("jdbc:postgresql://orders.cluster-abc.eu-west-1.rds.amazonaws.com:5432/orders?sslmode=verify-full&sslrootcert=/etc/flyway/rds/rds-ca.pem"))
+		Expect(driver.DumpCommand(spec, "/backup/orders.dump")).To(HavePrefix("PGSSLMODE=verify-full PGSSLROOTCERT=/etc/flyway/rds/rds-ca.pem "))
+	})
+})
+
+var _ = Describe("RDSCABundle", func() {
+	newCertificate := func(name string) []byte {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		Expect(err).NotTo(HaveOccurred())
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: name},
+			NotBefore:             time.Now(),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+		Expect(err).NotTo(HaveOccurred())
+		return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	}
+	writeBundle := func(data []byte) string {
+		dir, err := ioutil.TempDir("", "rds-ca")
+		Expect(err).NotTo(HaveOccurred())
+		path := filepath.Join(dir, "global-bundle.pem")
+		Expect(ioutil.WriteFile(path, data, 0600)).To(Succeed())
+		return path
+	}
+
+	It("converts the certificates to a java trust store", func() {
+		path := writeBundle(append(newCertificate("Amazon RDS eu-west-1 Root CA"), newCertificate("Amazon RDS us-east-1 Root CA")...))
+		defer os.RemoveAll(filepath.Dir(path))
+
+		bundle, err := LoadRDSCABundle(path)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(bundle.Path).To(Equal(path))
+
+		store := bundle.TrustStore
+		Expect(binary.BigEndian.Uint32(store[0:])).To(Equal(uint32(0xFEEDFEED)))
+		Expect(binary.BigEndian.Uint32(store[4:])).To(Equal(uint32(2)))
+		Expect(binary.BigEndian.Uint32(store[8:])).To(Equal(uint32(2)))
+		digest := sha1.Sum(append([]byte("\x00c\x00h\x00a\x00n\x00g\x00e\x00i\x00t"+"Mighty Aphrodite"), store[:len(store)-sha1.Size]...))
+		Expect(bytes.HasSuffix(store, digest[:])).To(BeTrue())
+	})
+
+	It("fails without certificates", func() {
+		path := writeBundle([]byte("not a certificate"))
+		defer os.RemoveAll(filepath.Dir(path))
+
+		_, err := LoadRDSCABundle(path)
+		Expect(err).To(HaveOccurred())
+	})
+})
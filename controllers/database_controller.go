@@ -0,0 +1,196 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+type (
+	// DatabaseReconciler probes the reachability of a Database
+	DatabaseReconciler struct {
+		client.Client
+		Log      logr.Logger
+		Scheme   *runtime.Scheme
+		Recorder record.EventRecorder
+	}
+
+	// ClusterDatabaseReconciler probes the reachability of a ClusterDatabase
+	ClusterDatabaseReconciler struct {
+		client.Client
+		Log      logr.Logger
+		Scheme   *runtime.Scheme
+		Recorder record.EventRecorder
+	}
+)
+
+// databaseProbeInterval is the time between two reachability checks of a database
+const databaseProbeInterval = time.Minute
+
+// +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=databases,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=databases/status,verbs=get;update;patch
+
+func (r *DatabaseReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("database", req.NamespacedName)
+
+	var database migrationsv1alpha1.Database
+	if err := r.Get(ctx, req.NamespacedName, &database); err != nil {
+		log.Info("unable to fetch database " + req.NamespacedName.Name)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	database.Default()
+
+	namespace := database.ObjectMeta.Namespace
+	condition, err := probeDatabase(ctx, r.Client, &database.Spec.DBSpec, namespace, GetConnectivity(&database.Spec.DBSpec, namespace))
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if changed := setReachable(r.Recorder, &database, &database.Status, condition); changed {
+		if err := r.Status().Update(ctx, &database); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: databaseProbeInterval}, nil
+}
+
+func (r *DatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&migrationsv1alpha1.Database{}).
+		Complete(r)
+}
+
+// +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=clusterdatabases,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=clusterdatabases/status,verbs=get;update;patch
+
+func (r *ClusterDatabaseReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("clusterdatabase", req.NamespacedName)
+
+	var database migrationsv1alpha1.ClusterDatabase
+	if err := r.Get(ctx, req.NamespacedName, &database); err != nil {
+		log.Info("unable to fetch cluster database " + req.NamespacedName.Name)
+		return ctrl.Result{}, client.IgnoreNotFound(err)
+	}
+	database.Default()
+
+	var condition migrationsv1alpha1.MigrationCondition
+	if secret := database.Spec.Secret; secret != nil && secret.Namespace == "" {
+		condition = unreachable(ReasonCredentialsMissing, "the namespace of the credentials secret is not set")
+	} else if tunnel := database.Spec.SSHTunnel; tunnel != nil && tunnel.KeySecretNamespace == "" {
+		condition = unreachable(ReasonCredentialsMissing, "the namespace of the ssh tunnel key secret is not set")
+	} else {
+		var namespace string
+		if secret != nil {
+			namespace = secret.Namespace
+		}
+		var err error
+		if condition, err = probeDatabase(ctx, r.Client, &database.Spec.DBSpec, namespace, clusterConnectivity(&database.Spec.DBSpec)); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	if changed := setReachable(r.Recorder, &database, &database.Status, condition); changed {
+		if err := r.Status().Update(ctx, &database); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	return ctrl.Result{RequeueAfter: databaseProbeInterval}, nil
+}
+
+func (r *ClusterDatabaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&migrationsv1alpha1.ClusterDatabase{}).
+		Complete(r)
+}
+
+// probeDatabase checks once whether the database accepts connections with its credentials through its connectivity,
+// the credentials secret is read from the given namespace
+func probeDatabase(ctx context.Context, c client.Reader, spec *migrationsv1alpha1.DBSpec, namespace string, connectivity Connectivity) (migrationsv1alpha1.MigrationCondition, error) {
+	sqlDriver, err := GetDriver(spec)
+	if err != nil {
+		return conditionFromError(err)
+	}
+	creds := GetCredentials(spec, namespace)
+	if creds == nil {
+		return unreachable(ReasonCredentialsMissing, "no db credentials source is set on the database"), nil
+	}
+	userPass, err := creds.GetUserPassword(ctx, c)
+	if err != nil {
+		return conditionFromError(err)
+	}
+
+	var dialer Dialer
+	if connectivity != nil {
+		if dialer, err = connectivity.Dialer(ctx, c); err != nil {
+			return conditionFromError(err)
+		}
+	}
+
+	if _, err := sqlDriver.CheckDBAvailability(spec, userPass, dialer); err != nil {
+		return unreachable(ReasonDatabaseUnreachable, err.Error()), nil
+	}
+	return migrationsv1alpha1.MigrationCondition{
+		Type:    migrationsv1alpha1.ConditionReachable,
+		Status:  corev1.ConditionTrue,
+		Reason:  ReasonDatabaseReachable,
+		Message: "database " + dbAddress(spec) + " accepts connections",
+	}, nil
+}
+
+func conditionFromError(err error) (migrationsv1alpha1.MigrationCondition, error) {
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) {
+		return migrationsv1alpha1.MigrationCondition{}, err
+	}
+	return unreachable(migrationErr.Reason, migrationErr.Message), nil
+}
+
+func unreachable(reason, message string) migrationsv1alpha1.MigrationCondition {
+	return migrationsv1alpha1.MigrationCondition{
+		Type:    migrationsv1alpha1.ConditionReachable,
+		Status:  corev1.ConditionFalse,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// setReachable reports the probe outcome on the database status, an event is recorded when the reachability changes
+func setReachable(recorder record.EventRecorder, database runtime.Object, status *migrationsv1alpha1.DatabaseStatus, condition migrationsv1alpha1.MigrationCondition) bool {
+	original := status.DeepCopy()
+	previous := original.GetCondition(migrationsv1alpha1.ConditionReachable)
+	if previous == nil || previous.Status != condition.Status {
+		eventType := corev1.EventTypeNormal
+		if condition.Status != corev1.ConditionTrue {
+			eventType = corev1.EventTypeWarning
+		}
+		recorder.Event(database, eventType, condition.Reason, condition.Message)
+	}
+	status.SetCondition(condition)
+	return !equality.Semantic.DeepEqual(original, status)
+}
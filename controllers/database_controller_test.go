@@ -0,0 +1,56 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("probeDatabase", func() {
+	ctx := context.Background()
+
+	It("reports an unsupported driver as unreachable", func() {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme)
+
+		condition, err := probeDatabase(ctx, c, &migrationsv1alpha1.DBSpec{Host: "db", Driver: "com.example.Driver"}, "default", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(condition.Type).To(Equal(migrationsv1alpha1.ConditionReachable))
+		Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(ReasonUnknownDriver))
+	})
+
+	It("reports missing credentials as unreachable", func() {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme)
+
+		condition, err := probeDatabase(ctx, c, &migrationsv1alpha1.DBSpec{
+			Host:   "db",
+			Driver: migrationsv1alpha1.PostgresDriver,
+			Secret: &migrationsv1alpha1.SecretSpec{Name: "db-credentials", UserKey: "user", PasswordKey: "password"},
+		}, "default", nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+		Expect(condition.Reason).To(Equal(ReasonSecretNotFound))
+	})
+})
@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// dependsOnField indexes migrations by the namespaced names of the migrations they depend on
+const dependsOnField = ".spec.dependsOn"
+
+func dependencyKey(migration *migrationsv1alpha1.Migration, ref migrationsv1alpha1.MigrationReference) types.NamespacedName {
+	key := types.NamespacedName{Namespace: ref.Namespace, Name: ref.Name}
+	if key.Namespace == "" {
+		key.Namespace = migration.ObjectMeta.Namespace
+	}
+	return key
+}
+
+// checkDependencies returns why the migration has to wait for its dependencies, an empty string once they all succeeded
+func (r *MigrationReconciler) checkDependencies(ctx context.Context, migration *migrationsv1alpha1.Migration) (string, error) {
+	if cycle, err := r.findCycle(ctx, migration, nil, map[string]bool{}); err != nil {
+		return "", err
+	} else if cycle != nil {
+		return "", newMigrationError(ReasonDependencyCycle, "dependency cycle detected: %s", strings.Join(cycle, " -> "))
+	}
+
+	var pending []string
+	for _, ref := range migration.Spec.DependsOn {
+		key := dependencyKey(migration, ref)
+		var dependency migrationsv1alpha1.Migration
+		if err := r.Get(ctx, key, &dependency); err != nil {
+			if apierrors.IsNotFound(err) {
+				pending = append(pending, fmt.Sprintf("%s not found", key))
+				continue
+			}
+			return "", err
+		}
+
+		switch {
+		case dependency.Status.Phase != migrationsv1alpha1.MigrationSucceeded:
+			pending = append(pending, fmt.Sprintf("%s is %s", key, phaseOrPending(dependency.Status.Phase)))
+		case ref.MinVersion != "" && compareVersions(dependency.Status.CurrentVersion, ref.MinVersion) < 0:
+			pending = append(pending, fmt.Sprintf("%s is at version %s, %s required", key, dependency.Status.CurrentVersion, ref.MinVersion))
+		}
+	}
+
+	if len(pending) == 0 {
+		return "", nil
+	}
+	return "waiting for " + strings.Join(pending, ", "), nil
+}
+
+// findCycle walks the dependency graph depth first and returns the first cycle met as a path of migrations,
+// the migrations whose dependencies were all walked are done and not walked again
+func (r *MigrationReconciler) findCycle(ctx context.Context, migration *migrationsv1alpha1.Migration, path []string, done map[string]bool) ([]string, error) {
+	name := types.NamespacedName{Namespace: migration.ObjectMeta.Namespace, Name: migration.ObjectMeta.Name}.String()
+	for i, visited := range path {
+		if visited == name {
+			return append(append([]string{}, path[i:]...), name), nil
+		}
+	}
+	if done[name] {
+		return nil, nil
+	}
+	path = append(path, name)
+
+	for _, ref := range migration.Spec.DependsOn {
+		var dependency migrationsv1alpha1.Migration
+		if err := r.Get(ctx, dependencyKey(migration, ref), &dependency); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		if cycle, err := r.findCycle(ctx, &dependency, path, done); cycle != nil || err != nil {
+			return cycle, err
+		}
+	}
+	done[name] = true
+	return nil, nil
+}
+
+func phaseOrPending(phase migrationsv1alpha1.MigrationPhase) migrationsv1alpha1.MigrationPhase {
+	if phase == "" {
+		return migrationsv1alpha1.MigrationPending
+	}
+	return phase
+}
+
+// migrationsDependingOn enqueues the migrations depending on the changed migration
+func (r *MigrationReconciler) migrationsDependingOn(obj handler.MapObject) []reconcile.Request {
+	key := types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: obj.Meta.GetName()}
+	var migrations migrationsv1alpha1.MigrationList
+	if err := r.List(context.Background(), &migrations, client.MatchingFields{dependsOnField: key.String()}); err != nil {
+		r.Log.Error(err, "unable to list dependent migrations", "migration", key)
+		return nil
+	}
+	return reconcileRequests(migrations.Items)
+}
+
+// block holds the migration until its dependencies succeeded, it is reconciled again when they change
+func (r *MigrationReconciler) block(ctx context.Context, migration *migrationsv1alpha1.Migration, reason string) (ctrl.Result, error) {
+	if migration.Status.Phase != migrationsv1alpha1.MigrationBlocked {
+		r.Recorder.Event(migration, corev1.EventTypeNormal, ReasonDependenciesPending, reason)
+	}
+	migration.Status.Phase = migrationsv1alpha1.MigrationBlocked
+	migration.Status.SetCondition(migrationsv1alpha1.MigrationCondition{
+		Type:    migrationsv1alpha1.ConditionReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  ReasonDependenciesPending,
+		Message: reason,
+	})
+	return ctrl.Result{}, r.Status().Update(ctx, migration)
+}
@@ -0,0 +1,133 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+// countingClient counts the reads of the wrapped client
+type countingClient struct {
+	client.Client
+	gets int
+}
+
+func (c *countingClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	c.gets++
+	return c.Client.Get(ctx, key, obj)
+}
+
+var _ = Describe("Migration dependencies", func() {
+	ctx := context.Background()
+
+	newMigration := func(name string, phase migrationsv1alpha1.MigrationPhase, dependsOn ...string) *migrationsv1alpha1.Migration {
+		migration := &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"},
+			Status:     migrationsv1alpha1.MigrationStatus{Phase: phase, CurrentVersion: "3"},
+		}
+		for _, dependency := range dependsOn {
+			migration.Spec.DependsOn = append(migration.Spec.DependsOn, migrationsv1alpha1.MigrationReference{Name: dependency})
+		}
+		return migration
+	}
+
+	reconciler := func(migrations ...*migrationsv1alpha1.Migration) (*MigrationReconciler, *countingClient) {
+		objs := make([]runtime.Object, 0, len(migrations))
+		for _, migration := range migrations {
+			objs = append(objs, migration.DeepCopy())
+		}
+		c := &countingClient{Client: fake.NewFakeClientWithScheme(scheme.Scheme, objs...)}
+		return &MigrationReconciler{Client: c, Log: ctrl.Log, Recorder: record.NewFakeRecorder(10)}, c
+	}
+
+	It("detects dependency cycles", func() {
+		orders := newMigration("orders", "", "billing")
+		r, _ := reconciler(orders, newMigration("billing", "", "customers"), newMigration("customers", "", "billing"))
+
+		_, err := r.checkDependencies(ctx, orders)
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonDependencyCycle))
+		Expect(err.(*MigrationError).Message).To(ContainSubstring("shop/billing -> shop/customers -> shop/billing"))
+	})
+
+	It("walks every migration of a dependency graph once", func() {
+		// every layer depends on both migrations of the layer below, the paths double with every layer
+		var migrations []*migrationsv1alpha1.Migration
+		for layer := 0; layer < 12; layer++ {
+			var dependsOn []string
+			if layer > 0 {
+				dependsOn = []string{fmt.Sprintf("left-%d", layer-1), fmt.Sprintf("right-%d", layer-1)}
+			}
+			migrations = append(migrations,
+				newMigration(fmt.Sprintf("left-%d", layer), migrationsv1alpha1.MigrationSucceeded, dependsOn...),
+				newMigration(fmt.Sprintf("right-%d", layer), migrationsv1alpha1.MigrationSucceeded, dependsOn...))
+		}
+		top := newMigration("orders", "", "left-11", "right-11")
+		r, c := reconciler(append(migrations, top)...)
+
+		reason, err := r.checkDependencies(ctx, top)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(BeEmpty())
+		// one read per edge of the graph and one per dependency checked
+		Expect(c.gets).To(BeNumerically("<=", 2*len(migrations)+4))
+	})
+
+	It("waits for the dependencies to succeed at the required version", func() {
+		orders := newMigration("orders", "", "billing", "customers")
+		orders.Spec.DependsOn[1].MinVersion = "4"
+		r, _ := reconciler(orders, newMigration("billing", migrationsv1alpha1.MigrationRunning), newMigration("customers", migrationsv1alpha1.MigrationSucceeded))
+
+		reason, err := r.checkDependencies(ctx, orders)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reason).To(Equal("waiting for shop/billing is Running, shop/customers is at version 3, 4 required"))
+	})
+
+	It("blocks the migration until its dependencies succeeded", func() {
+		orders := newMigration("orders", migrationsv1alpha1.MigrationPending, "billing")
+		orders.Spec.DB = migrationsv1alpha1.DBSpec{Host: "orders.db", DBName: "orders", Driver: migrationsv1alpha1.PostgresDriver,
+			Secret: &migrationsv1alpha1.SecretSpec{Name: "orders"}}
+		orders.Spec.SQL = migrationsv1alpha1.SQLSpec{VolumeClaim: "scripts", Path: "sql"}
+		r, c := reconciler(orders, newMigration("billing", ""))
+		Expect(c.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Data:       map[string][]byte{"user": []byte("flyway"), "password": []byte("secret")},
+		})).To(Succeed())
+
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: client.ObjectKey{Namespace: "shop", Name: "orders"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(r.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "orders"}, orders)).To(Succeed())
+		Expect(orders.Status.Phase).To(Equal(migrationsv1alpha1.MigrationBlocked))
+		ready := orders.Status.GetCondition(migrationsv1alpha1.ConditionReady)
+		Expect(ready.Status).To(Equal(corev1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(ReasonDependenciesPending))
+		Expect(ready.Message).To(Equal("waiting for shop/billing is Pending"))
+	})
+})
@@ -0,0 +1,248 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"hash/crc32"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/memory"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	utf8ByteOrderMark      = "\uFEFF"
+	versionedScriptPrefix  = "V"
+	undoScriptPrefix       = "U"
+	compensatingPrefix     = "C"
+	scriptVersionSeparator = "__"
+	sqlScriptSuffix        = ".sql"
+	baselineHistoryType    = "BASELINE"
+	undoHistoryTypePrefix  = "UNDO"
+)
+
+// script is a versioned migration script found at the recorded revision
+type script struct {
+	name     string
+	version  string
+	checksum int32
+}
+
+// checkDrift compares the schema history of every target of a succeeded migration with the scripts
+// at its revision once the interval elapsed, it returns the delay until the next check
+func (r *MigrationReconciler) checkDrift(ctx context.Context, migration *migrationsv1alpha1.Migration, runs []*flywayRun, now time.Time) time.Duration {
+	spec := migration.Spec.DriftDetection
+	if spec == nil || migration.Status.Phase != migrationsv1alpha1.MigrationSucceeded {
+		return 0
+	}
+	if last := migration.Status.LastDriftCheck; last != nil {
+		if next := last.Add(spec.Interval.Duration); now.Before(next) {
+			return next.Sub(now)
+		}
+	}
+	checked := metav1.NewTime(now)
+	migration.Status.LastDriftCheck = &checked
+
+	files, err := loadScripts(ctx, r.APIReader, migration.ObjectMeta.Namespace, &migration.Spec.SQL, migration.Status.Revision)
+	if err != nil {
+		r.setDrift(migration, corev1.ConditionUnknown, ReasonDriftCheckFailed, err.Error())
+		return spec.Interval.Duration
+	}
+	scripts := scriptsByVersion(files, versionedScriptPrefix)
+
+	var findings []string
+	for _, run := range runs {
+		entries, err := run.driver.SchemaHistory(&run.target.db, run.userPass, run.dialer, &migration.Spec.History)
+		if err != nil {
+			r.setDrift(migration, corev1.ConditionUnknown, ReasonDriftCheckFailed, fmt.Sprintf("unable to read the schema history of %s: %s", run.target.name, err))
+			return spec.Interval.Duration
+		}
+		drift := compareSchema(scripts, entries, migration.Spec.TargetVersion)
+		value := 0.0
+		if len(drift) > 0 {
+			value = 1
+		}
+		schemaDrift.With(metricLabels(migration, run.target)).Set(value)
+		for _, finding := range drift {
+			findings = append(findings, run.target.name+": "+finding)
+		}
+	}
+
+	if len(findings) == 0 {
+		r.setDrift(migration, corev1.ConditionFalse, ReasonNoDrift, "schema history matches the scripts at the recorded revision")
+		return spec.Interval.Duration
+	}
+	message := strings.Join(findings, "; ")
+	if condition := migration.Status.GetCondition(migrationsv1alpha1.ConditionSchemaDrift); spec.Events && (condition == nil || condition.Status != corev1.ConditionTrue) {
+		r.Recorder.Event(migration, corev1.EventTypeWarning, ReasonDriftDetected, message)
+	}
+	r.setDrift(migration, corev1.ConditionTrue, ReasonDriftDetected, message)
+	return spec.Interval.Duration
+}
+
+func (r *MigrationReconciler) setDrift(migration *migrationsv1alpha1.Migration, status corev1.ConditionStatus, reason, message string) {
+	migration.Status.SetCondition(migrationsv1alpha1.MigrationCondition{
+		Type:    migrationsv1alpha1.ConditionSchemaDrift,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// compareSchema lists the checksums differing from the scripts, the versions applied without a script
+// and the scripts up to the target left unapplied although the migration succeeded, undone versions are not expected
+func compareSchema(scripts map[string]script, entries []migrationsv1alpha1.AppliedScript, target string) []string {
+	applied := map[string]migrationsv1alpha1.AppliedScript{}
+	undone := map[string]bool{}
+	baseline := ""
+	for _, entry := range entries {
+		if !entry.Success || entry.Version == "" {
+			continue
+		}
+		key := versionKey(entry.Version)
+		switch {
+		case entry.Type == baselineHistoryType:
+			baseline = entry.Version
+		case strings.HasPrefix(entry.Type, undoHistoryTypePrefix):
+			delete(applied, key)
+			undone[key] = true
+		default:
+			applied[key] = entry
+			delete(undone, key)
+		}
+	}
+
+	var findings []string
+	for key, entry := range applied {
+		script, ok := scripts[key]
+		if !ok {
+			findings = append(findings, fmt.Sprintf("version %s was applied without a script", entry.Version))
+		} else if entry.Checksum != nil && *entry.Checksum != script.checksum {
+			findings = append(findings, fmt.Sprintf("checksum of version %s differs from %s", entry.Version, script.name))
+		}
+	}
+	for key, script := range scripts {
+		if _, ok := applied[key]; ok || undone[key] {
+			continue
+		}
+		if (baseline == "" || compareVersions(script.version, baseline) > 0) && (target == "" || compareVersions(script.version, target) <= 0) {
+			findings = append(findings, fmt.Sprintf("%s is not applied", script.name))
+		}
+	}
+	sort.Strings(findings)
+	return findings
+}
+
+// scriptsByVersion keys the scripts of the files named with the given prefix by version
+func scriptsByVersion(files map[string][]byte, prefix string) map[string]script {
+	scripts := map[string]script{}
+	for name, content := range files {
+		base := path.Base(name)
+		separator := strings.Index(base, scriptVersionSeparator)
+		if !strings.HasPrefix(base, prefix) || !strings.HasSuffix(base, sqlScriptSuffix) || separator <= len(prefix) {
+			continue
+		}
+		version := strings.Replace(base[len(prefix):separator], "_", ".", -1)
+		scripts[versionKey(version)] = script{name: name, version: version, checksum: flywayChecksum(content)}
+	}
+	return scripts
+}
+
+// versionKey normalizes a version so that the ones flyway considers equal share the same key
+func versionKey(version string) string {
+	parts := strings.FieldsFunc(version, func(r rune) bool { return r == '.' || r == '_' })
+	for i, part := range parts {
+		if n, err := strconv.ParseInt(part, 10, 64); err == nil {
+			parts[i] = strconv.FormatInt(n, 10)
+		}
+	}
+	for len(parts) > 1 && parts[len(parts)-1] == "0" {
+		parts = parts[:len(parts)-1]
+	}
+	return strings.Join(parts, ".")
+}
+
+// flywayChecksum is the CRC32 flyway computes over the lines of a script, without line breaks nor byte order mark
+func flywayChecksum(content []byte) int32 {
+	text := strings.NewReplacer("\r\n", "\n", "\r", "\n").Replace(string(content))
+	lines := strings.Split(strings.TrimPrefix(text, utf8ByteOrderMark), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	checksum := crc32.NewIEEE()
+	for _, line := range lines {
+		checksum.Write([]byte(line))
+	}
+	return int32(checksum.Sum32())
+}
+
+// loadScripts reads the sql scripts of the migration at the given revision, keyed by their path
+func loadScripts(ctx context.Context, c client.Reader, namespace string, spec *migrationsv1alpha1.SQLSpec, revision string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	switch {
+	case spec.Git != nil:
+		auth, err := gitAuth(ctx, c, namespace, spec.Git)
+		if err != nil {
+			return nil, err
+		}
+		repository, err := git.CloneContext(ctx, memory.NewStorage(), nil, &git.CloneOptions{
+			URL:           spec.Git.CheckoutURL,
+			Auth:          auth,
+			ReferenceName: plumbing.NewBranchReferenceName(spec.Git.Branch),
+			SingleBranch:  true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to clone %s: %v", spec.Git.CheckoutURL, err)
+		}
+		hash := plumbing.NewHash(revision)
+		if revision == "" {
+			head, err := repository.Head()
+			if err != nil {
+				return nil, err
+			}
+			hash = head.Hash()
+		}
+		commit, err := repository.CommitObject(hash)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find revision %s: %v", hash, err)
+		}
+		scripts, err := commit.Files()
+		if err != nil {
+			return nil, err
+		}
+		err = scripts.ForEach(func(file *object.File) error {
+			if !strings.HasSuffix(file.Name, sqlScriptSuffix) {
+				return nil
+			}
+			content, err := file.Contents()
+			files[file.Name] = []byte(content)
+			return err
+		})
+		return files, err
+	case spec.VolumeClaim != "":
+		var configMap corev1.ConfigMap
+		if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: spec.VolumeClaim}, &configMap); err != nil {
+			return nil, err
+		}
+		for name, content := range configMap.Data {
+			files[name] = []byte(content)
+		}
+		for name, content := range configMap.BinaryData {
+			files[name] = content
+		}
+		return files, nil
+	}
+	return nil, newMigrationError(ReasonScriptsLocationInvalid, "unable to detect sql scripts location")
+}
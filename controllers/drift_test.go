@@ -0,0 +1,86 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("schema drift", func() {
+	table.DescribeTable("flywayChecksum ignores line breaks and byte order mark",
+		func(content string) {
+			Expect(flywayChecksum([]byte(content))).To(Equal(int32(-593479011)))
+		},
+		table.Entry("unix line breaks", "select 1;\nselect 2;\n"),
+		table.Entry("windows line breaks", "select 1;\r\nselect 2;"),
+		table.Entry("byte order mark", "\uFEFFselect 1;\rselect 2;\n"),
+	)
+
+	Describe("compareSchema", func() {
+		checksum := func(content string) *int32 {
+			sum := flywayChecksum([]byte(content))
+			return &sum
+		}
+		entry := func(version, kind string, sum *int32) migrationsv1alpha1.AppliedScript {
+			return migrationsv1alpha1.AppliedScript{Version: version, Type: kind, Checksum: sum, Success: true}
+		}
+		scripts := scriptsByVersion(map[string][]byte{
+			"db/V1__init.sql":    []byte("create table a;"),
+			"db/V1_1__add_b.sql": []byte("create table b;"),
+			"db/R__views.sql":    []byte("create view v;"),
+			"db/README.md":       []byte("docs"),
+		}, versionedScriptPrefix)
+
+		It("reports no drift when the history matches the scripts", func() {
+			Expect(compareSchema(scripts, []migrationsv1alpha1.AppliedScript{
+				entry("1", "SQL", checksum("create table a;")),
+				entry("1.1", "SQL", checksum("create table b;")),
+				entry("", "SQL", checksum("create view v;")),
+			}, "")).To(BeEmpty())
+		})
+
+		It("reports edited scripts, out of band versions and unapplied scripts", func() {
+			Expect(compareSchema(scripts, []migrationsv1alpha1.AppliedScript{
+				entry("1", "SQL", checksum("create table a (id int);")),
+				entry("2", "SQL", checksum("create table hotfix;")),
+			}, "")).To(Equal([]string{
+				"checksum of version 1 differs from db/V1__init.sql",
+				"db/V1_1__add_b.sql is not applied",
+				"version 2 was applied without a script",
+			}))
+		})
+
+		It("skips the scripts below the baseline and the undone versions", func() {
+			Expect(compareSchema(scripts, []migrationsv1alpha1.AppliedScript{
+				entry("1", "BASELINE", nil),
+				entry("1.1", "SQL", checksum("create table b;")),
+				entry("1.1", "UNDO_SQL", nil),
+			}, "")).To(BeEmpty())
+		})
+
+		It("skips the scripts above the target", func() {
+			Expect(compareSchema(scripts, []migrationsv1alpha1.AppliedScript{
+				entry("1", "SQL", checksum("create table a;")),
+			}, "1")).To(BeEmpty())
+			Expect(compareSchema(scripts, []migrationsv1alpha1.AppliedScript{}, "1.0")).To(Equal([]string{"db/V1__init.sql is not applied"}))
+		})
+	})
+})
@@ -1,41 +1,272 @@
 package controllers
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"fmt"
+	"io"
+	"strings"
+	"time"
 
 	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
 
 	"github.com/jmoiron/sqlx"
-
-	// import postgres driver
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type (
-	// Driver interface
+	// Driver interface, the dialer is nil when the database is reached directly
 	Driver interface {
-		CheckDBAvailability(spec *migrationsv1alpha1.DBSpec, creds *UserPassword) (bool, error)
+		CheckDBAvailability(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer) (bool, error)
 		ConnectionURL(spec *migrationsv1alpha1.DBSpec) string
+		// SchemaHistory reads the flyway schema history table, the entries are ordered by installed rank
+		SchemaHistory(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer, history *migrationsv1alpha1.HistorySpec) ([]migrationsv1alpha1.AppliedScript, error)
+	}
+
+	// Compensator is implemented by the drivers able to execute compensating scripts from a job
+	Compensator interface {
+		// CompensateImage is the default image holding the sql client of the driver
+		CompensateImage() string
+		// CompensateCommand is the shell command executing the compensating script of the file and removing its version
+		// from the schema history, the credentials are read from the FLYWAY_USER and FLYWAY_PASSWORD variables
+		CompensateCommand(spec *migrationsv1alpha1.DBSpec, history *migrationsv1alpha1.HistorySpec, version, file string) string
+	}
+
+	// Locker is implemented by the drivers able to take a database level advisory lock
+	Locker interface {
+		// TryLock takes the lock of the key without waiting, the lock is held until the returned closer is closed
+		TryLock(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer, key string) (io.Closer, bool, error)
+	}
+
+	// Dumper is implemented by the drivers able to dump a database for backups and restore it from the dump
+	Dumper interface {
+		// DumpImage is the default image holding the dump client of the driver
+		DumpImage() string
+		// DumpCommand is the shell command writing a dump of the database to the file,
+		// the credentials are read from the FLYWAY_USER and FLYWAY_PASSWORD variables
+		DumpCommand(spec *migrationsv1alpha1.DBSpec, file string) string
+		// RestoreCommand is the shell command replacing the content of the database with the dump of the file
+		RestoreCommand(spec *migrationsv1alpha1.DBSpec, file string) string
 	}
 
 	// PostgresDriver implementation
 	PostgresDriver struct{}
+
+	// postgresConnector opens the connections of a pool through a dialer
+	postgresConnector struct {
+		dsn    string
+		dialer Dialer
+	}
+
+	// historyRow is a row of the flyway schema history table
+	historyRow struct {
+		InstalledRank int32          `db:"installed_rank"`
+		Version       sql.NullString `db:"version"`
+		Description   string         `db:"description"`
+		Type          string         `db:"type"`
+		Script        string         `db:"script"`
+		Checksum      sql.NullInt32  `db:"checksum"`
+		InstalledBy   string         `db:"installed_by"`
+		InstalledOn   time.Time      `db:"installed_on"`
+		ExecutionTime int32          `db:"execution_time"`
+		Success       bool           `db:"success"`
+	}
+)
+
+const (
+	// postgresDropOwned drops the schemas the user owns with their content and its relations in the other schemas,
+	// so that nothing a failed run created survives the restore. The public schema the dumps do not create is recreated.
+	postgresDropOwned = `DO $$DECLARE r record; BEGIN ` +
+		`FOR r IN SELECT nspname FROM pg_namespace WHERE nspname <> 'information_schema' AND nspname NOT LIKE 'pg\_%' AND pg_has_role(nspowner, 'USAGE') LOOP ` +
+		`EXECUTE format('DROP SCHEMA %I CASCADE', r.nspname); END LOOP; ` +
+		`FOR r IN SELECT n.nspname, c.relname, c.relkind FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace ` +
+		`WHERE n.nspname <> 'information_schema' AND n.nspname NOT LIKE 'pg\_%' AND c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f') AND pg_has_role(c.relowner, 'USAGE') LOOP ` +
+		`EXECUTE format('DROP %s IF EXISTS %I.%I CASCADE', CASE r.relkind WHEN 'v' THEN 'VIEW' WHEN 'm' THEN 'MATERIALIZED VIEW' WHEN 'S' THEN 'SEQUENCE' WHEN 'f' THEN 'FOREIGN TABLE' ELSE 'TABLE' END, r.nspname, r.relname); ` +
+		`END LOOP; END$$; CREATE SCHEMA IF NOT EXISTS public`
+	// postgresRestoreScript is where the restore jobs write the script of the dump
+	postgresRestoreScript = "/tmp/restore.sql"
 )
 
 var (
 	Drivers = map[string]Driver{
-		"org.postgresql.Driver": PostgresDriver{},
+		migrationsv1alpha1.PostgresDriver: PostgresDriver{},
+		migrationsv1alpha1.MySQLDriver:    MySQLDriver{},
 	}
 )
 
-func (d PostgresDriver) CheckDBAvailability(spec *migrationsv1alpha1.DBSpec, creds *UserPassword) (bool, error) {
-	_, err := sqlx.Connect("postgres", fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=disable", spec.Host, spec.Port, spec.DBName, creds.User, creds.Password))
+// GetDriver returns the driver registered for the JDBC driver class of the spec
+func GetDriver(spec *migrationsv1alpha1.DBSpec) (Driver, error) {
+	driver, ok := Drivers[spec.Driver]
+	if !ok {
+		return nil, newMigrationError(ReasonUnknownDriver, "unsupported db driver %q", spec.Driver)
+	}
+	return driver, nil
+}
+
+// connect opens the database through the dialer when it is set
+func (d PostgresDriver) connect(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer) (*sqlx.DB, error) {
+	if dialer == nil {
+		return sqlx.Connect("postgres", d.dataSourceName(spec, creds))
+	}
+	db := sqlx.NewDb(sql.OpenDB(postgresConnector{dsn: d.dataSourceName(spec, creds), dialer: dialer}), "postgres")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (d PostgresDriver) CheckDBAvailability(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer) (bool, error) {
+	db, err := d.connect(spec, creds, dialer)
 	if err != nil {
 		return false, err
 	}
+	defer db.Close()
 	return true, nil
 }
 
+// TryLock takes a session advisory lock, the pool is pinned to a single connection so that the session outlives the call
+func (d PostgresDriver) TryLock(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer, key string) (io.Closer, bool, error) {
+	db, err := d.connect(spec, creds, dialer)
+	if err != nil {
+		return nil, false, err
+	}
+	db.SetMaxOpenConns(1)
+
+	var locked bool
+	if err := db.Get(&locked, "SELECT pg_try_advisory_lock(hashtext($1))", key); err != nil || !locked {
+		db.Close()
+		return nil, false, err
+	}
+	return db, true, nil
+}
+
+// SchemaHistory returns no entries when the history table does not exist yet
+func (d PostgresDriver) SchemaHistory(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer, history *migrationsv1alpha1.HistorySpec) ([]migrationsv1alpha1.AppliedScript, error) {
+	db, err := d.connect(spec, creds, dialer)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	table := d.historyTable(history)
+	var exists bool
+	if err := db.Get(&exists, "SELECT to_regclass($1) IS NOT NULL", table); err != nil || !exists {
+		return nil, err
+	}
+
+	var rows []historyRow
+	err = db.Select(&rows, "SELECT installed_rank, version, description, type, script, checksum, installed_by, installed_on, execution_time, success FROM "+table+" ORDER BY installed_rank")
+	if err != nil {
+		return nil, err
+	}
+	return historyEntries(rows), nil
+}
+
+// historyEntries converts the rows of the schema history table
+func historyEntries(rows []historyRow) []migrationsv1alpha1.AppliedScript {
+	entries := make([]migrationsv1alpha1.AppliedScript, 0, len(rows))
+	for _, row := range rows {
+		entry := migrationsv1alpha1.AppliedScript{
+			InstalledRank: row.InstalledRank,
+			Version:       row.Version.String,
+			Description:   row.Description,
+			Type:          row.Type,
+			Script:        row.Script,
+			InstalledBy:   row.InstalledBy,
+			InstalledOn:   metav1.NewTime(row.InstalledOn),
+			ExecutionTime: row.ExecutionTime,
+			Success:       row.Success,
+		}
+		if row.Checksum.Valid {
+			checksum := row.Checksum.Int32
+			entry.Checksum = &checksum
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (c postgresConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return pq.DialOpen(c.dialer, c.dsn)
+}
+
+func (c postgresConnector) Driver() driver.Driver {
+	return &pq.Driver{}
+}
+
+func (d PostgresDriver) DumpImage() string {
+	return "postgres:13-alpine"
+}
+
+// DumpCommand writes a dump in the custom format pg_restore reads
+func (d PostgresDriver) DumpCommand(spec *migrationsv1alpha1.DBSpec, file string) string {
+	host, port := podAddress(spec)
+	return d.sslEnv(spec) + fmt.Sprintf(`PGPASSWORD="$FLYWAY_PASSWORD" pg_dump --host=%s --port=%d --username="$FLYWAY_USER" --format=custom --file=%s %s`,
+		shellQuote(host), port, shellQuote(file), shellQuote(spec.DBName))
+}
+
+// RestoreCommand drops what the user owns and the objects of the dump before recreating them, in a single transaction.
+// The dump is turned into a script first so that nothing is dropped when it cannot be read.
+func (d PostgresDriver) RestoreCommand(spec *migrationsv1alpha1.DBSpec, file string) string {
+	host, port := podAddress(spec)
+	return fmt.Sprintf(`pg_restore --clean --if-exists --file=%s %s && `, postgresRestoreScript, shellQuote(file)) +
+		d.sslEnv(spec) + fmt.Sprintf(`PGPASSWORD="$FLYWAY_PASSWORD" psql --host=%s --port=%d --username="$FLYWAY_USER" --dbname=%s --no-psqlrc --set=ON_ERROR_STOP=1 --single-transaction --command=%s --file=%s`,
+		shellQuote(host), port, shellQuote(spec.DBName), shellQuote(postgresDropOwned), postgresRestoreScript)
+}
+
+func (d PostgresDriver) CompensateImage() string {
+	return d.DumpImage()
+}
+
+// CompensateCommand runs in a single transaction, the script is reverted along with the history when it fails
+func (d PostgresDriver) CompensateCommand(spec *migrationsv1alpha1.DBSpec, history *migrationsv1alpha1.HistorySpec, version, file string) string {
+	host, port := podAddress(spec)
+	remove := "DELETE FROM " + d.historyTable(history) + " WHERE version = " + pq.QuoteLiteral(version)
+	return d.sslEnv(spec) + fmt.Sprintf(`PGPASSWORD="$FLYWAY_PASSWORD" psql --host=%s --port=%d --username="$FLYWAY_USER" --dbname=%s --no-psqlrc --set=ON_ERROR_STOP=1 --single-transaction --file=%s --command=%s`,
+		shellQuote(host), port, shellQuote(spec.DBName), shellQuote(file), shellQuote(remove))
+}
+
+func (d PostgresDriver) historyTable(history *migrationsv1alpha1.HistorySpec) string {
+	table := pq.QuoteIdentifier(history.Table)
+	if history.Schema != "" {
+		table = pq.QuoteIdentifier(history.Schema) + "." + table
+	}
+	return table
+}
+
+func (d PostgresDriver) dataSourceName(spec *migrationsv1alpha1.DBSpec, creds *UserPassword) string {
+	return fmt.Sprintf("host=%s port=%d dbname=%s user=%s password=%s sslmode=%s", spec.Host, spec.Port, spec.DBName, creds.User, creds.Password, d.sslMode(spec))
+}
+
+// ConnectionURL points to the sidecar of the pods when the database is not directly reachable
 func (d PostgresDriver) ConnectionURL(spec *migrationsv1alpha1.DBSpec) string {
-	return fmt.Sprintf("jdbc:postgresql://%s:%d/%s", spec.Host, spec.Port, spec.DBName)
+	host, port := podAddress(spec)
+	url := fmt.Sprintf("jdbc:postgresql://%s:%d/%s", host, port, spec.DBName)
+	if spec.RDSIAM != nil {
+		url += "?sslmode=verify-full&sslrootcert=" + rdsCAMountPath + "/" + rdsCAKey
+	}
+	return url
+}
+
+// sslMode verifies the certificate of databases authenticating with tokens, RDS refuses them over plain connections
+func (d PostgresDriver) sslMode(spec *migrationsv1alpha1.DBSpec) string {
+	if spec.RDSIAM != nil {
+		return "verify-full sslrootcert=" + rdsCABundlePath()
+	}
+	return "disable"
+}
+
+func (d PostgresDriver) sslEnv(spec *migrationsv1alpha1.DBSpec) string {
+	if spec.RDSIAM != nil {
+		return "PGSSLMODE=verify-full PGSSLROOTCERT=" + rdsCAMountPath + "/" + rdsCAKey + " "
+	}
+	return ""
+}
+
+// shellQuote quotes a value for sh
+func shellQuote(value string) string {
+	return "'" + strings.Replace(value, "'", `'"'"'`, -1) + "'"
 }
@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"fmt"
+	"time"
+)
+
+// Reasons reported on migration conditions and events when a migration cannot be run
+const (
+	ReasonUnknownDriver            = "UnknownDriver"
+	ReasonCredentialsMissing       = "CredentialsMissing"
+	ReasonSecretNotFound           = "SecretNotFound"
+	ReasonSecretKeyMissing         = "SecretKeyMissing"
+	ReasonScriptsLocationInvalid   = "ScriptsLocationInvalid"
+	ReasonDatabaseUnreachable      = "DatabaseUnreachable"
+	ReasonRevisionUnresolved       = "RevisionUnresolved"
+	ReasonDependencyCycle          = "DependencyCycle"
+	ReasonNoTargets                = "NoTargets"
+	ReasonTargetInvalid            = "TargetInvalid"
+	ReasonDatabaseNotFound         = "DatabaseNotFound"
+	ReasonDatabaseNotAllowed       = "DatabaseNotAllowed"
+	ReasonScheduleInvalid          = "ScheduleInvalid"
+	ReasonDowngradeRefused         = "DowngradeRefused"
+	ReasonRollbackUnavailable      = "RollbackUnavailable"
+	ReasonBackupUnsupported        = "BackupUnsupported"
+	ReasonAWSCredentialsMissing    = "AWSCredentialsMissing"
+	ReasonRDSCABundleMissing       = "RDSCABundleMissing"
+	ReasonGoogleCredentialsMissing = "GoogleCredentialsMissing"
+	ReasonSidecarsUnsupported      = "SidecarsUnsupported"
+	ReasonKnownHostsMissing        = "KnownHostsMissing"
+	ReasonSecretConflict           = "SecretConflict"
+)
+
+const transientRequeueDelay = time.Minute
+
+type (
+	// MigrationError is a failure the user has to act upon, it is surfaced on the migration
+	// status and as an event instead of being retried by the controller
+	MigrationError struct {
+		Reason  string
+		Message string
+		// Transient errors may resolve by themselves (e.g. a secret being created) and are retried
+		Transient bool
+	}
+)
+
+func newMigrationError(reason string, format string, args ...interface{}) *MigrationError {
+	return &MigrationError{Reason: reason, Message: fmt.Sprintf(format, args...)}
+}
+
+func newTransientError(reason string, format string, args ...interface{}) *MigrationError {
+	err := newMigrationError(reason, format, args...)
+	err.Transient = true
+	return err
+}
+
+func (e *MigrationError) Error() string {
+	return e.Message
+}
@@ -0,0 +1,32 @@
+package controllers
+
+// Reasons of the events recorded along a migration run
+const (
+	ReasonWaitingForDatabase  = "WaitingForDatabase"
+	ReasonDatabaseReachable   = "DatabaseReachable"
+	ReasonJobCreated          = "JobCreated"
+	ReasonReconciled          = "Reconciled"
+	ReasonMigrationSucceeded  = "MigrationSucceeded"
+	ReasonMigrationFailed     = "MigrationFailed"
+	ReasonRevisionChanged     = "RevisionChanged"
+	ReasonDependenciesPending = "DependenciesPending"
+	ReasonQueued              = "Queued"
+	ReasonWithinWindow        = "WithinWindow"
+	ReasonWindowExceeded      = "WindowExceeded"
+	ReasonHistoryUnavailable  = "HistoryUnavailable"
+	ReasonNoDrift             = "NoDrift"
+	ReasonDriftDetected       = "DriftDetected"
+	ReasonDriftCheckFailed    = "DriftCheckFailed"
+	ReasonTargetChanged       = "TargetChanged"
+	ReasonBackupStarted       = "BackupStarted"
+	ReasonBackupSucceeded     = "BackupSucceeded"
+	ReasonBackupFailed        = "BackupFailed"
+	ReasonRestoreStarted      = "RestoreStarted"
+	ReasonRestoreSucceeded    = "RestoreSucceeded"
+	ReasonRestoreFailed       = "RestoreFailed"
+	ReasonNotificationFailed  = "NotificationFailed"
+	ReasonSuspended           = "Suspended"
+	ReasonAwaitingApproval    = "AwaitingApproval"
+	ReasonApproved            = "Approved"
+	ReasonRerun               = "Rerun"
+)
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	"github.com/go-git/go-git/v5"
+	gitconfig "github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// resolveRemoteHead returns the commit the branch points to on the remote, the way git ls-remote does
+func resolveRemoteHead(ctx context.Context, c client.Reader, namespace string, spec *migrationsv1alpha1.GitMigrationSpec) (string, error) {
+	auth, err := gitAuth(ctx, c, namespace, spec)
+	if err != nil {
+		return "", err
+	}
+
+	remote := git.NewRemote(memory.NewStorage(), &gitconfig.RemoteConfig{
+		Name: "origin",
+		URLs: []string{spec.CheckoutURL},
+	})
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return "", newTransientError(ReasonRevisionUnresolved, "unable to list references of %s: %v", spec.CheckoutURL, err)
+	}
+
+	branch := plumbing.NewBranchReferenceName(spec.Branch)
+	for _, ref := range refs {
+		if ref.Name() == branch {
+			return ref.Hash().String(), nil
+		}
+	}
+	return "", newTransientError(ReasonRevisionUnresolved, "branch %s not found on %s", spec.Branch, spec.CheckoutURL)
+}
+
+// checkKnownHosts makes sure the git secret holds the host keys the clone init container checks the server against
+func checkKnownHosts(ctx context.Context, c client.Reader, namespace string, spec *migrationsv1alpha1.GitMigrationSpec) error {
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: spec.Secret}, &secret); err != nil {
+		return newTransientError(ReasonSecretNotFound, "unable to read git secret %s/%s: %v", namespace, spec.Secret, err)
+	}
+	if _, ok := secret.Data[gitKnownHostsName]; !ok {
+		return newTransientError(ReasonKnownHostsMissing, "strict host key checking requires the host keys of the git server under key %q of git secret %s/%s", gitKnownHostsName, namespace, spec.Secret)
+	}
+	return nil
+}
+
+// gitAuth loads the ssh key the clone init container uses, the git server must present one of the known host keys
+func gitAuth(ctx context.Context, c client.Reader, namespace string, spec *migrationsv1alpha1.GitMigrationSpec) (transport.AuthMethod, error) {
+	if spec.Secret == "" {
+		return nil, nil
+	}
+
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: namespace, Name: spec.Secret}, &secret); err != nil {
+		return nil, newTransientError(ReasonRevisionUnresolved, "unable to read git secret %s/%s: %v", namespace, spec.Secret, err)
+	}
+	key, ok := secret.Data[gitKeyName]
+	if !ok {
+		return nil, newTransientError(ReasonSecretKeyMissing, "key %q is missing from git secret %s/%s", gitKeyName, namespace, spec.Secret)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, newMigrationError(ReasonRevisionUnresolved, "invalid ssh key in git secret %s/%s: %v", namespace, spec.Secret, err)
+	}
+	knownHosts, ok := secret.Data[gitKnownHostsName]
+	if !ok {
+		return nil, newTransientError(ReasonSecretKeyMissing, "key %q is missing from git secret %s/%s", gitKnownHostsName, namespace, spec.Secret)
+	}
+	hostKeyCallback, err := knownHostsCallback(string(knownHosts))
+	if err != nil {
+		return nil, newMigrationError(ReasonRevisionUnresolved, "invalid known hosts in git secret %s/%s: %v", namespace, spec.Secret, err)
+	}
+
+	return &gitssh.PublicKeys{
+		User:   "git",
+		Signer: signer,
+		HostKeyCallbackHelper: gitssh.HostKeyCallbackHelper{
+			HostKeyCallback: hostKeyCallback,
+		},
+	}, nil
+}
@@ -0,0 +1,110 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("Git authentication", func() {
+	ctx := context.Background()
+	spec := &migrationsv1alpha1.GitMigrationSpec{CheckoutURL: "git@git.example.com:shop/orders.git", Branch: "main", Secret: "git"}
+	server := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+	publicKey := func() ssh.PublicKey {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		public, err := ssh.NewPublicKey(&key.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		return public
+	}
+
+	var hostKey ssh.PublicKey
+	secret := func(knownHosts bool) *corev1.Secret {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		data := map[string][]byte{
+			gitKeyName: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}),
+		}
+		if knownHosts {
+			data[gitKnownHostsName] = []byte(knownhosts.Line([]string{"git.example.com"}, hostKey))
+		}
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "git", Namespace: "shop"}, Data: data}
+	}
+
+	BeforeEach(func() {
+		hostKey = publicKey()
+	})
+
+	It("only accepts the known host keys of the git server", func() {
+		auth, err := gitAuth(ctx, fake.NewFakeClientWithScheme(scheme.Scheme, secret(true)), "shop", spec)
+		Expect(err).NotTo(HaveOccurred())
+		callback := auth.(*gitssh.PublicKeys).HostKeyCallback
+
+		Expect(callback("git.example.com:22", server, hostKey)).To(Succeed())
+		Expect(callback("git.example.com:22", server, publicKey())).NotTo(Succeed())
+		Expect(callback("other.example.com:22", server, hostKey)).NotTo(Succeed())
+	})
+
+	It("fails when the git secret has no known hosts", func() {
+		_, err := gitAuth(ctx, fake.NewFakeClientWithScheme(scheme.Scheme, secret(false)), "shop", spec)
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonSecretKeyMissing))
+	})
+
+	It("checks the host keys when cloning the scripts with strict host key checking", func() {
+		var tpl corev1.PodTemplateSpec
+		GitLocation{Spec: spec}.MutateTemplate(&tpl)
+		Expect(tpl.Spec.InitContainers[0].Env).To(ContainElement(corev1.EnvVar{
+			Name:  "GIT_SSH_COMMAND",
+			Value: "ssh -o StrictHostKeyChecking=no -i /etc/git-secret/id_rsa",
+		}))
+
+		strict := spec.DeepCopy()
+		strict.StrictHostKeyChecking = true
+		GitLocation{Spec: strict}.MutateTemplate(&tpl)
+		Expect(tpl.Spec.InitContainers[0].Env).To(ContainElement(corev1.EnvVar{
+			Name:  "GIT_SSH_COMMAND",
+			Value: "ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile=/etc/git-secret/known_hosts -i /etc/git-secret/id_rsa",
+		}))
+	})
+
+	It("requires known hosts in the git secret for strict host key checking", func() {
+		Expect(checkKnownHosts(ctx, fake.NewFakeClientWithScheme(scheme.Scheme, secret(true)), "shop", spec)).To(Succeed())
+
+		err := checkKnownHosts(ctx, fake.NewFakeClientWithScheme(scheme.Scheme, secret(false)), "shop", spec)
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonKnownHostsMissing))
+	})
+})
@@ -0,0 +1,232 @@
+package controllers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type (
+	// GitWebhookReceiver accepts GitHub, GitLab and Gitea push webhooks and starts a new run
+	// of every migration following the pushed branch
+	GitWebhookReceiver struct {
+		Client client.Client
+		Log    logr.Logger
+		// Addr is the address the receiver listens on
+		Addr string
+		// Secret signs the payloads (GitHub, Gitea) or is sent as token (GitLab)
+		Secret []byte
+	}
+
+	// pushEvent holds the fields of the push payloads the receiver needs, providers name them differently
+	pushEvent struct {
+		Ref        string `json:"ref"`
+		After      string `json:"after"`
+		Repository struct {
+			CloneURL   string `json:"clone_url"`
+			SSHURL     string `json:"ssh_url"`
+			GitURL     string `json:"git_url"`
+			HTMLURL    string `json:"html_url"`
+			GitSSHURL  string `json:"git_ssh_url"`
+			GitHTTPURL string `json:"git_http_url"`
+		} `json:"repository"`
+		Project struct {
+			GitSSHURL  string `json:"git_ssh_url"`
+			GitHTTPURL string `json:"git_http_url"`
+			WebURL     string `json:"web_url"`
+		} `json:"project"`
+	}
+)
+
+const (
+	// GitWebhookPath is the path push webhooks are delivered to
+	GitWebhookPath = "/git/push"
+	maxPayloadSize = 10 << 20
+)
+
+var errInvalidSignature = errors.New("invalid webhook signature")
+
+func (g *GitWebhookReceiver) Start(stop <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle(GitWebhookPath, g)
+	server := &http.Server{Addr: g.Addr, Handler: mux}
+
+	errs := make(chan error, 1)
+	go func() {
+		g.Log.Info("starting git webhook receiver", "addr", g.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
+
+// NeedLeaderElection lets every replica behind the service handle deliveries
+func (g *GitWebhookReceiver) NeedLeaderElection() bool {
+	return false
+}
+
+func (g *GitWebhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxPayloadSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	isPush, err := g.verify(req.Header, body)
+	if err != nil {
+		g.Log.Info("rejected git webhook", "reason", err.Error())
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if !isPush {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	var event pushEvent
+	if err := json.Unmarshal(body, &event); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// branch deletions carry an all zero commit
+	if !strings.HasPrefix(event.Ref, "refs/heads/") || strings.Trim(event.After, "0") == "" {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	triggered, err := g.trigger(req.Context(), &event)
+	if err != nil {
+		g.Log.Error(err, "unable to trigger migrations for push", "ref", event.Ref)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string][]string{"triggered": triggered})
+}
+
+// verify authenticates the delivery according to its provider and tells whether it is a push
+func (g *GitWebhookReceiver) verify(header http.Header, body []byte) (bool, error) {
+	switch {
+	// gitea also sends github headers, it has to be checked first
+	case header.Get("X-Gitea-Event") != "":
+		if !validHMAC(sha256.New, g.Secret, body, header.Get("X-Gitea-Signature")) {
+			return false, errInvalidSignature
+		}
+		return header.Get("X-Gitea-Event") == "push", nil
+	case header.Get("X-GitHub-Event") != "":
+		if signature := header.Get("X-Hub-Signature-256"); signature != "" {
+			if !validHMAC(sha256.New, g.Secret, body, strings.TrimPrefix(signature, "sha256=")) {
+				return false, errInvalidSignature
+			}
+		} else if !validHMAC(sha1.New, g.Secret, body, strings.TrimPrefix(header.Get("X-Hub-Signature"), "sha1=")) {
+			return false, errInvalidSignature
+		}
+		return header.Get("X-GitHub-Event") == "push", nil
+	case header.Get("X-Gitlab-Event") != "":
+		// gitlab does not sign payloads but sends the secret token back
+		if !hmac.Equal([]byte(header.Get("X-Gitlab-Token")), g.Secret) {
+			return false, errInvalidSignature
+		}
+		return header.Get("X-Gitlab-Event") == "Push Hook", nil
+	}
+	return false, errors.New("unknown webhook provider")
+}
+
+func validHMAC(algorithm func() hash.Hash, secret, body []byte, signature string) bool {
+	expected, err := hex.DecodeString(signature)
+	if err != nil || len(expected) == 0 {
+		return false
+	}
+	mac := hmac.New(algorithm, secret)
+	mac.Write(body)
+	return hmac.Equal(mac.Sum(nil), expected)
+}
+
+// trigger requests a new run at the pushed commit from every migration following the branch
+func (g *GitWebhookReceiver) trigger(ctx context.Context, event *pushEvent) ([]string, error) {
+	repositories := map[string]bool{}
+	for _, u := range []string{
+		event.Repository.CloneURL, event.Repository.SSHURL, event.Repository.GitURL, event.Repository.HTMLURL,
+		event.Repository.GitSSHURL, event.Repository.GitHTTPURL,
+		event.Project.GitSSHURL, event.Project.GitHTTPURL, event.Project.WebURL,
+	} {
+		if u != "" {
+			repositories[normalizeRepositoryURL(u)] = true
+		}
+	}
+	branch := strings.TrimPrefix(event.Ref, "refs/heads/")
+
+	var migrations migrationsv1alpha1.MigrationList
+	if err := g.Client.List(ctx, &migrations); err != nil {
+		return nil, err
+	}
+
+	triggered := []string{}
+	for i := range migrations.Items {
+		migration := &migrations.Items[i]
+		git := migration.Spec.SQL.Git
+		if git == nil || git.Branch != branch || !repositories[normalizeRepositoryURL(git.CheckoutURL)] {
+			continue
+		}
+
+		patch := client.MergeFrom(migration.DeepCopy())
+		if migration.ObjectMeta.Annotations == nil {
+			migration.ObjectMeta.Annotations = map[string]string{}
+		}
+		migration.ObjectMeta.Annotations[migrationsv1alpha1.GitRevisionAnnotation] = event.After
+		if err := g.Client.Patch(ctx, migration, patch); err != nil {
+			return triggered, err
+		}
+		triggered = append(triggered, migration.ObjectMeta.Namespace+"/"+migration.ObjectMeta.Name)
+	}
+
+	g.Log.Info("git push received", "ref", event.Ref, "commit", event.After, "migrations", triggered)
+	return triggered, nil
+}
+
+// normalizeRepositoryURL reduces ssh, scp-like and http urls of a repository to host/path
+func normalizeRepositoryURL(repository string) string {
+	repository = strings.TrimSpace(repository)
+	if !strings.Contains(repository, "://") {
+		// scp-like syntax, e.g. git@github.com:owner/repo.git
+		if i := strings.Index(repository, ":"); i > 0 {
+			repository = "ssh://" + repository[:i] + "/" + repository[i+1:]
+		}
+	}
+
+	var host, path string
+	if u, err := url.Parse(repository); err == nil && u.Host != "" {
+		host, path = u.Hostname(), u.Path
+	} else {
+		path = repository
+	}
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	return strings.ToLower(host) + "/" + path
+}
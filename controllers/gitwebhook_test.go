@@ -0,0 +1,203 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("GitWebhookReceiver", func() {
+	secret := []byte("webhook-secret")
+
+	var (
+		c        client.Client
+		receiver *GitWebhookReceiver
+	)
+
+	newMigration := func(name, checkoutURL, branch string) *migrationsv1alpha1.Migration {
+		return &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				SQL: migrationsv1alpha1.SQLSpec{
+					Git: &migrationsv1alpha1.GitMigrationSpec{CheckoutURL: checkoutURL, Branch: branch},
+				},
+			},
+		}
+	}
+
+	payload := func(name string) []byte {
+		body, err := ioutil.ReadFile(filepath.Join("testdata", name))
+		Expect(err).ToNot(HaveOccurred())
+		return body
+	}
+
+	sign := func(body []byte) string {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(body)
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+
+	deliver := func(body []byte, headers map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, GitWebhookPath, bytes.NewReader(body))
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		rec := httptest.NewRecorder()
+		receiver.ServeHTTP(rec, req)
+		return rec
+	}
+
+	requestedRevision := func(name string) string {
+		var migration migrationsv1alpha1.Migration
+		Expect(c.Get(context.Background(), client.ObjectKey{Namespace: "default", Name: name}, &migration)).To(Succeed())
+		return migration.ObjectMeta.Annotations[migrationsv1alpha1.GitRevisionAnnotation]
+	}
+
+	BeforeEach(func() {
+		c = fake.NewFakeClientWithScheme(scheme.Scheme,
+			newMigration("github-ssh", "git@github.com:acme/schemas.git", "main"),
+			newMigration("github-other-branch", "https://github.com/acme/schemas", "develop"),
+			newMigration("gitlab-https", "https://gitlab.acme.io/platform/schemas.git", "main"),
+			newMigration("gitea-ssh", "ssh://git@gitea.acme.io/platform/schemas.git", "develop"),
+		)
+		receiver = &GitWebhookReceiver{Client: c, Log: logf.Log.WithName("git-webhook"), Secret: secret}
+	})
+
+	It("triggers migrations following the branch pushed to github", func() {
+		body := payload("github_push.json")
+		rec := deliver(body, map[string]string{
+			"X-GitHub-Event":      "push",
+			"X-Hub-Signature-256": "sha256=" + sign(body),
+		})
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(requestedRevision("github-ssh")).To(Equal("0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c"))
+		Expect(requestedRevision("github-other-branch")).To(BeEmpty())
+		Expect(requestedRevision("gitlab-https")).To(BeEmpty())
+	})
+
+	It("triggers migrations following the branch pushed to gitlab", func() {
+		rec := deliver(payload("gitlab_push.json"), map[string]string{
+			"X-Gitlab-Event": "Push Hook",
+			"X-Gitlab-Token": string(secret),
+		})
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(requestedRevision("gitlab-https")).To(Equal("da1560886d4f094c3e6c9ef40349f7d38b5d27d7"))
+		Expect(requestedRevision("github-ssh")).To(BeEmpty())
+	})
+
+	It("triggers migrations following the branch pushed to gitea", func() {
+		body := payload("gitea_push.json")
+		rec := deliver(body, map[string]string{
+			"X-Gitea-Event":     "push",
+			"X-GitHub-Event":    "push",
+			"X-Gitea-Signature": sign(body),
+		})
+
+		Expect(rec.Code).To(Equal(http.StatusOK))
+		Expect(requestedRevision("gitea-ssh")).To(Equal("bffeb74224043ba2feb48d137756c8a9331c449a"))
+	})
+
+	It("rejects deliveries with an invalid signature", func() {
+		body := payload("github_push.json")
+		rec := deliver(body, map[string]string{
+			"X-GitHub-Event":      "push",
+			"X-Hub-Signature-256": "sha256=" + sign([]byte("tampered")),
+		})
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+		Expect(requestedRevision("github-ssh")).To(BeEmpty())
+	})
+
+	It("rejects gitlab deliveries with a wrong token", func() {
+		rec := deliver(payload("gitlab_push.json"), map[string]string{
+			"X-Gitlab-Event": "Push Hook",
+			"X-Gitlab-Token": "guess",
+		})
+
+		Expect(rec.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("ignores events other than push", func() {
+		body := []byte(`{"zen": "Keep it logically awesome."}`)
+		rec := deliver(body, map[string]string{
+			"X-GitHub-Event":      "ping",
+			"X-Hub-Signature-256": "sha256=" + sign(body),
+		})
+
+		Expect(rec.Code).To(Equal(http.StatusNoContent))
+	})
+})
+
+var _ = Describe("consumePushedRevision", func() {
+	It("clears the pushed revision and keeps the status and defaults of the pass", func() {
+		ctx := context.Background()
+		migration := &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "orders",
+				Namespace:   "default",
+				Annotations: map[string]string{migrationsv1alpha1.GitRevisionAnnotation: "abc123"},
+			},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				SQL: migrationsv1alpha1.SQLSpec{
+					Git: &migrationsv1alpha1.GitMigrationSpec{CheckoutURL: "git@example.com:shop/orders.git", Branch: "main"},
+				},
+			},
+			Status: migrationsv1alpha1.MigrationStatus{Phase: migrationsv1alpha1.MigrationSucceeded, Revision: "000000"},
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, migration.DeepCopy())
+		r := &MigrationReconciler{Client: c, Log: logf.Log}
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "orders"}, migration)).To(Succeed())
+		migration.Default()
+		migration.Status.Phase = migrationsv1alpha1.MigrationRunning
+		migration.Status.Revision = "abc123"
+
+		revision, err := r.consumePushedRevision(ctx, migration)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(revision).To(Equal("abc123"))
+		Expect(migration.Status.Phase).To(Equal(migrationsv1alpha1.MigrationRunning))
+		Expect(migration.Status.Revision).To(Equal("abc123"))
+		Expect(migration.Spec.Image).To(Equal(migrationsv1alpha1.DefaultImage))
+		Expect(migration.ObjectMeta.Annotations).NotTo(HaveKey(migrationsv1alpha1.GitRevisionAnnotation))
+
+		// the status of the pass is saved over the patched migration
+		Expect(c.Status().Update(ctx, migration)).To(Succeed())
+		var stored migrationsv1alpha1.Migration
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "default", Name: "orders"}, &stored)).To(Succeed())
+		Expect(stored.ObjectMeta.Annotations).NotTo(HaveKey(migrationsv1alpha1.GitRevisionAnnotation))
+		Expect(stored.Status.Phase).To(Equal(migrationsv1alpha1.MigrationRunning))
+	})
+})
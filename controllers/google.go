@@ -0,0 +1,142 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	"cloud.google.com/go/cloudsqlconn"
+	"golang.org/x/oauth2"
+)
+
+type (
+	// GoogleCredentialsProvider reads the access tokens of the operator from the metadata server, which serves the
+	// Google service account bound to the Kubernetes service account of the operator through workload identity
+	GoogleCredentialsProvider struct {
+		HTTPClient *http.Client
+		// MetadataEndpoint defaults to GCE_METADATA_HOST, to the metadata server of GKE nodes otherwise
+		MetadataEndpoint string
+		// AdminEndpoint is the base URL of the Cloud SQL Admin API, the public endpoint when empty
+		AdminEndpoint string
+
+		mu          sync.Mutex
+		token       *oauth2.Token
+		connectorMu sync.Mutex
+		connector   *cloudsqlconn.Dialer
+	}
+
+	googleToken struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+
+	// cloudSQLDialer connects to a Cloud SQL instance with the Cloud SQL Go connector, the way the Auth Proxy does
+	cloudSQLDialer struct {
+		spec      *migrationsv1alpha1.CloudSQLProxySpec
+		connector *cloudsqlconn.Dialer
+		// dial opens the connections to the instance, the connector dials its server port when nil
+		dial func(ctx context.Context, network, address string) (net.Conn, error)
+	}
+)
+
+const (
+	googleTokenRefreshMargin = 5 * time.Minute
+	cloudSQLDialTimeout      = 30 * time.Second
+)
+
+// DefaultGoogleCredentials is the provider of the credentials connecting to Cloud SQL instances
+var DefaultGoogleCredentials = &GoogleCredentialsProvider{HTTPClient: &http.Client{Timeout: 10 * time.Second}}
+
+// Token returns the cached access token unless it is about to expire
+func (p *GoogleCredentialsProvider) Token() (*oauth2.Token, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.token != nil && time.Until(p.token.Expiry) > googleTokenRefreshMargin {
+		return p.token, nil
+	}
+	endpoint := p.MetadataEndpoint
+	if endpoint == "" {
+		endpoint = "http://metadata.google.internal"
+		if host := os.Getenv("GCE_METADATA_HOST"); host != "" {
+			endpoint = "http://" + host
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(endpoint, "/")+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	var token googleToken
+	if err := fetch(p.HTTPClient, req, func(body []byte) error { return json.Unmarshal(body, &token) }); err != nil {
+		return nil, newTransientError(ReasonGoogleCredentialsMissing, "unable to retrieve the Google credentials of the operator, set up workload identity for its service account: %v", err)
+	}
+	p.token = &oauth2.Token{
+		AccessToken: token.AccessToken,
+		TokenType:   "Bearer",
+		Expiry:      time.Now().Add(time.Duration(token.ExpiresIn) * time.Second),
+	}
+	return p.token, nil
+}
+
+// cloudSQLConnector returns the connector of the provider, shared by the dialers since it refreshes
+// the ephemeral certificates of the instances it connected to in the background.
+// The access tokens of the operator authenticate both the Admin API and the IAM database users.
+func (p *GoogleCredentialsProvider) cloudSQLConnector() (*cloudsqlconn.Dialer, error) {
+	p.connectorMu.Lock()
+	defer p.connectorMu.Unlock()
+
+	if p.connector != nil {
+		return p.connector, nil
+	}
+	opts := []cloudsqlconn.Option{cloudsqlconn.WithIAMAuthN(), cloudsqlconn.WithIAMAuthNTokenSources(p, p)}
+	if p.AdminEndpoint != "" {
+		opts = append(opts, cloudsqlconn.WithAdminAPIEndpoint(p.AdminEndpoint))
+	}
+	// the connector outlives the reconciliation creating it
+	connector, err := cloudsqlconn.NewDialer(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	p.connector = connector
+	return connector, nil
+}
+
+func newCloudSQLDialer(spec *migrationsv1alpha1.CloudSQLProxySpec, provider *GoogleCredentialsProvider) (*cloudSQLDialer, error) {
+	// domain scoped projects hold a colon too, as in example.com:project:region:instance
+	if len(strings.Split(spec.Instance, ":")) < 3 {
+		return nil, newMigrationError(ReasonTargetInvalid, "invalid Cloud SQL instance connection name %q", spec.Instance)
+	}
+	connector, err := provider.cloudSQLConnector()
+	if err != nil {
+		return nil, err
+	}
+	return &cloudSQLDialer{spec: spec, connector: connector}, nil
+}
+
+func (d *cloudSQLDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialTimeout(network, address, cloudSQLDialTimeout)
+}
+
+// DialTimeout connects to the instance whatever the address, which is the one of the local proxy of the pods
+func (d *cloudSQLDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	opts := []cloudsqlconn.DialOption{cloudsqlconn.WithDialIAMAuthN(d.spec.IAMUser != "")}
+	if d.spec.PrivateIP {
+		opts = append(opts, cloudsqlconn.WithPrivateIP())
+	}
+	if d.dial != nil {
+		opts = append(opts, cloudsqlconn.WithOneOffDialFunc(d.dial))
+	}
+	return d.connector.Dial(ctx, d.spec.Instance, opts...)
+}
@@ -0,0 +1,50 @@
+package controllers
+
+import (
+	"strings"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+// applyHistory reports the applied state of a target from its schema history entries
+func applyHistory(status *migrationsv1alpha1.TargetStatus, entries []migrationsv1alpha1.AppliedScript, limit int) {
+	status.CurrentVersion = historyVersion(entries)
+
+	status.AppliedScripts = nil
+	status.FailedScripts = nil
+	for i := len(entries) - 1; i >= 0; i-- {
+		if len(status.AppliedScripts) < limit {
+			status.AppliedScripts = append(status.AppliedScripts, entries[i])
+		}
+		if !entries[i].Success {
+			status.FailedScripts = append(status.FailedScripts, entries[i])
+		}
+	}
+}
+
+// historyVersion is the highest version applied
+func historyVersion(entries []migrationsv1alpha1.AppliedScript) string {
+	current := ""
+	for version := range appliedVersions(entries) {
+		if current == "" || compareVersions(version, current) > 0 {
+			current = version
+		}
+	}
+	return current
+}
+
+// appliedVersions replays the successful versioned entries, undone versions no longer count as applied
+func appliedVersions(entries []migrationsv1alpha1.AppliedScript) map[string]bool {
+	applied := map[string]bool{}
+	for _, entry := range entries {
+		if !entry.Success || entry.Version == "" {
+			continue
+		}
+		if strings.HasPrefix(entry.Type, undoHistoryTypePrefix) {
+			delete(applied, entry.Version)
+		} else {
+			applied[entry.Version] = true
+		}
+	}
+	return applied
+}
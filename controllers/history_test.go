@@ -0,0 +1,67 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("applyHistory", func() {
+	script := func(rank int32, version, kind string, success bool) migrationsv1alpha1.AppliedScript {
+		return migrationsv1alpha1.AppliedScript{InstalledRank: rank, Version: version, Type: kind, Script: "V" + version + "__script.sql", Success: success}
+	}
+
+	It("reports the latest scripts first up to the limit", func() {
+		status := migrationsv1alpha1.TargetStatus{}
+		applyHistory(&status, []migrationsv1alpha1.AppliedScript{
+			script(1, "1", "SQL", true),
+			script(2, "2", "SQL", true),
+			script(3, "3", "SQL", true),
+		}, 2)
+
+		Expect(status.CurrentVersion).To(Equal("3"))
+		Expect(status.AppliedScripts).To(HaveLen(2))
+		Expect(status.AppliedScripts[0].InstalledRank).To(Equal(int32(3)))
+		Expect(status.FailedScripts).To(BeEmpty())
+	})
+
+	It("keeps failed entries beyond the limit and ignores them for the version", func() {
+		status := migrationsv1alpha1.TargetStatus{}
+		applyHistory(&status, []migrationsv1alpha1.AppliedScript{
+			script(1, "1", "SQL", true),
+			script(2, "2", "SQL", false),
+			script(3, "", "SQL", true),
+		}, 1)
+
+		Expect(status.CurrentVersion).To(Equal("1"))
+		Expect(status.FailedScripts).To(ConsistOf(script(2, "2", "SQL", false)))
+	})
+
+	It("does not count undone versions as applied", func() {
+		status := migrationsv1alpha1.TargetStatus{}
+		applyHistory(&status, []migrationsv1alpha1.AppliedScript{
+			script(1, "1", "SQL", true),
+			script(2, "1.1", "SQL", true),
+			script(3, "1.1", "UNDO_SQL", true),
+		}, 10)
+
+		Expect(status.CurrentVersion).To(Equal("1"))
+	})
+})
@@ -0,0 +1,142 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package integration
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+	"flyway-operator/controllers"
+)
+
+var _ = Describe("Migration controller", func() {
+	const (
+		namespace = "default"
+		timeout   = 10 * time.Second
+		interval  = 250 * time.Millisecond
+	)
+
+	ctx := context.Background()
+
+	newMigration := func(name string) *migrationsv1alpha1.Migration {
+		return &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				DB: migrationsv1alpha1.DBSpec{
+					Host:   "localhost",
+					DBName: "test",
+					Driver: migrationsv1alpha1.PostgresDriver,
+					Secret: &migrationsv1alpha1.SecretSpec{Name: name},
+				},
+				SQL: migrationsv1alpha1.SQLSpec{
+					VolumeClaim: name,
+					Path:        "migrations",
+				},
+			},
+		}
+	}
+
+	newSecret := func(name string, data map[string][]byte) *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       data,
+		}
+	}
+
+	// expectFailure waits for the migration to report the reason on its ready condition and as a warning event
+	expectFailure := func(name, reason string) {
+		key := client.ObjectKey{Namespace: namespace, Name: name}
+		Eventually(func() string {
+			var migration migrationsv1alpha1.Migration
+			if err := k8sClient.Get(ctx, key, &migration); err != nil {
+				return ""
+			}
+			condition := migration.Status.GetCondition(migrationsv1alpha1.ConditionReady)
+			if condition == nil || condition.Status != corev1.ConditionFalse {
+				return ""
+			}
+			return condition.Reason
+		}, timeout, interval).Should(Equal(reason))
+
+		Eventually(func() bool {
+			var events corev1.EventList
+			if err := k8sClient.List(ctx, &events, client.InNamespace(namespace)); err != nil {
+				return false
+			}
+			for _, event := range events.Items {
+				if event.InvolvedObject.Name == name && event.Reason == reason && event.Type == corev1.EventTypeWarning {
+					return true
+				}
+			}
+			return false
+		}, timeout, interval).Should(BeTrue())
+	}
+
+	Context("when the migration cannot be run", func() {
+		It("reports an unknown driver", func() {
+			migration := newMigration("unknown-driver")
+			migration.Spec.DB.Driver = "com.example.Driver"
+			Expect(k8sClient.Create(ctx, migration)).Should(Succeed())
+
+			expectFailure(migration.Name, controllers.ReasonUnknownDriver)
+		})
+
+		It("reports missing credentials", func() {
+			migration := newMigration("no-credentials")
+			migration.Spec.DB.Secret = nil
+			Expect(k8sClient.Create(ctx, migration)).Should(Succeed())
+
+			expectFailure(migration.Name, controllers.ReasonCredentialsMissing)
+		})
+
+		It("reports a missing credentials secret", func() {
+			migration := newMigration("missing-secret")
+			Expect(k8sClient.Create(ctx, migration)).Should(Succeed())
+
+			expectFailure(migration.Name, controllers.ReasonSecretNotFound)
+		})
+
+		It("reports a key missing from the credentials secret", func() {
+			migration := newMigration("missing-secret-key")
+			Expect(k8sClient.Create(ctx, newSecret(migration.Name, map[string][]byte{
+				migrationsv1alpha1.DefaultUserKey: []byte("flyway"),
+			}))).Should(Succeed())
+			Expect(k8sClient.Create(ctx, migration)).Should(Succeed())
+
+			expectFailure(migration.Name, controllers.ReasonSecretKeyMissing)
+		})
+
+		It("reports a missing scripts location", func() {
+			migration := newMigration("no-location")
+			migration.Spec.SQL.VolumeClaim = ""
+			Expect(k8sClient.Create(ctx, newSecret(migration.Name, map[string][]byte{
+				migrationsv1alpha1.DefaultUserKey:     []byte("flyway"),
+				migrationsv1alpha1.DefaultPasswordKey: []byte("flyway"),
+			}))).Should(Succeed())
+			Expect(k8sClient.Create(ctx, migration)).Should(Succeed())
+
+			expectFailure(migration.Name, controllers.ReasonScriptsLocationInvalid)
+		})
+	})
+})
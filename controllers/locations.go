@@ -13,6 +13,8 @@ type (
 
 	GitLocation struct {
 		Spec *migrationsv1alpha1.GitMigrationSpec
+		// Revision pins the commit to checkout, the branch head is used when empty
+		Revision string
 	}
 
 	VolumeLocation struct {
@@ -22,13 +24,19 @@ type (
 
 const (
 	gitMountName = "git-key"
+	// gitKeyName is the key of the ssh private key in the git secret
+	gitKeyName = "id_rsa"
+	// gitKnownHostsName is the key of the host keys of the git server in the git secret
+	gitKnownHostsName = "known_hosts"
 	// SQLVolumeName that sets the name of volume for sql scripts
 	SQLVolumeName = "sql-scripts"
+	// sqlMountPath is where the flyway jobs read the scripts
+	sqlMountPath = "/flyway/sql"
 )
 
-func GetScriptsLocation(spec *migrationsv1alpha1.SQLSpec) ScriptsLocation {
-	if spec.Git != (migrationsv1alpha1.GitMigrationSpec{}) {
-		return GitLocation{Spec: &spec.Git}
+func GetScriptsLocation(spec *migrationsv1alpha1.SQLSpec, revision string) ScriptsLocation {
+	if spec.Git != nil {
+		return GitLocation{Spec: spec.Git, Revision: revision}
 	} else if spec.VolumeClaim != "" {
 		return VolumeLocation{Name: spec.VolumeClaim}
 	}
@@ -36,12 +44,16 @@ func GetScriptsLocation(spec *migrationsv1alpha1.SQLSpec) ScriptsLocation {
 }
 
 func (git GitLocation) MutateTemplate(tpl *corev1.PodTemplateSpec) {
+	sshCommand := "ssh -o StrictHostKeyChecking=no -i /etc/git-secret/" + gitKeyName
+	if git.Spec.StrictHostKeyChecking {
+		sshCommand = "ssh -o StrictHostKeyChecking=yes -o UserKnownHostsFile=/etc/git-secret/" + gitKnownHostsName + " -i /etc/git-secret/" + gitKeyName
+	}
 	tpl.Spec.InitContainers = []corev1.Container{
 		corev1.Container{
 			Name:  "git",
 			Image: "alpine/git:1.0.2",
 			Env: []corev1.EnvVar{
-				corev1.EnvVar{Name: "GIT_SSH_COMMAND", Value: "ssh -o StrictHostKeyChecking=no -i /etc/git-secret/id_rsa"},
+				corev1.EnvVar{Name: "GIT_SSH_COMMAND", Value: sshCommand},
 			},
 			Args: []string{"clone", "--branch", git.Spec.Branch, git.Spec.CheckoutURL, "/opt/sources"},
 			VolumeMounts: []corev1.VolumeMount{
@@ -50,6 +62,12 @@ func (git GitLocation) MutateTemplate(tpl *corev1.PodTemplateSpec) {
 			},
 		},
 	}
+	if git.Revision != "" {
+		clone := tpl.Spec.InitContainers[0]
+		clone.Command = []string{"sh", "-c", `git clone --branch "$0" "$1" /opt/sources && git -C /opt/sources checkout "$2"`}
+		clone.Args = []string{git.Spec.Branch, git.Spec.CheckoutURL, git.Revision}
+		tpl.Spec.InitContainers[0] = clone
+	}
 	mode := int32(256)
 	tpl.Spec.Volumes = []corev1.Volume{
 		corev1.Volume{
@@ -0,0 +1,242 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+const (
+	// lockQueueAnnotation lists the runs waiting for the lease of a database, in order
+	lockQueueAnnotation = "migrations.flywayoperator.io/queue"
+	// lockDatabaseAnnotation tells which database a lease serializes
+	lockDatabaseAnnotation = "migrations.flywayoperator.io/database"
+	// lockRetryDelay is the time between two attempts of a queued run to take its lock
+	lockRetryDelay = 10 * time.Second
+	// lockGracePeriod protects a freshly acquired lease until its holder reports a running target
+	lockGracePeriod = time.Minute
+)
+
+// lockKey identifies a database across migrations, whatever resource its connection is defined in
+func lockKey(db *migrationsv1alpha1.DBSpec) string {
+	return fmt.Sprintf("%s|%s|%d|%s", db.Driver, db.Host, db.Port, db.DBName)
+}
+
+func leaseName(db *migrationsv1alpha1.DBSpec) string {
+	return fmt.Sprintf("flyway-lock-%x", sha256.Sum256([]byte(lockKey(db))))[:28]
+}
+
+func lockHolder(migration *migrationsv1alpha1.Migration, target *migrationTarget) string {
+	return fmt.Sprintf("%s/%s/%s", migration.ObjectMeta.Namespace, migration.ObjectMeta.Name, target.name)
+}
+
+// leaseTarget takes the lease of the target database before the target runs,
+// the target is queued when another run holds the database
+func (r *MigrationReconciler) leaseTarget(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus) (bool, error) {
+	if r.LockNamespace == "" {
+		return false, nil
+	}
+	position, err := r.acquireLease(ctx, &run.target.db, lockHolder(migration, run.target))
+	if err != nil {
+		return false, err
+	}
+	if position > 0 {
+		r.queue(migration, status, position, fmt.Sprintf("waiting for the runs queued on database %s", dbAddress(&run.target.db)))
+		return true, nil
+	}
+	return false, nil
+}
+
+// advisoryLockTarget takes the database level lock of the target when enabled, the lock is held by this operator
+// process for the whole run and the target is queued when an operator of another cluster holds it
+func (r *MigrationReconciler) advisoryLockTarget(migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus) (bool, error) {
+	holder := lockHolder(migration, run.target)
+	if !run.target.db.AdvisoryLock {
+		return false, nil
+	}
+	if _, held := r.advisoryLocks.Load(holder); held {
+		return false, nil
+	}
+	locker, ok := run.driver.(Locker)
+	if !ok {
+		return false, newMigrationError(ReasonTargetInvalid, "driver %s does not support advisory locks", run.target.db.Driver)
+	}
+	session, locked, err := locker.TryLock(&run.target.db, run.userPass, run.dialer, lockKey(&run.target.db))
+	if err != nil {
+		return false, newTransientError(ReasonDatabaseUnreachable, "unable to take the advisory lock of database %s: %v", dbAddress(&run.target.db), err)
+	}
+	if !locked {
+		r.queue(migration, status, 1, fmt.Sprintf("database %s is locked by another operator", dbAddress(&run.target.db)))
+		return true, nil
+	}
+	r.advisoryLocks.Store(holder, session)
+	return false, nil
+}
+
+func (r *MigrationReconciler) queue(migration *migrationsv1alpha1.Migration, status *migrationsv1alpha1.TargetStatus, position int, message string) {
+	if status.Phase != migrationsv1alpha1.MigrationQueued {
+		r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonQueued, "target %s queued at position %d: %s", status.Name, position, message)
+	}
+	status.Phase = migrationsv1alpha1.MigrationQueued
+	status.QueuePosition = int32(position)
+	status.Message = message
+}
+
+// unlockTarget releases the locks taken for a finished target
+func (r *MigrationReconciler) unlockTarget(ctx context.Context, migration *migrationsv1alpha1.Migration, target *migrationTarget) error {
+	holder := lockHolder(migration, target)
+	if session, held := r.advisoryLocks.Load(holder); held {
+		r.advisoryLocks.Delete(holder)
+		if err := session.(io.Closer).Close(); err != nil {
+			r.Log.Error(err, "unable to release advisory lock", "holder", holder)
+		}
+	}
+	if r.LockNamespace == "" {
+		return nil
+	}
+
+	var lease coordinationv1.Lease
+	if err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: r.LockNamespace, Name: leaseName(&target.db)}, &lease); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != holder {
+		return nil
+	}
+	lease.Spec.HolderIdentity = nil
+	return r.Update(ctx, &lease)
+}
+
+// acquireLease takes the lease of the database for the holder, or queues the holder behind the current one.
+// It returns the position of the holder in the queue, zero once the lease is held.
+func (r *MigrationReconciler) acquireLease(ctx context.Context, db *migrationsv1alpha1.DBSpec, holder string) (int, error) {
+	now := metav1.NewMicroTime(time.Now())
+
+	var lease coordinationv1.Lease
+	err := r.APIReader.Get(ctx, client.ObjectKey{Namespace: r.LockNamespace, Name: leaseName(db)}, &lease)
+	if apierrors.IsNotFound(err) {
+		lease = coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        leaseName(db),
+				Namespace:   r.LockNamespace,
+				Annotations: map[string]string{lockDatabaseAnnotation: db.Driver + "://" + dbAddress(db)},
+			},
+			Spec: coordinationv1.LeaseSpec{HolderIdentity: &holder, AcquireTime: &now},
+		}
+		// a concurrent creation fails here and the reconcile is retried
+		return 0, r.Create(ctx, &lease)
+	} else if err != nil {
+		return 0, err
+	}
+
+	current := ""
+	if lease.Spec.HolderIdentity != nil {
+		current = *lease.Spec.HolderIdentity
+	}
+	if current == holder {
+		return 0, nil
+	}
+
+	queue, err := r.liveQueue(ctx, &lease)
+	if err != nil {
+		return 0, err
+	}
+	active, err := r.holderActive(ctx, &lease)
+	if err != nil {
+		return 0, err
+	}
+
+	position := 0
+	if !active && (len(queue) == 0 || queue[0] == holder) {
+		lease.Spec.HolderIdentity = &holder
+		lease.Spec.AcquireTime = &now
+		queue = removeHolder(queue, holder)
+	} else {
+		for i, queued := range queue {
+			if queued == holder {
+				position = i + 1
+			}
+		}
+		if position == 0 {
+			queue = append(queue, holder)
+			position = len(queue)
+		}
+	}
+
+	if lease.ObjectMeta.Annotations == nil {
+		lease.ObjectMeta.Annotations = map[string]string{}
+	}
+	lease.ObjectMeta.Annotations[lockQueueAnnotation] = strings.Join(queue, ",")
+	return position, r.Update(ctx, &lease)
+}
+
+// liveQueue reads the queue of the lease, dropping the runs of deleted migrations
+func (r *MigrationReconciler) liveQueue(ctx context.Context, lease *coordinationv1.Lease) ([]string, error) {
+	var queue []string
+	for _, holder := range strings.Split(lease.ObjectMeta.Annotations[lockQueueAnnotation], ",") {
+		if holder == "" {
+			continue
+		}
+		parts := strings.SplitN(holder, "/", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		var migration migrationsv1alpha1.Migration
+		if err := r.Get(ctx, client.ObjectKey{Namespace: parts[0], Name: parts[1]}, &migration); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, err
+		}
+		queue = append(queue, holder)
+	}
+	return queue, nil
+}
+
+// holderActive tells whether the lease holder still runs, holders of deleted migrations or finished targets lose the lease
+func (r *MigrationReconciler) holderActive(ctx context.Context, lease *coordinationv1.Lease) (bool, error) {
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity == "" {
+		return false, nil
+	}
+	if lease.Spec.AcquireTime != nil && time.Since(lease.Spec.AcquireTime.Time) < lockGracePeriod {
+		return true, nil
+	}
+
+	parts := strings.SplitN(*lease.Spec.HolderIdentity, "/", 3)
+	if len(parts) != 3 {
+		return false, nil
+	}
+	var migration migrationsv1alpha1.Migration
+	if err := r.Get(ctx, client.ObjectKey{Namespace: parts[0], Name: parts[1]}, &migration); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, target := range migration.Status.Targets {
+		if target.Name == parts[2] {
+			return target.Phase == migrationsv1alpha1.MigrationRunning || target.Phase == migrationsv1alpha1.MigrationQueued, nil
+		}
+	}
+	return false, nil
+}
+
+func removeHolder(queue []string, holder string) []string {
+	kept := queue[:0]
+	for _, queued := range queue {
+		if queued != holder {
+			kept = append(kept, queued)
+		}
+	}
+	return kept
+}
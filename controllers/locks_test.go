@@ -0,0 +1,73 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("database leases", func() {
+	ctx := context.Background()
+	db := migrationsv1alpha1.DBSpec{Host: "postgres", Port: 5432, DBName: "app", Driver: migrationsv1alpha1.PostgresDriver}
+
+	var r *MigrationReconciler
+
+	BeforeEach(func() {
+		migrations := make([]runtime.Object, 0, 3)
+		for _, name := range []string{"first", "second", "third"} {
+			migrations = append(migrations, &migrationsv1alpha1.Migration{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"}})
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, migrations...)
+		r = &MigrationReconciler{Client: c, APIReader: c, Log: ctrl.Log, LockNamespace: "flyway-system"}
+	})
+
+	It("queues the runs behind the lease holder in order", func() {
+		Expect(r.acquireLease(ctx, &db, "default/first/app")).To(Equal(0))
+		Expect(r.acquireLease(ctx, &db, "default/second/app")).To(Equal(1))
+		Expect(r.acquireLease(ctx, &db, "default/third/app")).To(Equal(2))
+		Expect(r.acquireLease(ctx, &db, "default/second/app")).To(Equal(1))
+	})
+
+	It("hands the lease over to the head of the queue once released", func() {
+		Expect(r.acquireLease(ctx, &db, "default/first/app")).To(Equal(0))
+		Expect(r.acquireLease(ctx, &db, "default/second/app")).To(Equal(1))
+		Expect(r.acquireLease(ctx, &db, "default/third/app")).To(Equal(2))
+
+		first := &migrationsv1alpha1.Migration{ObjectMeta: metav1.ObjectMeta{Name: "first", Namespace: "default"}}
+		Expect(r.unlockTarget(ctx, first, &migrationTarget{name: "app", db: db})).To(Succeed())
+
+		Expect(r.acquireLease(ctx, &db, "default/third/app")).To(Equal(2))
+		Expect(r.acquireLease(ctx, &db, "default/second/app")).To(Equal(0))
+		Expect(r.acquireLease(ctx, &db, "default/third/app")).To(Equal(1))
+	})
+
+	It("drops the runs of deleted migrations from the queue", func() {
+		Expect(r.acquireLease(ctx, &db, "default/first/app")).To(Equal(0))
+		Expect(r.acquireLease(ctx, &db, "default/deleted/app")).To(Equal(1))
+		Expect(r.acquireLease(ctx, &db, "default/second/app")).To(Equal(1))
+	})
+})
@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+type (
+	// PodLogs reads the logs of pod containers, the cached client cannot reach the log subresource
+	PodLogs interface {
+		GetLogs(namespace, pod, container string) (string, error)
+	}
+
+	clientsetPodLogs struct {
+		clientset kubernetes.Interface
+	}
+)
+
+// NewPodLogs returns a PodLogs talking to the api server of the given config
+func NewPodLogs(cfg *rest.Config) (PodLogs, error) {
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return clientsetPodLogs{clientset: clientset}, nil
+}
+
+func (l clientsetPodLogs) GetLogs(namespace, pod, container string) (string, error) {
+	logs, err := l.clientset.CoreV1().Pods(namespace).GetLogs(pod, &corev1.PodLogOptions{Container: container}).DoRaw()
+	if err != nil {
+		return "", err
+	}
+	return string(logs), nil
+}
@@ -0,0 +1,135 @@
+package controllers
+
+import (
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	migrationLabels = []string{"namespace", "name", "target", "driver"}
+
+	migrationRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "flyway_migration_runs_total",
+		Help: "Number of finished migration runs by result",
+	}, append(migrationLabels, "result"))
+
+	migrationRunDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flyway_migration_run_duration_seconds",
+		Help:    "Duration of the flyway jobs",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, migrationLabels)
+
+	dbWaitDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flyway_migration_db_wait_seconds",
+		Help:    "Time spent waiting for the database before starting a run",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	}, migrationLabels)
+
+	schemaVersion = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flyway_migration_schema_version",
+		Help: "Schema version currently applied, the version is held by the label",
+	}, append(migrationLabels, "version"))
+
+	pendingMigrations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flyway_migration_pending_scripts",
+		Help: "Number of migration scripts not applied yet",
+	}, migrationLabels)
+
+	schemaDrift = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "flyway_migration_schema_drift",
+		Help: "Whether the schema history differs from the scripts at the recorded revision",
+	}, migrationLabels)
+
+	dbProbeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "flyway_migration_db_probe_duration_seconds",
+		Help:    "Latency of the database reachability checks",
+		Buckets: prometheus.DefBuckets,
+	}, migrationLabels)
+)
+
+// migrationMetrics are the vectors holding per-target series
+var migrationMetrics = []metricVec{
+	migrationRuns,
+	migrationRunDuration,
+	dbWaitDuration,
+	schemaVersion,
+	pendingMigrations,
+	schemaDrift,
+	dbProbeDuration,
+}
+
+type metricVec interface {
+	prometheus.Collector
+	Delete(prometheus.Labels) bool
+}
+
+func init() {
+	for _, vec := range migrationMetrics {
+		metrics.Registry.MustRegister(vec)
+	}
+}
+
+func metricLabels(migration *migrationsv1alpha1.Migration, target *migrationTarget) prometheus.Labels {
+	return prometheus.Labels{
+		"namespace": migration.ObjectMeta.Namespace,
+		"name":      migration.ObjectMeta.Name,
+		"target":    target.name,
+		"driver":    target.db.Driver,
+	}
+}
+
+// recordSchemaState exposes the applied version and pending scripts of a target, the previous version series is dropped
+func recordSchemaState(labels prometheus.Labels, status *migrationsv1alpha1.TargetStatus, previousVersion string) {
+	if previousVersion != "" && previousVersion != status.CurrentVersion {
+		schemaVersion.DeleteLabelValues(labels["namespace"], labels["name"], labels["target"], labels["driver"], previousVersion)
+	}
+	if status.CurrentVersion != "" {
+		schemaVersion.With(withLabel(labels, "version", status.CurrentVersion)).Set(1)
+	}
+	pendingMigrations.With(labels).Set(float64(status.PendingMigrations))
+}
+
+func withLabel(labels prometheus.Labels, name, value string) prometheus.Labels {
+	extended := prometheus.Labels{name: value}
+	for k, v := range labels {
+		extended[k] = v
+	}
+	return extended
+}
+
+// forgetMetrics deletes every series of a migration, the label values of its targets are read back from the vectors
+func forgetMetrics(namespace, name string) {
+	for _, vec := range migrationMetrics {
+		for _, labels := range migrationSeries(vec, namespace, name) {
+			vec.Delete(labels)
+		}
+	}
+}
+
+// migrationSeries lists the label sets of the series a vector holds for a migration
+func migrationSeries(vec prometheus.Collector, namespace, name string) []prometheus.Labels {
+	collected := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(collected)
+		close(collected)
+	}()
+
+	var series []prometheus.Labels
+	for metric := range collected {
+		var m dto.Metric
+		if err := metric.Write(&m); err != nil {
+			continue
+		}
+		labels := prometheus.Labels{}
+		for _, pair := range m.GetLabel() {
+			labels[pair.GetName()] = pair.GetValue()
+		}
+		if labels["namespace"] == namespace && labels["name"] == name {
+			series = append(series, labels)
+		}
+	}
+	return series
+}
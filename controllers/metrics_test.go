@@ -0,0 +1,77 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("Migration metrics", func() {
+	labels := func(name, target string) prometheus.Labels {
+		return prometheus.Labels{"namespace": "metrics", "name": name, "target": target, "driver": migrationsv1alpha1.PostgresDriver}
+	}
+
+	AfterEach(func() {
+		forgetMetrics("metrics", "orders")
+		forgetMetrics("metrics", "billing")
+	})
+
+	It("exposes the applied version and the pending scripts", func() {
+		orders := labels("orders", "eu")
+		recordSchemaState(orders, &migrationsv1alpha1.TargetStatus{CurrentVersion: "3", PendingMigrations: 2}, "")
+		recordSchemaState(orders, &migrationsv1alpha1.TargetStatus{CurrentVersion: "4", PendingMigrations: 1}, "3")
+
+		Expect(testutil.ToFloat64(schemaVersion.With(withLabel(orders, "version", "4")))).To(Equal(1.0))
+		Expect(testutil.ToFloat64(pendingMigrations.With(orders))).To(Equal(1.0))
+		Expect(migrationSeries(schemaVersion, "metrics", "orders")).To(ConsistOf(withLabel(orders, "version", "4")))
+	})
+
+	It("drops every series of a migration and keeps the others", func() {
+		for _, target := range []string{"eu", "us"} {
+			recordSchemaState(labels("orders", target), &migrationsv1alpha1.TargetStatus{CurrentVersion: "4"}, "")
+			migrationRuns.With(withLabel(labels("orders", target), "result", "succeeded")).Inc()
+			migrationRunDuration.With(labels("orders", target)).Observe(12)
+		}
+		recordSchemaState(labels("billing", "eu"), &migrationsv1alpha1.TargetStatus{CurrentVersion: "7"}, "")
+
+		forgetMetrics("metrics", "orders")
+
+		for _, vec := range migrationMetrics {
+			Expect(migrationSeries(vec, "metrics", "orders")).To(BeEmpty())
+		}
+		Expect(migrationSeries(schemaVersion, "metrics", "billing")).To(HaveLen(1))
+	})
+
+	It("forgets a migration once it is deleted", func() {
+		recordSchemaState(labels("orders", "eu"), &migrationsv1alpha1.TargetStatus{CurrentVersion: "4", PendingMigrations: 1}, "")
+		r := &MigrationReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme), Log: ctrl.Log}
+
+		_, err := r.Reconcile(ctrl.Request{NamespacedName: types.NamespacedName{Namespace: "metrics", Name: "orders"}})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(migrationSeries(pendingMigrations, "metrics", "orders")).To(BeEmpty())
+		Expect(migrationSeries(schemaVersion, "metrics", "orders")).To(BeEmpty())
+	})
+})
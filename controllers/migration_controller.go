@@ -20,31 +20,92 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-logr/logr"
 	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
 )
 
-// MigrationReconciler reconciles a Migration object
-type MigrationReconciler struct {
-	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
-}
+type (
+	// MigrationReconciler reconciles a Migration object
+	MigrationReconciler struct {
+		client.Client
+		Log      logr.Logger
+		Scheme   *runtime.Scheme
+		Recorder record.EventRecorder
+		Logs     PodLogs
+		// APIReader reads leases and script config maps without caching every one of the cluster
+		APIReader client.Reader
+		// LockNamespace holds the leases serializing the runs per database, runs are not serialized when empty
+		LockNamespace string
+		// Notifier sends the notifications of rollouts, none are sent when nil
+		Notifier *Notifier
+
+		// advisoryLocks holds the database sessions locking the running targets
+		advisoryLocks sync.Map
+	}
+
+	// flywayRun gathers what a flyway job needs for a migration
+	flywayRun struct {
+		target   *migrationTarget
+		driver   Driver
+		userPass *UserPassword
+		// deadline stops the job when its maintenance window closes
+		deadline time.Time
+		// undo runs flyway undo down to the target instead of migrate
+		undo bool
+		// dialer opens the connections of the operator, nil when the database is reached directly
+		dialer Dialer
+	}
+)
+
+const (
+	// dbWaitTimeout reports the database of a target unreachable once it has been waited for that long
+	dbWaitTimeout = 10 * time.Minute
+	// dbPollInterval is the delay between the checks of a database being waited for
+	dbPollInterval = 10 * time.Second
+	// tokenRefreshMargin renews authentication tokens before they expire
+	tokenRefreshMargin = time.Minute
+	// FlywayContainerName is the container of the flyway jobs running flyway, or the compensating scripts
+	FlywayContainerName = "flyway-migration"
+	// secretNameField indexes migrations by the secrets holding the credentials of their targets
+	secretNameField = ".spec.db.secret.name"
+	// databaseRefField indexes migrations by the databases they reference
+	databaseRefField = ".spec.databaseRef"
+)
 
 // +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=migrations,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=migrations/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get
+// +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=databases;clusterdatabases,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+// +kubebuilder:rbac:groups=core,resources=pods/log,verbs=get
 // +kubebuilder:rbac:groups=batch,resources=jobs/status,verbs=get
+// +kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;create;update
+// +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=notificationpolicies,verbs=get;list;watch
+// +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=migrationruns,verbs=get;list;watch;create;delete
+// +kubebuilder:rbac:groups=migrations.flywayoperator.io,resources=migrationruns/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=snapshot.storage.k8s.io,resources=volumesnapshots,verbs=get;list;create;delete
 
 func (r *MigrationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -52,109 +113,893 @@ func (r *MigrationReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 
 	var migration migrationsv1alpha1.Migration
 	if err := r.Get(ctx, req.NamespacedName, &migration); err != nil {
+		if apierrors.IsNotFound(err) {
+			// the migration is gone, its series would otherwise be exported forever
+			forgetMetrics(req.Namespace, req.Name)
+			return ctrl.Result{}, nil
+		}
 		log.Info("unable to fetch migration spec " + req.NamespacedName.Name)
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		return ctrl.Result{}, err
 	}
 
 	if migration.ObjectMeta.DeletionTimestamp.IsZero() {
-		// load db creds if provided through secret
-		creds := GetCredentials(&migration)
-		if creds == nil {
-			return ctrl.Result{}, errors.New("unable to get db credentials for migration")
+		// apply defaults in case the admission webhook is not deployed
+		migration.Default()
+		original := migration.Status.DeepCopy()
+
+		// targets and their credentials are resolved on every pass so that secret changes are re-checked
+		targets, err := r.resolveTargets(ctx, &migration)
+		if err != nil {
+			return r.reportError(ctx, &migration, err)
+		}
+		runs := make([]*flywayRun, 0, len(targets))
+		for _, target := range targets {
+			run, err := r.resolveRun(ctx, &migration, target)
+			if err != nil {
+				return r.reportError(ctx, &migration, err)
+			}
+			runs = append(runs, run)
+		}
+		syncTargetStatuses(&migration.Status, targets)
+
+		started, backingUp := false, false
+		for i, run := range runs {
+			status := &migration.Status.Targets[i]
+			if backup := status.Backup; backup != nil && backup.Phase == migrationsv1alpha1.MigrationRunning && status.Phase == migrationsv1alpha1.MigrationRunning {
+				started, backingUp = true, true
+				if err := r.updateBackup(ctx, &migration, run, status); err != nil {
+					return r.reportError(ctx, &migration, err)
+				}
+				continue
+			}
+			if restore := status.Restore; restore != nil && restore.Phase == migrationsv1alpha1.MigrationRunning {
+				started = true
+				if err := r.updateRestore(ctx, &migration, run, status); err != nil {
+					return ctrl.Result{}, err
+				}
+				continue
+			}
+			// every run has its own job, the jobs of restarted runs may linger while they are deleted
+			if status.Job == "" {
+				continue
+			}
+			var existing batchv1.Job
+			err := r.Get(ctx, client.ObjectKey{Namespace: req.NamespacedName.Namespace, Name: status.Job}, &existing)
+			if apierrors.IsNotFound(err) {
+				continue
+			} else if err != nil {
+				return ctrl.Result{}, err
+			}
+			if !existing.ObjectMeta.DeletionTimestamp.IsZero() {
+				continue
+			}
+			started = true
+			if err := r.updateFromJob(ctx, &migration, run, status, &existing); err != nil {
+				return ctrl.Result{}, err
+			}
+		}
+
+		if started {
+			if ready := migration.Status.GetCondition(migrationsv1alpha1.ConditionReady); ready != nil && ready.Status != corev1.ConditionTrue {
+				r.markReady(&migration, ReasonReconciled, "migration credentials and scripts location are resolved")
+			}
+		} else {
+			if reason, err := r.checkDependencies(ctx, &migration); err != nil {
+				migration.Status.Phase = migrationsv1alpha1.MigrationBlocked
+				return r.reportError(ctx, &migration, err)
+			} else if reason != "" {
+				return r.block(ctx, &migration, reason)
+			}
+
+			// the first run of a followed branch pins the head it starts from
+			if pushed, err := r.consumePushedRevision(ctx, &migration); err != nil {
+				return ctrl.Result{}, err
+			} else if pushed != "" {
+				migration.Status.Revision = pushed
+			} else if git := migration.Spec.SQL.Git; git != nil && git.PollInterval != nil && migration.Status.Revision == "" {
+				head, err := resolveRemoteHead(ctx, r.Client, migration.ObjectMeta.Namespace, git)
+				if err != nil {
+					return r.reportError(ctx, &migration, err)
+				}
+				migration.Status.Revision = head
+			}
 		}
 
-		userPass, err := creds.GetUserPassword()
+		now := time.Now()
+		next, phase := planRollout(migration.Spec.Rollout, migration.Status.Targets)
+		next, windowDelay, err := r.applySchedule(&migration, next, runs, now)
+		if err != nil {
+			return r.reportError(ctx, &migration, err)
+		}
+		next, phase, err = r.gateRollout(ctx, &migration, next, phase)
 		if err != nil {
 			return ctrl.Result{}, err
 		}
+		// a target that cannot start keeps its error, the other targets start regardless
+		waitingForDB, failedTransiently := false, false
+		for _, i := range next {
+			waiting, err := r.startTarget(ctx, &migration, runs[i], &migration.Status.Targets[i], log)
+			if err != nil {
+				transient, ok := r.recordTargetError(&migration, &migration.Status.Targets[i], err)
+				if !ok {
+					return ctrl.Result{}, err
+				}
+				failedTransiently = failedTransiently || transient
+			}
+			waitingForDB = waitingForDB || waiting
+		}
+		migration.Status.Phase = phase
+		summarizeTargets(&migration.Status)
+		if migration.Status.NextWindow != nil && migration.Status.Phase == migrationsv1alpha1.MigrationRunning && !hasRunningTargets(&migration.Status) {
+			migration.Status.Phase = migrationsv1alpha1.MigrationWaitingForWindow
+		}
+		if delay := r.checkWindow(&migration, now); delay > 0 && (windowDelay == 0 || delay < windowDelay) {
+			windowDelay = delay
+		}
+		driftDelay := r.checkDrift(ctx, &migration, runs, now)
+		queued := migration.Status.Phase == migrationsv1alpha1.MigrationQueued
+
+		if !equality.Semantic.DeepEqual(original, &migration.Status) {
+			if err := r.Status().Update(ctx, &migration); err != nil {
+				return ctrl.Result{}, err
+			}
+			if event, ok := rolloutEvent(original, &migration.Status); ok {
+				r.notify(&migration, event)
+			}
+		}
+		if result, err := r.followTarget(ctx, &migration, targets); err != nil || result.Requeue {
+			return result, err
+		}
+		if result, err := r.rerun(ctx, &migration, targets); err != nil || result.Requeue {
+			return result, err
+		}
+		result, err := r.followBranch(ctx, &migration, targets)
+		if err != nil {
+			return result, err
+		}
+		if queued {
+			requeueWithin(&result, lockRetryDelay)
+		}
+		if waitingForDB {
+			requeueWithin(&result, dbPollInterval)
+		}
+		if failedTransiently {
+			requeueWithin(&result, transientRequeueDelay)
+		}
+		if backingUp {
+			requeueWithin(&result, backupPollInterval)
+		}
+		requeueWithin(&result, windowDelay)
+		requeueWithin(&result, driftDelay)
+		return result, nil
+
+	} else {
+		// TODO finalizer and job clean up
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// resolveRun looks up the driver, credentials and scripts location of a migration target
+func (r *MigrationReconciler) resolveRun(ctx context.Context, migration *migrationsv1alpha1.Migration, target *migrationTarget) (*flywayRun, error) {
+	sqlDriver, err := GetDriver(&target.db)
+	if err != nil {
+		return nil, err
+	}
+
+	// load db creds if provided through secret
+	if target.creds == nil {
+		return nil, newMigrationError(ReasonCredentialsMissing, "no db credentials source is set for database %s", target.name)
+	}
+	target.creds = withTokenSecret(target.creds, target.job)
+
+	userPass, err := target.creds.GetUserPassword(ctx, r.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer, err := getDialer(ctx, r.Client, &target.db, migration.ObjectMeta.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	if GetScriptsLocation(&migration.Spec.SQL, migration.Status.Revision) == nil {
+		return nil, newMigrationError(ReasonScriptsLocationInvalid, "unable to detect sql scripts location")
+	}
+	if git := migration.Spec.SQL.Git; git != nil && git.StrictHostKeyChecking {
+		if err := checkKnownHosts(ctx, r.Client, migration.ObjectMeta.Namespace, git); err != nil {
+			return nil, err
+		}
+	}
+
+	return &flywayRun{target: target, driver: sqlDriver, userPass: userPass, dialer: dialer}, nil
+}
+
+// startTarget locks the target and starts its backup or its run once its database is reachable,
+// it returns whether the target waits for its database
+func (r *MigrationReconciler) startTarget(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus, log logr.Logger) (bool, error) {
+	if queued, err := r.leaseTarget(ctx, migration, run, status); err != nil || queued {
+		return false, err
+	}
+
+	if reachable, err := r.probeDB(ctx, migration, run, status, log); err != nil || !reachable {
+		return !reachable, err
+	}
+	if status.Phase != migrationsv1alpha1.MigrationQueued {
+		r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonDatabaseReachable, "database %s is reachable", dbAddress(&run.target.db))
+	}
+
+	queued, err := r.advisoryLockTarget(migration, run, status)
+	if err != nil || queued {
+		return false, err
+	}
+	status.Restore = nil
+
+	if migration.Spec.Backup != nil {
+		err = r.startBackup(ctx, migration, run, status)
+	} else {
+		err = r.runTarget(ctx, migration, run, status)
+	}
+	if err != nil {
+		// the locks are released so that the next attempt or other runs take them again
+		if unlockErr := r.unlockTarget(ctx, migration, run.target); unlockErr != nil {
+			return false, unlockErr
+		}
+	}
+	return false, err
+}
+
+// runTarget reverts or migrates a locked target
+func (r *MigrationReconciler) runTarget(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus) error {
+	// the schema history is read once the database is locked so that no other run moves it meanwhile
+	rollback, err := r.planRollback(ctx, migration, run)
+	if err != nil {
+		return err
+	}
+	var steps []migrationsv1alpha1.RollbackStep
+	command := migrationsv1alpha1.RunMigrate
+	if rollback != nil {
+		steps = rollback.steps
+		for i := range steps {
+			steps[i].Phase = migrationsv1alpha1.MigrationRunning
+		}
+		command = migrationsv1alpha1.RunCompensate
+		if steps[0].Method == migrationsv1alpha1.RollbackUndo {
+			command = migrationsv1alpha1.RunUndo
+			run.undo = true
+		}
+	}
 
-		wait := waitForDB(&migration.Spec.DB, log, userPass)
-		if <-wait == false {
-			return ctrl.Result{}, errors.New("timeout reached after trying to connect to db")
+	if err := r.issueToken(ctx, migration, run); err != nil {
+		return err
+	}
+	var job *batchv1.Job
+	if command == migrationsv1alpha1.RunCompensate {
+		if job, err = buildCompensateJob(migration, run, steps); err != nil {
+			return err
 		}
-		sqlDriver := Drivers[migration.Spec.DB.Driver]
-		job := batchv1.Job{
-			ObjectMeta: metav1.ObjectMeta{
-				Name:      fmt.Sprintf("flyway-%s", req.NamespacedName.Name),
-				Namespace: req.NamespacedName.Namespace,
+	} else {
+		job = buildJob(migration, run)
+	}
+	job.ObjectMeta.Name = runName(migration, run.target, status)
+	if !run.deadline.IsZero() {
+		seconds := int64(time.Until(run.deadline).Seconds())
+		if seconds < 1 {
+			seconds = 1
+		}
+		job.Spec.ActiveDeadlineSeconds = &seconds
+	}
+	if err := ctrl.SetControllerReference(migration, job, r.Scheme); err != nil {
+		return err
+	}
+	record, err := r.createRun(ctx, migration, run, status, command, job.ObjectMeta.Name)
+	if err != nil {
+		return err
+	}
+	// a job left by an attempt whose status was not saved is taken over
+	if err := createJob(ctx, r.Client, job); err != nil && !apierrors.IsAlreadyExists(err) {
+		r.discardRun(ctx, record)
+		return err
+	}
+	startRun(status, record)
+
+	message := fmt.Sprintf("flyway job %s created", job.ObjectMeta.Name)
+	r.Recorder.Event(migration, corev1.EventTypeNormal, ReasonJobCreated, message)
+	r.markReady(migration, ReasonJobCreated, message)
+	status.Phase = migrationsv1alpha1.MigrationRunning
+	status.Job = job.ObjectMeta.Name
+	status.QueuePosition = 0
+	status.Message = ""
+	status.Target = migration.Spec.TargetVersion
+	status.RollbackSteps = steps
+	return nil
+}
+
+// buildJob renders the flyway job running the migration on a target
+func buildJob(migration *migrationsv1alpha1.Migration, run *flywayRun) *batchv1.Job {
+	command := "migrate"
+	if run.undo {
+		command = "undo"
+	}
+	job := batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      run.target.job,
+			Namespace: migration.ObjectMeta.Namespace,
+			Labels: map[string]string{
+				migrationsv1alpha1.MigrationNameLabel: migration.ObjectMeta.Name,
+				migrationsv1alpha1.TargetNameLabel:    run.target.name,
 			},
-			Spec: batchv1.JobSpec{
-				Template: corev1.PodTemplateSpec{
-					Spec: corev1.PodSpec{
-						RestartPolicy: "Never",
-						Containers: []corev1.Container{
-							corev1.Container{
-								Name:            "flyway-migration",
-								Image:           "flyway/flyway",
-								ImagePullPolicy: corev1.PullIfNotPresent,
-								Env: []corev1.EnvVar{
-									corev1.EnvVar{Name: "FLYWAY_DRIVER", Value: migration.Spec.DB.Driver},
-									corev1.EnvVar{Name: "FLYWAY_URL", Value: sqlDriver.ConnectionURL(&migration.Spec.DB)},
-								},
-								Args: []string{"migrate"},
-								VolumeMounts: []corev1.VolumeMount{
-									corev1.VolumeMount{Name: SQLVolumeName, MountPath: "/flyway/sql"},
-								},
+		},
+		Spec: batchv1.JobSpec{
+			Template: corev1.PodTemplateSpec{
+				Spec: corev1.PodSpec{
+					RestartPolicy: "Never",
+					Containers: []corev1.Container{
+						corev1.Container{
+							Name:            FlywayContainerName,
+							Image:           migration.Spec.Image,
+							ImagePullPolicy: corev1.PullIfNotPresent,
+							Env: []corev1.EnvVar{
+								corev1.EnvVar{Name: "FLYWAY_DRIVER", Value: run.target.db.Driver},
+								corev1.EnvVar{Name: "FLYWAY_URL", Value: run.driver.ConnectionURL(&run.target.db)},
+								corev1.EnvVar{Name: "FLYWAY_TABLE", Value: migration.Spec.History.Table},
+							},
+							// info reports the schema version and pending scripts once migrated
+							Args: []string{command, "info"},
+							// keeps the tail of flyway output as termination message to report errors
+							TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+							VolumeMounts: []corev1.VolumeMount{
+								corev1.VolumeMount{Name: SQLVolumeName, MountPath: sqlMountPath},
 							},
 						},
 					},
 				},
 			},
-		}
+		},
+	}
+
+	if target := migration.Spec.TargetVersion; target != "" {
+		container := &job.Spec.Template.Spec.Containers[0]
+		container.Env = append(container.Env, corev1.EnvVar{Name: "FLYWAY_TARGET", Value: target})
+	}
+	if key := migration.Spec.Rollback.LicenseKey; key != nil {
+		container := &job.Spec.Template.Spec.Containers[0]
+		container.Env = append(container.Env, corev1.EnvVar{Name: "FLYWAY_LICENSE_KEY", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: key}})
+	}
+	// flyway keeps its history table in its default schema, which leaves the schemas it manages untouched
+	if schema := migration.Spec.History.Schema; schema != "" {
+		container := &job.Spec.Template.Spec.Containers[0]
+		container.Env = append(container.Env, corev1.EnvVar{Name: "FLYWAY_DEFAULT_SCHEMA", Value: schema})
+	}
+
+	// mutate template according to creds specs
+	run.target.creds.MutateTemplate(&job.Spec.Template)
+	// mutate template according to sql scripts location
+	GetScriptsLocation(&migration.Spec.SQL, migration.Status.Revision).MutateTemplate(&job.Spec.Template)
+	// sidecars come last, the scripts location replaces the init containers and volumes
+	if connectivity := GetConnectivity(&run.target.db, migration.ObjectMeta.Namespace); connectivity != nil {
+		connectivity.MutateTemplate(&job.Spec.Template)
+	}
+
+	return &job
+}
+
+// markNotReady surfaces a migration error as a warning event and on the ready condition
+func (r *MigrationReconciler) markNotReady(migration *migrationsv1alpha1.Migration, migrationErr *MigrationError) {
+	r.Recorder.Event(migration, corev1.EventTypeWarning, migrationErr.Reason, migrationErr.Message)
+	migration.Status.SetCondition(migrationsv1alpha1.MigrationCondition{
+		Type:    migrationsv1alpha1.ConditionReady,
+		Status:  corev1.ConditionFalse,
+		Reason:  migrationErr.Reason,
+		Message: migrationErr.Message,
+	})
+}
+
+// recordTargetError keeps the migration error of a target which could not start on its status, the target stays in
+// its phase and starts again on the next pass. It returns whether the error is transient, and false for other errors.
+func (r *MigrationReconciler) recordTargetError(migration *migrationsv1alpha1.Migration, status *migrationsv1alpha1.TargetStatus, err error) (bool, bool) {
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) {
+		return false, false
+	}
+	status.Message = migrationErr.Message
+	r.markNotReady(migration, &MigrationError{
+		Reason:    migrationErr.Reason,
+		Message:   fmt.Sprintf("target %s: %s", status.Name, migrationErr.Message),
+		Transient: migrationErr.Transient,
+	})
+	return migrationErr.Transient, true
+}
+
+func (r *MigrationReconciler) markReady(migration *migrationsv1alpha1.Migration, reason, message string) {
+	migration.Status.SetCondition(migrationsv1alpha1.MigrationCondition{
+		Type:    migrationsv1alpha1.ConditionReady,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// reportError surfaces migration errors on the status and as a warning event, other errors are returned to the controller
+func (r *MigrationReconciler) reportError(ctx context.Context, migration *migrationsv1alpha1.Migration, err error) (ctrl.Result, error) {
+	var migrationErr *MigrationError
+	if !errors.As(err, &migrationErr) {
+		return ctrl.Result{}, err
+	}
+
+	event, notify := errorEvent(migration.Status.GetCondition(migrationsv1alpha1.ConditionReady).DeepCopy(), migrationErr)
+	r.markNotReady(migration, migrationErr)
+	if migration.Status.Phase == "" {
+		migration.Status.Phase = migrationsv1alpha1.MigrationPending
+	}
+	if err := r.Status().Update(ctx, migration); err != nil {
+		return ctrl.Result{}, err
+	}
+	if notify {
+		r.notify(migration, event)
+	}
+
+	if migrationErr.Transient {
+		return ctrl.Result{RequeueAfter: transientRequeueDelay}, nil
+	}
+	return ctrl.Result{}, nil
+}
 
-		// mutate template according to creds specs
-		creds.MutateTemplate(&job.Spec.Template)
-		location := GetScriptsLocation(&migration.Spec.SQL)
-		if location == nil {
-			return ctrl.Result{}, errors.New("unable to detect sql scripts location")
+// followBranch starts a new rollout once the current one is over if the followed branch moved,
+// either pushed through the git webhook or polled again after the configured interval
+func (r *MigrationReconciler) followBranch(ctx context.Context, migration *migrationsv1alpha1.Migration, targets []*migrationTarget) (ctrl.Result, error) {
+	git := migration.Spec.SQL.Git
+	if git == nil {
+		return ctrl.Result{}, nil
+	}
+	_, pushed := migration.ObjectMeta.Annotations[migrationsv1alpha1.GitRevisionAnnotation]
+	if !pushed && git.PollInterval == nil {
+		return ctrl.Result{}, nil
+	}
+
+	var poll ctrl.Result
+	if git.PollInterval != nil {
+		poll.RequeueAfter = git.PollInterval.Duration
+	}
+	if !runOver(migration.Status.Phase) {
+		return poll, nil
+	}
+
+	head, err := r.consumePushedRevision(ctx, migration)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if head == "" {
+		head, err = resolveRemoteHead(ctx, r.Client, migration.ObjectMeta.Namespace, git)
+		if err != nil {
+			r.Recorder.Event(migration, corev1.EventTypeWarning, ReasonRevisionUnresolved, err.Error())
+			return poll, nil
 		}
-		// mutate template according to sql scripts location
-		location.MutateTemplate(&job.Spec.Template)
+	}
+	if head == migration.Status.Revision {
+		return poll, nil
+	}
 
-		if err := r.Client.Create(ctx, &job); err != nil {
+	r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonRevisionChanged, "branch %s moved from %s to %s, starting a new run", git.Branch, migration.Status.Revision, head)
+	for i := range migration.Status.Targets {
+		if err := r.deleteRunJob(ctx, migration, &migration.Status.Targets[i]); err != nil {
 			return ctrl.Result{}, err
 		}
+		migration.Status.Targets[i].Phase = migrationsv1alpha1.MigrationPending
+		migration.Status.Targets[i].Message = ""
+	}
+	migration.Status.Revision = head
+	triggerRollout(migration, migrationsv1alpha1.TriggerRevisionChanged, "")
+	migration.Status.Phase = migrationsv1alpha1.MigrationPending
+	if err := r.Status().Update(ctx, migration); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
 
-	} else {
-		// TODO finalizer and job clean up
+// consumePushedRevision returns the commit requested by the git webhook and clears the request
+func (r *MigrationReconciler) consumePushedRevision(ctx context.Context, migration *migrationsv1alpha1.Migration) (string, error) {
+	revision, ok := migration.ObjectMeta.Annotations[migrationsv1alpha1.GitRevisionAnnotation]
+	if !ok {
+		return "", nil
 	}
 
-	return ctrl.Result{}, nil
+	// a copy is patched, the patch response would replace the status and defaults of this pass
+	stored := migration.DeepCopy()
+	patch := client.MergeFrom(migration.DeepCopy())
+	delete(stored.ObjectMeta.Annotations, migrationsv1alpha1.GitRevisionAnnotation)
+	if err := r.Patch(ctx, stored, patch); err != nil {
+		return "", err
+	}
+	delete(migration.ObjectMeta.Annotations, migrationsv1alpha1.GitRevisionAnnotation)
+	migration.ObjectMeta.ResourceVersion = stored.ObjectMeta.ResourceVersion
+	return revision, nil
 }
 
-func waitForDB(spec *migrationsv1alpha1.DBSpec, log logr.Logger, creds *UserPassword) chan bool {
-	wait := make(chan bool)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+// updateFromJob moves the target phase along its flyway job and records the outcome of the run
+func (r *MigrationReconciler) updateFromJob(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus, job *batchv1.Job) error {
+	status.Job = job.ObjectMeta.Name
+	phase := jobPhase(job)
+	// a restored target already handled the failure of its job
+	if status.Phase == phase || status.Restore != nil {
+		return nil
+	}
 
-	go func() {
-		select {
-		case <-ctx.Done():
-			log.Info("timeout (after 10 mns) while waiting for db access !")
-			wait <- false
+	target := run.target
+	labels := metricLabels(migration, target)
+	previousVersion := status.CurrentVersion
+	// compensation jobs print the output of the sql client, their outcome is read from the schema history
+	compensating := len(status.RollbackSteps) > 0 && status.RollbackSteps[0].Method == migrationsv1alpha1.RollbackCompensate
+	var report FlywayReport
+	switch phase {
+	case migrationsv1alpha1.MigrationSucceeded:
+		r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonMigrationSucceeded, "flyway job %s completed", job.ObjectMeta.Name)
+		if !compensating {
+			var err error
+			if report, err = r.readSchemaState(ctx, status, job); err != nil {
+				return err
+			}
+		}
+	case migrationsv1alpha1.MigrationFailed:
+		message, err := r.flywayError(ctx, job)
+		if err != nil {
+			return err
+		}
+		status.Message = message
+		r.Recorder.Event(migration, corev1.EventTypeWarning, ReasonMigrationFailed, message)
+	}
+	if phase == migrationsv1alpha1.MigrationSucceeded || phase == migrationsv1alpha1.MigrationFailed {
+		r.readHistory(migration, run, status)
+		recordSchemaState(labels, status, previousVersion)
+		finishRollback(status, metav1.Now())
+		if compensating {
+			for _, step := range status.RollbackSteps {
+				if step.Phase == migrationsv1alpha1.MigrationSucceeded {
+					report.Applied++
+				}
+			}
 		}
-	}()
+		migrationRuns.With(withLabel(labels, "result", string(phase))).Inc()
+		if duration, ok := jobDuration(job); ok {
+			migrationRunDuration.With(labels).Observe(duration.Seconds())
+		}
+		if err := r.finishRun(ctx, migration, target, status, phase, report); err != nil {
+			return err
+		}
+		// the database stays locked until it is restored
+		if phase == migrationsv1alpha1.MigrationFailed {
+			restore, reason := restorable(migration, status)
+			if restore {
+				return r.startRestore(ctx, migration, run, status)
+			}
+			if reason != "" {
+				status.Message = fmt.Sprintf("%s\n%s was not restored: %s", status.Message, target.name, reason)
+				r.Recorder.Eventf(migration, corev1.EventTypeWarning, ReasonRestoreFailed, "%s was not restored: %s", target.name, reason)
+			}
+		}
+		if err := r.unlockTarget(ctx, migration, target); err != nil {
+			return err
+		}
+	}
 
-	go func() {
-		defer cancel()
-		sqlDriver := Drivers[spec.Driver]
-		for {
-			_, err := sqlDriver.CheckDBAvailability(spec, creds)
-			if err != nil {
-				log.Info(err.Error())
-				time.Sleep(10 * time.Second)
-				log.Info("waiting for database availability...")
-			} else {
-				wait <- true
-				break
+	status.Phase = phase
+	return nil
+}
+
+// readSchemaState loads the schema version and pending scripts from the flyway info output of the job,
+// it returns the whole report of the output
+func (r *MigrationReconciler) readSchemaState(ctx context.Context, status *migrationsv1alpha1.TargetStatus, job *batchv1.Job) (FlywayReport, error) {
+	pods, err := r.jobPods(ctx, job)
+	if err != nil {
+		return FlywayReport{}, err
+	}
+
+	for _, pod := range pods {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		output, err := r.Logs.GetLogs(pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, FlywayContainerName)
+		if err != nil {
+			return FlywayReport{}, err
+		}
+		report := parseFlywayOutput(output)
+		status.CurrentVersion = report.SchemaVersion
+		status.PendingMigrations = report.Pending
+		return report, nil
+	}
+	return FlywayReport{}, nil
+}
+
+// readHistory reports the applied and failed scripts from the schema history table of the target,
+// an unreachable table keeps the state read from the flyway output
+func (r *MigrationReconciler) readHistory(migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus) {
+	entries, err := run.driver.SchemaHistory(&run.target.db, run.userPass, run.dialer, &migration.Spec.History)
+	if err != nil {
+		r.Recorder.Eventf(migration, corev1.EventTypeWarning, ReasonHistoryUnavailable, "unable to read the schema history of %s: %s", run.target.name, err)
+		return
+	}
+	if len(entries) > 0 {
+		applyHistory(status, entries, int(migration.Spec.History.Limit))
+	}
+}
+
+// flywayError extracts the error reported by flyway from the termination message of the job pods
+func (r *MigrationReconciler) flywayError(ctx context.Context, job *batchv1.Job) (string, error) {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Reason == "DeadlineExceeded" {
+			return fmt.Sprintf("flyway job %s stopped when its maintenance window closed", job.ObjectMeta.Name), nil
+		}
+	}
+
+	pods, err := r.jobPods(ctx, job)
+	if err != nil {
+		return "", err
+	}
+
+	for _, pod := range pods {
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name != FlywayContainerName || status.State.Terminated == nil || status.State.Terminated.ExitCode == 0 {
+				continue
+			}
+			if message := parseFlywayError(status.State.Terminated.Message); message != "" {
+				return message, nil
 			}
 		}
-	}()
+	}
+	return fmt.Sprintf("flyway job %s failed", job.ObjectMeta.Name), nil
+}
 
-	return wait
+func (r *MigrationReconciler) jobPods(ctx context.Context, job *batchv1.Job) ([]corev1.Pod, error) {
+	var pods corev1.PodList
+	if err := r.List(ctx, &pods, client.InNamespace(job.ObjectMeta.Namespace), client.MatchingLabels{"job-name": job.ObjectMeta.Name}); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// jobDuration is the time between the start of the job and its completion or failure
+func jobDuration(job *batchv1.Job) (time.Duration, bool) {
+	if job.Status.StartTime == nil {
+		return 0, false
+	}
+	if job.Status.CompletionTime != nil {
+		return job.Status.CompletionTime.Sub(job.Status.StartTime.Time), true
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return condition.LastTransitionTime.Sub(job.Status.StartTime.Time), true
+		}
+	}
+	return 0, false
+}
+
+// parseFlywayError keeps the output starting at the first error flyway printed
+func parseFlywayError(output string) string {
+	if i := strings.Index(output, "ERROR:"); i >= 0 {
+		output = output[i:]
+	}
+	return strings.TrimSpace(output)
+}
+
+func hasRunningTargets(status *migrationsv1alpha1.MigrationStatus) bool {
+	for _, target := range status.Targets {
+		if target.Phase == migrationsv1alpha1.MigrationRunning {
+			return true
+		}
+	}
+	return false
+}
+
+// requeueWithin shortens the requeue delay of the result to the given delay if set
+func requeueWithin(result *ctrl.Result, delay time.Duration) {
+	if delay > 0 && (result.RequeueAfter == 0 || delay < result.RequeueAfter) {
+		result.RequeueAfter = delay
+	}
+}
+
+// deleteRunJob deletes the flyway job of the last run of a target so that the next run starts afresh
+func (r *MigrationReconciler) deleteRunJob(ctx context.Context, migration *migrationsv1alpha1.Migration, status *migrationsv1alpha1.TargetStatus) error {
+	if status.Job == "" {
+		return nil
+	}
+	job := batchv1.Job{ObjectMeta: metav1.ObjectMeta{Namespace: migration.ObjectMeta.Namespace, Name: status.Job}}
+	if err := r.Delete(ctx, &job, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+		return err
+	}
+	status.Job = ""
+	return nil
+}
+
+// jobName names the job of a migration to a single database, the names too long to derive others from are shortened
+// and end with a digest of the migration UID
+func jobName(migration *migrationsv1alpha1.Migration) string {
+	name := fmt.Sprintf("flyway-%s", migration.ObjectMeta.Name)
+	if len(name) <= jobNameLength {
+		return name
+	}
+	return shortenName(name, jobNameLength-runUIDLength-1) + "-" + nameDigest(string(migration.ObjectMeta.UID))
+}
+
+// jobPhase maps the state of a flyway job to the phase of its migration
+func jobPhase(job *batchv1.Job) migrationsv1alpha1.MigrationPhase {
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			return migrationsv1alpha1.MigrationSucceeded
+		case batchv1.JobFailed:
+			return migrationsv1alpha1.MigrationFailed
+		}
+	}
+	return migrationsv1alpha1.MigrationRunning
+}
+
+func dbAddress(spec *migrationsv1alpha1.DBSpec) string {
+	return fmt.Sprintf("%s:%d/%s", spec.Host, spec.Port, spec.DBName)
+}
+
+// probeDB checks once whether the database of a target accepts connections. The target waits for its database across
+// reconciliations, it is reported unreachable once it waited for longer than dbWaitTimeout.
+func (r *MigrationReconciler) probeDB(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus, log logr.Logger) (bool, error) {
+	spec := &run.target.db
+	labels := metricLabels(migration, run.target)
+	// tokens expire while waiting, a new one is generated once they are about to
+	if expires := run.userPass.Expires; !expires.IsZero() && time.Until(expires) < tokenRefreshMargin {
+		userPass, err := run.target.creds.GetUserPassword(ctx, r.Client)
+		if err != nil {
+			return false, err
+		}
+		run.userPass = userPass
+	}
+
+	probeStart := time.Now()
+	_, err := run.driver.CheckDBAvailability(spec, run.userPass, run.dialer)
+	dbProbeDuration.With(labels).Observe(time.Since(probeStart).Seconds())
+	if err == nil {
+		waitStart := probeStart
+		if status.WaitingSince != nil {
+			waitStart = status.WaitingSince.Time
+		}
+		dbWaitDuration.With(labels).Observe(time.Since(waitStart).Seconds())
+		status.WaitingSince = nil
+		return true, nil
+	}
+
+	log.Info(err.Error())
+	if status.WaitingSince == nil {
+		since := metav1.NewTime(probeStart)
+		status.WaitingSince = &since
+		r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonWaitingForDatabase, "waiting for database %s: %v", dbAddress(spec), err)
+	}
+	status.Message = fmt.Sprintf("waiting for database %s: %v", dbAddress(spec), err)
+	if waited := time.Since(status.WaitingSince.Time); waited > dbWaitTimeout {
+		return false, newTransientError(ReasonDatabaseUnreachable, "database %s unreachable for %s: %v", dbAddress(spec), waited.Round(time.Second), err)
+	}
+	return false, nil
+}
+
+// migrationsForSecret enqueues the migrations reading their credentials from the secret
+func (r *MigrationReconciler) migrationsForSecret(obj handler.MapObject) []reconcile.Request {
+	var migrations migrationsv1alpha1.MigrationList
+	if err := r.List(context.Background(), &migrations,
+		client.InNamespace(obj.Meta.GetNamespace()),
+		client.MatchingFields{secretNameField: obj.Meta.GetName()}); err != nil {
+		r.Log.Error(err, "unable to list migrations of secret", "secret", obj.Meta.GetName())
+		return nil
+	}
+	return reconcileRequests(migrations.Items)
+}
+
+// migrationsForDatabase enqueues the migrations of the namespace referencing the database
+// and the migrations selecting it as a target
+func (r *MigrationReconciler) migrationsForDatabase(obj handler.MapObject) []reconcile.Request {
+	ref := migrationsv1alpha1.DatabaseReference{Kind: migrationsv1alpha1.DatabaseKind, Name: obj.Meta.GetName()}
+	var migrations migrationsv1alpha1.MigrationList
+	if err := r.List(context.Background(), &migrations,
+		client.InNamespace(obj.Meta.GetNamespace()),
+		client.MatchingFields{databaseRefField: databaseRefKey(&ref)}); err != nil {
+		r.Log.Error(err, "unable to list migrations of database", "database", obj.Meta.GetName())
+		return nil
+	}
+
+	var all migrationsv1alpha1.MigrationList
+	if err := r.List(context.Background(), &all, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "unable to list migrations selecting database", "database", obj.Meta.GetName())
+		return nil
+	}
+	for _, migration := range all.Items {
+		if migration.Spec.TargetSelector == nil {
+			continue
+		}
+		selector, err := metav1.LabelSelectorAsSelector(migration.Spec.TargetSelector)
+		if err == nil && selector.Matches(labels.Set(obj.Meta.GetLabels())) {
+			migrations.Items = append(migrations.Items, migration)
+		}
+	}
+
+	return reconcileRequests(migrations.Items)
+}
+
+// migrationsForClusterDatabase enqueues the migrations of every namespace referencing the cluster database
+func (r *MigrationReconciler) migrationsForClusterDatabase(obj handler.MapObject) []reconcile.Request {
+	ref := migrationsv1alpha1.DatabaseReference{Kind: migrationsv1alpha1.ClusterDatabaseKind, Name: obj.Meta.GetName()}
+	var migrations migrationsv1alpha1.MigrationList
+	if err := r.List(context.Background(), &migrations, client.MatchingFields{databaseRefField: databaseRefKey(&ref)}); err != nil {
+		r.Log.Error(err, "unable to list migrations of cluster database", "database", obj.Meta.GetName())
+		return nil
+	}
+	return reconcileRequests(migrations.Items)
+}
+
+func reconcileRequests(migrations []migrationsv1alpha1.Migration) []reconcile.Request {
+	requests := make([]reconcile.Request, 0, len(migrations))
+	for _, migration := range migrations {
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{
+			Namespace: migration.ObjectMeta.Namespace,
+			Name:      migration.ObjectMeta.Name,
+		}})
+	}
+	return requests
 }
 
 func (r *MigrationReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(&migrationsv1alpha1.Migration{}, secretNameField, func(obj runtime.Object) []string {
+		migration := obj.(*migrationsv1alpha1.Migration)
+		var secrets []string
+		if migration.Spec.DB.Secret != nil {
+			secrets = append(secrets, migration.Spec.DB.Secret.Name)
+		}
+		for _, target := range migration.Spec.Targets {
+			if target.Secret != nil {
+				secrets = append(secrets, target.Secret.Name)
+			}
+		}
+		return secrets
+	}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(&migrationsv1alpha1.Migration{}, databaseRefField, func(obj runtime.Object) []string {
+		migration := obj.(*migrationsv1alpha1.Migration)
+		var databases []string
+		if migration.Spec.DatabaseRef != nil {
+			databases = append(databases, databaseRefKey(migration.Spec.DatabaseRef))
+		}
+		for _, target := range migration.Spec.Targets {
+			if target.DatabaseRef != nil {
+				databases = append(databases, databaseRefKey(target.DatabaseRef))
+			}
+		}
+		return databases
+	}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(&migrationsv1alpha1.Migration{}, dependsOnField, func(obj runtime.Object) []string {
+		migration := obj.(*migrationsv1alpha1.Migration)
+		dependencies := make([]string, 0, len(migration.Spec.DependsOn))
+		for _, ref := range migration.Spec.DependsOn {
+			dependencies = append(dependencies, dependencyKey(migration, ref).String())
+		}
+		return dependencies
+	}); err != nil {
+		return err
+	}
+
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&migrationsv1alpha1.Migration{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.migrationsForSecret),
+		}).
+		Watches(&source.Kind{Type: &migrationsv1alpha1.Migration{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.migrationsDependingOn),
+		}).
+		Watches(&source.Kind{Type: &migrationsv1alpha1.Database{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.migrationsForDatabase),
+		}).
+		Watches(&source.Kind{Type: &migrationsv1alpha1.ClusterDatabase{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.migrationsForClusterDatabase),
+		}).
 		Complete(r)
 }
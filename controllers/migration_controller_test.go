@@ -0,0 +1,162 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+// unreachableDriver is a postgres driver whose databases refuse connections
+type unreachableDriver struct {
+	PostgresDriver
+}
+
+func (unreachableDriver) CheckDBAvailability(*migrationsv1alpha1.DBSpec, *UserPassword, Dialer) (bool, error) {
+	return false, errors.New("connection refused")
+}
+
+// reachableDriver is a postgres driver whose databases accept connections
+type reachableDriver struct {
+	PostgresDriver
+}
+
+func (reachableDriver) CheckDBAvailability(*migrationsv1alpha1.DBSpec, *UserPassword, Dialer) (bool, error) {
+	return true, nil
+}
+
+var _ = Describe("Starting targets", func() {
+	ctx := context.Background()
+
+	var (
+		r         *MigrationReconciler
+		recorder  *record.FakeRecorder
+		migration *migrationsv1alpha1.Migration
+		run       *flywayRun
+	)
+
+	BeforeEach(func() {
+		migration = &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Status: migrationsv1alpha1.MigrationStatus{
+				Targets: []migrationsv1alpha1.TargetStatus{{Name: "eu", Phase: migrationsv1alpha1.MigrationPending}},
+			},
+		}
+		run = &flywayRun{
+			target:   &migrationTarget{name: "eu", job: "flyway-orders-eu", db: migrationsv1alpha1.DBSpec{Host: "eu.db", Port: 5432, DBName: "orders"}},
+			driver:   unreachableDriver{},
+			userPass: &UserPassword{},
+		}
+		recorder = record.NewFakeRecorder(10)
+		r = &MigrationReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme), Log: ctrl.Log, Recorder: recorder}
+	})
+
+	It("waits for an unreachable database without blocking", func() {
+		status := &migration.Status.Targets[0]
+		waiting, err := r.startTarget(ctx, migration, run, status, ctrl.Log)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(waiting).To(BeTrue())
+		Expect(status.Phase).To(Equal(migrationsv1alpha1.MigrationPending))
+		Expect(status.WaitingSince).NotTo(BeNil())
+		Expect(status.Message).To(Equal("waiting for database eu.db:5432/orders: connection refused"))
+		Expect(recorder.Events).To(Receive(ContainSubstring(ReasonWaitingForDatabase)))
+
+		// the next pass keeps waiting without telling again
+		waiting, err = r.startTarget(ctx, migration, run, status, ctrl.Log)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(waiting).To(BeTrue())
+		Expect(recorder.Events).NotTo(Receive())
+	})
+
+	It("reports the database unreachable once it waited for too long", func() {
+		status := &migration.Status.Targets[0]
+		since := metav1.NewTime(time.Now().Add(-dbWaitTimeout - time.Minute))
+		status.WaitingSince = &since
+
+		reachable, err := r.probeDB(ctx, migration, run, status, ctrl.Log)
+		Expect(reachable).To(BeFalse())
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonDatabaseUnreachable))
+		Expect(err.(*MigrationError).Transient).To(BeTrue())
+	})
+
+	It("stops waiting once the database is reachable", func() {
+		status := &migration.Status.Targets[0]
+		since := metav1.NewTime(time.Now().Add(-time.Minute))
+		status.WaitingSince = &since
+		run.driver = reachableDriver{}
+
+		reachable, err := r.probeDB(ctx, migration, run, status, ctrl.Log)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(reachable).To(BeTrue())
+		Expect(status.WaitingSince).To(BeNil())
+	})
+
+	It("keeps the error of a target which cannot start on its status", func() {
+		status := &migration.Status.Targets[0]
+		transient, ok := r.recordTargetError(migration, status, newTransientError(ReasonSecretNotFound, "secret eu not found"))
+		Expect(ok).To(BeTrue())
+		Expect(transient).To(BeTrue())
+		Expect(status.Phase).To(Equal(migrationsv1alpha1.MigrationPending))
+		Expect(status.Message).To(Equal("secret eu not found"))
+		ready := migration.Status.GetCondition(migrationsv1alpha1.ConditionReady)
+		Expect(ready.Status).To(Equal(corev1.ConditionFalse))
+		Expect(ready.Reason).To(Equal(ReasonSecretNotFound))
+		Expect(ready.Message).To(Equal("target eu: secret eu not found"))
+
+		_, ok = r.recordTargetError(migration, status, errors.New("conflict"))
+		Expect(ok).To(BeFalse())
+	})
+})
+
+var _ = Describe("buildJob", func() {
+	It("keeps the history table in the default schema of flyway", func() {
+		spec := migrationsv1alpha1.DBSpec{
+			Driver: migrationsv1alpha1.PostgresDriver, Host: "orders", Port: 5432, DBName: "orders",
+			Secret: &migrationsv1alpha1.SecretSpec{Name: "orders"},
+		}
+		migration := &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				SQL:     migrationsv1alpha1.SQLSpec{Git: &migrationsv1alpha1.GitMigrationSpec{CheckoutURL: "git@example.com:shop/orders.git", Branch: "main"}},
+				History: migrationsv1alpha1.HistorySpec{Schema: "audit"},
+			},
+		}
+		run := &flywayRun{
+			target: &migrationTarget{name: "orders", job: "orders", db: spec, creds: GetCredentials(&spec, "shop")},
+			driver: PostgresDriver{},
+		}
+
+		env := buildJob(migration, run).Spec.Template.Spec.Containers[0].Env
+		Expect(env).To(ContainElement(corev1.EnvVar{Name: "FLYWAY_DEFAULT_SCHEMA", Value: "audit"}))
+		for _, variable := range env {
+			Expect(variable.Name).NotTo(Equal("FLYWAY_SCHEMAS"))
+		}
+	})
+})
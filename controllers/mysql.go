@@ -0,0 +1,193 @@
+package controllers
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+)
+
+type (
+	// MySQLDriver implementation. MySQL commits DDL statements right away, the failed runs are restored from backups.
+	MySQLDriver struct{}
+
+	// mysqlConnector opens the connections of a pool through a dialer
+	mysqlConnector struct {
+		connector driver.Connector
+		dialer    Dialer
+	}
+
+	// mysqlDialerKey carries the dialer of a connection to the dial function of the mysql driver
+	mysqlDialerKey struct{}
+)
+
+// mysqlDialNet is the network of the connections opened through a dialer
+const mysqlDialNet = "flyway-dialer"
+
+func init() {
+	mysql.RegisterDialContext(mysqlDialNet, func(ctx context.Context, addr string) (net.Conn, error) {
+		return ctx.Value(mysqlDialerKey{}).(Dialer).Dial("tcp", addr)
+	})
+}
+
+// connect opens the database through the dialer when it is set
+func (d MySQLDriver) connect(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer) (*sqlx.DB, error) {
+	config := d.config(spec, creds)
+	if dialer != nil {
+		config.Net = mysqlDialNet
+	}
+	connector, err := mysql.NewConnector(config)
+	if err != nil {
+		return nil, err
+	}
+	if dialer != nil {
+		connector = mysqlConnector{connector: connector, dialer: dialer}
+	}
+	db := sqlx.NewDb(sql.OpenDB(connector), "mysql")
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+func (d MySQLDriver) config(spec *migrationsv1alpha1.DBSpec, creds *UserPassword) *mysql.Config {
+	config := mysql.NewConfig()
+	config.User = creds.User
+	config.Passwd = creds.Password
+	config.Net = "tcp"
+	config.Addr = net.JoinHostPort(spec.Host, strconv.Itoa(int(spec.Port)))
+	config.DBName = spec.DBName
+	config.ParseTime = true
+	// RDS only accepts tokens over SSL, sent as clear text passwords to a server verified with the CA bundle
+	if spec.RDSIAM != nil {
+		config.TLSConfig = rdsTLSConfig
+		config.AllowCleartextPasswords = true
+	}
+	return config
+}
+
+func (d MySQLDriver) CheckDBAvailability(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer) (bool, error) {
+	db, err := d.connect(spec, creds, dialer)
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	return true, nil
+}
+
+// ConnectionURL points to the sidecar of the pods when the database is not directly reachable
+func (d MySQLDriver) ConnectionURL(spec *migrationsv1alpha1.DBSpec) string {
+	host, port := podAddress(spec)
+	url := fmt.Sprintf("jdbc:mysql://%s:%d/%s", host, port, spec.DBName)
+	if spec.RDSIAM != nil {
+		url += "?sslMode=VERIFY_IDENTITY&trustCertificateKeyStoreUrl=file:" + rdsCAMountPath + "/" + rdsTrustStoreKey +
+			"&trustCertificateKeyStorePassword=" + rdsTrustStorePassword
+	}
+	return url
+}
+
+// TryLock takes a named lock, the pool is pinned to a single connection so that the session outlives the call
+func (d MySQLDriver) TryLock(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer, key string) (io.Closer, bool, error) {
+	db, err := d.connect(spec, creds, dialer)
+	if err != nil {
+		return nil, false, err
+	}
+	db.SetMaxOpenConns(1)
+
+	// lock names are limited to 64 characters
+	var locked bool
+	if err := db.Get(&locked, "SELECT COALESCE(GET_LOCK(SHA1(?), 0), 0)", key); err != nil || !locked {
+		db.Close()
+		return nil, false, err
+	}
+	return db, true, nil
+}
+
+// SchemaHistory returns no entries when the history table does not exist yet
+func (d MySQLDriver) SchemaHistory(spec *migrationsv1alpha1.DBSpec, creds *UserPassword, dialer Dialer, history *migrationsv1alpha1.HistorySpec) ([]migrationsv1alpha1.AppliedScript, error) {
+	db, err := d.connect(spec, creds, dialer)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var exists bool
+	query := "SELECT COUNT(*) > 0 FROM information_schema.tables WHERE table_schema = COALESCE(NULLIF(?, ''), DATABASE()) AND table_name = ?"
+	if err := db.Get(&exists, query, history.Schema, history.Table); err != nil || !exists {
+		return nil, err
+	}
+
+	var rows []historyRow
+	err = db.Select(&rows, "SELECT installed_rank, version, description, type, script, checksum, installed_by, installed_on, execution_time, success FROM "+d.historyTable(history)+" ORDER BY installed_rank")
+	if err != nil {
+		return nil, err
+	}
+	return historyEntries(rows), nil
+}
+
+func (d MySQLDriver) DumpImage() string {
+	return "mysql:8.0"
+}
+
+// DumpCommand dumps the database along with the statements dropping and recreating it
+func (d MySQLDriver) DumpCommand(spec *migrationsv1alpha1.DBSpec, file string) string {
+	return d.client("mysqldump", spec) + fmt.Sprintf(" --single-transaction --routines --triggers --set-gtid-purged=OFF --no-tablespaces --add-drop-database --result-file=%s --databases %s",
+		shellQuote(file), shellQuote(spec.DBName))
+}
+
+// RestoreCommand drops the database and recreates it from the dump, nothing a failed run created survives
+func (d MySQLDriver) RestoreCommand(spec *migrationsv1alpha1.DBSpec, file string) string {
+	return d.client("mysql", spec) + " < " + shellQuote(file)
+}
+
+func (d MySQLDriver) CompensateImage() string {
+	return d.DumpImage()
+}
+
+// CompensateCommand removes the version from the schema history once its script succeeded,
+// the statements of the script applied before a failure stay applied
+func (d MySQLDriver) CompensateCommand(spec *migrationsv1alpha1.DBSpec, history *migrationsv1alpha1.HistorySpec, version, file string) string {
+	client := d.client("mysql", spec) + " " + shellQuote(spec.DBName)
+	remove := "DELETE FROM " + d.historyTable(history) + " WHERE version = '" + strings.Replace(version, "'", "''", -1) + "'"
+	return fmt.Sprintf("%s < %s && %s --execute=%s", client, shellQuote(file), client, shellQuote(remove))
+}
+
+// client is the command line of a mysql client, the credentials are read from the FLYWAY_USER and FLYWAY_PASSWORD variables
+func (d MySQLDriver) client(command string, spec *migrationsv1alpha1.DBSpec) string {
+	host, port := podAddress(spec)
+	client := fmt.Sprintf(`MYSQL_PWD="$FLYWAY_PASSWORD" %s --host=%s --port=%d --user="$FLYWAY_USER"`, command, shellQuote(host), port)
+	if spec.RDSIAM != nil {
+		client += " --ssl-mode=VERIFY_IDENTITY --ssl-ca=" + rdsCAMountPath + "/" + rdsCAKey + " --enable-cleartext-plugin"
+	}
+	return client
+}
+
+func (d MySQLDriver) historyTable(history *migrationsv1alpha1.HistorySpec) string {
+	table := mysqlQuoteIdentifier(history.Table)
+	if history.Schema != "" {
+		table = mysqlQuoteIdentifier(history.Schema) + "." + table
+	}
+	return table
+}
+
+func (c mysqlConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return c.connector.Connect(context.WithValue(ctx, mysqlDialerKey{}, c.dialer))
+}
+
+func (c mysqlConnector) Driver() driver.Driver {
+	return c.connector.Driver()
+}
+
+func mysqlQuoteIdentifier(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
@@ -0,0 +1,119 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	"github.com/go-sql-driver/mysql"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// recordingDialer refuses the connections, recording their address
+type recordingDialer struct {
+	addresses []string
+}
+
+func (d *recordingDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialTimeout(network, address, 0)
+}
+
+func (d *recordingDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	d.addresses = append(d.addresses, address)
+	return nil, errors.New("connection refused")
+}
+
+var _ = Describe("MySQL driver", func() {
+	var (
+		driver MySQLDriver
+		spec   *migrationsv1alpha1.DBSpec
+	)
+
+	BeforeEach(func() {
+		spec = &migrationsv1alpha1.DBSpec{Driver: migrationsv1alpha1.MySQLDriver, Host: "orders", Port: 3306, DBName: "orders"}
+	})
+
+	It("is registered with its default port", func() {
+		Expect(GetDriver(spec)).To(Equal(MySQLDriver{}))
+		Expect(migrationsv1alpha1.DriverPorts).To(HaveKeyWithValue(migrationsv1alpha1.MySQLDriver, int32(3306)))
+	})
+
+	It("connects flyway and the operator with verified SSL to databases authenticating with tokens", func() {
+		Expect(driver.ConnectionURL(spec)).To(Equal("jdbc:mysql://orders:3306/orders"))
+		spec.RDSIAM = &migrationsv1alpha1.RDSIAMSpec{Region: "eu-west-1"}
+		Expect(driver.ConnectionURL(spec)).To(Equal("jdbc:mysql://orders:3306/orders?sslMode=VERIFY_IDENTITY" +
+			"&trustCertificateKeyStoreUrl=file:/etc/flyway/rds/rds-ca.jks&trustCertificateKeyStorePassword=changeit"))
+
+		config := driver.config(spec, &UserPassword{User: "flyway", Password: "token"})
+		Expect(config.Addr).To(Equal("orders:3306"))
+		Expect(config.TLSConfig).To(Equal("rds"))
+		Expect(config.AllowCleartextPasswords).To(BeTrue())
+	})
+
+	It("opens the connections of the operator through the dialer", func() {
+		dialer := &recordingDialer{}
+		config := driver.config(spec, &UserPassword{User: "flyway", Password: "secret"})
+		config.Net = mysqlDialNet
+		connector, err := mysql.NewConnector(config)
+		Expect(err).NotTo(HaveOccurred())
+
+		_, err = mysqlConnector{connector: connector, dialer: dialer}.Connect(context.Background())
+		Expect(err).To(MatchError("connection refused"))
+		Expect(dialer.addresses).To(Equal([]string{"orders:3306"}))
+	})
+
+	It("dumps the statements recreating the database, which the restore replays", func() {
+		dump := driver.DumpCommand(spec, "/backup/orders.dump")
+		Expect(dump).To(HavePrefix(`MYSQL_PWD="$FLYWAY_PASSWORD" mysqldump --host='orders' --port=3306 --user="$FLYWAY_USER"`))
+		Expect(dump).To(ContainSubstring("--add-drop-database"))
+		Expect(dump).To(HaveSuffix("--result-file='/backup/orders.dump' --databases 'orders'"))
+
+		Expect(driver.RestoreCommand(spec, "/backup/orders.dump")).To(Equal(`MYSQL_PWD="$FLYWAY_PASSWORD" mysql --host='orders' --port=3306 --user="$FLYWAY_USER" < '/backup/orders.dump'`))
+	})
+
+	It("removes the version from the history once its compensating script succeeded", func() {
+		history := &migrationsv1alpha1.HistorySpec{Table: "flyway_schema_history", Schema: "audit"}
+		command := driver.CompensateCommand(spec, history, "3", "/flyway/sql/rollback/C3__drop_c.sql")
+		Expect(command).To(Equal(`MYSQL_PWD="$FLYWAY_PASSWORD" mysql --host='orders' --port=3306 --user="$FLYWAY_USER" 'orders' < '/flyway/sql/rollback/C3__drop_c.sql' && ` +
+			`MYSQL_PWD="$FLYWAY_PASSWORD" mysql --host='orders' --port=3306 --user="$FLYWAY_USER" 'orders' --execute='DELETE FROM ` + "`audit`.`flyway_schema_history`" + ` WHERE version = '"'"'3'"'"''`))
+	})
+
+	It("restores failed runs from the backup taken before them", func() {
+		migration := &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec:       migrationsv1alpha1.MigrationSpec{Backup: &migrationsv1alpha1.BackupSpec{VolumeClaim: "backups"}},
+		}
+		spec.Secret = &migrationsv1alpha1.SecretSpec{Name: "orders", UserKey: "user", PasswordKey: "password"}
+		run := &flywayRun{
+			target: &migrationTarget{name: "orders", job: "flyway-orders", db: *spec, creds: GetCredentials(spec, "shop")},
+			driver: driver,
+		}
+
+		job, err := buildRestoreJob(migration, run, "flyway-orders-restore-20200101000000", "pvc://backups/orders/orders/20200101000000.dump")
+		Expect(err).NotTo(HaveOccurred())
+		restore := job.Spec.Template.Spec.Containers[0]
+		Expect(restore.Image).To(Equal("mysql:8.0"))
+		Expect(restore.Command[2]).To(HaveSuffix("< '/backup/orders/orders/20200101000000.dump'"))
+	})
+})
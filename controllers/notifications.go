@@ -0,0 +1,435 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+type (
+	// Notifier delivers the notifications of rollouts to the sinks of the migration and of the notification
+	// policies of its namespace
+	Notifier struct {
+		// Client reads the notification policies and the secrets holding sink URLs
+		Client     client.Reader
+		HTTPClient *http.Client
+		// Backoff is the delay before the first retry of a delivery, doubled on every retry
+		Backoff time.Duration
+	}
+
+	// Notification is the payload sent to the sinks, body and text templates are executed with it
+	Notification struct {
+		Event     migrationsv1alpha1.NotificationEvent `json:"event"`
+		Namespace string                               `json:"namespace"`
+		Migration string                               `json:"migration"`
+		Phase     migrationsv1alpha1.MigrationPhase    `json:"phase"`
+		Version   string                               `json:"version,omitempty"`
+		Revision  string                               `json:"revision,omitempty"`
+		Message   string                               `json:"message,omitempty"`
+		Targets   []NotifiedTarget                     `json:"targets"`
+		Time      time.Time                            `json:"time"`
+	}
+
+	// NotifiedTarget is the state of one target of the notified rollout
+	NotifiedTarget struct {
+		Name    string                            `json:"name"`
+		Phase   migrationsv1alpha1.MigrationPhase `json:"phase,omitempty"`
+		Version string                            `json:"version,omitempty"`
+		Message string                            `json:"message,omitempty"`
+	}
+
+	// sinkRequest is the rendered request of a sink, sent again on every attempt
+	sinkRequest struct {
+		url    string
+		header http.Header
+		body   []byte
+	}
+)
+
+const (
+	cloudEventsSpecVersion = "1.0"
+	cloudEventsTypePrefix  = "io.flywayoperator.migration."
+	teamsCardContext       = "https://schema.org/extensions"
+	// notificationDeadline bounds the deliveries of an event, retries included
+	notificationDeadline = 5 * time.Minute
+)
+
+var teamsColors = map[migrationsv1alpha1.NotificationEvent]string{
+	migrationsv1alpha1.NotificationStarted:   "0078D7",
+	migrationsv1alpha1.NotificationSucceeded: "2EB886",
+	migrationsv1alpha1.NotificationFailed:    "D13438",
+}
+
+// Summary describes the rollout in a sentence, it is the default text of chat messages
+func (n *Notification) Summary() string {
+	name := n.Namespace + "/" + n.Migration
+	switch n.Event {
+	case migrationsv1alpha1.NotificationStarted:
+		return fmt.Sprintf("Migration %s started on %d database(s)", name, len(n.Targets))
+	case migrationsv1alpha1.NotificationSucceeded:
+		return fmt.Sprintf("Migration %s succeeded, schema version %s", name, n.Version)
+	}
+	var failures []string
+	for _, target := range n.Targets {
+		if target.Phase == migrationsv1alpha1.MigrationFailed || target.Phase == migrationsv1alpha1.MigrationRolledBack {
+			failures = append(failures, fmt.Sprintf("%s (%s): %s", target.Name, target.Phase, target.Message))
+		}
+	}
+	if len(failures) == 0 {
+		return fmt.Sprintf("Migration %s failed: %s", name, n.Message)
+	}
+	return fmt.Sprintf("Migration %s failed on %s", name, strings.Join(failures, ", "))
+}
+
+// newNotification snapshots the status of a migration for an event, the message is the reason it is not ready
+func newNotification(migration *migrationsv1alpha1.Migration, event migrationsv1alpha1.NotificationEvent, now time.Time) *Notification {
+	notification := &Notification{
+		Event:     event,
+		Namespace: migration.ObjectMeta.Namespace,
+		Migration: migration.ObjectMeta.Name,
+		Phase:     migration.Status.Phase,
+		Version:   migration.Status.CurrentVersion,
+		Revision:  migration.Status.Revision,
+		Targets:   make([]NotifiedTarget, 0, len(migration.Status.Targets)),
+		Time:      now.UTC(),
+	}
+	if ready := migration.Status.GetCondition(migrationsv1alpha1.ConditionReady); ready != nil && ready.Status == corev1.ConditionFalse {
+		notification.Message = ready.Message
+	}
+	for _, target := range migration.Status.Targets {
+		notification.Targets = append(notification.Targets, NotifiedTarget{
+			Name:    target.Name,
+			Phase:   target.Phase,
+			Version: target.CurrentVersion,
+			Message: target.Message,
+		})
+	}
+	return notification
+}
+
+// rolloutEvent returns the event of the rollout that started or ended since the original status
+func rolloutEvent(original, status *migrationsv1alpha1.MigrationStatus) (migrationsv1alpha1.NotificationEvent, bool) {
+	if runOver(status.Phase) && !runOver(original.Phase) {
+		if status.Phase == migrationsv1alpha1.MigrationSucceeded {
+			return migrationsv1alpha1.NotificationSucceeded, true
+		}
+		return migrationsv1alpha1.NotificationFailed, true
+	}
+	if status.Phase != migrationsv1alpha1.MigrationRunning && status.Phase != migrationsv1alpha1.MigrationQueued {
+		return "", false
+	}
+	switch original.Phase {
+	case migrationsv1alpha1.MigrationRunning, migrationsv1alpha1.MigrationQueued:
+		return "", false
+	case migrationsv1alpha1.MigrationWaitingForWindow:
+		// waves run across several windows, only the first one starts the rollout
+		if rolloutStarted(original) {
+			return "", false
+		}
+	}
+	return migrationsv1alpha1.NotificationStarted, true
+}
+
+// notify sends the event in the background once the status it reports is persisted,
+// a pass whose status update conflicts sends it on the next pass
+func (r *MigrationReconciler) notify(migration *migrationsv1alpha1.Migration, event migrationsv1alpha1.NotificationEvent) {
+	if r.Notifier == nil {
+		return
+	}
+	go r.deliverNotification(migration.DeepCopy(), event)
+}
+
+// deliverNotification sends the event to the sinks within notificationDeadline and records the deliveries on the
+// latest status of the migration
+func (r *MigrationReconciler) deliverNotification(migration *migrationsv1alpha1.Migration, event migrationsv1alpha1.NotificationEvent) {
+	ctx, cancel := context.WithTimeout(context.Background(), notificationDeadline)
+	defer cancel()
+	log := r.Log.WithValues("migration", client.ObjectKey{Namespace: migration.ObjectMeta.Namespace, Name: migration.ObjectMeta.Name})
+
+	deliveries := r.Notifier.Notify(ctx, migration, event)
+	if len(deliveries) == 0 {
+		return
+	}
+	for _, delivery := range deliveries {
+		if delivery.Phase == migrationsv1alpha1.MigrationFailed {
+			r.Recorder.Eventf(migration, corev1.EventTypeWarning, ReasonNotificationFailed, "unable to notify %s of %s: %s", delivery.Sink, strings.ToLower(string(event)), delivery.Message)
+		}
+	}
+	err := retry.RetryOnConflict(retry.DefaultBackoff, func() error {
+		var latest migrationsv1alpha1.Migration
+		if err := r.Get(ctx, client.ObjectKey{Namespace: migration.ObjectMeta.Namespace, Name: migration.ObjectMeta.Name}, &latest); err != nil {
+			return err
+		}
+		recordDeliveries(&latest.Status, deliveries)
+		return r.Status().Update(ctx, &latest)
+	})
+	if err != nil {
+		log.Error(err, "unable to record the notification deliveries")
+	}
+}
+
+// errorEvent returns the failed event when a migration error is reported for the first time, the
+// reasons of the previous ready condition reported again on every pass are not notified again
+func errorEvent(previous *migrationsv1alpha1.MigrationCondition, migrationErr *MigrationError) (migrationsv1alpha1.NotificationEvent, bool) {
+	if migrationErr.Transient {
+		return "", false
+	}
+	if previous != nil && previous.Status == corev1.ConditionFalse && previous.Reason == migrationErr.Reason {
+		return "", false
+	}
+	return migrationsv1alpha1.NotificationFailed, true
+}
+
+// recordDeliveries keeps the latest delivery of every event to every sink
+func recordDeliveries(status *migrationsv1alpha1.MigrationStatus, deliveries []migrationsv1alpha1.NotificationDelivery) {
+	for _, delivery := range deliveries {
+		replaced := false
+		for i := range status.Notifications {
+			if recorded := &status.Notifications[i]; recorded.Sink == delivery.Sink && recorded.Event == delivery.Event {
+				*recorded = delivery
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			status.Notifications = append(status.Notifications, delivery)
+		}
+	}
+}
+
+// Notify delivers the event to the sinks subscribed to it, in the notification policies of the namespace
+// then in the migration, and returns the delivery results
+func (n *Notifier) Notify(ctx context.Context, migration *migrationsv1alpha1.Migration, event migrationsv1alpha1.NotificationEvent) []migrationsv1alpha1.NotificationDelivery {
+	notification := newNotification(migration, event, time.Now())
+	var deliveries []migrationsv1alpha1.NotificationDelivery
+
+	var policies migrationsv1alpha1.NotificationPolicyList
+	if err := n.Client.List(ctx, &policies, client.InNamespace(migration.ObjectMeta.Namespace)); err != nil {
+		deliveries = append(deliveries, migrationsv1alpha1.NotificationDelivery{
+			Sink:    "*",
+			Event:   event,
+			Phase:   migrationsv1alpha1.MigrationFailed,
+			Message: "unable to list the notification policies: " + err.Error(),
+			Time:    metav1.NewTime(notification.Time),
+		})
+	}
+	for i := range policies.Items {
+		policy := &policies.Items[i]
+		policy.Default()
+		deliveries = append(deliveries, n.notifySinks(ctx, policy.ObjectMeta.Name+"/", &policy.Spec.NotificationSpec, notification)...)
+	}
+	if spec := migration.Spec.Notifications; spec != nil {
+		deliveries = append(deliveries, n.notifySinks(ctx, "", spec, notification)...)
+	}
+	return deliveries
+}
+
+func (n *Notifier) notifySinks(ctx context.Context, prefix string, spec *migrationsv1alpha1.NotificationSpec, notification *Notification) []migrationsv1alpha1.NotificationDelivery {
+	if !subscribed(spec.Events, notification.Event) {
+		return nil
+	}
+	retries := int32(migrationsv1alpha1.DefaultNotificationRetries)
+	if spec.Retries != nil {
+		retries = *spec.Retries
+	}
+	// notification policies are not validated by a webhook
+	if retries > migrationsv1alpha1.MaxNotificationRetries {
+		retries = migrationsv1alpha1.MaxNotificationRetries
+	}
+	deliveries := make([]migrationsv1alpha1.NotificationDelivery, 0, len(spec.Sinks))
+	for i := range spec.Sinks {
+		delivery := n.deliver(ctx, notification.Namespace, &spec.Sinks[i], notification, retries)
+		delivery.Sink = prefix + delivery.Sink
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries
+}
+
+func subscribed(events []migrationsv1alpha1.NotificationEvent, event migrationsv1alpha1.NotificationEvent) bool {
+	if len(events) == 0 {
+		return true
+	}
+	for _, subscribed := range events {
+		if subscribed == event {
+			return true
+		}
+	}
+	return false
+}
+
+// deliver posts the notification to a sink, retrying with an exponential backoff until it answers with a 2xx status
+func (n *Notifier) deliver(ctx context.Context, namespace string, sink *migrationsv1alpha1.NotificationSink, notification *Notification, retries int32) migrationsv1alpha1.NotificationDelivery {
+	delivery := migrationsv1alpha1.NotificationDelivery{
+		Sink:  sink.Name,
+		Event: notification.Event,
+		Phase: migrationsv1alpha1.MigrationFailed,
+		Time:  metav1.NewTime(notification.Time),
+	}
+	request, err := n.render(ctx, namespace, sink, notification)
+	if err != nil {
+		delivery.Message = err.Error()
+		return delivery
+	}
+
+	backoff := n.Backoff
+	for {
+		delivery.Attempts++
+		err = n.post(ctx, request)
+		if err == nil {
+			delivery.Phase = migrationsv1alpha1.MigrationSucceeded
+			delivery.Message = ""
+			return delivery
+		}
+		delivery.Message = err.Error()
+		if delivery.Attempts > retries {
+			return delivery
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return delivery
+		}
+		backoff *= 2
+	}
+}
+
+func (n *Notifier) post(ctx context.Context, request *sinkRequest) error {
+	httpRequest, err := http.NewRequestWithContext(ctx, http.MethodPost, request.url, bytes.NewReader(request.body))
+	if err != nil {
+		return err
+	}
+	for key, values := range request.header {
+		httpRequest.Header[key] = values
+	}
+	httpClient := n.HTTPClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	response, err := httpClient.Do(httpRequest)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("sink answered %s", response.Status)
+	}
+	return nil
+}
+
+// render builds the request of the kind of the sink
+func (n *Notifier) render(ctx context.Context, namespace string, sink *migrationsv1alpha1.NotificationSink, notification *Notification) (*sinkRequest, error) {
+	var (
+		endpoint *migrationsv1alpha1.SinkEndpoint
+		header   = http.Header{"Content-Type": []string{"application/json"}}
+		body     []byte
+		err      error
+	)
+	switch {
+	case sink.Webhook != nil:
+		endpoint = &sink.Webhook.SinkEndpoint
+		for key, value := range sink.Webhook.Headers {
+			header.Set(key, value)
+		}
+		if sink.Webhook.Body == "" {
+			body, err = json.Marshal(notification)
+		} else {
+			var text string
+			text, err = executeTemplate(sink.Webhook.Body, notification)
+			body = []byte(text)
+		}
+	case sink.Slack != nil:
+		endpoint = &sink.Slack.SinkEndpoint
+		var text string
+		if text, err = chatText(sink.Slack, notification); err == nil {
+			body, err = json.Marshal(map[string]string{"text": text})
+		}
+	case sink.Teams != nil:
+		endpoint = &sink.Teams.SinkEndpoint
+		var text string
+		if text, err = chatText(sink.Teams, notification); err == nil {
+			body, err = json.Marshal(map[string]string{
+				"@type":      "MessageCard",
+				"@context":   teamsCardContext,
+				"themeColor": teamsColors[notification.Event],
+				"summary":    notification.Summary(),
+				"title":      fmt.Sprintf("Migration %s/%s %s", notification.Namespace, notification.Migration, strings.ToLower(string(notification.Event))),
+				"text":       text,
+			})
+		}
+	case sink.CloudEvents != nil:
+		endpoint = &sink.CloudEvents.SinkEndpoint
+		source := sink.CloudEvents.Source
+		if source == "" {
+			source = fmt.Sprintf("/namespaces/%s/migrations/%s", notification.Namespace, notification.Migration)
+		}
+		header.Set("ce-specversion", cloudEventsSpecVersion)
+		header.Set("ce-id", string(uuid.NewUUID()))
+		header.Set("ce-source", source)
+		header.Set("ce-type", cloudEventsTypePrefix+strings.ToLower(string(notification.Event)))
+		header.Set("ce-time", notification.Time.Format(time.RFC3339Nano))
+		body, err = json.Marshal(notification)
+	default:
+		return nil, fmt.Errorf("sink %s has no kind", sink.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	url, err := n.endpointURL(ctx, namespace, endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &sinkRequest{url: url, header: header, body: body}, nil
+}
+
+func chatText(sink *migrationsv1alpha1.ChatSink, notification *Notification) (string, error) {
+	if sink.Text == "" {
+		return notification.Summary(), nil
+	}
+	return executeTemplate(sink.Text, notification)
+}
+
+func executeTemplate(text string, notification *Notification) (string, error) {
+	tmpl, err := template.New("notification").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, notification); err != nil {
+		return "", err
+	}
+	return out.String(), nil
+}
+
+// endpointURL reads the URL of a sink, from its secret when set
+func (n *Notifier) endpointURL(ctx context.Context, namespace string, endpoint *migrationsv1alpha1.SinkEndpoint) (string, error) {
+	selector := endpoint.URLSecret
+	if selector == nil {
+		return endpoint.URL, nil
+	}
+	var secret corev1.Secret
+	if err := n.Client.Get(ctx, client.ObjectKey{Namespace: namespace, Name: selector.Name}, &secret); err != nil {
+		return "", fmt.Errorf("unable to read the URL of the sink: %v", err)
+	}
+	url, ok := secret.Data[selector.Key]
+	if !ok {
+		return "", fmt.Errorf("secret %s has no %s key", selector.Name, selector.Key)
+	}
+	return strings.TrimSpace(string(url)), nil
+}
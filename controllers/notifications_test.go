@@ -0,0 +1,322 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("notifications", func() {
+	ctx := context.Background()
+
+	type received struct {
+		path   string
+		header http.Header
+		body   []byte
+	}
+
+	var (
+		server    *httptest.Server
+		mutex     sync.Mutex
+		requests  []received
+		failures  int
+		migration *migrationsv1alpha1.Migration
+	)
+
+	notifier := func(objects ...runtime.Object) *Notifier {
+		return &Notifier{Client: fake.NewFakeClientWithScheme(scheme.Scheme, objects...), HTTPClient: server.Client()}
+	}
+	retries := func(n int32) *int32 { return &n }
+
+	BeforeEach(func() {
+		requests, failures = nil, 0
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			body, _ := ioutil.ReadAll(req.Body)
+			mutex.Lock()
+			defer mutex.Unlock()
+			requests = append(requests, received{path: req.URL.Path, header: req.Header, body: body})
+			if failures > 0 {
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+			}
+		}))
+		migration = &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Status: migrationsv1alpha1.MigrationStatus{
+				Phase:          migrationsv1alpha1.MigrationFailed,
+				CurrentVersion: "3",
+				Targets: []migrationsv1alpha1.TargetStatus{
+					{Name: "eu", Phase: migrationsv1alpha1.MigrationSucceeded, CurrentVersion: "4"},
+					{Name: "us", Phase: migrationsv1alpha1.MigrationRolledBack, CurrentVersion: "3", Message: "V4 failed"},
+				},
+			},
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("renders every kind of sink", func() {
+		migration.Spec.Notifications = &migrationsv1alpha1.NotificationSpec{
+			Retries: retries(0),
+			Sinks: []migrationsv1alpha1.NotificationSink{
+				{Name: "hook", Webhook: &migrationsv1alpha1.WebhookSink{
+					SinkEndpoint: migrationsv1alpha1.SinkEndpoint{URL: server.URL + "/hook"},
+					Headers:      map[string]string{"Authorization": "Bearer token"},
+					Body:         `{"app": "{{ .Migration }}", "status": "{{ .Event }}"}`,
+				}},
+				{Name: "slack", Slack: &migrationsv1alpha1.ChatSink{
+					SinkEndpoint: migrationsv1alpha1.SinkEndpoint{URLSecret: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: "slack"}, Key: "url"}},
+				}},
+				{Name: "teams", Teams: &migrationsv1alpha1.ChatSink{
+					SinkEndpoint: migrationsv1alpha1.SinkEndpoint{URL: server.URL + "/teams"},
+					Text:         "{{ .Migration }} is {{ .Phase }}",
+				}},
+				{Name: "events", CloudEvents: &migrationsv1alpha1.CloudEventsSink{
+					SinkEndpoint: migrationsv1alpha1.SinkEndpoint{URL: server.URL + "/events"},
+				}},
+			},
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "slack", Namespace: "shop"},
+			Data:       map[string][]byte{"url": []byte(server.URL + "/slack\n")},
+		}
+
+		deliveries := notifier(secret).Notify(ctx, migration, migrationsv1alpha1.NotificationFailed)
+		Expect(deliveries).To(HaveLen(4))
+		for _, delivery := range deliveries {
+			Expect(delivery.Phase).To(Equal(migrationsv1alpha1.MigrationSucceeded), delivery.Sink)
+			Expect(delivery.Attempts).To(Equal(int32(1)))
+		}
+		Expect(requests).To(HaveLen(4))
+
+		Expect(requests[0].path).To(Equal("/hook"))
+		Expect(requests[0].header.Get("Authorization")).To(Equal("Bearer token"))
+		Expect(string(requests[0].body)).To(Equal(`{"app": "orders", "status": "Failed"}`))
+
+		var slack map[string]string
+		Expect(json.Unmarshal(requests[1].body, &slack)).To(Succeed())
+		Expect(slack["text"]).To(Equal("Migration shop/orders failed on us (RolledBack): V4 failed"))
+
+		var teams map[string]string
+		Expect(json.Unmarshal(requests[2].body, &teams)).To(Succeed())
+		Expect(teams["@type"]).To(Equal("MessageCard"))
+		Expect(teams["text"]).To(Equal("orders is Failed"))
+
+		Expect(requests[3].header.Get("ce-specversion")).To(Equal("1.0"))
+		Expect(requests[3].header.Get("ce-type")).To(Equal("io.flywayoperator.migration.failed"))
+		Expect(requests[3].header.Get("ce-source")).To(Equal("/namespaces/shop/migrations/orders"))
+		Expect(requests[3].header.Get("ce-id")).NotTo(BeEmpty())
+		var notification Notification
+		Expect(json.Unmarshal(requests[3].body, &notification)).To(Succeed())
+		Expect(notification.Targets).To(HaveLen(2))
+		Expect(notification.Version).To(Equal("3"))
+	})
+
+	It("retries failed deliveries and records the last error", func() {
+		failures = 5
+		spec := migrationsv1alpha1.NotificationSpec{
+			Retries: retries(2),
+			Sinks: []migrationsv1alpha1.NotificationSink{
+				{Name: "hook", Webhook: &migrationsv1alpha1.WebhookSink{SinkEndpoint: migrationsv1alpha1.SinkEndpoint{URL: server.URL}}},
+			},
+		}
+		policy := &migrationsv1alpha1.NotificationPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "oncall", Namespace: "shop"},
+			Spec:       migrationsv1alpha1.NotificationPolicySpec{NotificationSpec: spec},
+		}
+		migration.Spec.Notifications = spec.DeepCopy()
+		migration.Spec.Notifications.Retries = retries(5)
+
+		deliveries := notifier(policy).Notify(ctx, migration, migrationsv1alpha1.NotificationFailed)
+		Expect(deliveries).To(HaveLen(2))
+		Expect(deliveries[0].Sink).To(Equal("oncall/hook"))
+		Expect(deliveries[0].Phase).To(Equal(migrationsv1alpha1.MigrationFailed))
+		Expect(deliveries[0].Attempts).To(Equal(int32(3)))
+		Expect(deliveries[0].Message).To(ContainSubstring("503"))
+		Expect(deliveries[1].Sink).To(Equal("hook"))
+		Expect(deliveries[1].Phase).To(Equal(migrationsv1alpha1.MigrationSucceeded))
+		Expect(deliveries[1].Attempts).To(Equal(int32(3)))
+	})
+
+	It("caps the retries of the notification policies", func() {
+		failures = 100
+		policy := &migrationsv1alpha1.NotificationPolicy{
+			ObjectMeta: metav1.ObjectMeta{Name: "oncall", Namespace: "shop"},
+			Spec: migrationsv1alpha1.NotificationPolicySpec{NotificationSpec: migrationsv1alpha1.NotificationSpec{
+				Retries: retries(50),
+				Sinks: []migrationsv1alpha1.NotificationSink{
+					{Name: "hook", Webhook: &migrationsv1alpha1.WebhookSink{SinkEndpoint: migrationsv1alpha1.SinkEndpoint{URL: server.URL}}},
+				},
+			}},
+		}
+
+		deliveries := notifier(policy).Notify(ctx, migration, migrationsv1alpha1.NotificationFailed)
+		Expect(deliveries).To(HaveLen(1))
+		Expect(deliveries[0].Attempts).To(Equal(int32(migrationsv1alpha1.MaxNotificationRetries + 1)))
+	})
+
+	It("records the deliveries on the latest status of the migration", func() {
+		migration.Spec.Notifications = &migrationsv1alpha1.NotificationSpec{
+			Retries: retries(0),
+			Sinks: []migrationsv1alpha1.NotificationSink{
+				{Name: "hook", Webhook: &migrationsv1alpha1.WebhookSink{SinkEndpoint: migrationsv1alpha1.SinkEndpoint{URL: server.URL}}},
+			},
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, migration.DeepCopy())
+		r := &MigrationReconciler{
+			Client:   c,
+			Log:      ctrl.Log,
+			Recorder: record.NewFakeRecorder(10),
+			Notifier: &Notifier{Client: c, HTTPClient: server.Client()},
+		}
+
+		// the delivered snapshot is older than the stored migration
+		snapshot := migration.DeepCopy()
+		snapshot.ObjectMeta.ResourceVersion = "0"
+		r.deliverNotification(snapshot, migrationsv1alpha1.NotificationFailed)
+		Expect(requests).To(HaveLen(1))
+
+		var latest migrationsv1alpha1.Migration
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "orders"}, &latest)).To(Succeed())
+		Expect(latest.Status.Notifications).To(HaveLen(1))
+		Expect(latest.Status.Notifications[0].Phase).To(Equal(migrationsv1alpha1.MigrationSucceeded))
+		Expect(latest.Status.Targets).To(HaveLen(2))
+	})
+
+	It("describes the errors failing migrations without failed targets", func() {
+		migration.Status.Targets = nil
+		migration.Status.SetCondition(migrationsv1alpha1.MigrationCondition{
+			Type:    migrationsv1alpha1.ConditionReady,
+			Status:  corev1.ConditionFalse,
+			Reason:  ReasonDatabaseNotFound,
+			Message: "database orders not found",
+		})
+		notification := newNotification(migration, migrationsv1alpha1.NotificationFailed, time.Now())
+		Expect(notification.Summary()).To(Equal("Migration shop/orders failed: database orders not found"))
+	})
+
+	It("only notifies the migration errors once", func() {
+		invalid := newMigrationError(ReasonDatabaseNotFound, "database orders not found")
+		event, ok := errorEvent(nil, invalid)
+		Expect(ok).To(BeTrue())
+		Expect(event).To(Equal(migrationsv1alpha1.NotificationFailed))
+
+		reported := &migrationsv1alpha1.MigrationCondition{Type: migrationsv1alpha1.ConditionReady, Status: corev1.ConditionFalse, Reason: ReasonDatabaseNotFound}
+		_, ok = errorEvent(reported, invalid)
+		Expect(ok).To(BeFalse())
+		reported.Status = corev1.ConditionTrue
+		_, ok = errorEvent(reported, invalid)
+		Expect(ok).To(BeTrue())
+
+		_, ok = errorEvent(nil, newTransientError(ReasonDatabaseNotFound, "database orders unavailable"))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("only notifies the subscribed events", func() {
+		migration.Spec.Notifications = &migrationsv1alpha1.NotificationSpec{
+			Events: []migrationsv1alpha1.NotificationEvent{migrationsv1alpha1.NotificationFailed},
+			Sinks: []migrationsv1alpha1.NotificationSink{
+				{Name: "hook", Webhook: &migrationsv1alpha1.WebhookSink{SinkEndpoint: migrationsv1alpha1.SinkEndpoint{URL: server.URL}}},
+			},
+		}
+		Expect(notifier().Notify(ctx, migration, migrationsv1alpha1.NotificationStarted)).To(BeEmpty())
+		Expect(requests).To(BeEmpty())
+	})
+
+	It("keeps the latest delivery of every event to every sink", func() {
+		status := &migrationsv1alpha1.MigrationStatus{}
+		recordDeliveries(status, []migrationsv1alpha1.NotificationDelivery{
+			{Sink: "hook", Event: migrationsv1alpha1.NotificationStarted, Phase: migrationsv1alpha1.MigrationSucceeded},
+			{Sink: "hook", Event: migrationsv1alpha1.NotificationFailed, Phase: migrationsv1alpha1.MigrationFailed},
+		})
+		recordDeliveries(status, []migrationsv1alpha1.NotificationDelivery{
+			{Sink: "hook", Event: migrationsv1alpha1.NotificationFailed, Phase: migrationsv1alpha1.MigrationSucceeded},
+		})
+		Expect(status.Notifications).To(HaveLen(2))
+		Expect(status.Notifications[1].Phase).To(Equal(migrationsv1alpha1.MigrationSucceeded))
+	})
+})
+
+var _ = Describe("rolloutEvent", func() {
+	const (
+		pending   = migrationsv1alpha1.MigrationPending
+		waiting   = migrationsv1alpha1.MigrationWaitingForWindow
+		running   = migrationsv1alpha1.MigrationRunning
+		succeeded = migrationsv1alpha1.MigrationSucceeded
+		restored  = migrationsv1alpha1.MigrationRolledBack
+	)
+
+	status := func(phase migrationsv1alpha1.MigrationPhase, targets ...migrationsv1alpha1.MigrationPhase) *migrationsv1alpha1.MigrationStatus {
+		s := &migrationsv1alpha1.MigrationStatus{Phase: phase}
+		for _, target := range targets {
+			s.Targets = append(s.Targets, migrationsv1alpha1.TargetStatus{Phase: target})
+		}
+		return s
+	}
+
+	It("starts a rollout leaving a finished or pending state", func() {
+		event, ok := rolloutEvent(status(succeeded), status(running))
+		Expect(ok).To(BeTrue())
+		Expect(event).To(Equal(migrationsv1alpha1.NotificationStarted))
+
+		event, ok = rolloutEvent(status(waiting, pending, pending), status(running))
+		Expect(ok).To(BeTrue())
+		Expect(event).To(Equal(migrationsv1alpha1.NotificationStarted))
+	})
+
+	It("does not start a rollout again between its waves", func() {
+		_, ok := rolloutEvent(status(waiting, succeeded, pending), status(running))
+		Expect(ok).To(BeFalse())
+		_, ok = rolloutEvent(status(running), status(running))
+		Expect(ok).To(BeFalse())
+	})
+
+	It("ends a rollout once it is over", func() {
+		event, ok := rolloutEvent(status(running), status(succeeded))
+		Expect(ok).To(BeTrue())
+		Expect(event).To(Equal(migrationsv1alpha1.NotificationSucceeded))
+
+		event, ok = rolloutEvent(status(running), status(restored))
+		Expect(ok).To(BeTrue())
+		Expect(event).To(Equal(migrationsv1alpha1.NotificationFailed))
+
+		_, ok = rolloutEvent(status(succeeded), status(succeeded))
+		Expect(ok).To(BeFalse())
+	})
+})
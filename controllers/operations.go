@@ -0,0 +1,101 @@
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// gateRollout holds the targets of a suspended migration and the rollouts awaiting approval,
+// it consumes the approval of the rollout it lets start
+func (r *MigrationReconciler) gateRollout(ctx context.Context, migration *migrationsv1alpha1.Migration, next []int, phase migrationsv1alpha1.MigrationPhase) ([]int, migrationsv1alpha1.MigrationPhase, error) {
+	if len(next) == 0 {
+		return next, phase, nil
+	}
+	if migration.Spec.Suspend {
+		// queued targets already started, they keep their place in the queue of their database
+		var queued []int
+		for _, i := range next {
+			if migration.Status.Targets[i].Phase == migrationsv1alpha1.MigrationQueued {
+				queued = append(queued, i)
+			}
+		}
+		if len(queued) > 0 || hasRunningTargets(&migration.Status) {
+			return queued, phase, nil
+		}
+		if migration.Status.Phase != migrationsv1alpha1.MigrationSuspended {
+			r.Recorder.Event(migration, corev1.EventTypeNormal, ReasonSuspended, "migration is suspended, no run starts until it is resumed")
+		}
+		return nil, migrationsv1alpha1.MigrationSuspended, nil
+	}
+	if !migration.Spec.RequireApproval || rolloutStarted(&migration.Status) {
+		return next, phase, nil
+	}
+
+	approver, approved := migration.ObjectMeta.Annotations[migrationsv1alpha1.ApproveAnnotation]
+	if !approved {
+		if migration.Status.Phase != migrationsv1alpha1.MigrationAwaitingApproval {
+			r.Recorder.Event(migration, corev1.EventTypeNormal, ReasonAwaitingApproval, "rollout is waiting for approval")
+		}
+		return nil, migrationsv1alpha1.MigrationAwaitingApproval, nil
+	}
+	status := migration.Status.DeepCopy()
+	patch := client.MergeFrom(migration.DeepCopy())
+	delete(migration.ObjectMeta.Annotations, migrationsv1alpha1.ApproveAnnotation)
+	if err := r.Patch(ctx, migration, patch); err != nil {
+		return nil, phase, err
+	}
+	// the patch response holds the stored status, the one of this pass is kept
+	migration.Status = *status
+	rolloutTrigger(migration).ApprovedBy = approver
+	r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonApproved, "rollout approved by %s", approver)
+	return next, phase, nil
+}
+
+// rerun starts a new rollout on every target of a finished migration annotated for a rerun
+func (r *MigrationReconciler) rerun(ctx context.Context, migration *migrationsv1alpha1.Migration, targets []*migrationTarget) (ctrl.Result, error) {
+	user, ok := migration.ObjectMeta.Annotations[migrationsv1alpha1.RerunAnnotation]
+	if !ok || !runOver(migration.Status.Phase) {
+		return ctrl.Result{}, nil
+	}
+	patch := client.MergeFrom(migration.DeepCopy())
+	delete(migration.ObjectMeta.Annotations, migrationsv1alpha1.RerunAnnotation)
+	if err := r.Patch(ctx, migration, patch); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	restarted, err := r.restartTargets(ctx, migration, targets, func(*migrationsv1alpha1.TargetStatus) bool { return true })
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonRerun, "new run requested on %s", strings.Join(restarted, ", "))
+	triggerRollout(migration, migrationsv1alpha1.TriggerRerun, user)
+	migration.Status.Phase = migrationsv1alpha1.MigrationPending
+	if err := r.Status().Update(ctx, migration); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
+
+// restartTargets deletes the flyway job of the selected targets and sets them pending again, it returns their names
+func (r *MigrationReconciler) restartTargets(ctx context.Context, migration *migrationsv1alpha1.Migration, targets []*migrationTarget, restart func(*migrationsv1alpha1.TargetStatus) bool) ([]string, error) {
+	var restarted []string
+	for i, target := range targets {
+		status := &migration.Status.Targets[i]
+		if !restart(status) {
+			continue
+		}
+		if err := r.deleteRunJob(ctx, migration, status); err != nil {
+			return nil, err
+		}
+		status.Phase = migrationsv1alpha1.MigrationPending
+		status.Message = ""
+		restarted = append(restarted, target.name)
+	}
+	return restarted, nil
+}
@@ -0,0 +1,175 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	batchv1 "k8s.io/api/batch/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("gateRollout", func() {
+	ctx := context.Background()
+
+	var (
+		r         *MigrationReconciler
+		migration *migrationsv1alpha1.Migration
+	)
+
+	reconciler := func() *MigrationReconciler {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, migration.DeepCopy())
+		return &MigrationReconciler{Client: c, APIReader: c, Log: ctrl.Log, Recorder: record.NewFakeRecorder(10)}
+	}
+
+	BeforeEach(func() {
+		migration = &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Status: migrationsv1alpha1.MigrationStatus{
+				Phase: migrationsv1alpha1.MigrationPending,
+				Targets: []migrationsv1alpha1.TargetStatus{
+					{Name: "eu", Phase: migrationsv1alpha1.MigrationPending},
+					{Name: "us", Phase: migrationsv1alpha1.MigrationPending},
+				},
+			},
+		}
+	})
+
+	It("holds the targets of a suspended migration", func() {
+		migration.Spec.Suspend = true
+		r = reconciler()
+		next, phase, err := r.gateRollout(ctx, migration, []int{0, 1}, migrationsv1alpha1.MigrationRunning)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(next).To(BeEmpty())
+		Expect(phase).To(Equal(migrationsv1alpha1.MigrationSuspended))
+	})
+
+	It("lets the queued targets of a suspended migration keep their place", func() {
+		migration.Spec.Suspend = true
+		migration.Status.Targets[1].Phase = migrationsv1alpha1.MigrationQueued
+		r = reconciler()
+		next, phase, err := r.gateRollout(ctx, migration, []int{0, 1}, migrationsv1alpha1.MigrationRunning)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(next).To(Equal([]int{1}))
+		Expect(phase).To(Equal(migrationsv1alpha1.MigrationRunning))
+	})
+
+	It("holds a rollout until it is approved", func() {
+		migration.Spec.RequireApproval = true
+		r = reconciler()
+		next, phase, err := r.gateRollout(ctx, migration, []int{0, 1}, migrationsv1alpha1.MigrationRunning)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(next).To(BeEmpty())
+		Expect(phase).To(Equal(migrationsv1alpha1.MigrationAwaitingApproval))
+	})
+
+	It("consumes the approval of the rollout it starts", func() {
+		migration.Spec.RequireApproval = true
+		migration.ObjectMeta.Annotations = map[string]string{migrationsv1alpha1.ApproveAnnotation: "jane"}
+		r = reconciler()
+		next, phase, err := r.gateRollout(ctx, migration, []int{0, 1}, migrationsv1alpha1.MigrationRunning)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(next).To(Equal([]int{0, 1}))
+		Expect(phase).To(Equal(migrationsv1alpha1.MigrationRunning))
+
+		var stored migrationsv1alpha1.Migration
+		Expect(r.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "orders"}, &stored)).To(Succeed())
+		Expect(stored.ObjectMeta.Annotations).NotTo(HaveKey(migrationsv1alpha1.ApproveAnnotation))
+	})
+
+	It("does not ask again for approval between waves", func() {
+		migration.Spec.RequireApproval = true
+		migration.Status.Targets[0].Phase = migrationsv1alpha1.MigrationSucceeded
+		r = reconciler()
+		next, _, err := r.gateRollout(ctx, migration, []int{1}, migrationsv1alpha1.MigrationRunning)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(next).To(Equal([]int{1}))
+	})
+})
+
+var _ = Describe("restartTargets", func() {
+	ctx := context.Background()
+
+	It("deletes the job of the last run so that the next run gets its own job", func() {
+		migration := &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Status: migrationsv1alpha1.MigrationStatus{
+				Targets: []migrationsv1alpha1.TargetStatus{
+					{Name: "eu", Phase: migrationsv1alpha1.MigrationFailed, Job: "flyway-orders-eu-1", Runs: 1},
+					{Name: "us", Phase: migrationsv1alpha1.MigrationSucceeded, Job: "flyway-orders-us-1", Runs: 1},
+				},
+			},
+		}
+		job := func(name string) *batchv1.Job {
+			return &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "shop"}}
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, migration.DeepCopy(), job("flyway-orders-eu-1"), job("flyway-orders-us-1"))
+		r := &MigrationReconciler{Client: c, APIReader: c, Log: ctrl.Log, Recorder: record.NewFakeRecorder(10)}
+		targets := []*migrationTarget{{name: "eu", job: "flyway-orders-eu"}, {name: "us", job: "flyway-orders-us"}}
+
+		restarted, err := r.restartTargets(ctx, migration, targets, func(status *migrationsv1alpha1.TargetStatus) bool {
+			return status.Phase == migrationsv1alpha1.MigrationFailed
+		})
+		Expect(err).NotTo(HaveOccurred())
+		Expect(restarted).To(Equal([]string{"eu"}))
+
+		eu := &migration.Status.Targets[0]
+		Expect(eu.Phase).To(Equal(migrationsv1alpha1.MigrationPending))
+		Expect(eu.Job).To(BeEmpty())
+		Expect(apierrors.IsNotFound(c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "flyway-orders-eu-1"}, &batchv1.Job{}))).To(BeTrue())
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "flyway-orders-us-1"}, &batchv1.Job{})).To(Succeed())
+		Expect(runName(migration, targets[0], eu)).To(HaveSuffix("-2"))
+	})
+})
+
+var _ = Describe("RenderJobs", func() {
+	It("renders the flyway job of every target without writing to the cluster", func() {
+		migration := &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop", UID: "1234"},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				Targets: []migrationsv1alpha1.MigrationTarget{
+					{Name: "eu", DBSpec: migrationsv1alpha1.DBSpec{Host: "eu", DBName: "orders", Driver: migrationsv1alpha1.PostgresDriver,
+						Secret: &migrationsv1alpha1.SecretSpec{Name: "eu"}}},
+					{Name: "us", DBSpec: migrationsv1alpha1.DBSpec{Host: "us", DBName: "orders", Driver: migrationsv1alpha1.PostgresDriver,
+						Secret: &migrationsv1alpha1.SecretSpec{Name: "us"}}},
+				},
+				SQL: migrationsv1alpha1.SQLSpec{VolumeClaim: "scripts", Path: "sql"},
+			},
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme)
+
+		jobs, err := RenderJobs(context.Background(), c, scheme.Scheme, migration)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(jobs).To(HaveLen(2))
+		Expect(jobs[0].ObjectMeta.Name).To(Equal(targetJobName(migration, "eu")))
+		Expect(jobs[1].ObjectMeta.OwnerReferences[0].Name).To(Equal("orders"))
+		container := jobs[1].Spec.Template.Spec.Containers[0]
+		Expect(container.Name).To(Equal(FlywayContainerName))
+		Expect(container.Image).To(Equal(migrationsv1alpha1.DefaultImage))
+		Expect(container.Env[1].Value).To(Equal("jdbc:postgresql://us:5432/orders"))
+	})
+})
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"bufio"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type (
+	// FlywayReport holds the schema state printed by flyway info and the outcome printed by migrate or undo
+	FlywayReport struct {
+		SchemaVersion string
+		Pending       int32
+		// InitialVersion is the schema version before migrate or undo ran
+		InitialVersion string
+		// Applied is the number of scripts migrate applied or undo reverted
+		Applied int32
+	}
+)
+
+var (
+	schemaVersionLine  = regexp.MustCompile(`^Schema version: (.+)$`)
+	currentVersionLine = regexp.MustCompile(`^Current version of schema "[^"]*": (.+)$`)
+	appliedLine        = regexp.MustCompile(`^Successfully (?:applied|undid) (\d+) migrations?`)
+)
+
+// parseFlywayOutput reads the schema version and the pending scripts from the output of flyway info,
+// and the scripts applied from the output of migrate or undo
+func parseFlywayOutput(output string) FlywayReport {
+	var report FlywayReport
+
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if match := schemaVersionLine.FindStringSubmatch(line); match != nil {
+			// an empty schema is reported as << Empty Schema >>
+			if !strings.HasPrefix(match[1], "<<") {
+				report.SchemaVersion = match[1]
+			}
+			continue
+		}
+		if match := currentVersionLine.FindStringSubmatch(line); match != nil {
+			if !strings.HasPrefix(match[1], "<<") {
+				report.InitialVersion = match[1]
+			}
+			continue
+		}
+		if match := appliedLine.FindStringSubmatch(line); match != nil {
+			applied, _ := strconv.ParseInt(match[1], 10, 32)
+			report.Applied = int32(applied)
+			continue
+		}
+		// info rows are formatted as | Category | Version | Description | Type | Installed On | State |
+		if strings.HasPrefix(line, "|") {
+			cells := strings.Split(strings.Trim(line, "|"), "|")
+			if strings.TrimSpace(cells[len(cells)-1]) == "Pending" {
+				report.Pending++
+			}
+		}
+	}
+
+	return report
+}
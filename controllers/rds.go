@@ -0,0 +1,118 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// RDSCABundle holds the certificates of the RDS certificate authorities, the operator and the pods connecting
+// with IAM tokens verify the certificate of the database with them
+type RDSCABundle struct {
+	// Path the operator reads the bundle from
+	Path string
+	PEM  []byte
+	// TrustStore holds the certificates in a Java keystore, the MySQL JDBC driver does not read PEM files
+	TrustStore []byte
+}
+
+const (
+	// the pods read the bundle from the secret of the token
+	rdsCAVolumeName  = "rds-ca"
+	rdsCAMountPath   = "/etc/flyway/rds"
+	rdsCAKey         = "rds-ca.pem"
+	rdsTrustStoreKey = "rds-ca.jks"
+	// rdsTrustStorePassword only protects the integrity of the trust store, which holds public certificates
+	rdsTrustStorePassword = "changeit"
+	// rdsTLSConfig names the TLS configuration of the MySQL connections, registered with the bundle
+	rdsTLSConfig = "rds"
+)
+
+// DefaultRDSCABundle verifies the databases authenticating with RDS IAM tokens, connections are refused until it is loaded
+var DefaultRDSCABundle *RDSCABundle
+
+// LoadRDSCABundle reads the PEM certificates of a bundle, such as the global bundle of
+// https://truststore.pki.rds.amazonaws.com, and registers them for the MySQL connections
+func LoadRDSCABundle(path string) (*RDSCABundle, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for rest := data; ; {
+		var block *pem.Block
+		if block, rest = pem.Decode(rest); block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %v", path, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificate in %s", path)
+	}
+
+	pool := x509.NewCertPool()
+	for _, cert := range certs {
+		pool.AddCert(cert)
+	}
+	// the driver verifies the host name of the address it connects to
+	if err := mysql.RegisterTLSConfig(rdsTLSConfig, &tls.Config{RootCAs: pool}); err != nil {
+		return nil, err
+	}
+	return &RDSCABundle{Path: path, PEM: data, TrustStore: javaTrustStore(certs, rdsTrustStorePassword, time.Now())}, nil
+}
+
+// rdsCABundlePath is empty until the bundle is loaded, the drivers then only trust the system certificates
+func rdsCABundlePath() string {
+	if DefaultRDSCABundle == nil {
+		return ""
+	}
+	return DefaultRDSCABundle.Path
+}
+
+// javaTrustStore encodes certificates as the trusted entries of a JKS keystore
+func javaTrustStore(certs []*x509.Certificate, password string, created time.Time) []byte {
+	var store bytes.Buffer
+	write := func(value interface{}) {
+		binary.Write(&store, binary.BigEndian, value)
+	}
+	writeUTF := func(value string) {
+		write(uint16(len(value)))
+		store.WriteString(value)
+	}
+
+	write(uint32(0xFEEDFEED))
+	write(uint32(2))
+	write(uint32(len(certs)))
+	for i, cert := range certs {
+		write(uint32(2))
+		writeUTF(fmt.Sprintf("rds-ca-%d", i))
+		write(created.UnixNano() / int64(time.Millisecond))
+		writeUTF("X.509")
+		write(uint32(len(cert.Raw)))
+		store.Write(cert.Raw)
+	}
+
+	// the keystore ends with the digest of the UTF-16 password, a salt and the entries
+	digest := sha1.New()
+	for _, r := range password {
+		digest.Write([]byte{byte(r >> 8), byte(r)})
+	}
+	digest.Write([]byte("Mighty Aphrodite"))
+	digest.Write(store.Bytes())
+	return append(store.Bytes(), digest.Sum(nil)...)
+}
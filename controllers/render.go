@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"context"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// dryRunClient submits its writes in dry run mode so that resolving targets leaves the cluster untouched
+type dryRunClient struct {
+	client.Client
+}
+
+func (c dryRunClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return c.Client.Create(ctx, obj, append(opts, client.DryRunAll)...)
+}
+
+func (c dryRunClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return c.Client.Update(ctx, obj, append(opts, client.DryRunAll)...)
+}
+
+func (c dryRunClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.Client.Patch(ctx, obj, patch, append(opts, client.DryRunAll)...)
+}
+
+func (c dryRunClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	return c.Client.Delete(ctx, obj, append(opts, client.DryRunAll)...)
+}
+
+// RenderJobs returns the flyway jobs a forward run of the migration creates, one per target in rollout order.
+// Nothing is written to the cluster, rollbacks and backups are not rendered since they depend on the databases.
+func RenderJobs(ctx context.Context, c client.Client, scheme *runtime.Scheme, migration *migrationsv1alpha1.Migration) ([]*batchv1.Job, error) {
+	migration = migration.DeepCopy()
+	migration.Default()
+	r := &MigrationReconciler{Client: dryRunClient{c}, APIReader: c, Scheme: scheme}
+
+	if GetScriptsLocation(&migration.Spec.SQL, migration.Status.Revision) == nil {
+		return nil, newMigrationError(ReasonScriptsLocationInvalid, "unable to detect sql scripts location")
+	}
+	targets, err := r.resolveTargets(ctx, migration)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*batchv1.Job, 0, len(targets))
+	for _, target := range targets {
+		driver, err := GetDriver(&target.db)
+		if err != nil {
+			return nil, err
+		}
+		if target.creds == nil {
+			return nil, newMigrationError(ReasonCredentialsMissing, "no db credentials source is set for database %s", target.name)
+		}
+		target.creds = withTokenSecret(target.creds, target.job)
+		job := buildJob(migration, &flywayRun{target: target, driver: driver})
+		if err := ctrl.SetControllerReference(migration, job, scheme); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// RequesterStamper replaces the value of the rerun and approve annotations with the user who set them,
+// the operator records it as the requester of the run whatever the client wrote
+type RequesterStamper struct {
+	Log logr.Logger
+}
+
+// RequesterStamperPath serves the requester stamper, for every version of the migrations
+const RequesterStamperPath = "/mutate-migrations-flywayoperator-io-requester"
+
+// requesterAnnotations are stamped with the user setting them
+var requesterAnnotations = []string{migrationsv1alpha1.RerunAnnotation, migrationsv1alpha1.ApproveAnnotation}
+
+// +kubebuilder:webhook:path=/mutate-migrations-flywayoperator-io-requester,mutating=true,failurePolicy=fail,groups=migrations.flywayoperator.io,resources=migrations,verbs=create;update,versions=v1alpha1;v1beta1,name=mrequester.flywayoperator.io
+
+func (s *RequesterStamper) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var object, old unstructured.Unstructured
+	if err := object.UnmarshalJSON(req.Object.Raw); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if len(req.OldObject.Raw) > 0 {
+		if err := old.UnmarshalJSON(req.OldObject.Raw); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	}
+
+	annotations, previous := object.GetAnnotations(), old.GetAnnotations()
+	stamped := false
+	for _, key := range requesterAnnotations {
+		value, ok := annotations[key]
+		// an annotation left untouched by the request keeps the user stamped when it was set
+		if !ok || value == req.UserInfo.Username {
+			continue
+		}
+		if previousValue, set := previous[key]; set && previousValue == value {
+			continue
+		}
+		annotations[key] = req.UserInfo.Username
+		stamped = true
+	}
+	if !stamped {
+		return admission.Allowed("no requester to stamp")
+	}
+
+	object.SetAnnotations(annotations)
+	marshaled, err := object.MarshalJSON()
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	s.Log.Info("stamped requester", "namespace", req.Namespace, "migration", req.Name, "user", req.UserInfo.Username)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
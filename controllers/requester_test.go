@@ -0,0 +1,87 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("RequesterStamper", func() {
+	const (
+		rerunPath   = "/metadata/annotations/migrations.flywayoperator.io~1rerun"
+		approvePath = "/metadata/annotations/migrations.flywayoperator.io~1approve"
+	)
+
+	raw := func(annotations map[string]string) []byte {
+		if annotations == nil {
+			return nil
+		}
+		migration := migrationsv1alpha1.Migration{
+			TypeMeta:   metav1.TypeMeta{APIVersion: migrationsv1alpha1.GroupVersion.String(), Kind: "Migration"},
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop", Annotations: annotations},
+		}
+		encoded, err := json.Marshal(&migration)
+		Expect(err).NotTo(HaveOccurred())
+		return encoded
+	}
+	handle := func(user string, annotations, old map[string]string) map[string]interface{} {
+		stamper := &RequesterStamper{Log: logf.Log}
+		response := stamper.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+			Namespace: "shop",
+			Name:      "orders",
+			UserInfo:  authenticationv1.UserInfo{Username: user},
+			Object:    runtime.RawExtension{Raw: raw(annotations)},
+			OldObject: runtime.RawExtension{Raw: raw(old)},
+		}})
+		Expect(response.Allowed).To(BeTrue())
+		patches := map[string]interface{}{}
+		for _, patch := range response.Patches {
+			patches[patch.Path] = patch.Value
+		}
+		return patches
+	}
+
+	It("replaces the requester the client wrote with the user of the request", func() {
+		patches := handle("alice", map[string]string{
+			migrationsv1alpha1.RerunAnnotation:   "kubectl-flyway",
+			migrationsv1alpha1.ApproveAnnotation: "bob",
+		}, nil)
+		Expect(patches).To(Equal(map[string]interface{}{rerunPath: "alice", approvePath: "alice"}))
+	})
+
+	It("stamps the annotations set by an update", func() {
+		patches := handle("alice", map[string]string{migrationsv1alpha1.ApproveAnnotation: "bob"}, map[string]string{"team": "orders"})
+		Expect(patches).To(Equal(map[string]interface{}{approvePath: "alice"}))
+	})
+
+	It("keeps the requester of the annotations the request left untouched", func() {
+		Expect(handle("bob", map[string]string{migrationsv1alpha1.RerunAnnotation: "alice"}, map[string]string{migrationsv1alpha1.RerunAnnotation: "alice"})).To(BeEmpty())
+		Expect(handle("bob", map[string]string{"team": "orders"}, nil)).To(BeEmpty())
+	})
+})
@@ -0,0 +1,141 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	restoreContainerName  = "restore"
+	downloadContainerName = "download"
+	// restoreBackupLabel marks restore jobs so that they are never pruned as backups
+	restoreBackupLabel = "restore"
+	restoreDumpFile    = "restore.dump"
+)
+
+// restorable reports whether a failed run of the target is restored from the dump taken before it,
+// and why it is not when the migration asks for it
+func restorable(migration *migrationsv1alpha1.Migration, status *migrationsv1alpha1.TargetStatus) (bool, string) {
+	if migration.Spec.OnFailure != migrationsv1alpha1.OnFailureRestore {
+		return false, ""
+	}
+	backup := status.Backup
+	switch {
+	case migration.Spec.Backup == nil || backup == nil:
+		return false, "no backup was taken before the run"
+	case migration.Spec.Backup.VolumeSnapshot != nil:
+		return false, fmt.Sprintf("volume snapshots are not restored automatically, restore %s by hand", backup.Name)
+	case backup.Phase != migrationsv1alpha1.MigrationSucceeded || backup.Location == "":
+		return false, fmt.Sprintf("backup %s did not succeed", backup.Name)
+	}
+	return true, ""
+}
+
+// startRestore restores the database of a failed target from its backup, the target stays locked until the restore is over
+func (r *MigrationReconciler) startRestore(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus) error {
+	name := strings.Replace(status.Backup.Name, "-backup-", "-restore-", 1)
+	if err := r.issueToken(ctx, migration, run); err != nil {
+		return err
+	}
+	job, err := buildRestoreJob(migration, run, name, status.Backup.Location)
+	if err != nil {
+		return err
+	}
+	if err := ctrl.SetControllerReference(migration, job, r.Scheme); err != nil {
+		return err
+	}
+	if err := createJob(ctx, r.Client, job); err != nil && !apierrors.IsAlreadyExists(err) {
+		return err
+	}
+
+	r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonRestoreStarted, "restoring %s from %s", run.target.name, status.Backup.Location)
+	started := metav1.NewTime(time.Now())
+	status.Phase = migrationsv1alpha1.MigrationRunning
+	status.Restore = &migrationsv1alpha1.BackupStatus{
+		Name:      name,
+		Phase:     migrationsv1alpha1.MigrationRunning,
+		Location:  status.Backup.Location,
+		StartedAt: &started,
+	}
+	return nil
+}
+
+// updateRestore follows the restore of a target, the target is rolled back once it succeeded
+// and keeps the flyway error of the failed run in its message
+func (r *MigrationReconciler) updateRestore(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus) error {
+	restore := status.Restore
+	phase := migrationsv1alpha1.MigrationFailed
+	var job batchv1.Job
+	err := r.Get(ctx, client.ObjectKey{Namespace: migration.ObjectMeta.Namespace, Name: restore.Name}, &job)
+	if err == nil {
+		phase = jobPhase(&job)
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+	if phase == migrationsv1alpha1.MigrationRunning {
+		return nil
+	}
+
+	completed := metav1.Now()
+	restore.CompletedAt = &completed
+	restore.Phase = phase
+	if phase == migrationsv1alpha1.MigrationFailed {
+		restore.Message = "the restore job failed"
+		if err != nil {
+			restore.Message = "the restore job was deleted"
+		}
+		status.Phase = migrationsv1alpha1.MigrationFailed
+		r.Recorder.Eventf(migration, corev1.EventTypeWarning, ReasonRestoreFailed, "restore of %s from %s failed: %s", run.target.name, restore.Location, restore.Message)
+	} else {
+		status.Phase = migrationsv1alpha1.MigrationRolledBack
+		r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonRestoreSucceeded, "%s restored from %s", run.target.name, restore.Location)
+	}
+
+	previousVersion := status.CurrentVersion
+	r.readHistory(migration, run, status)
+	recordSchemaState(metricLabels(migration, run.target), status, previousVersion)
+	return r.unlockTarget(ctx, migration, run.target)
+}
+
+// buildRestoreJob renders the job restoring the database of a target from the dump at the location
+func buildRestoreJob(migration *migrationsv1alpha1.Migration, run *flywayRun, name, location string) (*batchv1.Job, error) {
+	dumper, ok := run.driver.(Dumper)
+	if !ok {
+		return nil, newMigrationError(ReasonBackupUnsupported, "driver %s cannot restore databases", run.target.db.Driver)
+	}
+	spec := migration.Spec.Backup
+	image := spec.Image
+	if image == "" {
+		image = dumper.DumpImage()
+	}
+
+	job := newDatabaseJob(migration, run, name, restoreBackupLabel, restoreContainerName, image)
+	restore := &job.Spec.Template.Spec.Containers[0]
+
+	if spec.VolumeClaim != "" {
+		file := strings.TrimPrefix(location, pvcScheme+spec.VolumeClaim+"/")
+		restore.Command = []string{"sh", "-c", dumper.RestoreCommand(&run.target.db, path.Join(backupMountPath, file))}
+		job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, backupVolume(&corev1.PersistentVolumeClaimVolumeSource{ClaimName: spec.VolumeClaim, ReadOnly: true}))
+		return job, nil
+	}
+
+	// the dump is downloaded to a scratch volume before the restore
+	file := path.Join(backupMountPath, restoreDumpFile)
+	restore.Command = []string{"sh", "-c", dumper.RestoreCommand(&run.target.db, file)}
+	download := objectStorageContainer(downloadContainerName, spec.ObjectStorage, awsCLI(spec.ObjectStorage)+" s3 cp "+shellQuote(location)+" "+shellQuote(file))
+	job.Spec.Template.Spec.InitContainers = append(job.Spec.Template.Spec.InitContainers, download)
+	job.Spec.Template.Spec.Volumes = append(job.Spec.Template.Spec.Volumes, backupVolume(nil))
+	return job, nil
+}
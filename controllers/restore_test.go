@@ -0,0 +1,110 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("restore", func() {
+	var (
+		migration *migrationsv1alpha1.Migration
+		run       *flywayRun
+		status    *migrationsv1alpha1.TargetStatus
+	)
+
+	BeforeEach(func() {
+		migration = &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				Backup:    &migrationsv1alpha1.BackupSpec{VolumeClaim: "backups"},
+				OnFailure: migrationsv1alpha1.OnFailureRestore,
+			},
+		}
+		db := migrationsv1alpha1.DBSpec{Host: "db", Port: 5432, DBName: "orders", Driver: migrationsv1alpha1.PostgresDriver,
+			Secret: &migrationsv1alpha1.SecretSpec{Name: "db", UserKey: "user", PasswordKey: "password"}}
+		run = &flywayRun{
+			target: &migrationTarget{name: "orders", job: "flyway-orders", db: db, creds: GetCredentials(&db, "shop")},
+			driver: PostgresDriver{},
+		}
+		status = &migrationsv1alpha1.TargetStatus{
+			Backup: &migrationsv1alpha1.BackupStatus{
+				Name:     "flyway-orders-backup-20200101000000",
+				Phase:    migrationsv1alpha1.MigrationSucceeded,
+				Location: "pvc://backups/orders/orders/20200101000000.dump",
+			},
+		}
+	})
+
+	It("restores only failed runs with a succeeded dump when asked to", func() {
+		restore, reason := restorable(migration, status)
+		Expect(restore).To(BeTrue())
+		Expect(reason).To(BeEmpty())
+
+		status.Backup.Phase = migrationsv1alpha1.MigrationFailed
+		restore, reason = restorable(migration, status)
+		Expect(restore).To(BeFalse())
+		Expect(reason).To(Equal("backup flyway-orders-backup-20200101000000 did not succeed"))
+
+		status.Backup.Phase = migrationsv1alpha1.MigrationSucceeded
+		migration.Spec.OnFailure = migrationsv1alpha1.OnFailureFail
+		restore, reason = restorable(migration, status)
+		Expect(restore).To(BeFalse())
+		Expect(reason).To(BeEmpty())
+	})
+
+	It("says why volume snapshots are not restored", func() {
+		migration.Spec.Backup = &migrationsv1alpha1.BackupSpec{VolumeSnapshot: &migrationsv1alpha1.VolumeSnapshotSpec{ClaimName: "data"}}
+		restore, reason := restorable(migration, status)
+		Expect(restore).To(BeFalse())
+		Expect(reason).To(ContainSubstring("restore flyway-orders-backup-20200101000000 by hand"))
+	})
+
+	It("restores from the dump of the volume claim", func() {
+		job, err := buildRestoreJob(migration, run, "flyway-orders-restore-20200101000000", status.Backup.Location)
+		Expect(err).NotTo(HaveOccurred())
+
+		Expect(job.ObjectMeta.Labels).To(HaveKeyWithValue(migrationsv1alpha1.BackupLabel, "restore"))
+		Expect(job.Spec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName).To(Equal("backups"))
+		restore := job.Spec.Template.Spec.Containers[0]
+		Expect(restore.Command[2]).To(HavePrefix("pg_restore --clean --if-exists --file=/tmp/restore.sql '/backup/orders/orders/20200101000000.dump' && "))
+		Expect(restore.Command[2]).To(ContainSubstring("psql --host='db' --port=5432"))
+		Expect(restore.Command[2]).To(ContainSubstring("DROP SCHEMA %I CASCADE"))
+		Expect(restore.Command[2]).To(HaveSuffix("--single-transaction --command=" + shellQuote(postgresDropOwned) + " --file=/tmp/restore.sql"))
+		Expect(restore.Env).To(HaveLen(2))
+	})
+
+	It("downloads the dump from object storage before the restore", func() {
+		migration.Spec.Backup = &migrationsv1alpha1.BackupSpec{
+			ObjectStorage: &migrationsv1alpha1.ObjectStorageSpec{URL: "s3://backups/flyway", Secret: "s3"},
+		}
+		location := "s3://backups/flyway/shop/orders/orders/20200101000000.dump"
+		job, err := buildRestoreJob(migration, run, "flyway-orders-restore-20200101000000", location)
+		Expect(err).NotTo(HaveOccurred())
+
+		spec := job.Spec.Template.Spec
+		Expect(spec.InitContainers).To(HaveLen(1))
+		Expect(spec.InitContainers[0].Command[2]).To(Equal("aws s3 cp '" + location + "' '/backup/restore.dump'"))
+		Expect(spec.InitContainers[0].EnvFrom[0].SecretRef.Name).To(Equal("s3"))
+		Expect(spec.Containers[0].Command[2]).To(ContainSubstring("'/backup/restore.dump' && "))
+		Expect(spec.Volumes[0].EmptyDir).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,175 @@
+package controllers
+
+import (
+	"context"
+	"path"
+	"sort"
+	"strings"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+// flywayTeamsImage is the repository of the flyway images shipping every edition
+const flywayTeamsImage = "redgate/flyway"
+
+// rollbackPlan lists the versions of a target above the spec target, the most recent first
+type rollbackPlan struct {
+	steps []migrationsv1alpha1.RollbackStep
+}
+
+// planRollback returns the versions to revert when the spec target is below the applied version of the target,
+// nil when the run moves forward
+func (r *MigrationReconciler) planRollback(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun) (*rollbackPlan, error) {
+	target := migration.Spec.TargetVersion
+	if target == "" {
+		return nil, nil
+	}
+	entries, err := run.driver.SchemaHistory(&run.target.db, run.userPass, run.dialer, &migration.Spec.History)
+	if err != nil {
+		return nil, newTransientError(ReasonDatabaseUnreachable, "unable to read the schema history of %s: %v", run.target.name, err)
+	}
+
+	var versions []string
+	for version := range appliedVersions(entries) {
+		if compareVersions(version, target) > 0 {
+			versions = append(versions, version)
+		}
+	}
+	if len(versions) == 0 {
+		return nil, nil
+	}
+	sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) > 0 })
+	if !migration.Spec.Rollback.AllowDowngrade {
+		return nil, newMigrationError(ReasonDowngradeRefused, "target %s is below version %s applied on %s, rollback.allowDowngrade must be set to roll it back",
+			target, versions[0], run.target.name)
+	}
+
+	files, err := loadScripts(ctx, r.APIReader, migration.ObjectMeta.Namespace, &migration.Spec.SQL, migration.Status.Revision)
+	if err != nil {
+		return nil, err
+	}
+	return rollbackSteps(files, versions, migration.Spec.Rollback.CompensatingScripts, flywayTeams(&migration.Spec))
+}
+
+// rollbackSteps reverts the versions with flyway undo when undo is available and every version has an undo script,
+// with the compensating scripts of the directory otherwise
+func rollbackSteps(files map[string][]byte, versions []string, compensatingDir string, undo bool) (*rollbackPlan, error) {
+	if !undo && compensatingDir == "" {
+		return nil, newMigrationError(ReasonRollbackUnavailable, "flyway undo requires Flyway Teams, rollback.licenseKey or a %s image must be set when no compensating scripts are", flywayTeamsImage)
+	}
+	if undo {
+		steps, missing := scriptSteps(scriptsByVersion(files, undoScriptPrefix), versions, migrationsv1alpha1.RollbackUndo)
+		if missing == "" {
+			return &rollbackPlan{steps: steps}, nil
+		}
+		if compensatingDir == "" {
+			return nil, newMigrationError(ReasonRollbackUnavailable, "no undo script reverts version %s and no compensating scripts are set", missing)
+		}
+	}
+
+	dir := path.Clean(compensatingDir)
+	compensating := map[string][]byte{}
+	for name, content := range files {
+		if path.Dir(name) == dir {
+			compensating[name] = content
+		}
+	}
+	steps, missing := scriptSteps(scriptsByVersion(compensating, compensatingPrefix), versions, migrationsv1alpha1.RollbackCompensate)
+	if missing != "" {
+		return nil, newMigrationError(ReasonRollbackUnavailable, "neither an undo script nor a compensating script in %s reverts version %s", dir, missing)
+	}
+	return &rollbackPlan{steps: steps}, nil
+}
+
+// scriptSteps returns the steps reverting the versions with the scripts, or the first version without script
+func scriptSteps(scripts map[string]script, versions []string, method migrationsv1alpha1.RollbackMethod) ([]migrationsv1alpha1.RollbackStep, string) {
+	steps := make([]migrationsv1alpha1.RollbackStep, 0, len(versions))
+	for _, version := range versions {
+		script, ok := scripts[versionKey(version)]
+		if !ok {
+			return nil, version
+		}
+		steps = append(steps, migrationsv1alpha1.RollbackStep{
+			Version: version,
+			Method:  method,
+			Script:  script.name,
+			Phase:   migrationsv1alpha1.MigrationPending,
+		})
+	}
+	return steps, ""
+}
+
+// buildCompensateJob renders the job executing the compensating scripts of the steps in order,
+// each one in its own transaction along with the removal of its version from the schema history
+func buildCompensateJob(migration *migrationsv1alpha1.Migration, run *flywayRun, steps []migrationsv1alpha1.RollbackStep) (*batchv1.Job, error) {
+	compensator, ok := run.driver.(Compensator)
+	if !ok {
+		return nil, newMigrationError(ReasonRollbackUnavailable, "driver %s cannot execute compensating scripts", run.target.db.Driver)
+	}
+	commands := make([]string, 0, len(steps))
+	for _, step := range steps {
+		commands = append(commands, compensator.CompensateCommand(&run.target.db, &migration.Spec.History, step.Version, path.Join(sqlMountPath, step.Script)))
+	}
+
+	// the job runs like the flyway jobs so that its outcome is followed the same way, the first failure stops it
+	job := buildJob(migration, run)
+	container := &job.Spec.Template.Spec.Containers[0]
+	container.Image = compensator.CompensateImage()
+	container.Command = []string{"sh", "-c", strings.Join(commands, " && ")}
+	container.Args = nil
+	return job, nil
+}
+
+// flywayTeams reports whether the flyway jobs run Flyway Teams, which flyway undo requires
+func flywayTeams(spec *migrationsv1alpha1.MigrationSpec) bool {
+	return spec.Rollback.LicenseKey != nil || strings.HasPrefix(spec.Image, flywayTeamsImage)
+}
+
+// finishRollback settles the rollback steps of a finished job, a step succeeded once its version is no longer applied
+func finishRollback(status *migrationsv1alpha1.TargetStatus, finished metav1.Time) {
+	for i := range status.RollbackSteps {
+		step := &status.RollbackSteps[i]
+		if step.Phase != migrationsv1alpha1.MigrationRunning {
+			continue
+		}
+		step.FinishedAt = &finished
+		step.Phase = migrationsv1alpha1.MigrationSucceeded
+		if compareVersions(step.Version, status.CurrentVersion) <= 0 {
+			step.Phase = migrationsv1alpha1.MigrationFailed
+		}
+	}
+}
+
+// followTarget starts a new run on the finished targets whose latest run aimed at another version than the spec
+func (r *MigrationReconciler) followTarget(ctx context.Context, migration *migrationsv1alpha1.Migration, targets []*migrationTarget) (ctrl.Result, error) {
+	if !runOver(migration.Status.Phase) {
+		return ctrl.Result{}, nil
+	}
+
+	moved, err := r.restartTargets(ctx, migration, targets, func(status *migrationsv1alpha1.TargetStatus) bool {
+		return status.Target != migration.Spec.TargetVersion && runOver(status.Phase)
+	})
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	if len(moved) == 0 {
+		return ctrl.Result{}, nil
+	}
+
+	target := migration.Spec.TargetVersion
+	if target == "" {
+		target = "latest"
+	}
+	r.Recorder.Eventf(migration, corev1.EventTypeNormal, ReasonTargetChanged, "target moved to version %s, starting a new run on %s", target, strings.Join(moved, ", "))
+	triggerRollout(migration, migrationsv1alpha1.TriggerTargetChanged, "")
+	migration.Status.Phase = migrationsv1alpha1.MigrationPending
+	if err := r.Status().Update(ctx, migration); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{Requeue: true}, nil
+}
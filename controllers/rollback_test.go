@@ -0,0 +1,131 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"strings"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var _ = Describe("rollback", func() {
+	files := map[string][]byte{
+		"V1__init.sql":            []byte("create table a;"),
+		"V2__add_b.sql":           []byte("create table b;"),
+		"V3__add_c.sql":           []byte("create table c;"),
+		"U3__drop_c.sql":          []byte("drop table c;"),
+		"rollback/C2__drop_b.sql": []byte("drop table b;"),
+		"rollback/C3__drop_c.sql": []byte("drop table c;"),
+	}
+
+	It("undoes the versions when every one has an undo script", func() {
+		plan, err := rollbackSteps(files, []string{"3"}, "rollback", true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.steps).To(Equal([]migrationsv1alpha1.RollbackStep{
+			{Version: "3", Method: migrationsv1alpha1.RollbackUndo, Script: "U3__drop_c.sql", Phase: migrationsv1alpha1.MigrationPending},
+		}))
+	})
+
+	It("falls back to the compensating scripts, the most recent version first", func() {
+		plan, err := rollbackSteps(files, []string{"3", "2"}, "rollback/", true)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.steps).To(HaveLen(2))
+		Expect(plan.steps[0].Script).To(Equal("rollback/C3__drop_c.sql"))
+		Expect(plan.steps[1].Method).To(Equal(migrationsv1alpha1.RollbackCompensate))
+	})
+
+	It("only undoes the versions with Flyway Teams", func() {
+		plan, err := rollbackSteps(files, []string{"3"}, "rollback", false)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(plan.steps[0].Method).To(Equal(migrationsv1alpha1.RollbackCompensate))
+		Expect(plan.steps[0].Script).To(Equal("rollback/C3__drop_c.sql"))
+
+		_, err = rollbackSteps(files, []string{"3"}, "", false)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonRollbackUnavailable))
+
+		Expect(flywayTeams(&migrationsv1alpha1.MigrationSpec{Image: "flyway/flyway"})).To(BeFalse())
+		Expect(flywayTeams(&migrationsv1alpha1.MigrationSpec{Image: "redgate/flyway:10"})).To(BeTrue())
+		Expect(flywayTeams(&migrationsv1alpha1.MigrationSpec{
+			Rollback: migrationsv1alpha1.RollbackSpec{LicenseKey: &corev1.SecretKeySelector{Key: "key"}},
+		})).To(BeTrue())
+	})
+
+	It("executes the compensating scripts in a job, the most recent version first", func() {
+		spec := migrationsv1alpha1.DBSpec{
+			Driver: migrationsv1alpha1.PostgresDriver, Host: "orders", Port: 5432, DBName: "orders",
+			Secret: &migrationsv1alpha1.SecretSpec{Name: "orders"},
+		}
+		migration := &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				SQL:     migrationsv1alpha1.SQLSpec{VolumeClaim: "scripts"},
+				History: migrationsv1alpha1.HistorySpec{Table: "flyway_schema_history"},
+			},
+		}
+		run := &flywayRun{
+			target: &migrationTarget{name: "orders", job: "orders", db: spec, creds: GetCredentials(&spec, "shop")},
+			driver: PostgresDriver{},
+		}
+		plan, err := rollbackSteps(files, []string{"3", "2"}, "rollback", false)
+		Expect(err).NotTo(HaveOccurred())
+
+		job, err := buildCompensateJob(migration, run, plan.steps)
+		Expect(err).NotTo(HaveOccurred())
+		container := job.Spec.Template.Spec.Containers[0]
+		Expect(container.Image).To(Equal(PostgresDriver{}.CompensateImage()))
+		Expect(container.Args).To(BeEmpty())
+		Expect(container.Command).To(HaveLen(3))
+		commands := strings.Split(container.Command[2], " && ")
+		Expect(commands).To(HaveLen(2))
+		Expect(commands[0]).To(ContainSubstring("--single-transaction --file='/flyway/sql/rollback/C3__drop_c.sql'"))
+		Expect(commands[0]).To(ContainSubstring(`--command='DELETE FROM "flyway_schema_history" WHERE version = '"'"'3'"'"''`))
+		Expect(commands[1]).To(ContainSubstring("/flyway/sql/rollback/C2__drop_b.sql"))
+		Expect(container.VolumeMounts).To(ContainElement(corev1.VolumeMount{Name: SQLVolumeName, MountPath: sqlMountPath}))
+	})
+
+	It("refuses versions no script reverts", func() {
+		_, err := rollbackSteps(files, []string{"3", "2", "1"}, "rollback", true)
+		Expect(err).To(HaveOccurred())
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonRollbackUnavailable))
+
+		_, err = rollbackSteps(files, []string{"2"}, "", true)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("settles the undo steps from the version applied after the job", func() {
+		status := migrationsv1alpha1.TargetStatus{
+			CurrentVersion: "2",
+			RollbackSteps: []migrationsv1alpha1.RollbackStep{
+				{Version: "4", Phase: migrationsv1alpha1.MigrationRunning},
+				{Version: "3", Phase: migrationsv1alpha1.MigrationRunning},
+				{Version: "2", Phase: migrationsv1alpha1.MigrationRunning},
+			},
+		}
+		finishRollback(&status, metav1.Now())
+
+		Expect(status.RollbackSteps[0].Phase).To(Equal(migrationsv1alpha1.MigrationSucceeded))
+		Expect(status.RollbackSteps[1].Phase).To(Equal(migrationsv1alpha1.MigrationSucceeded))
+		Expect(status.RollbackSteps[2].Phase).To(Equal(migrationsv1alpha1.MigrationFailed))
+		Expect(status.RollbackSteps[2].FinishedAt).NotTo(BeNil())
+	})
+})
@@ -0,0 +1,89 @@
+package controllers
+
+import (
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+// planRollout returns the indexes of the targets to start now and the phase of the whole rollout.
+// Targets are split in waves of the configured size, a wave starts once every target of the previous
+// one is over and at most maxParallel targets run at the same time.
+func planRollout(strategy migrationsv1alpha1.RolloutStrategy, targets []migrationsv1alpha1.TargetStatus) ([]int, migrationsv1alpha1.MigrationPhase) {
+	running, failed, rolledBack := 0, false, false
+	for _, target := range targets {
+		switch target.Phase {
+		case migrationsv1alpha1.MigrationRunning:
+			running++
+		case migrationsv1alpha1.MigrationFailed:
+			failed = true
+		case migrationsv1alpha1.MigrationRolledBack:
+			rolledBack = true
+		}
+	}
+	// restored targets fail the rollout the same way, the rollout is only reported failed
+	// when a database was left as its run left it
+	failure := migrationsv1alpha1.MigrationRolledBack
+	if failed {
+		failure = migrationsv1alpha1.MigrationFailed
+	}
+
+	if (failed || rolledBack) && strategy.FailurePolicy != migrationsv1alpha1.RolloutContinue {
+		if running > 0 {
+			return nil, migrationsv1alpha1.MigrationRunning
+		}
+		return nil, failure
+	}
+
+	waveSize := int(strategy.WaveSize)
+	if waveSize <= 0 {
+		waveSize = len(targets)
+	}
+	for start := 0; start < len(targets); start += waveSize {
+		end := start + waveSize
+		if end > len(targets) {
+			end = len(targets)
+		}
+
+		var next []int
+		over := true
+		for i := start; i < end; i++ {
+			switch targets[i].Phase {
+			case migrationsv1alpha1.MigrationSucceeded, migrationsv1alpha1.MigrationFailed, migrationsv1alpha1.MigrationRolledBack:
+			case migrationsv1alpha1.MigrationRunning:
+				over = false
+			default:
+				over = false
+				if strategy.MaxParallel <= 0 || running < int(strategy.MaxParallel) {
+					next = append(next, i)
+					running++
+				}
+			}
+		}
+		if !over {
+			return next, migrationsv1alpha1.MigrationRunning
+		}
+	}
+
+	if failed || rolledBack {
+		return nil, failure
+	}
+	return nil, migrationsv1alpha1.MigrationSucceeded
+}
+
+// runOver tells whether a run ended, successfully or not
+func runOver(phase migrationsv1alpha1.MigrationPhase) bool {
+	switch phase {
+	case migrationsv1alpha1.MigrationSucceeded, migrationsv1alpha1.MigrationFailed, migrationsv1alpha1.MigrationRolledBack:
+		return true
+	}
+	return false
+}
+
+// rolloutStarted tells whether a target of the current rollout left the pending phase
+func rolloutStarted(status *migrationsv1alpha1.MigrationStatus) bool {
+	for _, target := range status.Targets {
+		if target.Phase != "" && target.Phase != migrationsv1alpha1.MigrationPending {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("planRollout", func() {
+	const (
+		pending   = migrationsv1alpha1.MigrationPending
+		running   = migrationsv1alpha1.MigrationRunning
+		succeeded = migrationsv1alpha1.MigrationSucceeded
+		failed    = migrationsv1alpha1.MigrationFailed
+		restored  = migrationsv1alpha1.MigrationRolledBack
+	)
+
+	targets := func(phases ...migrationsv1alpha1.MigrationPhase) []migrationsv1alpha1.TargetStatus {
+		statuses := make([]migrationsv1alpha1.TargetStatus, 0, len(phases))
+		for _, phase := range phases {
+			statuses = append(statuses, migrationsv1alpha1.TargetStatus{Phase: phase})
+		}
+		return statuses
+	}
+
+	table.DescribeTable("starts the next targets",
+		func(strategy migrationsv1alpha1.RolloutStrategy, statuses []migrationsv1alpha1.TargetStatus, next []int, phase migrationsv1alpha1.MigrationPhase) {
+			start, rolloutPhase := planRollout(strategy, statuses)
+			Expect(start).To(Equal(next))
+			Expect(rolloutPhase).To(Equal(phase))
+		},
+		table.Entry("all targets at once by default",
+			migrationsv1alpha1.RolloutStrategy{}, targets(pending, pending, pending), []int{0, 1, 2}, running),
+		table.Entry("no more than maxParallel targets",
+			migrationsv1alpha1.RolloutStrategy{MaxParallel: 2}, targets(running, pending, pending), []int{1}, running),
+		table.Entry("the next wave once the previous one is over",
+			migrationsv1alpha1.RolloutStrategy{WaveSize: 2}, targets(succeeded, succeeded, pending, pending, pending), []int{2, 3}, running),
+		table.Entry("not the next wave while the current one runs",
+			migrationsv1alpha1.RolloutStrategy{WaveSize: 2}, targets(succeeded, running, pending), nil, running),
+		table.Entry("nothing after a failure by default",
+			migrationsv1alpha1.RolloutStrategy{FailurePolicy: migrationsv1alpha1.RolloutStop}, targets(failed, running, pending), nil, running),
+		table.Entry("the remaining targets after a failure when continuing",
+			migrationsv1alpha1.RolloutStrategy{FailurePolicy: migrationsv1alpha1.RolloutContinue}, targets(failed, pending), []int{1}, running),
+		table.Entry("a failed rollout once stopped",
+			migrationsv1alpha1.RolloutStrategy{FailurePolicy: migrationsv1alpha1.RolloutStop}, targets(failed, succeeded, pending), nil, failed),
+		table.Entry("a failed rollout once every target ran",
+			migrationsv1alpha1.RolloutStrategy{FailurePolicy: migrationsv1alpha1.RolloutContinue}, targets(failed, succeeded), nil, failed),
+		table.Entry("a rolled back rollout once every failed target was restored",
+			migrationsv1alpha1.RolloutStrategy{FailurePolicy: migrationsv1alpha1.RolloutStop}, targets(restored, succeeded, pending), nil, restored),
+		table.Entry("a failed rollout when a restore failed",
+			migrationsv1alpha1.RolloutStrategy{FailurePolicy: migrationsv1alpha1.RolloutContinue}, targets(restored, failed), nil, failed),
+		table.Entry("a succeeded rollout",
+			migrationsv1alpha1.RolloutStrategy{WaveSize: 1}, targets(succeeded, succeeded), nil, succeeded),
+	)
+})
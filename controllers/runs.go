@@ -0,0 +1,204 @@
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// runUIDLength is the number of hexadecimal digits of the migration UID digest in run names
+const runUIDLength = 5
+
+// rolloutTrigger returns what started the current rollout, the creation of the migration when nothing else did
+func rolloutTrigger(migration *migrationsv1alpha1.Migration) *migrationsv1alpha1.RunTrigger {
+	if migration.Status.Trigger == nil {
+		migration.Status.Trigger = &migrationsv1alpha1.RunTrigger{
+			Reason: migrationsv1alpha1.TriggerCreated,
+			Time:   migration.ObjectMeta.CreationTimestamp,
+		}
+	}
+	return migration.Status.Trigger
+}
+
+// triggerRollout records what starts the next rollout
+func triggerRollout(migration *migrationsv1alpha1.Migration, reason migrationsv1alpha1.TriggerReason, user string) {
+	migration.Status.Trigger = &migrationsv1alpha1.RunTrigger{Reason: reason, User: user, Time: metav1.Now()}
+}
+
+// createRun records a run about to start on a locked target, the record is created first so that no run goes unrecorded
+func (r *MigrationReconciler) createRun(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun, status *migrationsv1alpha1.TargetStatus, command migrationsv1alpha1.RunCommand, job string) (*migrationsv1alpha1.MigrationRun, error) {
+	source, err := r.runSource(ctx, migration)
+	if err != nil {
+		return nil, err
+	}
+	record := buildRun(migration, run, runName(migration, run.target, status), command, job)
+	record.Spec.Source = source
+	if err := ctrl.SetControllerReference(migration, record, r.Scheme); err != nil {
+		return nil, err
+	}
+	// a record left by an attempt whose run could not start is taken over
+	if err := r.Create(ctx, record); apierrors.IsAlreadyExists(err) {
+		if err := r.Get(ctx, client.ObjectKey{Namespace: record.ObjectMeta.Namespace, Name: record.ObjectMeta.Name}, record); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	started := metav1.Now()
+	record.Status = migrationsv1alpha1.MigrationRunStatus{
+		Phase:          migrationsv1alpha1.MigrationRunning,
+		StartedAt:      &started,
+		InitialVersion: status.CurrentVersion,
+	}
+	if err := r.Status().Update(ctx, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// runName names the record and the job of the next run on a target. The names hold the UID of the migration, the runs of a
+// migration created again under the same name, or of another migration whose name and target join the same way, never
+// take over the jobs and records of its runs.
+func runName(migration *migrationsv1alpha1.Migration, target *migrationTarget, status *migrationsv1alpha1.TargetStatus) string {
+	return fmt.Sprintf("%s-%s-%d", target.job, nameDigest(string(migration.ObjectMeta.UID)), status.Runs+1)
+}
+
+// nameDigest is the start of the hexadecimal sha256 digest of a value, which keeps the names built from it unique
+func nameDigest(value string) string {
+	digest := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(digest[:])[:runUIDLength]
+}
+
+// startRun points the target to the record of the run that started
+func startRun(status *migrationsv1alpha1.TargetStatus, record *migrationsv1alpha1.MigrationRun) {
+	status.Runs++
+	status.Run = record.ObjectMeta.Name
+}
+
+// discardRun deletes the record of a run that could not start
+func (r *MigrationReconciler) discardRun(ctx context.Context, record *migrationsv1alpha1.MigrationRun) {
+	if err := r.Delete(ctx, record); client.IgnoreNotFound(err) != nil {
+		r.Log.Error(err, "unable to delete the record of a run that did not start", "run", record.ObjectMeta.Name)
+	}
+}
+
+// buildRun renders the record of a run on a target
+func buildRun(migration *migrationsv1alpha1.Migration, run *flywayRun, name string, command migrationsv1alpha1.RunCommand, job string) *migrationsv1alpha1.MigrationRun {
+	return &migrationsv1alpha1.MigrationRun{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: migration.ObjectMeta.Namespace,
+			Labels: map[string]string{
+				migrationsv1alpha1.MigrationNameLabel: migration.ObjectMeta.Name,
+				migrationsv1alpha1.TargetNameLabel:    run.target.name,
+			},
+		},
+		Spec: migrationsv1alpha1.MigrationRunSpec{
+			Migration:     migration.ObjectMeta.Name,
+			Target:        run.target.name,
+			Database:      dbAddress(&run.target.db),
+			Command:       command,
+			Job:           job,
+			TargetVersion: migration.Spec.TargetVersion,
+			Trigger:       *rolloutTrigger(migration),
+			MigrationSpec: *migration.Spec.DeepCopy(),
+		},
+	}
+}
+
+// runSource identifies the scripts of the run, the scripts of a config map are hashed since it may change under the same name
+func (r *MigrationReconciler) runSource(ctx context.Context, migration *migrationsv1alpha1.Migration) (migrationsv1alpha1.RunSource, error) {
+	sql := &migration.Spec.SQL
+	if sql.Git != nil {
+		return migrationsv1alpha1.RunSource{Revision: migration.Status.Revision, Branch: sql.Git.Branch}, nil
+	}
+	files, err := loadScripts(ctx, r.APIReader, migration.ObjectMeta.Namespace, sql, "")
+	if err != nil {
+		return migrationsv1alpha1.RunSource{}, err
+	}
+	return migrationsv1alpha1.RunSource{ConfigMap: sql.VolumeClaim, Digest: scriptsDigest(files)}, nil
+}
+
+// scriptsDigest is the sha256 of the scripts in name order
+func scriptsDigest(files map[string][]byte) string {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	hash := sha256.New()
+	for _, name := range names {
+		hash.Write([]byte(name))
+		hash.Write([]byte{0})
+		hash.Write(files[name])
+		hash.Write([]byte{0})
+	}
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil))
+}
+
+// finishRun records the outcome of the latest run of a target and prunes the records beyond the history limit
+func (r *MigrationReconciler) finishRun(ctx context.Context, migration *migrationsv1alpha1.Migration, target *migrationTarget, status *migrationsv1alpha1.TargetStatus, phase migrationsv1alpha1.MigrationPhase, report FlywayReport) error {
+	// runs started before the records existed have none
+	if status.Run == "" {
+		return nil
+	}
+	var record migrationsv1alpha1.MigrationRun
+	err := r.Get(ctx, client.ObjectKey{Namespace: migration.ObjectMeta.Namespace, Name: status.Run}, &record)
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if err == nil && record.Status.CompletedAt == nil {
+		completeRun(&record.Status, status, phase, report, metav1.Now())
+		if err := r.Status().Update(ctx, &record); err != nil {
+			return err
+		}
+	}
+	return r.pruneRuns(ctx, migration, target)
+}
+
+// completeRun fills the outcome of a run from the status of its target once the run is over
+func completeRun(run *migrationsv1alpha1.MigrationRunStatus, status *migrationsv1alpha1.TargetStatus, phase migrationsv1alpha1.MigrationPhase, report FlywayReport, completed metav1.Time) {
+	run.Phase = phase
+	run.CompletedAt = &completed
+	if report.InitialVersion != "" {
+		run.InitialVersion = report.InitialVersion
+	}
+	run.SchemaVersion = status.CurrentVersion
+	run.ScriptsApplied = report.Applied
+	run.PendingMigrations = status.PendingMigrations
+	run.Message = status.Message
+	run.RollbackSteps = status.RollbackSteps
+}
+
+// pruneRuns deletes the oldest records of the target beyond the history limit
+func (r *MigrationReconciler) pruneRuns(ctx context.Context, migration *migrationsv1alpha1.Migration, target *migrationTarget) error {
+	var runs migrationsv1alpha1.MigrationRunList
+	if err := r.List(ctx, &runs, client.InNamespace(migration.ObjectMeta.Namespace), client.MatchingLabels{
+		migrationsv1alpha1.MigrationNameLabel: migration.ObjectMeta.Name,
+		migrationsv1alpha1.TargetNameLabel:    target.name,
+	}); err != nil {
+		return err
+	}
+
+	records := make([]metav1.Object, 0, len(runs.Items))
+	for i := range runs.Items {
+		records = append(records, &runs.Items[i])
+	}
+	for _, record := range expiredObjects(records, int(migration.Spec.History.RunLimit)) {
+		if err := r.Delete(ctx, record); client.IgnoreNotFound(err) != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("migration runs", func() {
+	ctx := context.Background()
+
+	var (
+		r         *MigrationReconciler
+		migration *migrationsv1alpha1.Migration
+		run       *flywayRun
+		status    *migrationsv1alpha1.TargetStatus
+	)
+
+	BeforeEach(func() {
+		migration = &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop", UID: "uid"},
+			Spec: migrationsv1alpha1.MigrationSpec{
+				SQL:     migrationsv1alpha1.SQLSpec{VolumeClaim: "scripts", Path: "/"},
+				History: migrationsv1alpha1.HistorySpec{RunLimit: 2},
+			},
+		}
+		db := migrationsv1alpha1.DBSpec{Host: "db", Port: 5432, DBName: "orders", Driver: migrationsv1alpha1.PostgresDriver}
+		run = &flywayRun{target: &migrationTarget{name: "orders", job: "flyway-orders", db: db}, driver: PostgresDriver{}}
+		status = &migrationsv1alpha1.TargetStatus{Name: "orders", CurrentVersion: "1"}
+
+		scripts := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "scripts", Namespace: "shop"},
+			Data:       map[string]string{"V2__orders.sql": "create table orders (id int);"},
+		}
+		c := fake.NewFakeClientWithScheme(scheme.Scheme, scripts)
+		r = &MigrationReconciler{Client: c, APIReader: c, Scheme: scheme.Scheme, Log: ctrl.Log, Recorder: record.NewFakeRecorder(10)}
+	})
+
+	It("records what a run executes before it starts", func() {
+		record, err := r.createRun(ctx, migration, run, status, migrationsv1alpha1.RunMigrate, "flyway-orders")
+		Expect(err).NotTo(HaveOccurred())
+		startRun(status, record)
+
+		var stored migrationsv1alpha1.MigrationRun
+		name := runName(migration, run.target, &migrationsv1alpha1.TargetStatus{})
+		Expect(name).To(MatchRegexp(`^flyway-orders-[0-9a-f]{5}-1$`))
+		Expect(r.Get(ctx, client.ObjectKey{Namespace: "shop", Name: name}, &stored)).To(Succeed())
+		Expect(stored.ObjectMeta.OwnerReferences).To(HaveLen(1))
+		Expect(stored.Spec.Database).To(Equal("db:5432/orders"))
+		Expect(stored.Spec.Command).To(Equal(migrationsv1alpha1.RunMigrate))
+		Expect(stored.Spec.Source.ConfigMap).To(Equal("scripts"))
+		Expect(stored.Spec.Source.Digest).To(HavePrefix("sha256:"))
+		Expect(stored.Spec.Trigger.Reason).To(Equal(migrationsv1alpha1.TriggerCreated))
+		Expect(stored.Spec.MigrationSpec.SQL.VolumeClaim).To(Equal("scripts"))
+		Expect(stored.Status.Phase).To(Equal(migrationsv1alpha1.MigrationRunning))
+		Expect(stored.Status.InitialVersion).To(Equal("1"))
+		Expect(status.Run).To(Equal(name))
+		Expect(status.Runs).To(Equal(int32(1)))
+	})
+
+	It("names the runs of every migration apart", func() {
+		recreated := migration.DeepCopy()
+		recreated.ObjectMeta.UID = "recreated"
+		Expect(runName(recreated, run.target, status)).NotTo(Equal(runName(migration, run.target, status)))
+		status.Runs = 1
+		Expect(runName(migration, run.target, status)).To(HaveSuffix("-2"))
+	})
+
+	It("records the trigger of the rollout and its approver", func() {
+		triggerRollout(migration, migrationsv1alpha1.TriggerRerun, "alice")
+		rolloutTrigger(migration).ApprovedBy = "bob"
+
+		record := buildRun(migration, run, "flyway-orders-1", migrationsv1alpha1.RunUndo, "flyway-orders")
+		Expect(record.Spec.Trigger.Reason).To(Equal(migrationsv1alpha1.TriggerRerun))
+		Expect(record.Spec.Trigger.User).To(Equal("alice"))
+		Expect(record.Spec.Trigger.ApprovedBy).To(Equal("bob"))
+	})
+
+	It("records the outcome of the run and prunes the oldest runs", func() {
+		now := time.Now()
+		names := make([]string, 3)
+		for i := range names {
+			names[i] = runName(migration, run.target, &migrationsv1alpha1.TargetStatus{Runs: int32(i)})
+		}
+		for i := 1; i <= 2; i++ {
+			old := buildRun(migration, run, names[i-1], migrationsv1alpha1.RunMigrate, "flyway-orders")
+			old.ObjectMeta.CreationTimestamp = metav1.NewTime(now.Add(-time.Duration(3-i) * time.Hour))
+			Expect(r.Create(ctx, old)).To(Succeed())
+		}
+		status.Runs = 2
+		record, err := r.createRun(ctx, migration, run, status, migrationsv1alpha1.RunMigrate, "flyway-orders")
+		Expect(err).NotTo(HaveOccurred())
+		startRun(status, record)
+		// the fake client leaves the creation timestamps to the test
+		record.ObjectMeta.CreationTimestamp = metav1.NewTime(now)
+		Expect(r.Update(ctx, record)).To(Succeed())
+
+		status.CurrentVersion = "2"
+		report := parseFlywayOutput("Current version of schema \"public\": 1\nSuccessfully applied 1 migration to schema \"public\", now at version v2 (execution time 00:00.012s)\nSchema version: 2\n")
+		Expect(r.finishRun(ctx, migration, run.target, status, migrationsv1alpha1.MigrationSucceeded, report)).To(Succeed())
+
+		var stored migrationsv1alpha1.MigrationRun
+		Expect(r.Get(ctx, client.ObjectKey{Namespace: "shop", Name: names[2]}, &stored)).To(Succeed())
+		Expect(stored.Status.Phase).To(Equal(migrationsv1alpha1.MigrationSucceeded))
+		Expect(stored.Status.CompletedAt).NotTo(BeNil())
+		Expect(stored.Status.InitialVersion).To(Equal("1"))
+		Expect(stored.Status.SchemaVersion).To(Equal("2"))
+		Expect(stored.Status.ScriptsApplied).To(Equal(int32(1)))
+
+		var runs migrationsv1alpha1.MigrationRunList
+		Expect(r.List(ctx, &runs, client.InNamespace("shop"))).To(Succeed())
+		Expect(runs.Items).To(HaveLen(2))
+		for _, item := range runs.Items {
+			Expect(item.ObjectMeta.Name).NotTo(Equal(names[0]))
+		}
+	})
+
+	It("digests the scripts regardless of their order", func() {
+		digest := scriptsDigest(map[string][]byte{"V1__a.sql": []byte("a"), "V2__b.sql": []byte("b")})
+		Expect(scriptsDigest(map[string][]byte{"V2__b.sql": []byte("b"), "V1__a.sql": []byte("a")})).To(Equal(digest))
+		Expect(scriptsDigest(map[string][]byte{"V1__a.sql": []byte("b"), "V2__b.sql": []byte("a")})).NotTo(Equal(digest))
+	})
+})
@@ -0,0 +1,136 @@
+package controllers
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+// maintenanceWindow is the state of the schedule of a migration at a given time
+type maintenanceWindow struct {
+	open bool
+	// closes is when the open window ends
+	closes time.Time
+	// next is when the next window opens while closed
+	next time.Time
+}
+
+// currentWindow tells whether a maintenance window of the schedule is open at the given time.
+// A window opens at every activation of one of the cron expressions and lasts the schedule duration.
+func currentWindow(spec *migrationsv1alpha1.ScheduleSpec, now time.Time) (maintenanceWindow, error) {
+	location := time.UTC
+	if spec.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(spec.TimeZone); err != nil {
+			return maintenanceWindow{}, newMigrationError(ReasonScheduleInvalid, "invalid schedule time zone %q: %v", spec.TimeZone, err)
+		}
+	}
+	now = now.In(location)
+
+	var window maintenanceWindow
+	for _, expression := range spec.Windows {
+		schedule, err := cron.ParseStandard(expression)
+		if err != nil {
+			return maintenanceWindow{}, newMigrationError(ReasonScheduleInvalid, "invalid schedule window %q: %v", expression, err)
+		}
+
+		// the last opening within one duration before now is the window now may fall in
+		opening := schedule.Next(now.Add(-spec.Duration.Duration))
+		if opening.IsZero() {
+			// the expression never fires
+			continue
+		}
+		for next := opening; !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+			opening = next
+		}
+		if !opening.After(now) {
+			closes := opening.Add(spec.Duration.Duration)
+			if closes.After(now) && (!window.open || closes.After(window.closes)) {
+				window.open = true
+				window.closes = closes
+			}
+			opening = schedule.Next(now)
+		}
+		if window.next.IsZero() || opening.Before(window.next) {
+			window.next = opening
+		}
+	}
+	return window, nil
+}
+
+// applySchedule holds the targets about to start until a maintenance window opens. It returns the targets
+// allowed to start now and the delay until the schedule needs the migration to be reconciled again.
+func (r *MigrationReconciler) applySchedule(migration *migrationsv1alpha1.Migration, next []int, runs []*flywayRun, now time.Time) ([]int, time.Duration, error) {
+	spec := migration.Spec.Schedule
+	migration.Status.NextWindow = nil
+	if spec == nil || len(next) == 0 {
+		return next, 0, nil
+	}
+
+	window, err := currentWindow(spec, now)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !window.open {
+		if window.next.IsZero() {
+			return nil, 0, newMigrationError(ReasonScheduleInvalid, "no maintenance window ever opens")
+		}
+		opens := metav1.NewTime(window.next)
+		migration.Status.NextWindow = &opens
+		return nil, window.next.Sub(now), nil
+	}
+
+	closes := metav1.NewTime(window.closes)
+	migration.Status.WindowCloses = &closes
+	migration.Status.SetCondition(migrationsv1alpha1.MigrationCondition{
+		Type:    migrationsv1alpha1.ConditionWindowExceeded,
+		Status:  corev1.ConditionFalse,
+		Reason:  ReasonWithinWindow,
+		Message: fmt.Sprintf("runs started in the maintenance window closing at %s", closes.Format(time.RFC3339)),
+	})
+	if spec.HardStop {
+		for _, i := range next {
+			runs[i].deadline = window.closes
+		}
+	}
+	return next, 0, nil
+}
+
+// checkWindow reports the runs still going once their maintenance window closed,
+// it returns the delay until the window of the running targets closes
+func (r *MigrationReconciler) checkWindow(migration *migrationsv1alpha1.Migration, now time.Time) time.Duration {
+	closes := migration.Status.WindowCloses
+	if closes == nil {
+		return 0
+	}
+	var running []string
+	for _, target := range migration.Status.Targets {
+		if target.Phase == migrationsv1alpha1.MigrationRunning {
+			running = append(running, target.Name)
+		}
+	}
+	if len(running) == 0 {
+		return 0
+	}
+	if now.Before(closes.Time) {
+		return closes.Sub(now)
+	}
+
+	message := fmt.Sprintf("runs of %s still going after the maintenance window closed at %s", strings.Join(running, ", "), closes.Format(time.RFC3339))
+	if condition := migration.Status.GetCondition(migrationsv1alpha1.ConditionWindowExceeded); condition == nil || condition.Status != corev1.ConditionTrue {
+		r.Recorder.Event(migration, corev1.EventTypeWarning, ReasonWindowExceeded, message)
+	}
+	migration.Status.SetCondition(migrationsv1alpha1.MigrationCondition{
+		Type:    migrationsv1alpha1.ConditionWindowExceeded,
+		Status:  corev1.ConditionTrue,
+		Reason:  ReasonWindowExceeded,
+		Message: message,
+	})
+	return 0
+}
@@ -0,0 +1,61 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("currentWindow", func() {
+	at := func(value string) time.Time {
+		t, err := time.Parse(time.RFC3339, value)
+		Expect(err).ToNot(HaveOccurred())
+		return t
+	}
+
+	table.DescribeTable("tells whether a maintenance window is open",
+		func(windows []string, timeZone, now string, open bool, boundary string) {
+			spec := &migrationsv1alpha1.ScheduleSpec{Windows: windows, TimeZone: timeZone, Duration: metav1.Duration{Duration: 2 * time.Hour}}
+			window, err := currentWindow(spec, at(now))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(window.open).To(Equal(open))
+			if open {
+				Expect(window.closes.Equal(at(boundary))).To(BeTrue(), "closes at %s", window.closes)
+			} else {
+				Expect(window.next.Equal(at(boundary))).To(BeTrue(), "opens at %s", window.next)
+			}
+		},
+		table.Entry("inside a window", []string{"0 2 * * *"}, "", "2020-01-15T03:00:00Z", true, "2020-01-15T04:00:00Z"),
+		table.Entry("at the opening of a window", []string{"0 2 * * *"}, "", "2020-01-15T02:00:00Z", true, "2020-01-15T04:00:00Z"),
+		table.Entry("after a window", []string{"0 2 * * *"}, "", "2020-01-15T04:00:00Z", false, "2020-01-16T02:00:00Z"),
+		table.Entry("in the time zone of the schedule", []string{"0 2 * * *"}, "Europe/Paris", "2020-01-15T00:30:00Z", false, "2020-01-15T01:00:00Z"),
+		table.Entry("the earliest of several windows", []string{"0 2 * * *", "0 22 * * 6"}, "", "2020-01-18T12:00:00Z", false, "2020-01-18T22:00:00Z"),
+	)
+
+	It("rejects invalid expressions", func() {
+		_, err := currentWindow(&migrationsv1alpha1.ScheduleSpec{Windows: []string{"every night"}}, time.Now())
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonScheduleInvalid))
+	})
+})
@@ -0,0 +1,313 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+
+	"github.com/go-logr/logr"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+type (
+	// SchemaGate tells the init containers of gated pods whether a migration applied a schema version
+	SchemaGate struct {
+		Client client.Client
+		Log    logr.Logger
+		// Addr is the address the gate listens on
+		Addr string
+	}
+
+	// SchemaGateInjector adds an init container waiting for the schema versions required by a pod
+	SchemaGateInjector struct {
+		Log logr.Logger
+		// GateURL is the base URL the schema gate is reached at from the pods
+		GateURL string
+		// Image runs the init container, it needs a shell and wget
+		Image string
+
+		decoder *admission.Decoder
+	}
+
+	// schemaRequirement is one <migration>@<version> entry of the requires annotation
+	schemaRequirement struct {
+		migration types.NamespacedName
+		version   string
+	}
+)
+
+const (
+	// SchemaGatePath prefixes the schema gate URLs, followed by <namespace>/<migration>/versions/<version>
+	SchemaGatePath = "/migrations/"
+	// SchemaGateAudience is the audience of the service account tokens the init containers authenticate with
+	SchemaGateAudience = "flyway-schema-gate"
+	// DefaultSchemaGateImage runs the injected init container
+	DefaultSchemaGateImage = "busybox:1.32"
+	schemaGateContainer    = "flyway-requires"
+	schemaGatePollInterval = 5
+	schemaGateTokenVolume  = "flyway-schema-gate-token"
+	schemaGateTokenPath    = "/var/run/secrets/flyway-schema-gate"
+	// schemaGateTokenExpiration is renewed by the kubelet once 80% of it elapsed
+	schemaGateTokenExpiration = 3600
+)
+
+// the pods opting in are rejected while the webhook is down so that none starts without waiting for its schema,
+// config/default/webhook_schema_gate_patch.yaml restricts the webhook to the pods labelled with SchemaGateLabel
+// +kubebuilder:webhook:path=/mutate-v1-pod,mutating=true,failurePolicy=fail,groups="",resources=pods,verbs=create,versions=v1,name=mpod.flywayoperator.io
+
+func (g *SchemaGate) Start(stop <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle(SchemaGatePath, g)
+	server := &http.Server{Addr: g.Addr, Handler: mux}
+
+	errs := make(chan error, 1)
+	go func() {
+		g.Log.Info("starting schema gate", "addr", g.Addr)
+		errs <- server.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		return server.Shutdown(ctx)
+	}
+}
+
+// NeedLeaderElection lets every replica behind the service answer the init containers
+func (g *SchemaGate) NeedLeaderElection() bool {
+	return false
+}
+
+// +kubebuilder:rbac:groups=authentication.k8s.io,resources=tokenreviews,verbs=create
+// +kubebuilder:rbac:groups=authorization.k8s.io,resources=subjectaccessreviews,verbs=create
+
+// ServeHTTP answers 200 once the migration reports the version as applied, 503 while it does not. The callers
+// authenticate with a service account token, the ones of other namespaces must be allowed to get the migration.
+func (g *SchemaGate) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, SchemaGatePath), "/")
+	if len(parts) != 4 || parts[2] != "versions" {
+		http.NotFound(w, req)
+		return
+	}
+	key, version := types.NamespacedName{Namespace: parts[0], Name: parts[1]}, parts[3]
+
+	user, err := g.authenticate(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if user == nil {
+		http.Error(w, "a service account token for the "+SchemaGateAudience+" audience is required", http.StatusUnauthorized)
+		return
+	}
+	if allowed, err := g.authorize(req.Context(), user, key); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	} else if !allowed {
+		http.Error(w, fmt.Sprintf("%s is not allowed to get migration %s", user.Username, key), http.StatusForbidden)
+		return
+	}
+
+	var migration migrationsv1alpha1.Migration
+	if err := g.Client.Get(req.Context(), key, &migration); err != nil {
+		if client.IgnoreNotFound(err) == nil {
+			http.Error(w, fmt.Sprintf("migration %s not found", key), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	current := migration.Status.CurrentVersion
+	if current == "" || compareVersions(current, version) < 0 {
+		http.Error(w, fmt.Sprintf("migration %s is at version %q, %s required", key, current, version), http.StatusServiceUnavailable)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]string{"migration": key.String(), "currentVersion": current})
+}
+
+// authenticate reviews the bearer token of the request, the user is nil when the token is missing or rejected
+func (g *SchemaGate) authenticate(req *http.Request) (*authenticationv1.UserInfo, error) {
+	header := req.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return nil, nil
+	}
+	review := &authenticationv1.TokenReview{Spec: authenticationv1.TokenReviewSpec{
+		Token:     strings.TrimPrefix(header, "Bearer "),
+		Audiences: []string{SchemaGateAudience},
+	}}
+	if err := g.Client.Create(req.Context(), review); err != nil {
+		return nil, err
+	}
+	// authenticators ignoring audiences return none, the token may then be meant for another audience
+	if !review.Status.Authenticated || !sets.NewString(review.Status.Audiences...).Has(SchemaGateAudience) {
+		return nil, nil
+	}
+	return &review.Status.User, nil
+}
+
+// authorize lets the service accounts of the migration namespace in, other users must be allowed to get the migration
+func (g *SchemaGate) authorize(ctx context.Context, user *authenticationv1.UserInfo, key types.NamespacedName) (bool, error) {
+	if strings.HasPrefix(user.Username, "system:serviceaccount:"+key.Namespace+":") {
+		return true, nil
+	}
+	review := &authorizationv1.SubjectAccessReview{Spec: authorizationv1.SubjectAccessReviewSpec{
+		User:   user.Username,
+		UID:    user.UID,
+		Groups: user.Groups,
+		ResourceAttributes: &authorizationv1.ResourceAttributes{
+			Namespace: key.Namespace,
+			Verb:      "get",
+			Group:     migrationsv1alpha1.GroupVersion.Group,
+			Resource:  "migrations",
+			Name:      key.Name,
+		},
+	}}
+	if len(user.Extra) > 0 {
+		review.Spec.Extra = make(map[string]authorizationv1.ExtraValue, len(user.Extra))
+		for k, v := range user.Extra {
+			review.Spec.Extra[k] = authorizationv1.ExtraValue(v)
+		}
+	}
+	if err := g.Client.Create(ctx, review); err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
+
+// Handle injects the init container in the pods carrying the requires annotation
+func (i *SchemaGateInjector) Handle(ctx context.Context, req admission.Request) admission.Response {
+	var pod corev1.Pod
+	if err := i.decoder.Decode(req, &pod); err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	annotation, ok := pod.ObjectMeta.Annotations[migrationsv1alpha1.RequiresAnnotation]
+	if !ok {
+		return admission.Allowed("no schema version required")
+	}
+	for _, container := range pod.Spec.InitContainers {
+		if container.Name == schemaGateContainer {
+			return admission.Allowed("schema gate already injected")
+		}
+	}
+
+	requirements, err := parseRequirements(annotation, req.Namespace)
+	if err != nil {
+		return admission.Denied(err.Error())
+	}
+	pod.Spec.InitContainers = append([]corev1.Container{i.gateContainer(requirements)}, pod.Spec.InitContainers...)
+	pod.Spec.Volumes = append(pod.Spec.Volumes, gateTokenVolume())
+
+	marshaled, err := json.Marshal(&pod)
+	if err != nil {
+		return admission.Errored(http.StatusInternalServerError, err)
+	}
+	i.Log.Info("injected schema gate", "namespace", req.Namespace, "pod", pod.ObjectMeta.GenerateName+pod.ObjectMeta.Name, "requires", annotation)
+	return admission.PatchResponseFromRaw(req.Object.Raw, marshaled)
+}
+
+// InjectDecoder implements admission.DecoderInjector
+func (i *SchemaGateInjector) InjectDecoder(d *admission.Decoder) error {
+	i.decoder = d
+	return nil
+}
+
+// gateContainer polls the schema gate for every requirement in turn
+func (i *SchemaGateInjector) gateContainer(requirements []schemaRequirement) corev1.Container {
+	image := i.Image
+	if image == "" {
+		image = DefaultSchemaGateImage
+	}
+
+	// the token is read on every attempt since the kubelet renews it
+	args := []string{"-c", fmt.Sprintf(`for url in "$@"; do until wget -q -O /dev/null --header "Authorization: Bearer $(cat %s/token)" "$url"; do echo "waiting for $url"; sleep %d; done; done`,
+		schemaGateTokenPath, schemaGatePollInterval), "flyway-requires"}
+	for _, requirement := range requirements {
+		args = append(args, fmt.Sprintf("%s%s%s/%s/versions/%s", strings.TrimSuffix(i.GateURL, "/"), SchemaGatePath,
+			url.PathEscape(requirement.migration.Namespace), url.PathEscape(requirement.migration.Name), url.PathEscape(requirement.version)))
+	}
+
+	return corev1.Container{
+		Name:            schemaGateContainer,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"sh"},
+		Args:            args,
+		VolumeMounts: []corev1.VolumeMount{
+			{Name: schemaGateTokenVolume, MountPath: schemaGateTokenPath, ReadOnly: true},
+		},
+		Resources: corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("10m"),
+				corev1.ResourceMemory: resource.MustParse("16Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("50m"),
+				corev1.ResourceMemory: resource.MustParse("32Mi"),
+			},
+		},
+	}
+}
+
+// gateTokenVolume projects a service account token of the pod for the schema gate audience
+func gateTokenVolume() corev1.Volume {
+	expiration := int64(schemaGateTokenExpiration)
+	return corev1.Volume{
+		Name: schemaGateTokenVolume,
+		VolumeSource: corev1.VolumeSource{
+			Projected: &corev1.ProjectedVolumeSource{
+				Sources: []corev1.VolumeProjection{{
+					ServiceAccountToken: &corev1.ServiceAccountTokenProjection{
+						Audience:          SchemaGateAudience,
+						ExpirationSeconds: &expiration,
+						Path:              "token",
+					},
+				}},
+			},
+		},
+	}
+}
+
+// parseRequirements reads the comma separated <migration>@<version> entries of the requires annotation
+func parseRequirements(annotation, namespace string) ([]schemaRequirement, error) {
+	var requirements []schemaRequirement
+	for _, entry := range strings.Split(annotation, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		at := strings.LastIndex(entry, "@")
+		if at <= 0 || at == len(entry)-1 {
+			return nil, fmt.Errorf("invalid %s entry %q, expected <migration>@<version>", migrationsv1alpha1.RequiresAnnotation, entry)
+		}
+		requirement := schemaRequirement{migration: types.NamespacedName{Namespace: namespace, Name: entry[:at]}, version: entry[at+1:]}
+		if slash := strings.Index(requirement.migration.Name, "/"); slash >= 0 {
+			requirement.migration.Namespace, requirement.migration.Name = requirement.migration.Name[:slash], requirement.migration.Name[slash+1:]
+		}
+		requirements = append(requirements, requirement)
+	}
+	if len(requirements) == 0 {
+		return nil, fmt.Errorf("%s does not hold any requirement", migrationsv1alpha1.RequiresAnnotation)
+	}
+	return requirements, nil
+}
@@ -0,0 +1,172 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+// reviewingClient answers the token and subject access reviews the way the API server would
+type reviewingClient struct {
+	client.Client
+	// users are the users authenticated by each token for the schema gate audience
+	users map[string]authenticationv1.UserInfo
+	// allowed are the users allowed to get the migrations of other namespaces
+	allowed map[string]bool
+}
+
+func (c *reviewingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	switch review := obj.(type) {
+	case *authenticationv1.TokenReview:
+		user, ok := c.users[review.Spec.Token]
+		review.Status = authenticationv1.TokenReviewStatus{Authenticated: ok, User: user}
+		if ok {
+			review.Status.Audiences = review.Spec.Audiences
+		}
+		return nil
+	case *authorizationv1.SubjectAccessReview:
+		attributes := review.Spec.ResourceAttributes
+		review.Status.Allowed = c.allowed[review.Spec.User] && attributes.Verb == "get" && attributes.Resource == "migrations"
+		return nil
+	}
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+var _ = Describe("SchemaGate", func() {
+	gate := func() *SchemaGate {
+		migration := &migrationsv1alpha1.Migration{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-schema", Namespace: "default"},
+			Status:     migrationsv1alpha1.MigrationStatus{CurrentVersion: "1.4"},
+		}
+		c := &reviewingClient{
+			Client: fake.NewFakeClientWithScheme(scheme.Scheme, migration),
+			users: map[string]authenticationv1.UserInfo{
+				"app":     {Username: "system:serviceaccount:default:app"},
+				"reports": {Username: "system:serviceaccount:reports:reader"},
+				"billing": {Username: "system:serviceaccount:billing:app"},
+			},
+			allowed: map[string]bool{"system:serviceaccount:reports:reader": true},
+		}
+		return &SchemaGate{Client: c, Log: logf.Log}
+	}
+
+	getAs := func(token, path string) int {
+		recorder := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		gate().ServeHTTP(recorder, req)
+		return recorder.Code
+	}
+	get := func(path string) int {
+		return getAs("app", path)
+	}
+
+	It("opens once the version is applied", func() {
+		Expect(get("/migrations/default/app-schema/versions/1.4")).To(Equal(http.StatusOK))
+		Expect(get("/migrations/default/app-schema/versions/1.2")).To(Equal(http.StatusOK))
+	})
+
+	It("stays closed while the version is not applied", func() {
+		Expect(get("/migrations/default/app-schema/versions/1.10")).To(Equal(http.StatusServiceUnavailable))
+		Expect(get("/migrations/default/unknown/versions/1")).To(Equal(http.StatusServiceUnavailable))
+	})
+
+	It("requires a valid service account token", func() {
+		Expect(getAs("", "/migrations/default/app-schema/versions/1.4")).To(Equal(http.StatusUnauthorized))
+		Expect(getAs("forged", "/migrations/default/app-schema/versions/1.4")).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("only answers other namespaces allowed to get the migration", func() {
+		Expect(getAs("reports", "/migrations/default/app-schema/versions/1.4")).To(Equal(http.StatusOK))
+		Expect(getAs("billing", "/migrations/default/app-schema/versions/1.4")).To(Equal(http.StatusForbidden))
+	})
+})
+
+var _ = Describe("SchemaGateInjector", func() {
+	handle := func(annotations map[string]string) admission.Response {
+		decoder, err := admission.NewDecoder(scheme.Scheme)
+		Expect(err).ToNot(HaveOccurred())
+		injector := &SchemaGateInjector{Log: logf.Log, GateURL: "http://gate.flyway.svc"}
+		Expect(injector.InjectDecoder(decoder)).To(Succeed())
+
+		pod := corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "app", Annotations: annotations},
+			Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "app"}}},
+		}
+		raw, err := json.Marshal(&pod)
+		Expect(err).ToNot(HaveOccurred())
+		return injector.Handle(context.Background(), admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+			Namespace: "default",
+			Object:    runtime.RawExtension{Raw: raw},
+		}})
+	}
+
+	It("leaves pods without requirement untouched", func() {
+		response := handle(nil)
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(BeEmpty())
+	})
+
+	It("injects an init container polling every requirement", func() {
+		response := handle(map[string]string{migrationsv1alpha1.RequiresAnnotation: "app-schema@1.4, shared/reference-data@2"})
+		Expect(response.Allowed).To(BeTrue())
+		Expect(response.Patches).To(HaveLen(2))
+		patches := map[string]interface{}{}
+		for _, patch := range response.Patches {
+			patches[patch.Path] = patch.Value
+		}
+
+		containers := patches["/spec/initContainers"].([]interface{})
+		args := containers[0].(map[string]interface{})["args"].([]interface{})
+		Expect(args[1]).To(ContainSubstring(`--header "Authorization: Bearer $(cat /var/run/secrets/flyway-schema-gate/token)"`))
+		Expect(args[3:]).To(Equal([]interface{}{
+			"http://gate.flyway.svc/migrations/default/app-schema/versions/1.4",
+			"http://gate.flyway.svc/migrations/shared/reference-data/versions/2",
+		}))
+
+		// the token the init container authenticates with is projected for the gate audience
+		volumes := patches["/spec/volumes"].([]interface{})
+		token := volumes[0].(map[string]interface{})["projected"].(map[string]interface{})["sources"].([]interface{})[0]
+		Expect(token.(map[string]interface{})["serviceAccountToken"]).To(HaveKeyWithValue("audience", SchemaGateAudience))
+	})
+
+	It("rejects malformed requirements", func() {
+		response := handle(map[string]string{migrationsv1alpha1.RequiresAnnotation: "app-schema"})
+		Expect(response.Allowed).To(BeFalse())
+	})
+})
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+type (
+	SSHTunnelConnectivity struct {
+		Spec      *migrationsv1alpha1.SSHTunnelSpec
+		Host      string
+		Port      int32
+		Namespace string
+	}
+
+	// sshDialer opens a session with the bastion for every connection, closed along with it
+	sshDialer struct {
+		bastion string
+		config  *ssh.ClientConfig
+	}
+
+	// tunnelConn is a connection forwarded by the bastion
+	tunnelConn struct {
+		net.Conn
+		client *ssh.Client
+	}
+)
+
+const (
+	// SSHTunnelContainerName is the sidecar forwarding the connections of the pods through the bastion
+	SSHTunnelContainerName = "ssh-tunnel"
+	// sshTunnelImage holds the OpenSSH client, like the git clone init container
+	sshTunnelImage       = "alpine/git:1.0.2"
+	sshTunnelMountName   = "ssh-tunnel-key"
+	sshTunnelMountPath   = "/etc/ssh-tunnel"
+	sshKeyName           = corev1.SSHAuthPrivateKey
+	sshTunnelDialTimeout = 30 * time.Second
+)
+
+// Dialer authenticates with the key of the secret, the bastion must present one of the known host keys
+func (t SSHTunnelConnectivity) Dialer(ctx context.Context, c client.Reader) (Dialer, error) {
+	var secret corev1.Secret
+	if err := c.Get(ctx, client.ObjectKey{Namespace: t.Namespace, Name: t.Spec.KeySecret}, &secret); err != nil {
+		return nil, newTransientError(ReasonSecretNotFound, "unable to read ssh tunnel secret %s/%s: %v", t.Namespace, t.Spec.KeySecret, err)
+	}
+	key, ok := secret.Data[sshKeyName]
+	if !ok {
+		return nil, newTransientError(ReasonSecretKeyMissing, "key %q is missing from ssh tunnel secret %s/%s", sshKeyName, t.Namespace, t.Spec.KeySecret)
+	}
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		return nil, newMigrationError(ReasonCredentialsMissing, "invalid ssh key in ssh tunnel secret %s/%s: %v", t.Namespace, t.Spec.KeySecret, err)
+	}
+	hostKeys, err := knownHostsCallback(t.Spec.KnownHosts)
+	if err != nil {
+		return nil, newMigrationError(ReasonCredentialsMissing, "invalid known hosts of ssh bastion %s: %v", t.Spec.Host, err)
+	}
+
+	return &sshDialer{
+		bastion: net.JoinHostPort(t.Spec.Host, strconv.Itoa(int(t.bastionPort()))),
+		config: &ssh.ClientConfig{
+			User:            t.Spec.User,
+			Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+			HostKeyCallback: hostKeys,
+		},
+	}, nil
+}
+
+// MutateTemplate forwards the port of the database on localhost, the tunnel is closed when the pod stops the sidecar
+func (t SSHTunnelConnectivity) MutateTemplate(tpl *corev1.PodTemplateSpec) {
+	image := t.Spec.Image
+	if image == "" {
+		image = sshTunnelImage
+	}
+	forward := fmt.Sprintf("%s:%d:%s:%d", localhost, t.Port, t.Host, t.Port)
+	mode := int32(256)
+	tunnel := corev1.Container{
+		Name:            SSHTunnelContainerName,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Env: []corev1.EnvVar{
+			corev1.EnvVar{Name: "SSH_KNOWN_HOSTS", Value: t.Spec.KnownHosts},
+		},
+		Command: []string{"sh", "-c", `printf '%s\n' "$SSH_KNOWN_HOSTS" > /tmp/known_hosts && exec ssh -N ` +
+			`-o StrictHostKeyChecking=yes -o UserKnownHostsFile=/tmp/known_hosts -o ExitOnForwardFailure=yes -o ServerAliveInterval=30 ` +
+			`-i "$0" -L "$1" -p "$2" "$3"`},
+		Args: []string{
+			sshTunnelMountPath + "/" + sshKeyName,
+			forward,
+			strconv.Itoa(int(t.bastionPort())),
+			t.Spec.User + "@" + t.Spec.Host,
+		},
+		// the containers connecting through the tunnel start once it is forwarding
+		StartupProbe: &corev1.Probe{
+			Handler: corev1.Handler{
+				Exec: &corev1.ExecAction{Command: []string{"nc", "-z", localhost, strconv.Itoa(int(t.Port))}},
+			},
+			PeriodSeconds:    1,
+			FailureThreshold: 60,
+		},
+		VolumeMounts: []corev1.VolumeMount{
+			corev1.VolumeMount{Name: sshTunnelMountName, MountPath: sshTunnelMountPath, ReadOnly: true},
+		},
+	}
+	tpl.Spec.InitContainers = append([]corev1.Container{tunnel}, tpl.Spec.InitContainers...)
+	tpl.Spec.Volumes = append(tpl.Spec.Volumes, corev1.Volume{
+		Name: sshTunnelMountName,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{
+				SecretName:  t.Spec.KeySecret,
+				DefaultMode: &mode,
+			},
+		},
+	})
+}
+
+// clusterConnectivity reaches a cluster database from the operator, the key of its tunnel is read from the namespace of the key secret
+func clusterConnectivity(spec *migrationsv1alpha1.DBSpec) Connectivity {
+	if tunnel := spec.SSHTunnel; tunnel != nil {
+		return SSHTunnelConnectivity{Spec: tunnel, Host: spec.Host, Port: spec.Port, Namespace: tunnel.KeySecretNamespace}
+	}
+	return GetConnectivity(spec, "")
+}
+
+func (t SSHTunnelConnectivity) bastionPort() int32 {
+	if t.Spec.Port == 0 {
+		return migrationsv1alpha1.DefaultSSHPort
+	}
+	return t.Spec.Port
+}
+
+func (d *sshDialer) Dial(network, address string) (net.Conn, error) {
+	return d.DialTimeout(network, address, sshTunnelDialTimeout)
+}
+
+// DialTimeout asks the bastion to connect to the address, which it resolves itself
+func (d *sshDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", d.bastion, timeout)
+	if err != nil {
+		return nil, err
+	}
+	config := *d.config
+	config.Timeout = timeout
+	conn.SetDeadline(time.Now().Add(timeout))
+	sshConn, channels, requests, err := ssh.NewClientConn(conn, d.bastion, &config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to open ssh session with bastion %s: %v", d.bastion, err)
+	}
+	conn.SetDeadline(time.Time{})
+	sshClient := ssh.NewClient(sshConn, channels, requests)
+
+	forwarded, err := sshClient.Dial(network, address)
+	if err != nil {
+		sshClient.Close()
+		return nil, fmt.Errorf("bastion %s is unable to connect to %s: %v", d.bastion, address, err)
+	}
+	return &tunnelConn{Conn: forwarded, client: sshClient}, nil
+}
+
+func (c *tunnelConn) Close() error {
+	err := c.Conn.Close()
+	c.client.Close()
+	return err
+}
+
+// knownHostsCallback checks host keys against known_hosts lines, the way OpenSSH matches them
+func knownHostsCallback(knownHosts string) (ssh.HostKeyCallback, error) {
+	// knownhosts only reads files, which it parses right away
+	file, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(file.Name())
+	if _, err := file.WriteString(knownHosts + "\n"); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if err := file.Close(); err != nil {
+		return nil, err
+	}
+	return knownhosts.New(file.Name())
+}
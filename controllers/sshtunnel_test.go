@@ -0,0 +1,226 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net"
+	"strconv"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("SSH tunnel connectivity", func() {
+	ctx := context.Background()
+
+	var (
+		spec      *migrationsv1alpha1.DBSpec
+		clientKey *rsa.PrivateKey
+		hostKey   ssh.Signer
+		bastion   net.Listener
+		database  net.Listener
+	)
+
+	BeforeEach(func() {
+		var err error
+		clientKey, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		hostKey, err = ssh.NewSignerFromKey(key)
+		Expect(err).NotTo(HaveOccurred())
+
+		// the database echoes what it reads
+		database, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go func() {
+			for {
+				conn, err := database.Accept()
+				if err != nil {
+					return
+				}
+				go func() {
+					defer conn.Close()
+					io.Copy(conn, conn)
+				}()
+			}
+		}()
+
+		bastion, err = net.Listen("tcp", "127.0.0.1:0")
+		Expect(err).NotTo(HaveOccurred())
+		go serveBastion(bastion, hostKey, clientKey)
+
+		bastionPort := bastion.Addr().(*net.TCPAddr).Port
+		databasePort := database.Addr().(*net.TCPAddr).Port
+		spec = &migrationsv1alpha1.DBSpec{
+			Driver: migrationsv1alpha1.PostgresDriver,
+			Host:   "127.0.0.1",
+			Port:   int32(databasePort),
+			DBName: "orders",
+			SSHTunnel: &migrationsv1alpha1.SSHTunnelSpec{
+				Host:       "127.0.0.1",
+				Port:       int32(bastionPort),
+				User:       "flyway",
+				KeySecret:  "bastion",
+				KnownHosts: knownhosts.Line([]string{knownhosts.Normalize(bastion.Addr().String())}, hostKey.PublicKey()),
+			},
+		}
+	})
+
+	AfterEach(func() {
+		bastion.Close()
+		database.Close()
+	})
+
+	keySecret := func() *corev1.Secret {
+		return &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "bastion", Namespace: "shop"},
+			Data: map[string][]byte{
+				corev1.SSHAuthPrivateKey: pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(clientKey)}),
+			},
+		}
+	}
+
+	It("forwards the connections of the operator through the bastion", func() {
+		dialer, err := getDialer(ctx, fake.NewFakeClientWithScheme(scheme.Scheme, keySecret()), spec, "shop")
+		Expect(err).NotTo(HaveOccurred())
+
+		conn, err := dialer.Dial("tcp", net.JoinHostPort(spec.Host, strconv.Itoa(int(spec.Port))))
+		Expect(err).NotTo(HaveOccurred())
+		defer conn.Close()
+		_, err = conn.Write([]byte("ping"))
+		Expect(err).NotTo(HaveOccurred())
+		reply := make([]byte, 4)
+		_, err = io.ReadFull(conn, reply)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(reply)).To(Equal("ping"))
+	})
+
+	It("refuses bastions presenting an unknown host key", func() {
+		other, err := rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).NotTo(HaveOccurred())
+		otherKey, err := ssh.NewPublicKey(&other.PublicKey)
+		Expect(err).NotTo(HaveOccurred())
+		spec.SSHTunnel.KnownHosts = knownhosts.Line([]string{knownhosts.Normalize(bastion.Addr().String())}, otherKey)
+
+		dialer, err := getDialer(ctx, fake.NewFakeClientWithScheme(scheme.Scheme, keySecret()), spec, "shop")
+		Expect(err).NotTo(HaveOccurred())
+		_, err = dialer.Dial("tcp", net.JoinHostPort(spec.Host, strconv.Itoa(int(spec.Port))))
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("fails when the key secret does not exist", func() {
+		_, err := getDialer(ctx, fake.NewFakeClientWithScheme(scheme.Scheme), spec, "shop")
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonSecretNotFound))
+	})
+
+	It("runs a tunnel sidecar the pods connect to on localhost", func() {
+		spec.Host = "orders.internal"
+		spec.Port = 5432
+		spec.SSHTunnel.Host = "bastion.example.com"
+		spec.SSHTunnel.Port = 0
+		tpl := corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: FlywayContainerName}}}}
+		GetConnectivity(spec, "shop").MutateTemplate(&tpl)
+
+		Expect(tpl.Spec.InitContainers).To(HaveLen(1))
+		tunnel := tpl.Spec.InitContainers[0]
+		Expect(tunnel.Name).To(Equal(SSHTunnelContainerName))
+		Expect(tunnel.Args).To(Equal([]string{"/etc/ssh-tunnel/ssh-privatekey", "127.0.0.1:5432:orders.internal:5432", "22", "flyway@bastion.example.com"}))
+		Expect(tpl.Spec.Volumes).To(HaveLen(1))
+		Expect(tpl.Spec.Volumes[0].Secret.SecretName).To(Equal("bastion"))
+		Expect(sidecarContainers).To(HaveKey(SSHTunnelContainerName))
+
+		Expect(PostgresDriver{}.ConnectionURL(spec)).To(Equal("jdbc:postgresql://127.0.0.1:5432/orders"))
+	})
+})
+
+// serveBastion accepts the sessions of the client key and forwards their direct-tcpip channels
+func serveBastion(listener net.Listener, hostKey ssh.Signer, clientKey *rsa.PrivateKey) {
+	authorized, err := ssh.NewPublicKey(&clientKey.PublicKey)
+	if err != nil {
+		return
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == "flyway" && bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, io.EOF
+		},
+	}
+	config.AddHostKey(hostKey)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			_, channels, requests, err := ssh.NewServerConn(conn, config)
+			if err != nil {
+				conn.Close()
+				return
+			}
+			go ssh.DiscardRequests(requests)
+			for newChannel := range channels {
+				var target struct {
+					Host       string
+					Port       uint32
+					OriginHost string
+					OriginPort uint32
+				}
+				if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &target) != nil {
+					newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+					continue
+				}
+				upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, strconv.Itoa(int(target.Port))))
+				if err != nil {
+					newChannel.Reject(ssh.ConnectionFailed, err.Error())
+					continue
+				}
+				channel, channelRequests, err := newChannel.Accept()
+				if err != nil {
+					upstream.Close()
+					continue
+				}
+				go ssh.DiscardRequests(channelRequests)
+				go func() {
+					defer channel.Close()
+					defer upstream.Close()
+					go io.Copy(upstream, channel)
+					io.Copy(channel, upstream)
+				}()
+			}
+		}()
+	}
+}
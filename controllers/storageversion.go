@@ -0,0 +1,152 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// StorageVersionMigrator rewrites the objects of custom resources whose storage version changed, so that every
+// object is stored in the new version, then drops the former versions from the stored versions of the definition
+type StorageVersionMigrator struct {
+	Client client.Client
+	Log    logr.Logger
+	// CRDs are the names of the custom resource definitions to migrate
+	CRDs []string
+	// Interval between attempts while the definitions or the conversion webhook are not ready
+	Interval time.Duration
+}
+
+var customResourceDefinitionGVK = schema.GroupVersionKind{
+	Group:   "apiextensions.k8s.io",
+	Version: "v1",
+	Kind:    "CustomResourceDefinition",
+}
+
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions,verbs=get;list;watch
+// +kubebuilder:rbac:groups=apiextensions.k8s.io,resources=customresourcedefinitions/status,verbs=update;patch
+
+// Start migrates every definition in turn, it returns once they are all migrated
+func (m *StorageVersionMigrator) Start(stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for _, name := range m.CRDs {
+		log := m.Log.WithValues("crd", name)
+		err := wait.PollImmediateUntil(m.Interval, func() (bool, error) {
+			if err := m.migrate(ctx, name); err != nil {
+				log.Error(err, "unable to migrate the storage version, retrying")
+				return false, nil
+			}
+			return true, nil
+		}, stop)
+		if err == wait.ErrWaitTimeout {
+			return nil
+		}
+	}
+	return nil
+}
+
+// migrate rewrites the objects of a definition unless they are all stored in its storage version already
+func (m *StorageVersionMigrator) migrate(ctx context.Context, name string) error {
+	crd := &unstructured.Unstructured{}
+	crd.SetGroupVersionKind(customResourceDefinitionGVK)
+	if err := m.Client.Get(ctx, client.ObjectKey{Name: name}, crd); err != nil {
+		return err
+	}
+	gvk, err := storageVersionKind(crd)
+	if err != nil {
+		return err
+	}
+	stored, _, err := unstructured.NestedStringSlice(crd.Object, "status", "storedVersions")
+	if err != nil {
+		return err
+	}
+	if len(stored) == 1 && stored[0] == gvk.Version {
+		return nil
+	}
+
+	log := m.Log.WithValues("crd", name, "storageVersion", gvk.Version, "storedVersions", stored)
+	log.Info("migrating the stored objects")
+	list := &unstructured.UnstructuredList{}
+	list.SetGroupVersionKind(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+	if err := m.Client.List(ctx, list); err != nil {
+		return err
+	}
+	for i := range list.Items {
+		if err := m.rewrite(ctx, &list.Items[i]); err != nil {
+			return err
+		}
+	}
+
+	patch, err := json.Marshal(map[string]interface{}{
+		"status": map[string]interface{}{"storedVersions": []string{gvk.Version}},
+	})
+	if err != nil {
+		return err
+	}
+	if err := m.Client.Status().Patch(ctx, crd, client.RawPatch(types.MergePatchType, patch)); err != nil {
+		return err
+	}
+	log.Info("migrated the stored objects", "objects", len(list.Items))
+	return nil
+}
+
+// rewrite updates an object without changing it, which stores it again in the storage version
+func (m *StorageVersionMigrator) rewrite(ctx context.Context, obj *unstructured.Unstructured) error {
+	key := client.ObjectKey{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		err := m.Client.Update(ctx, obj)
+		if apierrors.IsConflict(err) {
+			if err := m.Client.Get(ctx, key, obj); err != nil {
+				return err
+			}
+		}
+		return err
+	})
+	return client.IgnoreNotFound(err)
+}
+
+// storageVersionKind returns the kind of a definition in the version its objects are stored in
+func storageVersionKind(crd *unstructured.Unstructured) (schema.GroupVersionKind, error) {
+	group, _, err := unstructured.NestedString(crd.Object, "spec", "group")
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	kind, _, err := unstructured.NestedString(crd.Object, "spec", "names", "kind")
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	versions, _, err := unstructured.NestedSlice(crd.Object, "spec", "versions")
+	if err != nil {
+		return schema.GroupVersionKind{}, err
+	}
+	for _, version := range versions {
+		version, ok := version.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if storage, _, _ := unstructured.NestedBool(version, "storage"); storage {
+			name, _, _ := unstructured.NestedString(version, "name")
+			return schema.GroupVersionKind{Group: group, Version: name, Kind: kind}, nil
+		}
+	}
+	return schema.GroupVersionKind{}, fmt.Errorf("custom resource definition %s has no storage version", crd.GetName())
+}
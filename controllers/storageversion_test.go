@@ -0,0 +1,85 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1beta1 "flyway-operator/api/v1beta1"
+)
+
+var _ = Describe("storage version migration", func() {
+	ctx := context.Background()
+
+	var crd *unstructured.Unstructured
+
+	BeforeEach(func() {
+		crd = &unstructured.Unstructured{Object: map[string]interface{}{
+			"metadata": map[string]interface{}{"name": "migrations.migrations.flywayoperator.io"},
+			"spec": map[string]interface{}{
+				"group": "migrations.flywayoperator.io",
+				"names": map[string]interface{}{"kind": "Migration"},
+				"versions": []interface{}{
+					map[string]interface{}{"name": "v1alpha1", "served": true, "storage": false},
+					map[string]interface{}{"name": "v1beta1", "served": true, "storage": true},
+				},
+			},
+			"status": map[string]interface{}{"storedVersions": []interface{}{"v1alpha1", "v1beta1"}},
+		}}
+		crd.SetGroupVersionKind(customResourceDefinitionGVK)
+	})
+
+	It("finds the storage version of a definition", func() {
+		gvk, err := storageVersionKind(crd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(gvk).To(Equal(schema.GroupVersionKind{Group: "migrations.flywayoperator.io", Version: "v1beta1", Kind: "Migration"}))
+
+		Expect(unstructured.SetNestedSlice(crd.Object, []interface{}{}, "spec", "versions")).To(Succeed())
+		_, err = storageVersionKind(crd)
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("rewrites the stored objects and records the storage version as the only stored one", func() {
+		migration := &migrationsv1beta1.Migration{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"}}
+		s := runtime.NewScheme()
+		Expect(migrationsv1beta1.AddToScheme(s)).To(Succeed())
+		m := &StorageVersionMigrator{
+			Client: fake.NewFakeClientWithScheme(s, crd, migration),
+			Log:    ctrl.Log,
+			CRDs:   []string{crd.GetName()},
+		}
+
+		Expect(m.migrate(ctx, crd.GetName())).To(Succeed())
+		stored := &unstructured.Unstructured{}
+		stored.SetGroupVersionKind(customResourceDefinitionGVK)
+		Expect(m.Client.Get(ctx, client.ObjectKey{Name: crd.GetName()}, stored)).To(Succeed())
+		versions, _, _ := unstructured.NestedStringSlice(stored.Object, "status", "storedVersions")
+		Expect(versions).To(Equal([]string{"v1beta1"}))
+
+		Expect(m.migrate(ctx, crd.GetName())).To(Succeed())
+	})
+})
@@ -0,0 +1,328 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+// migrationTarget is one database a migration applies to
+type migrationTarget struct {
+	name  string
+	job   string
+	db    migrationsv1alpha1.DBSpec
+	creds Credential
+}
+
+// resolveTargets lists the databases the migration applies to, in rollout order
+func (r *MigrationReconciler) resolveTargets(ctx context.Context, migration *migrationsv1alpha1.Migration) ([]*migrationTarget, error) {
+	namespace := migration.ObjectMeta.Namespace
+
+	switch {
+	case len(migration.Spec.Targets) > 0:
+		targets := make([]*migrationTarget, 0, len(migration.Spec.Targets))
+		for i := range migration.Spec.Targets {
+			spec := &migration.Spec.Targets[i]
+			if spec.DatabaseRef != nil {
+				target, err := r.resolveDatabase(ctx, migration, spec.DatabaseRef, spec.Name, targetJobName(migration, spec.Name))
+				if err != nil {
+					return nil, err
+				}
+				targets = append(targets, target)
+				continue
+			}
+			targets = append(targets, &migrationTarget{
+				name:  spec.Name,
+				job:   targetJobName(migration, spec.Name),
+				db:    spec.DBSpec,
+				creds: GetCredentials(&spec.DBSpec, namespace),
+			})
+		}
+		return targets, nil
+	case migration.Spec.TargetSelector != nil:
+		return r.selectTargets(ctx, migration)
+	case migration.Spec.DatabaseRef != nil:
+		target, err := r.resolveDatabase(ctx, migration, migration.Spec.DatabaseRef, migration.Spec.DatabaseRef.Name, jobName(migration))
+		if err != nil {
+			return nil, err
+		}
+		return []*migrationTarget{target}, nil
+	default:
+		// a single database keeps the job name it always had
+		return []*migrationTarget{{
+			name:  migration.Spec.DB.DBName,
+			job:   jobName(migration),
+			db:    migration.Spec.DB,
+			creds: GetCredentials(&migration.Spec.DB, namespace),
+		}}, nil
+	}
+}
+
+// resolveDatabase reads the connection of a target from the referenced Database or ClusterDatabase
+func (r *MigrationReconciler) resolveDatabase(ctx context.Context, migration *migrationsv1alpha1.Migration, ref *migrationsv1alpha1.DatabaseReference, name, job string) (*migrationTarget, error) {
+	namespace := migration.ObjectMeta.Namespace
+
+	if ref.Kind == migrationsv1alpha1.ClusterDatabaseKind {
+		var database migrationsv1alpha1.ClusterDatabase
+		if err := r.Get(ctx, client.ObjectKey{Name: ref.Name}, &database); err != nil {
+			if apierrors.IsNotFound(err) {
+				return nil, newTransientError(ReasonDatabaseNotFound, "cluster database %s not found", ref.Name)
+			}
+			return nil, err
+		}
+		// the credentials are only copied to the namespaces the cluster database trusts
+		if !database.AllowsNamespace(namespace) {
+			return nil, newMigrationError(ReasonDatabaseNotAllowed, "cluster database %s does not allow the migrations of namespace %s", ref.Name, namespace)
+		}
+		database.Default()
+
+		target := &migrationTarget{name: name, job: job, db: database.Spec.DBSpec}
+		if tunnel := database.Spec.SSHTunnel; tunnel != nil {
+			mirrored, err := r.mirrorSSHKey(ctx, migration, tunnel, job)
+			if err != nil {
+				return nil, err
+			}
+			target.db.SSHTunnel = mirrored
+		}
+		if secret := database.Spec.Secret; secret != nil {
+			creds, err := r.mirrorCredentials(ctx, migration, secret, job)
+			if err != nil {
+				return nil, err
+			}
+			target.creds = creds
+		} else {
+			target.creds = GetCredentials(&database.Spec.DBSpec, namespace)
+		}
+		return target, nil
+	}
+
+	var database migrationsv1alpha1.Database
+	if err := r.Get(ctx, client.ObjectKey{Namespace: namespace, Name: ref.Name}, &database); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, newTransientError(ReasonDatabaseNotFound, "database %s/%s not found", namespace, ref.Name)
+		}
+		return nil, err
+	}
+	return databaseTarget(migration, &database, name, job), nil
+}
+
+// databaseTarget reads the connection of a target from a Database of the migration namespace
+func databaseTarget(migration *migrationsv1alpha1.Migration, database *migrationsv1alpha1.Database, name, job string) *migrationTarget {
+	database.Default()
+	return &migrationTarget{
+		name:  name,
+		job:   job,
+		db:    database.Spec.DBSpec,
+		creds: GetCredentials(&database.Spec.DBSpec, migration.ObjectMeta.Namespace),
+	}
+}
+
+// mirrorCredentials copies the credentials secret of a cluster database in the migration namespace
+// since flyway pods can only read secrets of their own namespace
+func (r *MigrationReconciler) mirrorCredentials(ctx context.Context, migration *migrationsv1alpha1.Migration, secret *migrationsv1alpha1.SecretSpec, job string) (Credential, error) {
+	if secret.Namespace == "" {
+		return nil, newMigrationError(ReasonCredentialsMissing, "the namespace of cluster database secret %s is not set", secret.Name)
+	}
+	source := SecretCredential{Spec: secret, Namespace: secret.Namespace}
+	userPass, err := source.GetUserPassword(ctx, r.Client)
+	if err != nil {
+		return nil, err
+	}
+
+	mirror := job + "-credentials"
+	if err := r.writeCredentials(ctx, migration, mirror, userPass, nil); err != nil {
+		return nil, err
+	}
+
+	return MirroredSecretCredential{
+		Source: source,
+		Mirror: &migrationsv1alpha1.SecretSpec{
+			Name:        mirror,
+			UserKey:     migrationsv1alpha1.DefaultUserKey,
+			PasswordKey: migrationsv1alpha1.DefaultPasswordKey,
+		},
+	}, nil
+}
+
+// mirrorSSHKey copies the key secret of the ssh tunnel of a cluster database in the migration namespace,
+// the tunnel of the target reads the copy like the tunnels of other databases
+func (r *MigrationReconciler) mirrorSSHKey(ctx context.Context, migration *migrationsv1alpha1.Migration, tunnel *migrationsv1alpha1.SSHTunnelSpec, job string) (*migrationsv1alpha1.SSHTunnelSpec, error) {
+	if tunnel.KeySecretNamespace == "" {
+		return nil, newMigrationError(ReasonCredentialsMissing, "the namespace of cluster database ssh tunnel secret %s is not set", tunnel.KeySecret)
+	}
+	var source corev1.Secret
+	if err := r.Get(ctx, client.ObjectKey{Namespace: tunnel.KeySecretNamespace, Name: tunnel.KeySecret}, &source); err != nil {
+		return nil, newTransientError(ReasonSecretNotFound, "unable to read ssh tunnel secret %s/%s: %v", tunnel.KeySecretNamespace, tunnel.KeySecret, err)
+	}
+	key, ok := source.Data[sshKeyName]
+	if !ok {
+		return nil, newTransientError(ReasonSecretKeyMissing, "key %q is missing from ssh tunnel secret %s/%s", sshKeyName, tunnel.KeySecretNamespace, tunnel.KeySecret)
+	}
+
+	mirror := job + "-ssh-key"
+	if err := r.writeSecret(ctx, migration, mirror, map[string][]byte{sshKeyName: key}); err != nil {
+		return nil, err
+	}
+
+	mirrored := tunnel.DeepCopy()
+	mirrored.KeySecret = mirror
+	mirrored.KeySecretNamespace = ""
+	return mirrored, nil
+}
+
+// selectTargets reads the targets from the Databases matching the target selector, sorted by name
+func (r *MigrationReconciler) selectTargets(ctx context.Context, migration *migrationsv1alpha1.Migration) ([]*migrationTarget, error) {
+	selector, err := metav1.LabelSelectorAsSelector(migration.Spec.TargetSelector)
+	if err != nil {
+		return nil, newMigrationError(ReasonTargetInvalid, "invalid target selector: %v", err)
+	}
+
+	var databases migrationsv1alpha1.DatabaseList
+	if err := r.List(ctx, &databases, client.InNamespace(migration.ObjectMeta.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	if len(databases.Items) == 0 {
+		return nil, newMigrationError(ReasonNoTargets, "no database matches the target selector %s", selector)
+	}
+	sort.Slice(databases.Items, func(i, j int) bool { return databases.Items[i].ObjectMeta.Name < databases.Items[j].ObjectMeta.Name })
+
+	targets := make([]*migrationTarget, 0, len(databases.Items))
+	for i := range databases.Items {
+		database := &databases.Items[i]
+		targets = append(targets, databaseTarget(migration, database, database.ObjectMeta.Name, targetJobName(migration, database.ObjectMeta.Name)))
+	}
+	return targets, nil
+}
+
+// databaseRefKey indexes migrations by the kind and name of the databases they reference
+func databaseRefKey(ref *migrationsv1alpha1.DatabaseReference) string {
+	kind := ref.Kind
+	if kind == "" {
+		kind = migrationsv1alpha1.DatabaseKind
+	}
+	return kind + "/" + ref.Name
+}
+
+// jobNameLength caps the job names of the targets, the names derived from them with a suffix, such as the names of
+// their runs, backups and secrets, have to fit in 63 characters
+const jobNameLength = 40
+
+// targetJobName names the jobs of a target after the migration and the target. The names end with a digest of the
+// migration UID and the target, the targets of migrations whose name and target join the same way never share jobs.
+func targetJobName(migration *migrationsv1alpha1.Migration, target string) string {
+	name := fmt.Sprintf("flyway-%s-%s", migration.ObjectMeta.Name, target)
+	return shortenName(name, jobNameLength-runUIDLength-1) + "-" + nameDigest(string(migration.ObjectMeta.UID)+"/"+target)
+}
+
+// shortenName truncates a name to the given length without leaving a separator at its end
+func shortenName(name string, length int) string {
+	if len(name) > length {
+		name = strings.TrimRight(name[:length], "-.")
+	}
+	return name
+}
+
+// syncTargetStatuses lays the target statuses out in rollout order, statuses of removed targets are dropped
+func syncTargetStatuses(status *migrationsv1alpha1.MigrationStatus, targets []*migrationTarget) {
+	previous := make(map[string]migrationsv1alpha1.TargetStatus, len(status.Targets))
+	for _, target := range status.Targets {
+		previous[target.Name] = target
+	}
+
+	statuses := make([]migrationsv1alpha1.TargetStatus, 0, len(targets))
+	for _, target := range targets {
+		targetStatus, ok := previous[target.name]
+		if !ok {
+			targetStatus = migrationsv1alpha1.TargetStatus{Name: target.name, Phase: migrationsv1alpha1.MigrationPending}
+		}
+		statuses = append(statuses, targetStatus)
+	}
+	status.Targets = statuses
+}
+
+// summarizeTargets reports the lowest schema version and the highest number of pending scripts across targets,
+// a rollout only waiting for locked databases is queued
+func summarizeTargets(status *migrationsv1alpha1.MigrationStatus) {
+	version, pending := "", int32(0)
+	running, position := false, int32(0)
+	for _, target := range status.Targets {
+		switch target.Phase {
+		case migrationsv1alpha1.MigrationRunning:
+			running = true
+		case migrationsv1alpha1.MigrationQueued:
+			if position == 0 || target.QueuePosition < position {
+				position = target.QueuePosition
+			}
+		}
+		if target.CurrentVersion != "" && (version == "" || compareVersions(target.CurrentVersion, version) < 0) {
+			version = target.CurrentVersion
+		}
+		if target.PendingMigrations > pending {
+			pending = target.PendingMigrations
+		}
+	}
+	status.CurrentVersion = version
+	status.PendingMigrations = pending
+	status.QueuePosition = position
+	if status.Phase == migrationsv1alpha1.MigrationRunning && !running && position > 0 {
+		status.Phase = migrationsv1alpha1.MigrationQueued
+	}
+}
+
+// issueToken writes a fresh authentication token in the secret the pods of a target read it from,
+// right before they are created since tokens only authenticate new connections for a few minutes
+func (r *MigrationReconciler) issueToken(ctx context.Context, migration *migrationsv1alpha1.Migration, run *flywayRun) error {
+	creds, ok := run.target.creds.(RDSIAMCredential)
+	if !ok {
+		return nil
+	}
+	userPass, err := creds.GetUserPassword(ctx, r.Client)
+	if err != nil {
+		return err
+	}
+	run.userPass = userPass
+	return r.writeCredentials(ctx, migration, creds.Secret, userPass, map[string][]byte{
+		rdsCAKey:         creds.CABundle.PEM,
+		rdsTrustStoreKey: creds.CABundle.TrustStore,
+	})
+}
+
+// writeCredentials stores a user and password under the default keys of a secret owned by the migration, along with extra keys
+func (r *MigrationReconciler) writeCredentials(ctx context.Context, migration *migrationsv1alpha1.Migration, name string, userPass *UserPassword, extra map[string][]byte) error {
+	data := map[string][]byte{
+		migrationsv1alpha1.DefaultUserKey:     []byte(userPass.User),
+		migrationsv1alpha1.DefaultPasswordKey: []byte(userPass.Password),
+	}
+	for key, value := range extra {
+		data[key] = value
+	}
+	return r.writeSecret(ctx, migration, name, data)
+}
+
+// writeSecret creates or updates a secret controlled by the migration, the secrets of the same name it does not control
+// are left untouched rather than taken over and garbage collected with the migration
+func (r *MigrationReconciler) writeSecret(ctx context.Context, migration *migrationsv1alpha1.Migration, name string, data map[string][]byte) error {
+	secret := corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: migration.ObjectMeta.Namespace}}
+	if err := r.Get(ctx, client.ObjectKey{Namespace: secret.ObjectMeta.Namespace, Name: name}, &secret); err == nil {
+		if !metav1.IsControlledBy(&secret, migration) {
+			return newMigrationError(ReasonSecretConflict, "secret %s/%s already exists and is not controlled by the migration", secret.ObjectMeta.Namespace, name)
+		}
+	} else if !apierrors.IsNotFound(err) {
+		return err
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, &secret, func() error {
+		secret.Data = data
+		return ctrl.SetControllerReference(migration, &secret, r.Scheme)
+	})
+	return err
+}
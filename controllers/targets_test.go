@@ -0,0 +1,204 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+)
+
+var _ = Describe("selectTargets", func() {
+	ctx := context.Background()
+	tenant := map[string]string{"flywayoperator.io/tenant-database": "true"}
+
+	database := func(name, namespace string, labels map[string]string) *migrationsv1alpha1.Database {
+		return &migrationsv1alpha1.Database{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+			Spec: migrationsv1alpha1.DatabaseSpec{DBSpec: migrationsv1alpha1.DBSpec{
+				Host: name + ".db", DBName: "shop", Driver: migrationsv1alpha1.PostgresDriver,
+				Secret: &migrationsv1alpha1.SecretSpec{Name: name},
+			}},
+		}
+	}
+
+	migration := &migrationsv1alpha1.Migration{
+		ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop"},
+		Spec:       migrationsv1alpha1.MigrationSpec{TargetSelector: &metav1.LabelSelector{MatchLabels: tenant}},
+	}
+
+	It("fans out to the databases of the namespace matching the selector", func() {
+		c := fake.NewFakeClientWithScheme(scheme.Scheme,
+			database("tenant-b", "shop", tenant), database("tenant-a", "shop", tenant),
+			database("legacy", "shop", nil), database("tenant-c", "other", tenant))
+		r := &MigrationReconciler{Client: c, Log: ctrl.Log}
+
+		targets, err := r.selectTargets(ctx, migration)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(targets).To(HaveLen(2))
+		Expect(targets[0].name).To(Equal("tenant-a"))
+		Expect(targets[0].job).To(MatchRegexp(`^flyway-orders-tenant-a-[0-9a-f]{5}$`))
+		Expect(targets[0].db.Host).To(Equal("tenant-a.db"))
+		Expect(targets[0].db.Port).To(Equal(int32(5432)))
+		Expect(targets[1].name).To(Equal("tenant-b"))
+	})
+
+	It("names the jobs of the targets after the migration UID", func() {
+		other := &migrationsv1alpha1.Migration{ObjectMeta: metav1.ObjectMeta{Name: "orders-tenant", Namespace: "shop", UID: "other"}}
+		Expect(targetJobName(migration, "tenant-a")).NotTo(Equal(targetJobName(other, "a")))
+
+		long := targetJobName(migration, "tenant-with-a-database-name-longer-than-a-job-name-can-be")
+		Expect(len(long)).To(BeNumerically("<=", jobNameLength))
+		Expect(long).NotTo(Equal(targetJobName(migration, "tenant-with-a-database-name-longer-than-a-job-name-can-hold")))
+		Expect(jobName(other)).To(Equal("flyway-orders-tenant"))
+		other.ObjectMeta.Name = "orders-of-the-tenants-of-the-shop-and-their-archives"
+		Expect(len(jobName(other))).To(BeNumerically("<=", jobNameLength))
+	})
+
+	It("fails when no database matches", func() {
+		r := &MigrationReconciler{Client: fake.NewFakeClientWithScheme(scheme.Scheme, database("legacy", "shop", nil)), Log: ctrl.Log}
+
+		_, err := r.selectTargets(ctx, migration)
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonNoTargets))
+	})
+})
+
+var _ = Describe("Cluster database references", func() {
+	ctx := context.Background()
+
+	var (
+		c         client.Client
+		r         *MigrationReconciler
+		migration *migrationsv1alpha1.Migration
+	)
+	ref := &migrationsv1alpha1.DatabaseReference{Kind: migrationsv1alpha1.ClusterDatabaseKind, Name: "shared"}
+
+	BeforeEach(func() {
+		migration = &migrationsv1alpha1.Migration{ObjectMeta: metav1.ObjectMeta{Name: "orders", Namespace: "shop", UID: "1234"}}
+		database := &migrationsv1alpha1.ClusterDatabase{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+			Spec: migrationsv1alpha1.ClusterDatabaseSpec{
+				DatabaseSpec: migrationsv1alpha1.DatabaseSpec{DBSpec: migrationsv1alpha1.DBSpec{
+					Host: "shared.db", DBName: "shared", Driver: migrationsv1alpha1.PostgresDriver,
+					Secret: &migrationsv1alpha1.SecretSpec{Name: "shared", Namespace: "databases"},
+				}},
+				AllowedNamespaces: []string{"billing", "shop"},
+			},
+		}
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "databases"},
+			Data:       map[string][]byte{"user": []byte("flyway"), "password": []byte("secret")},
+		}
+		c = fake.NewFakeClientWithScheme(scheme.Scheme, database, secret)
+		r = &MigrationReconciler{Client: c, Scheme: scheme.Scheme, Log: ctrl.Log}
+	})
+
+	It("copies the credentials to the allowed namespaces", func() {
+		target, err := r.resolveDatabase(ctx, migration, ref, "shared", "flyway-orders")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.db.Host).To(Equal("shared.db"))
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "flyway-orders-credentials"}, &corev1.Secret{})).To(Succeed())
+	})
+
+	It("leaves the secrets it does not control untouched", func() {
+		owned := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "flyway-orders-credentials", Namespace: "shop"},
+			Data:       map[string][]byte{"token": []byte("user data")},
+		}
+		Expect(c.Create(ctx, owned)).To(Succeed())
+
+		_, err := r.resolveDatabase(ctx, migration, ref, "shared", "flyway-orders")
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonSecretConflict))
+		var secret corev1.Secret
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "flyway-orders-credentials"}, &secret)).To(Succeed())
+		Expect(secret.Data).To(Equal(owned.Data))
+		Expect(secret.ObjectMeta.OwnerReferences).To(BeEmpty())
+	})
+
+	It("refuses the migrations of other namespaces before copying the credentials", func() {
+		migration.ObjectMeta.Namespace = "tenant"
+		_, err := r.resolveDatabase(ctx, migration, ref, "shared", "flyway-orders")
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonDatabaseNotAllowed))
+		Expect(apierrors.IsNotFound(c.Get(ctx, client.ObjectKey{Namespace: "tenant", Name: "flyway-orders-credentials"}, &corev1.Secret{}))).To(BeTrue())
+	})
+
+	It("copies the key of the ssh tunnel to the allowed namespaces", func() {
+		var database migrationsv1alpha1.ClusterDatabase
+		Expect(c.Get(ctx, client.ObjectKey{Name: "shared"}, &database)).To(Succeed())
+		database.Spec.SSHTunnel = &migrationsv1alpha1.SSHTunnelSpec{Host: "bastion", User: "flyway", KeySecret: "bastion-key", KnownHosts: "bastion ssh-ed25519 AAAA"}
+		Expect(c.Update(ctx, &database)).To(Succeed())
+
+		_, err := r.resolveDatabase(ctx, migration, ref, "shared", "flyway-orders")
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonCredentialsMissing))
+
+		database.Spec.SSHTunnel.KeySecretNamespace = "databases"
+		Expect(c.Update(ctx, &database)).To(Succeed())
+		Expect(c.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "bastion-key", Namespace: "databases"},
+			Data:       map[string][]byte{corev1.SSHAuthPrivateKey: []byte("private key")},
+		})).To(Succeed())
+
+		target, err := r.resolveDatabase(ctx, migration, ref, "shared", "flyway-orders")
+		Expect(err).NotTo(HaveOccurred())
+		Expect(target.db.SSHTunnel.KeySecret).To(Equal("flyway-orders-ssh-key"))
+		Expect(target.db.SSHTunnel.KeySecretNamespace).To(BeEmpty())
+		var mirror corev1.Secret
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "flyway-orders-ssh-key"}, &mirror)).To(Succeed())
+		Expect(mirror.Data).To(Equal(map[string][]byte{corev1.SSHAuthPrivateKey: []byte("private key")}))
+		Expect(GetConnectivity(&target.db, "shop").(SSHTunnelConnectivity).Spec.KeySecret).To(Equal("flyway-orders-ssh-key"))
+	})
+
+	It("leaves the ssh key secrets it does not control untouched", func() {
+		var database migrationsv1alpha1.ClusterDatabase
+		Expect(c.Get(ctx, client.ObjectKey{Name: "shared"}, &database)).To(Succeed())
+		database.Spec.SSHTunnel = &migrationsv1alpha1.SSHTunnelSpec{
+			Host: "bastion", User: "flyway", KeySecret: "bastion-key", KeySecretNamespace: "databases", KnownHosts: "bastion ssh-ed25519 AAAA",
+		}
+		Expect(c.Update(ctx, &database)).To(Succeed())
+		Expect(c.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "bastion-key", Namespace: "databases"},
+			Data:       map[string][]byte{corev1.SSHAuthPrivateKey: []byte("private key")},
+		})).To(Succeed())
+		owned := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "flyway-orders-ssh-key", Namespace: "shop"},
+			Data:       map[string][]byte{corev1.SSHAuthPrivateKey: []byte("user key")},
+		}
+		Expect(c.Create(ctx, owned)).To(Succeed())
+
+		_, err := r.resolveDatabase(ctx, migration, ref, "shared", "flyway-orders")
+		Expect(err).To(BeAssignableToTypeOf(&MigrationError{}))
+		Expect(err.(*MigrationError).Reason).To(Equal(ReasonSecretConflict))
+		var secret corev1.Secret
+		Expect(c.Get(ctx, client.ObjectKey{Namespace: "shop", Name: "flyway-orders-ssh-key"}, &secret)).To(Succeed())
+		Expect(secret.Data).To(Equal(owned.Data))
+		Expect(secret.ObjectMeta.OwnerReferences).To(BeEmpty())
+	})
+})
@@ -0,0 +1,40 @@
+package controllers
+
+import (
+	"strconv"
+	"strings"
+)
+
+// compareVersions orders flyway versions, parts are separated by dots or underscores
+// and compared numerically, missing parts count as zero
+func compareVersions(a, b string) int {
+	split := func(version string) []string {
+		return strings.FieldsFunc(version, func(r rune) bool { return r == '.' || r == '_' })
+	}
+	partsA, partsB := split(a), split(b)
+
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		partA, partB := "0", "0"
+		if i < len(partsA) {
+			partA = partsA[i]
+		}
+		if i < len(partsB) {
+			partB = partsB[i]
+		}
+
+		numA, errA := strconv.ParseInt(partA, 10, 64)
+		numB, errB := strconv.ParseInt(partB, 10, 64)
+		switch {
+		case errA == nil && errB == nil:
+			if numA != numB {
+				if numA < numB {
+					return -1
+				}
+				return 1
+			}
+		case partA != partB:
+			return strings.Compare(partA, partB)
+		}
+	}
+	return 0
+}
@@ -0,0 +1,36 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	. "github.com/onsi/ginkgo"
+	"github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("compareVersions", func() {
+	table.DescribeTable("orders flyway versions",
+		func(a, b string, expected int) {
+			Expect(compareVersions(a, b)).To(Equal(expected))
+		},
+		table.Entry("equal versions", "1.2", "1.2", 0),
+		table.Entry("numeric parts", "1.10", "1.9", 1),
+		table.Entry("missing parts count as zero", "2", "2.0.0", 0),
+		table.Entry("underscore separators", "1_1", "1.2", -1),
+		table.Entry("empty version is the lowest", "", "1", -1),
+	)
+})
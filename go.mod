@@ -3,14 +3,23 @@ module flyway-operator
 go 1.13
 
 require (
+	cloud.google.com/go/cloudsqlconn v1.4.3
 	github.com/apex/log v1.9.0
+	github.com/go-git/go-git/v5 v5.1.0
 	github.com/go-logr/logr v0.1.0
+	github.com/go-sql-driver/mysql v1.7.1
 	github.com/jmoiron/sqlx v1.3.1
-	github.com/lib/pq v1.2.0
+	github.com/lib/pq v1.10.2
 	github.com/onsi/ginkgo v1.11.0
 	github.com/onsi/gomega v1.8.1
+	github.com/prometheus/client_golang v1.0.0
+	github.com/prometheus/client_model v0.3.0
+	github.com/robfig/cron/v3 v3.0.1
+	golang.org/x/crypto v0.12.0
+	golang.org/x/oauth2 v0.11.0
 	k8s.io/api v0.17.2
 	k8s.io/apimachinery v0.17.2
 	k8s.io/client-go v0.17.2
 	sigs.k8s.io/controller-runtime v0.5.0
+	sigs.k8s.io/yaml v1.1.0
 )
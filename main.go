@@ -18,15 +18,20 @@ package main
 
 import (
 	"flag"
+	"net/http"
 	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
 	migrationsv1alpha1 "flyway-operator/api/v1alpha1"
+	migrationsv1beta1 "flyway-operator/api/v1beta1"
 	"flyway-operator/controllers"
 	// +kubebuilder:scaffold:imports
 )
@@ -40,13 +45,33 @@ func init() {
 	_ = clientgoscheme.AddToScheme(scheme)
 
 	_ = migrationsv1alpha1.AddToScheme(scheme)
+	_ = migrationsv1beta1.AddToScheme(scheme)
 	// +kubebuilder:scaffold:scheme
 }
 
 func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
+	var gitWebhookAddr string
+	var lockNamespace string
+	var schemaGateAddr string
+	var notificationTimeout time.Duration
+	var rdsCABundle string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":9090", "The address the metric endpoint binds to.")
+	flag.StringVar(&gitWebhookAddr, "git-webhook-addr", ":8082",
+		"The address the git push webhook receiver binds to. "+
+			"The receiver only starts when GIT_WEBHOOK_SECRET is set.")
+	flag.StringVar(&schemaGateAddr, "schema-gate-addr", ":8083",
+		"The address the schema gate answering the init containers of gated pods binds to. "+
+			"The gate only starts when SCHEMA_GATE_URL is set.")
+	flag.StringVar(&lockNamespace, "lock-namespace", os.Getenv("POD_NAMESPACE"),
+		"The namespace of the leases serializing the migration runs per database. "+
+			"Runs are not serialized when empty.")
+	flag.DurationVar(&notificationTimeout, "notification-timeout", 10*time.Second,
+		"The timeout of every attempt to deliver a notification to a sink.")
+	flag.StringVar(&rdsCABundle, "rds-ca-bundle", os.Getenv("RDS_CA_BUNDLE"),
+		"The PEM bundle of the RDS certificate authorities verifying the databases authenticating with RDS IAM tokens. "+
+			"These databases are refused when empty.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
@@ -66,14 +91,123 @@ func main() {
 		os.Exit(1)
 	}
 
+	if rdsCABundle != "" {
+		if controllers.DefaultRDSCABundle, err = controllers.LoadRDSCABundle(rdsCABundle); err != nil {
+			setupLog.Error(err, "unable to load RDS CA bundle")
+			os.Exit(1)
+		}
+	}
+
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create discovery client")
+		os.Exit(1)
+	}
+	serverVersion, err := discoveryClient.ServerVersion()
+	if err != nil {
+		setupLog.Error(err, "unable to get server version")
+		os.Exit(1)
+	}
+	if controllers.NativeSidecars = controllers.RunsNativeSidecars(serverVersion); !controllers.NativeSidecars {
+		setupLog.Info("native sidecars require Kubernetes 1.29 or later, the databases reached through a sidecar are refused", "version", serverVersion.GitVersion)
+	}
+
+	podLogs, err := controllers.NewPodLogs(mgr.GetConfig())
+	if err != nil {
+		setupLog.Error(err, "unable to create pod logs client")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.MigrationReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Migration"),
-		Scheme: mgr.GetScheme(),
+		Client:        mgr.GetClient(),
+		Log:           ctrl.Log.WithName("controllers").WithName("Migration"),
+		Scheme:        mgr.GetScheme(),
+		Recorder:      mgr.GetEventRecorderFor("migration-controller"),
+		Logs:          podLogs,
+		APIReader:     mgr.GetAPIReader(),
+		LockNamespace: lockNamespace,
+		Notifier: &controllers.Notifier{
+			Client:     mgr.GetClient(),
+			HTTPClient: &http.Client{Timeout: notificationTimeout},
+			Backoff:    time.Second,
+		},
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Migration")
 		os.Exit(1)
 	}
+	if err = (&controllers.DatabaseReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("Database"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("database-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Database")
+		os.Exit(1)
+	}
+	if err = (&controllers.ClusterDatabaseReconciler{
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("ClusterDatabase"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("clusterdatabase-controller"),
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterDatabase")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&migrationsv1alpha1.Migration{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Migration")
+			os.Exit(1)
+		}
+		if err = (&migrationsv1alpha1.MigrationRun{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "MigrationRun")
+			os.Exit(1)
+		}
+		if err = (&migrationsv1beta1.Migration{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Migration", "version", "v1beta1")
+			os.Exit(1)
+		}
+		mgr.GetWebhookServer().Register(controllers.RequesterStamperPath, &webhook.Admission{Handler: &controllers.RequesterStamper{
+			Log: ctrl.Log.WithName("requester-stamper"),
+		}})
+		// the conversion webhook serves the rewrites, the objects are migrated once it runs
+		if err = mgr.Add(&controllers.StorageVersionMigrator{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("storage-version-migrator"),
+			CRDs:     []string{"migrations.migrations.flywayoperator.io"},
+			Interval: 10 * time.Second,
+		}); err != nil {
+			setupLog.Error(err, "unable to add storage version migrator")
+			os.Exit(1)
+		}
+	}
+	if gateURL := os.Getenv("SCHEMA_GATE_URL"); gateURL != "" {
+		if err = mgr.Add(&controllers.SchemaGate{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("schema-gate"),
+			Addr:   schemaGateAddr,
+		}); err != nil {
+			setupLog.Error(err, "unable to add schema gate")
+			os.Exit(1)
+		}
+		if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+			mgr.GetWebhookServer().Register("/mutate-v1-pod", &webhook.Admission{Handler: &controllers.SchemaGateInjector{
+				Log:     ctrl.Log.WithName("schema-gate-injector"),
+				GateURL: gateURL,
+				Image:   os.Getenv("SCHEMA_GATE_IMAGE"),
+			}})
+		}
+	}
+	if secret := os.Getenv("GIT_WEBHOOK_SECRET"); secret != "" {
+		if err = mgr.Add(&controllers.GitWebhookReceiver{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("git-webhook"),
+			Addr:   gitWebhookAddr,
+			Secret: []byte(secret),
+		}); err != nil {
+			setupLog.Error(err, "unable to add git webhook receiver")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")